@@ -1,21 +1,41 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate/btcutil"
 	"github.com/spf13/cobra"
 )
 
+var btcutilOptions struct {
+	interval time.Duration
+	network  string
+}
+
 // btcutilCommand represents the command for btcuitl estimation
 var btcutilCommand = &cobra.Command{
 	Use:   "btcutil",
 	Short: "Runs the btcutil fee estimation",
 	Long:  `Runs the btcutil fee estimation.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		network, err := btcutil.ParseNetwork(btcutilOptions.network)
+		if err != nil {
+			return err
+		}
+		btcutil.EstimatorNetwork = network
+
+		// stop cleanly so that the estimator state is saved
+		ctx, cancel := signalContext()
+		defer cancel()
+
 		estimator := btcutil.NewEstimator(logger, client, rateCache, mempoolCache)
-		return estimator.Run()
+		return estimator.Run(ctx, btcutilOptions.interval)
 	},
 }
 
 func init() {
+	btcutilCommand.Flags().DurationVarP(&btcutilOptions.interval, "interval", "i", btcutil.DefaultInterval, "fee estimation interval")
+	btcutilCommand.Flags().StringVarP(&btcutilOptions.network, "network", "n", btcutil.MainNet.String(), "bitcoin network (mainnet, testnet3 or regtest)")
+
 	RootCmd.AddCommand(btcutilCommand)
 }
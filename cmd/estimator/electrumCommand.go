@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/blockchain"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate/electrum"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var electrumURLs string
+
+// electrumCommand represents the command for estimation from Electrum fee
+// histograms
+var electrumCommand = &cobra.Command{
+	Use:   "electrum",
+	Short: "Runs fee estimation from Electrum fee histograms",
+	Long:  `Runs fee estimation from the mempool fee histograms of Electrum servers.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		proxy, err := utils.ParseProxy(options.proxyURL)
+		if err != nil {
+			return err
+		}
+
+		pool, err := blockchain.NewElectrumXPoolWithProxy(strings.Split(electrumURLs, ","), proxy)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+
+		estimator := electrum.NewEstimator(logger, pool, client, rateCache)
+		return estimator.Run()
+	},
+}
+
+func init() {
+	electrumCommand.Flags().StringVarP(&electrumURLs, "electrum", "", "tls://electrum.blockstream.info:50002", "comma separated electrum server urls")
+
+	RootCmd.AddCommand(electrumCommand)
+}
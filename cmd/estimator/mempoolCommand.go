@@ -1,21 +1,32 @@
 package cmd
 
 import (
-	"github.com/spf13/cobra"
+	"time"
+
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate/mempool"
+	"github.com/spf13/cobra"
 )
 
+var mempoolOptions struct {
+	interval time.Duration
+}
+
 // mempoolCommand represents the command for mempool estimation
 var mempoolCommand = &cobra.Command{
 	Use:   "mempool",
 	Short: "Runs the mempool fee estimation",
 	Long:  `Runs the mempool fee estimation.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signalContext()
+		defer cancel()
+
 		estimator := mempool.NewEstimator(logger, client, rateCache, mempoolCache)
-		return estimator.Run()
+		return estimator.Run(ctx, mempoolOptions.interval)
 	},
 }
 
 func init() {
+	mempoolCommand.Flags().DurationVarP(&mempoolOptions.interval, "interval", "i", mempool.DefaultInterval, "fee estimation interval")
+
 	RootCmd.AddCommand(mempoolCommand)
 }
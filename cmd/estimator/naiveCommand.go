@@ -1,21 +1,35 @@
 package cmd
 
 import (
-	"github.com/spf13/cobra"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate/naive"
+	"github.com/spf13/cobra"
 )
 
+var naiveOptions = naive.DefaultOptions()
+
 // naiveCommand represents the command for naive btc estimation
 var naiveCommand = &cobra.Command{
 	Use:   "naive",
 	Short: "Runs naive fee estimation",
 	Long:  `Runs naive fee estimation.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		estimator := naive.NewEstimator(logger, client, rateCache)
+		err := naiveOptions.Validate()
+		if err != nil {
+			return err
+		}
+
+		estimator := naive.NewEstimatorWithOptions(logger, client, rateCache, naiveOptions)
 		return estimator.Run()
 	},
 }
 
 func init() {
+	naiveCommand.Flags().IntVarP(&naiveOptions.Percentile, "percentile", "", naiveOptions.Percentile, "percentile of the block fee rates that is estimated")
+	naiveCommand.Flags().IntVarP(&naiveOptions.MaxFeeRate, "max-fee-rate", "", naiveOptions.MaxFeeRate, "upper bound of estimates in satoshi per byte, 0 disables it")
+	naiveCommand.Flags().IntVarP(&naiveOptions.WindowSize, "window", "w", naiveOptions.WindowSize, "number of recent blocks the percentile is taken over")
+	naiveCommand.Flags().BoolVarP(&naiveOptions.BlockStats, "blockstats", "", naiveOptions.BlockStats, "estimate from getblockstats instead of every tx, works without txindex")
+	naiveCommand.Flags().Float64VarP(&naiveOptions.OutlierMADs, "outlier-mads", "", naiveOptions.OutlierMADs, "median absolute deviations beyond which fee rates are left out, 0 disables trimming")
+	naiveCommand.Flags().Float64VarP(&naiveOptions.SmoothingHalfLife, "half-life", "", naiveOptions.SmoothingHalfLife, "half-life in blocks of the smoothed estimates, 0 disables smoothing")
+
 	RootCmd.AddCommand(naiveCommand)
 }
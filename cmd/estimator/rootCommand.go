@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
@@ -14,6 +18,7 @@ import (
 var (
 	logger       *zap.Logger
 	rateCache    *feerate.RateCache
+	rateStore    *feerate.BoltRateStore
 	client       *utils.CachedRPCClient
 	mempoolCache *feerate.MempoolCache
 )
@@ -23,8 +28,12 @@ var RootCmd = &cobra.Command{
 	Use:   "estimator",
 	Short: "btcfeeestimator",
 	Long:  `Bitcoin fee estimator.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setup()
+	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 		client.Close()
+		utils.IgnoreError(rateStore.Close())
 	},
 }
 
@@ -37,29 +46,95 @@ func Execute() {
 	}
 }
 
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM so
+// that estimators can stop cleanly
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
 var (
 	options struct {
 		btcRPCURL      string
 		btcRPCUser     string
 		btcRPCPassword string
+		btcRPCCookie   string
+		btcRPCCACert   string
+		btcdWSURL      string
+		esploraURL     string
+		proxyURL       string
 	}
 )
 
 func init() {
 	logger, _ = zap.NewDevelopment(zap.AddStacktrace(zapcore.FatalLevel))
 
-	naiveCommand.Flags().StringVarP(&options.btcRPCURL, "url", "", "13.80.132.186:8332", "bitcoin rpc url")
-	naiveCommand.Flags().StringVarP(&options.btcRPCUser, "user", "u", "bitcoinrpc", "bitcoin rpc username")
-	naiveCommand.Flags().StringVarP(&options.btcRPCPassword, "password", "p", "eaf672111c88b64fc436f01259dd1812", "bitcoin rpc password")
+	RootCmd.PersistentFlags().StringVarP(&options.btcRPCURL, "url", "", "13.80.132.186:8332", "bitcoin rpc url, calls fail over between several comma separated urls")
+	RootCmd.PersistentFlags().StringVarP(&options.btcRPCUser, "user", "u", "bitcoinrpc", "bitcoin rpc username")
+	RootCmd.PersistentFlags().StringVarP(&options.btcRPCPassword, "password", "p", "eaf672111c88b64fc436f01259dd1812", "bitcoin rpc password")
+	RootCmd.PersistentFlags().StringVarP(&options.btcRPCCookie, "cookie", "", "", "bitcoin rpc cookie file, used instead of user and password")
+	RootCmd.PersistentFlags().StringVarP(&options.btcRPCCACert, "cacert", "", "", "CA certificates to verify the TLS certificate of an https rpc url with")
+	RootCmd.PersistentFlags().StringVarP(&options.esploraURL, "esplora", "", "", "esplora api url to fetch blocks pruned by the node from, e.g. https://blockstream.info/api")
+	RootCmd.PersistentFlags().StringVarP(&options.btcdWSURL, "ws", "", "", "btcd websocket url to receive block and tx notifications from instead of polling")
+	RootCmd.PersistentFlags().StringVarP(&options.proxyURL, "proxy", "", "", "socks5 proxy to connect through, e.g. socks5://127.0.0.1:9050 for tor")
+}
+
+// setup creates the rpc client and the caches from the flags, it runs once
+// they are parsed
+func setup() error {
+	proxy, err := utils.ParseProxy(options.proxyURL)
+	if err != nil {
+		return err
+	}
 
-	client = utils.NewCachedRPCClient(options.btcRPCURL, options.btcRPCUser, options.btcRPCPassword, logger)
-	rateCache = feerate.NewRateCache(client, logger)
+	txStore := utils.NewLazyTxStore(utils.DefaultTxStorePath)
+
+	var nodes []utils.NodeConfig
+	for _, url := range strings.Split(options.btcRPCURL, ",") {
+		nodes = append(nodes, utils.NodeConfig{
+			URL:        strings.TrimSpace(url),
+			User:       options.btcRPCUser,
+			Password:   options.btcRPCPassword,
+			CookieFile: options.btcRPCCookie,
+			CACertFile: options.btcRPCCACert,
+			Proxy:      proxy,
+		})
+	}
+
+	clientOptions := utils.DefaultClientOptions()
+	clientOptions.EsploraURL = options.esploraURL
+	clientOptions.Proxy = proxy
+	client, err = utils.NewCachedRPCClientWithOptions(nodes, logger, txStore, clientOptions)
+	if err != nil {
+		return err
+	}
+	rateStore = feerate.NewBoltRateStore(feerate.DefaultRateStorePath)
+	rateCache = feerate.NewRateCacheWithStore(client, logger, rateStore)
 	mempoolCache = feerate.NewMempoolCache(logger, client)
+	err = mempoolCache.LoadFromDisk()
+	if err != nil {
+		logger.Error("could not load mempool snapshots", zap.Error(err))
+	}
 
-	go func() {
-		err := mempoolCache.Run()
-		if err != nil {
-			logger.Fatal("mempool cache error", zap.Error(err))
-		}
-	}()
+	if options.btcdWSURL != "" {
+		wsConfig := utils.NewWebsocketConfig(options.btcdWSURL, options.btcRPCUser, options.btcRPCPassword)
+		utils.ConfigureProxy(wsConfig, proxy)
+		go mempoolCache.RunWithNotifications(wsConfig)
+	} else {
+		go mempoolCache.Run()
+	}
+
+	return nil
 }
@@ -1,21 +1,54 @@
 package cmd
 
 import (
+	"math/rand"
+	"strings"
+
 	"github.com/spf13/cobra"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/coinselection"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/simulation"
 )
 
+var (
+	simSelector               string
+	simConsolidationFeeRate   int64
+	simConsolidationMaxInputs int
+	simSeed                   int64
+)
+
 // btcutilCommand represents the command for btcuitl estimation
 var simCommand = &cobra.Command{
 	Use:   "sim",
 	Short: "Runs fee estimation simulation",
 	Long:  `Runs fee estimation simulation.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sim := simulation.NewSimulation(logger)
+		var source rand.Source
+		if cmd.Flags().Changed("seed") {
+			source = rand.NewSource(simSeed)
+		}
+
+		selector, err := coinselection.NewStrategyWithSource(simSelector, source)
+		if err != nil {
+			return err
+		}
+		if simSelector == "consolidation" {
+			selector = coinselection.ConsolidationCoinSelector{
+				MaxInputs:  simConsolidationMaxInputs,
+				MaxFeeRate: simConsolidationFeeRate,
+			}
+		}
+
+		sim := simulation.NewSimulationWithStrategy(logger, selector)
 		return sim.Run()
 	},
 }
 
 func init() {
+	simCommand.Flags().StringVarP(&simSelector, "selector", "", "random", "coin selection strategy, one of "+strings.Join(coinselection.StrategyNames(), ", "))
+	simCommand.Flags().Int64VarP(&simConsolidationFeeRate, "consolidation-feerate", "", 0, "fee rate in satoshi per kB up to which the consolidation strategy consolidates, the long term fee rate if 0")
+	simCommand.Flags().IntVarP(&simConsolidationMaxInputs, "consolidation-max-inputs", "", coinselection.DefaultConsolidationInputs, "number of inputs the consolidation strategy spends at most, 0 for all")
+
+	simCommand.Flags().Int64VarP(&simSeed, "seed", "", 0, "seed of random coin selection strategies to replay a simulation, the current time if unset")
+
 	RootCmd.AddCommand(simCommand)
 }
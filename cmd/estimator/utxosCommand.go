@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/blockchain"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var utxoOptions struct {
+	backend string
+	urls    string
+	network string
+}
+
+// networks are the networks addresses can be looked up for by name
+var networks = map[string]*chaincfg.Params{
+	"mainnet": &chaincfg.MainNetParams,
+	"testnet": &chaincfg.TestNet3Params,
+	"signet":  &chaincfg.SigNetParams,
+	"regtest": &chaincfg.RegressionNetParams,
+}
+
+// defaultUTXOURLs are the urls looked up on per backend if --utxo-url is not
+// given
+var defaultUTXOURLs = map[string]string{
+	blockchain.UTXOBackendEsplora:  "https://blockstream.info/api",
+	blockchain.UTXOBackendElectrum: "tls://electrum.blockstream.info:50002",
+}
+
+// utxosCommand represents the command listing the UTXOs of an address
+var utxosCommand = &cobra.Command{
+	Use:   "utxos [address]",
+	Short: "Lists the UTXOs of an address",
+	Long:  `Lists the UTXOs of an address looked up on Electrum servers or an esplora api.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		params, ok := networks[utxoOptions.network]
+		if !ok {
+			return fmt.Errorf("unknown network %q", utxoOptions.network)
+		}
+
+		urls := utxoOptions.urls
+		if urls == "" {
+			urls, ok = defaultUTXOURLs[utxoOptions.backend]
+			if !ok {
+				return fmt.Errorf("unknown utxo backend %q", utxoOptions.backend)
+			}
+		}
+
+		proxy, err := utils.ParseProxy(options.proxyURL)
+		if err != nil {
+			return err
+		}
+
+		utxos, err := blockchain.NewUTXOManager(blockchain.UTXOManagerConfig{
+			Backend: utxoOptions.backend,
+			URLs:    strings.Split(urls, ","),
+			Params:  params,
+			Proxy:   proxy,
+		})
+		if err != nil {
+			return err
+		}
+
+		set, err := utxos.GetUTXOs(args[0])
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(set)
+	},
+}
+
+func init() {
+	utxosCommand.Flags().StringVarP(&utxoOptions.backend, "backend", "", blockchain.UTXOBackendEsplora, "utxo backend, electrum or esplora")
+	utxosCommand.Flags().StringVarP(&utxoOptions.urls, "utxo-url", "", "", "esplora api url or comma separated electrum server urls, blockstream.info's of the backend if empty")
+	utxosCommand.Flags().StringVarP(&utxoOptions.network, "network", "", "mainnet", "network of the address, mainnet, testnet, signet or regtest")
+
+	RootCmd.AddCommand(utxosCommand)
+}
@@ -2,33 +2,115 @@ package blockchain
 
 import (
 	"bufio"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg"
-	"github.com/btcsuite/btcd/txscript"
-	"github.com/btcsuite/btcutil"
 	"github.com/pkg/errors"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
 	"github.com/ybbus/jsonrpc"
 )
 
+var (
+	// ElectrumXCallTimeout is how long a call waits for its response
+	ElectrumXCallTimeout = 30 * time.Second
+
+	// ElectrumXDialTimeout is how long connecting to the server may take,
+	// including the proxy and the TLS handshake
+	ElectrumXDialTimeout = 30 * time.Second
+
+	// ElectrumXPingInterval is how often an idle connection is pinged to
+	// keep it open, servers close connections idle for some minutes
+	ElectrumXPingInterval = time.Minute
+
+	// ErrElectrumXClosed is returned by calls made after Close
+	ErrElectrumXClosed = errors.New("electrumx client is closed")
+)
+
 // ElectrumX implements JSON RPC protocol over encrypted TCP connection.
+// Requests are pipelined over a single persistent connection and matched to
+// their responses by ID. The connection is dialed on the first call and
+// dialed again by the next call after it broke.
 type ElectrumX struct {
-	hostname string // host
-	address  string // host:port
+	hostname string       // host
+	address  string       // host:port
+	proxy    *utils.Proxy // nil dials directly
+	dial     func() (net.Conn, error)
+
+	conn         *electrumXConn // nil while not connected
+	dialing      *electrumXDial // nil while not dialing
+	nextID       int
+	closed       bool
+	lastCall     time.Time
+	headers      chan ElectrumXHeader // nil until SubscribeHeaders
+	resubscribe  bool                 // set once subscribed, subscribes again on new connections
+	statuses     chan ElectrumXStatus // nil until ScriptHashStatuses
+	scriptHashes map[string]bool      // subscribed script hashes, subscribed again on new connections
+	mu           sync.Mutex
+}
+
+// ElectrumXHeader is the header of a new chain tip announced by an Electrum
+// server
+type ElectrumXHeader struct {
+	Height int64  `json:"height"`
+	Hex    string `json:"hex"` // serialized block header
+}
+
+// ElectrumXStatus is the status of a script hash announced by an Electrum
+// server, it changes with every tx of the script
+// https://electrumx.readthedocs.io/en/latest/protocol-basics.html#status
+type ElectrumXStatus struct {
+	ScriptHash string
+	Status     string // empty if the script has no history
+}
+
+// electrumXNotification is a notification sent by the server without a
+// request
+type electrumXNotification struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// electrumXConn is a connection with the calls waiting for their response
+type electrumXConn struct {
+	conn      net.Conn
+	writer    *bufio.Writer
+	pending   map[int]chan electrumXResult
+	headerIDs map[int]bool  // of the pending header subscriptions
+	done      chan struct{} // closed once the connection is dropped
+}
+
+// electrumXDial is a dial in progress, err is set once done is closed
+type electrumXDial struct {
+	done chan struct{}
+	err  error
+}
+
+type electrumXResult struct {
+	response *jsonrpc.RPCResponse
+	err      error
 }
 
 // NOTE, there is no context used in RPC client,
-// need to take care of deadline timeout
+// calls time out after ElectrumXCallTimeout
 
 // NewElectrumX creates new ElectrumX client
 func NewElectrumX(targetURL string) (jsonrpc.RPCClient, error) {
+	return NewElectrumXWithProxy(targetURL, nil)
+}
+
+// NewElectrumXWithProxy creates new ElectrumX client connecting through
+// proxy, nil connects directly
+func NewElectrumXWithProxy(targetURL string, proxy *utils.Proxy) (jsonrpc.RPCClient, error) {
 	// parse URL
 	u, err := url.Parse(targetURL)
 	if err != nil {
@@ -37,14 +119,52 @@ func NewElectrumX(targetURL string) (jsonrpc.RPCClient, error) {
 
 	// on "tls" scheme just use implementation below!
 	if u.Scheme == "tls" {
-		return &ElectrumX{
+		x := &ElectrumX{
 			hostname: u.Hostname(),
 			address:  u.Host,
-		}, nil // OK
+			proxy:    proxy,
+		}
+		x.dial = x.dialTLS
+		return x, nil // OK
 	}
 
 	// fallback to JSON RPC over HTTP
-	return jsonrpc.NewClient(targetURL), nil
+	return jsonrpc.NewClientWithOpts(targetURL, &jsonrpc.RPCClientOpts{
+		HTTPClient: utils.NewHTTPClient(proxy, ElectrumXCallTimeout),
+	}), nil
+}
+
+// dialTLS establishes the TLS connection to the server within
+// ElectrumXDialTimeout
+func (x *ElectrumX) dialTLS() (net.Conn, error) {
+	// TLS configuration, need to specify server name
+	tlsCfg := &tls.Config{
+		ServerName: x.hostname,
+	}
+
+	if x.proxy == nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: ElectrumXDialTimeout}, "tcp", x.address, tlsCfg)
+	}
+
+	deadline := time.Now().Add(ElectrumXDialTimeout)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	conn, err := x.proxy.DialContext(ctx, "tcp", x.address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, tlsCfg)
+	utils.IgnoreError(conn.SetDeadline(deadline))
+	err = tlsConn.Handshake()
+	if err != nil {
+		utils.IgnoreError(conn.Close())
+		return nil, err
+	}
+	utils.IgnoreError(conn.SetDeadline(time.Time{}))
+
+	return tlsConn, nil // OK
 }
 
 // Call do JSON RPC call (helper)
@@ -52,58 +172,433 @@ func (x *ElectrumX) Call(method string, params ...interface{}) (*jsonrpc.RPCResp
 	return x.CallRaw(jsonrpc.NewRequest(method, params...))
 }
 
-// CallRaw do JSON RPC call
-// NOTE: not effective implementation - new connection per each RPC call!
-// usually establishing a TLS connection is quite long process!
+// CallRaw do JSON RPC call. The ID of request is replaced by one unique on
+// the connection.
 func (x *ElectrumX) CallRaw(request *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
-	// TLS configuration, need to specify server name
-	tlsCfg := &tls.Config{
-		ServerName: x.hostname,
-	}
+	return x.call(request, false)
+}
 
-	// establish TLS connection
-	conn, err := tls.Dial("tcp", x.address, tlsCfg)
+// call sends request and waits for its response. The result of a header
+// subscription is delivered to the subscriber before any header notification
+// that follows it.
+func (x *ElectrumX) call(request *jsonrpc.RPCRequest, headers bool) (*jsonrpc.RPCResponse, error) {
+	c, results, err := x.send(request, headers)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to dial")
+		return nil, err
+	}
+
+	timeout := time.NewTimer(ElectrumXCallTimeout)
+	defer timeout.Stop()
+
+	select {
+	case result := <-results:
+		return result.response, result.err
+	case <-timeout.C:
+		x.forget(c, request.ID)
+		return nil, errors.Errorf("no response to %s within %s", request.Method, ElectrumXCallTimeout)
+	}
+}
+
+// send writes request to the connection, dialing it if needed, and returns
+// the connection and the channel its response is delivered to
+func (x *ElectrumX) send(request *jsonrpc.RPCRequest, headers bool) (*electrumXConn, chan electrumXResult, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for x.conn == nil && !x.closed {
+		if x.dialing != nil {
+			// wait for the dial in progress
+			dialing := x.dialing
+			x.mu.Unlock()
+			<-dialing.done
+			x.mu.Lock()
+			if dialing.err != nil {
+				return nil, nil, dialing.err
+			}
+			continue
+		}
+
+		err := x.redial()
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	defer utils.IgnoreErrorOn(conn.Close)
+
+	if x.closed {
+		return nil, nil, ErrElectrumXClosed
+	}
+	c := x.conn
+
+	x.nextID++
+	request.ID = x.nextID
 
 	// encode request
 	requestBytes, err := json.Marshal(request)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to encode request")
+		return nil, nil, errors.Wrap(err, "failed to encode request")
+	}
+
+	// write request, a stalled server must not block mu for good
+	utils.IgnoreError(c.conn.SetWriteDeadline(time.Now().Add(ElectrumXCallTimeout)))
+	_, err = c.writer.Write(requestBytes)
+	if err == nil {
+		err = c.writer.WriteByte('\n') // new line
+	}
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		x.drop(c, err)
+		return nil, nil, errors.Wrap(err, "failed to write request")
+	}
+
+	results := make(chan electrumXResult, 1)
+	c.pending[request.ID] = results
+	if headers {
+		c.headerIDs[request.ID] = true
+	}
+	x.lastCall = time.Now()
+	return c, results, nil
+}
+
+// redial dials the server without holding mu, so that dialing does not block
+// other calls or Close, and connects to it. Calls arriving meanwhile wait for
+// the dial. The caller must hold mu.
+func (x *ElectrumX) redial() error {
+	dialing := &electrumXDial{done: make(chan struct{})}
+	x.dialing = dialing
+	x.mu.Unlock()
+	conn, err := x.dial()
+	x.mu.Lock()
+	x.dialing = nil
+	defer close(dialing.done)
+
+	if err != nil {
+		dialing.err = errors.Wrap(err, "failed to dial")
+		return dialing.err
+	}
+
+	if x.closed {
+		utils.IgnoreError(conn.Close())
+		return nil
+	}
+
+	x.connect(conn)
+	return nil
+}
+
+// connect starts reading responses from and pinging the newly dialed
+// connection. The caller must hold mu.
+func (x *ElectrumX) connect(conn net.Conn) {
+	c := &electrumXConn{
+		conn:      conn,
+		writer:    bufio.NewWriter(conn),
+		pending:   make(map[int]chan electrumXResult),
+		headerIDs: make(map[int]bool),
+		done:      make(chan struct{}),
+	}
+	x.conn = c
+	x.lastCall = time.Now()
+
+	go x.read(c)
+	go x.keepAlive(c)
+	if x.resubscribe || len(x.scriptHashes) > 0 {
+		// subscriptions do not outlive the connection
+		scriptHashes := make([]string, 0, len(x.scriptHashes))
+		for scriptHash := range x.scriptHashes {
+			scriptHashes = append(scriptHashes, scriptHash)
+		}
+		go x.renewSubscriptions(c, x.resubscribe, scriptHashes)
+	}
+}
+
+// renewSubscriptions subscribes again on the new connection c. The statuses
+// of the script hashes are delivered as they may have changed while not
+// connected.
+func (x *ElectrumX) renewSubscriptions(c *electrumXConn, headers bool, scriptHashes []string) {
+	var err error
+	if headers {
+		err = x.subscribeHeaders()
+	}
+	for _, scriptHash := range scriptHashes {
+		if err != nil {
+			break
+		}
+
+		var status string
+		status, err = x.subscribeScriptHash(scriptHash)
+		if err == nil {
+			x.deliverStatus(ElectrumXStatus{ScriptHash: scriptHash, Status: status})
+		}
+	}
+
+	if err != nil {
+		// without them the subscriber would miss changes silently
+		x.mu.Lock()
+		x.drop(c, err)
+		x.mu.Unlock()
+	}
+}
+
+// SubscribeHeaders subscribes to the headers of new chain tips. The returned
+// channel receives the current tip right away and every new tip after it.
+// Tips are not delivered while the channel is full. The subscription is
+// renewed whenever the connection is dialed again, which delivers the tip of
+// that moment.
+func (x *ElectrumX) SubscribeHeaders() (<-chan ElectrumXHeader, error) {
+	x.mu.Lock()
+	if x.headers == nil {
+		x.headers = make(chan ElectrumXHeader, 16)
 	}
+	headers := x.headers
+	x.mu.Unlock()
 
-	// write request
-	encoder := bufio.NewWriter(conn)
-	_, err = encoder.Write(requestBytes)
+	err := x.subscribeHeaders()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to write request")
+		return nil, err
+	}
+
+	return headers, nil // OK
+}
+
+// subscribeHeaders subscribes on the current connection, the current tip is
+// delivered by read
+func (x *ElectrumX) subscribeHeaders() error {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-headers-subscribe
+	resp, err := x.call(jsonrpc.NewRequest("blockchain.headers.subscribe"), true)
+	if err == nil && resp.Error != nil {
+		err = resp.Error
 	}
-	err = encoder.WriteByte('\n') // new line
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to write request EOL")
+		return errors.Wrap(err, "failed to subscribe to headers")
+	}
+
+	x.mu.Lock()
+	x.resubscribe = true
+	x.mu.Unlock()
+	return nil // OK
+}
+
+// deliverHeader passes header on to the subscriber unless its channel is full
+func (x *ElectrumX) deliverHeader(header ElectrumXHeader) {
+	x.mu.Lock()
+	headers := x.headers
+	x.mu.Unlock()
+	if headers == nil {
+		return
+	}
+
+	select {
+	case headers <- header:
+	default:
 	}
-	err = encoder.Flush()
+}
+
+// SubscribeScriptHash subscribes to the status of scriptHash and returns the
+// current one. Changes are delivered to the channel of ScriptHashStatuses.
+// The subscription is renewed whenever the connection is dialed again, which
+// delivers the status of that moment.
+func (x *ElectrumX) SubscribeScriptHash(scriptHash string) (string, error) {
+	status, err := x.subscribeScriptHash(scriptHash)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to flush request")
+		return "", err
 	}
 
-	// read response
-	decoder := bufio.NewReader(conn)
-	responseBytes, err := decoder.ReadBytes('\n')
+	x.mu.Lock()
+	if x.scriptHashes == nil {
+		x.scriptHashes = make(map[string]bool)
+	}
+	x.scriptHashes[scriptHash] = true
+	x.mu.Unlock()
+	return status, nil // OK
+}
+
+// subscribeScriptHash subscribes on the current connection
+func (x *ElectrumX) subscribeScriptHash(scriptHash string) (string, error) {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-scripthash-subscribe
+	resp, err := x.call(jsonrpc.NewRequest("blockchain.scripthash.subscribe", scriptHash), false)
+	if err == nil && resp.Error != nil {
+		err = resp.Error
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read response")
+		return "", errors.Wrap(err, "failed to subscribe to script hash")
 	}
 
-	// decode response
-	var response jsonrpc.RPCResponse
-	err = json.Unmarshal(responseBytes, &response)
+	var status *string
+	err = resp.GetObject(&status)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode response")
+		return "", errors.Wrap(err, "failed to decode status")
+	}
+	if status == nil {
+		return "", nil // no history
+	}
+
+	return *status, nil // OK
+}
+
+// ScriptHashStatuses returns the channel the changed statuses of the
+// subscribed script hashes are delivered to. Statuses are not delivered while
+// the channel is full.
+func (x *ElectrumX) ScriptHashStatuses() <-chan ElectrumXStatus {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.statuses == nil {
+		x.statuses = make(chan ElectrumXStatus, 256)
+	}
+
+	return x.statuses
+}
+
+// deliverStatus passes status on to the subscriber unless its channel is full
+func (x *ElectrumX) deliverStatus(status ElectrumXStatus) {
+	x.mu.Lock()
+	statuses := x.statuses
+	x.mu.Unlock()
+	if statuses == nil {
+		return
+	}
+
+	select {
+	case statuses <- status:
+	default:
+	}
+}
+
+// notify passes the headers and statuses of notification on to the
+// subscribers
+func (x *ElectrumX) notify(notification electrumXNotification) {
+	switch notification.Method {
+	case "blockchain.headers.subscribe":
+		var headers []ElectrumXHeader
+		if json.Unmarshal(notification.Params, &headers) == nil {
+			for _, header := range headers {
+				x.deliverHeader(header)
+			}
+		}
+	case "blockchain.scripthash.subscribe":
+		var params [2]*string // script hash and status
+		if json.Unmarshal(notification.Params, &params) == nil && params[0] != nil {
+			status := ElectrumXStatus{ScriptHash: *params[0]}
+			if params[1] != nil {
+				status.Status = *params[1]
+			}
+			x.deliverStatus(status)
+		}
+	}
+}
+
+// read delivers the responses read from c to the calls waiting for them until
+// the connection breaks. Header and script hash notifications are passed on
+// to the subscribers, other notifications and responses nobody waits for
+// anymore are skipped.
+func (x *ElectrumX) read(c *electrumXConn) {
+	decoder := bufio.NewReader(c.conn)
+	for {
+		responseBytes, err := decoder.ReadBytes('\n')
+		if err != nil {
+			x.mu.Lock()
+			x.drop(c, errors.Wrap(err, "failed to read response"))
+			x.mu.Unlock()
+			return
+		}
+
+		// notifications carry a method instead of an ID
+		var notification electrumXNotification
+		err = json.Unmarshal(responseBytes, &notification)
+		if err == nil && notification.Method != "" {
+			x.notify(notification)
+			continue
+		}
+
+		// decode response
+		var response jsonrpc.RPCResponse
+		err = json.Unmarshal(responseBytes, &response)
+		if err != nil {
+			continue
+		}
+
+		x.mu.Lock()
+		results, ok := c.pending[response.ID]
+		headers := c.headerIDs[response.ID]
+		delete(c.pending, response.ID)
+		delete(c.headerIDs, response.ID)
+		x.mu.Unlock()
+
+		var tip ElectrumXHeader
+		if headers && response.Error == nil && response.GetObject(&tip) == nil {
+			x.deliverHeader(tip)
+		}
+		if ok {
+			results <- electrumXResult{response: &response}
+		}
+	}
+}
+
+// keepAlive pings the server whenever c was idle for ElectrumXPingInterval
+func (x *ElectrumX) keepAlive(c *electrumXConn) {
+	ticker := time.NewTicker(ElectrumXPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			x.mu.Lock()
+			idle := time.Since(x.lastCall) >= ElectrumXPingInterval
+			x.mu.Unlock()
+			if !idle {
+				continue
+			}
+
+			// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#server-ping
+			_, err := x.Call("server.ping")
+			if err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
 	}
+}
+
+// forget stops waiting for the response to the request with the given ID
+func (x *ElectrumX) forget(c *electrumXConn, id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// drop closes c and fails the calls waiting on it with err, the next call
+// dials a new connection. The caller must hold mu.
+func (x *ElectrumX) drop(c *electrumXConn, err error) {
+	if x.conn == c {
+		x.conn = nil
+	}
+
+	select {
+	case <-c.done:
+		return // dropped already
+	default:
+	}
+
+	close(c.done)
+	utils.IgnoreError(c.conn.Close())
+	for id, results := range c.pending {
+		results <- electrumXResult{err: err}
+		delete(c.pending, id)
+	}
+}
+
+// Close closes the connection, calls made afterwards fail
+func (x *ElectrumX) Close() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
 
-	return &response, nil // OK
+	x.closed = true
+	if x.conn != nil {
+		x.drop(x.conn, ErrElectrumXClosed)
+	}
 }
 
 // CallFor helper method to call method and extract response
@@ -133,21 +628,21 @@ func (x *ElectrumX) CallBatchRaw(requests jsonrpc.RPCRequests) (jsonrpc.RPCRespo
 // default BTC network
 var btcDefaultNet = &chaincfg.MainNetParams
 
-// createElectrumXScriptHash
+// createElectrumXScriptHash returns the script hash of address on the network
+// of params
 // https://electrumx.readthedocs.io/en/latest/protocol-basics.html#script-hashes
-func createElectrumXScriptHash(address string) (string, error) {
-	// decode address
-	decodedAddress, err := btcutil.DecodeAddress(address, btcDefaultNet)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to decode address")
-	}
-
+func createElectrumXScriptHash(address string, params *chaincfg.Params) (string, error) {
 	// Create public key script
-	script, err := txscript.PayToAddrScript(decodedAddress)
+	script, err := payToAddrScript(address, params)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create script")
 	}
 
+	return electrumXScriptHash(script), nil // OK
+}
+
+// electrumXScriptHash returns the script hash of script
+func electrumXScriptHash(script []byte) string {
 	// Apply SHA256
 	hash := sha256.Sum256(script)
 
@@ -158,7 +653,7 @@ func createElectrumXScriptHash(address string) (string, error) {
 		hash[i], hash[k] = hash[k], hash[i]
 	}
 
-	return hex.EncodeToString(hash[:]), nil // OK
+	return hex.EncodeToString(hash[:])
 }
 
 // basicAuth converts username and password to base64-encoded string
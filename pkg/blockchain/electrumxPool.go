@@ -0,0 +1,427 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/ybbus/jsonrpc"
+)
+
+var (
+	// ElectrumXCheckInterval is how often the pool checks the latency and
+	// tip height of its servers
+	ElectrumXCheckInterval = time.Minute
+
+	// ElectrumXMaxLag is the number of blocks a server may be behind the
+	// highest server and still be preferred
+	ElectrumXMaxLag int64 = 1
+
+	// ElectrumXCooldown is for how long a server that failed is only used if
+	// no other server is left
+	ElectrumXCooldown = 30 * time.Second
+
+	// ErrNoElectrumXServers is returned if a pool is created without servers
+	ErrNoElectrumXServers = errors.New("no electrumx servers configured")
+)
+
+// ElectrumXHealth describes the state of a server of an ElectrumXPool
+type ElectrumXHealth struct {
+	URL     string        `json:"url"`
+	Height  int64         `json:"height"`  // last checked tip height, 0 if unknown
+	Latency time.Duration `json:"latency"` // moving average of the calls
+	Healthy bool          `json:"healthy"`
+
+	LastError      string    `json:"lastError,omitempty"`
+	SuspendedUntil time.Time `json:"suspendedUntil"` // zero if the server is not suspended
+}
+
+// electrumXPoolServer is a server of an ElectrumXPool
+type electrumXPoolServer struct {
+	client jsonrpc.RPCClient
+	health ElectrumXHealth
+}
+
+// ElectrumXPool spreads calls over several Electrum servers. Calls go to the
+// fastest healthy server that is in sync with the others and fail over to the
+// next one on connection errors. Errors returned by a server are not retried.
+type ElectrumXPool struct {
+	servers      []*electrumXPoolServer
+	done         chan struct{}
+	closeOnce    sync.Once
+	statuses     chan ElectrumXStatus // merged from the servers
+	statusesOnce sync.Once
+	mu           sync.Mutex
+}
+
+// NewElectrumXPool creates a pool of the servers at targetURLs, see
+// NewElectrumX, and starts checking them every ElectrumXCheckInterval. The
+// pool must be closed to stop the checks.
+func NewElectrumXPool(targetURLs []string) (*ElectrumXPool, error) {
+	return NewElectrumXPoolWithProxy(targetURLs, nil)
+}
+
+// NewElectrumXPoolWithProxy creates a pool like NewElectrumXPool connecting
+// to the servers through proxy, nil connects directly
+func NewElectrumXPoolWithProxy(targetURLs []string, proxy *utils.Proxy) (*ElectrumXPool, error) {
+	clients := make([]jsonrpc.RPCClient, len(targetURLs))
+	for i, targetURL := range targetURLs {
+		client, err := NewElectrumXWithProxy(targetURL, proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create client for %s", targetURL)
+		}
+
+		clients[i] = client
+	}
+
+	pool, err := newElectrumXPool(targetURLs, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.Check()
+	go pool.run()
+	return pool, nil // OK
+}
+
+func newElectrumXPool(targetURLs []string, clients []jsonrpc.RPCClient) (*ElectrumXPool, error) {
+	if len(clients) == 0 {
+		return nil, ErrNoElectrumXServers
+	}
+
+	servers := make([]*electrumXPoolServer, len(clients))
+	for i, client := range clients {
+		servers[i] = &electrumXPoolServer{
+			client: client,
+			health: ElectrumXHealth{URL: targetURLs[i], Healthy: true},
+		}
+	}
+
+	return &ElectrumXPool{
+		servers: servers,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (p *ElectrumXPool) run() {
+	ticker := time.NewTicker(ElectrumXCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Check()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Check measures the latency and tip height of every server
+func (p *ElectrumXPool) Check() {
+	var wg sync.WaitGroup
+	for _, server := range p.servers {
+		wg.Add(1)
+		go func(server *electrumXPoolServer) {
+			defer wg.Done()
+
+			// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-headers-subscribe
+			var tip struct {
+				Height int64 `json:"height"`
+			}
+			start := time.Now()
+			err := server.client.CallFor(&tip, "blockchain.headers.subscribe")
+			p.record(server, time.Since(start), err)
+			if err != nil {
+				return
+			}
+
+			p.mu.Lock()
+			server.health.Height = tip.Height
+			p.mu.Unlock()
+		}(server)
+	}
+	wg.Wait()
+}
+
+// record updates the health of server with the outcome of a call that took d
+func (p *ElectrumXPool) record(server *electrumXPoolServer, d time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		server.health.Healthy = false
+		server.health.LastError = err.Error()
+		server.health.SuspendedUntil = time.Now().Add(ElectrumXCooldown)
+		return
+	}
+
+	server.health.Healthy = true
+	server.health.SuspendedUntil = time.Time{}
+	if server.health.Latency == 0 {
+		server.health.Latency = d
+	} else {
+		server.health.Latency = (7*server.health.Latency + 3*d) / 10
+	}
+}
+
+// pick returns the server to call next, skipping the tried ones. Healthy
+// servers at most ElectrumXMaxLag blocks behind the highest one are preferred,
+// the fastest of them first. Once those are tried the other servers are, so
+// that a call only fails if every server failed. nil is returned if every
+// server was tried.
+func (p *ElectrumXPool) pick(tried map[*electrumXPoolServer]bool, now time.Time) *electrumXPoolServer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best int64
+	for _, server := range p.servers {
+		if server.health.Height > best {
+			best = server.health.Height
+		}
+	}
+
+	var preferred, fallback *electrumXPoolServer
+	for _, server := range p.servers {
+		if tried[server] {
+			continue
+		}
+
+		inSync := best-server.health.Height <= ElectrumXMaxLag
+		available := server.health.Healthy || now.After(server.health.SuspendedUntil)
+		if inSync && available {
+			if preferred == nil || server.health.Latency < preferred.health.Latency {
+				preferred = server
+			}
+			continue
+		}
+
+		if fallback == nil {
+			fallback = server
+		}
+	}
+
+	if preferred != nil {
+		return preferred
+	}
+
+	return fallback
+}
+
+// call calls fn with the picked servers until it succeeds or every server
+// failed, the error of the last server is returned then
+func (p *ElectrumXPool) call(fn func(client jsonrpc.RPCClient) error) error {
+	tried := make(map[*electrumXPoolServer]bool)
+	var err error
+	for {
+		server := p.pick(tried, time.Now())
+		if server == nil {
+			return err
+		}
+		tried[server] = true
+
+		start := time.Now()
+		err = fn(server.client)
+		if _, ok := err.(*jsonrpc.RPCError); ok {
+			// the server answered, the call itself failed
+			p.record(server, time.Since(start), nil)
+			return err
+		}
+
+		p.record(server, time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+	}
+}
+
+// Call do JSON RPC call (helper)
+func (p *ElectrumXPool) Call(method string, params ...interface{}) (*jsonrpc.RPCResponse, error) {
+	return p.CallRaw(jsonrpc.NewRequest(method, params...))
+}
+
+// CallRaw do JSON RPC call on the picked server
+func (p *ElectrumXPool) CallRaw(request *jsonrpc.RPCRequest) (*jsonrpc.RPCResponse, error) {
+	var response *jsonrpc.RPCResponse
+	err := p.call(func(client jsonrpc.RPCClient) (err error) {
+		response, err = client.CallRaw(request)
+		return err
+	})
+
+	return response, err
+}
+
+// CallFor helper method to call method and extract response
+func (p *ElectrumXPool) CallFor(out interface{}, method string, params ...interface{}) error {
+	return p.call(func(client jsonrpc.RPCClient) error {
+		return client.CallFor(out, method, params...)
+	})
+}
+
+// CallBatch do JSON RPC batch call on the picked server
+func (p *ElectrumXPool) CallBatch(requests jsonrpc.RPCRequests) (jsonrpc.RPCResponses, error) {
+	return p.CallBatchRaw(requests)
+}
+
+// CallBatchRaw do JSON RPC batch call on the picked server
+func (p *ElectrumXPool) CallBatchRaw(requests jsonrpc.RPCRequests) (jsonrpc.RPCResponses, error) {
+	var responses jsonrpc.RPCResponses
+	err := p.call(func(client jsonrpc.RPCClient) (err error) {
+		responses, err = client.CallBatchRaw(requests)
+		return err
+	})
+
+	return responses, err
+}
+
+// SubscribeHeaders subscribes to the headers of new chain tips on every
+// server of the pool that supports it, see ElectrumX.SubscribeHeaders. The
+// returned channel receives each tip once, from whichever server announces it
+// first. An error is only returned if no server could be subscribed to.
+func (p *ElectrumXPool) SubscribeHeaders() (<-chan ElectrumXHeader, error) {
+	merged := make(chan ElectrumXHeader, 16)
+	var last ElectrumXHeader
+	var lastMu sync.Mutex
+	var err error
+	subscribed := 0
+	for _, server := range p.servers {
+		x, ok := server.client.(*ElectrumX)
+		if !ok {
+			continue
+		}
+
+		var headers <-chan ElectrumXHeader
+		headers, err = x.SubscribeHeaders()
+		if err != nil {
+			continue
+		}
+		subscribed++
+
+		go func() {
+			for {
+				select {
+				case header := <-headers:
+					lastMu.Lock()
+					isNew := header.Height > last.Height || (header.Height == last.Height && header.Hex != last.Hex)
+					if isNew {
+						last = header
+					}
+					lastMu.Unlock()
+
+					if isNew {
+						select {
+						case merged <- header:
+						default:
+						}
+					}
+				case <-p.done:
+					return
+				}
+			}
+		}()
+	}
+
+	if subscribed == 0 {
+		if err == nil {
+			err = errors.New("no server supports header subscriptions")
+		}
+		return nil, err
+	}
+
+	return merged, nil // OK
+}
+
+// SubscribeScriptHash subscribes to the status of scriptHash on every server
+// of the pool that supports it, see ElectrumX.SubscribeScriptHash, and
+// returns the status of the first of them. Changes announced by any server are
+// delivered to the channel of ScriptHashStatuses. An error is only returned if
+// no server could be subscribed to.
+func (p *ElectrumXPool) SubscribeScriptHash(scriptHash string) (string, error) {
+	p.ScriptHashStatuses()
+
+	var status string
+	var err error
+	subscribed := 0
+	for _, server := range p.servers {
+		x, ok := server.client.(*ElectrumX)
+		if !ok {
+			continue
+		}
+
+		serverStatus, serverErr := x.SubscribeScriptHash(scriptHash)
+		if serverErr != nil {
+			err = serverErr
+			continue
+		}
+		if subscribed == 0 {
+			status = serverStatus
+		}
+		subscribed++
+	}
+
+	if subscribed == 0 {
+		if err == nil {
+			err = errors.New("no server supports script hash subscriptions")
+		}
+		return "", err
+	}
+
+	return status, nil // OK
+}
+
+// ScriptHashStatuses returns the channel the changed statuses of the
+// subscribed script hashes are delivered to from every server. A change may
+// be delivered once per server.
+func (p *ElectrumXPool) ScriptHashStatuses() <-chan ElectrumXStatus {
+	p.statusesOnce.Do(func() {
+		p.statuses = make(chan ElectrumXStatus, 256)
+		for _, server := range p.servers {
+			x, ok := server.client.(*ElectrumX)
+			if !ok {
+				continue
+			}
+
+			go func(statuses <-chan ElectrumXStatus) {
+				for {
+					select {
+					case status := <-statuses:
+						select {
+						case p.statuses <- status:
+						default:
+						}
+					case <-p.done:
+						return
+					}
+				}
+			}(x.ScriptHashStatuses())
+		}
+	})
+
+	return p.statuses
+}
+
+// Health returns the state of the servers
+func (p *ElectrumXPool) Health() []ElectrumXHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	health := make([]ElectrumXHealth, len(p.servers))
+	for i, server := range p.servers {
+		health[i] = server.health
+	}
+
+	return health
+}
+
+// Close stops the checks and closes the connections to the servers
+func (p *ElectrumXPool) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		for _, server := range p.servers {
+			if x, ok := server.client.(*ElectrumX); ok {
+				x.Close()
+			}
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// fakeElectrumX answers every call with height, or fails with err
+type fakeElectrumX struct {
+	jsonrpc.RPCClient
+	height int64
+	err    error
+	calls  int
+}
+
+func (f *fakeElectrumX) CallFor(out interface{}, method string, params ...interface{}) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+
+	response := jsonrpc.RPCResponse{Result: map[string]int64{"height": f.height}}
+	return response.GetObject(out)
+}
+
+func TestElectrumXPoolPrefersInSyncServers(t *testing.T) {
+	// arrange
+	lagging := &fakeElectrumX{height: 90}
+	synced := &fakeElectrumX{height: 100}
+	pool, err := newElectrumXPool([]string{"lagging", "synced"}, []jsonrpc.RPCClient{lagging, synced})
+	require.NoError(t, err)
+	pool.Check()
+
+	// act
+	var tip struct{ Height int64 }
+	err = pool.CallFor(&tip, "blockchain.headers.subscribe")
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), tip.Height)
+	assert.Equal(t, 1, lagging.calls)
+	assert.Equal(t, 2, synced.calls)
+}
+
+func TestElectrumXPoolFailsOver(t *testing.T) {
+	// arrange
+	flaky := &fakeElectrumX{height: 100}
+	backup := &fakeElectrumX{height: 100}
+	pool, err := newElectrumXPool([]string{"flaky", "backup"}, []jsonrpc.RPCClient{flaky, backup})
+	require.NoError(t, err)
+	pool.Check()
+	pool.servers[0].health.Latency = time.Millisecond
+	pool.servers[1].health.Latency = time.Second
+	flaky.err = errors.New("connection reset")
+
+	// act
+	var tip struct{ Height int64 }
+	errs := make([]error, 3)
+	for i := range errs {
+		errs[i] = pool.CallFor(&tip, "blockchain.headers.subscribe")
+	}
+
+	// assert
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	health := pool.Health()
+	assert.False(t, health[0].Healthy)
+	assert.Equal(t, "connection reset", health[0].LastError)
+	assert.True(t, health[0].SuspendedUntil.After(time.Now()))
+	assert.True(t, health[1].Healthy)
+}
+
+func TestElectrumXPoolReturnsServerErrors(t *testing.T) {
+	// arrange
+	first := &fakeElectrumX{err: &jsonrpc.RPCError{Code: 1, Message: "unknown method"}}
+	second := &fakeElectrumX{}
+	pool, err := newElectrumXPool([]string{"first", "second"}, []jsonrpc.RPCClient{first, second})
+	require.NoError(t, err)
+
+	// act
+	err = pool.CallFor(nil, "unknown")
+
+	// assert
+	assert.Equal(t, first.err, err)
+	assert.Equal(t, 0, second.calls)
+	assert.True(t, pool.Health()[0].Healthy)
+}
@@ -0,0 +1,348 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// electrumXServer answers the requests on the connections it is dialed for
+// with their params, the first pair of requests in reverse order
+type electrumXServer struct {
+	dials int
+	mu    sync.Mutex
+}
+
+func (s *electrumXServer) dial() (net.Conn, error) {
+	s.mu.Lock()
+	s.dials++
+	s.mu.Unlock()
+
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *electrumXServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	var held []*jsonrpc.RPCRequest
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var request jsonrpc.RPCRequest
+		if json.Unmarshal(line, &request) != nil {
+			return
+		}
+		if request.Method == "disconnect" {
+			return
+		}
+
+		held = append(held, &request)
+		if len(held) < 2 {
+			continue
+		}
+
+		for i := len(held) - 1; i >= 0; i-- {
+			params := held[i].Params.([]interface{})
+			response, _ := json.Marshal(jsonrpc.RPCResponse{JSONRPC: "2.0", ID: held[i].ID, Result: params[0]})
+			_, err := conn.Write(append(response, '\n'))
+			if err != nil {
+				return
+			}
+		}
+		held = nil
+	}
+}
+
+func TestElectrumXPipelinesCalls(t *testing.T) {
+	// arrange
+	server := &electrumXServer{}
+	x := &ElectrumX{dial: server.dial}
+	defer x.Close()
+
+	// act
+	results := make([]string, 2)
+	var wg sync.WaitGroup
+	for i, param := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(i int, param string) {
+			defer wg.Done()
+			assert.NoError(t, x.CallFor(&results[i], "echo", param))
+		}(i, param)
+	}
+	wg.Wait()
+
+	// assert
+	assert.Equal(t, []string{"first", "second"}, results)
+	assert.Equal(t, 1, server.dials)
+}
+
+func TestElectrumXReconnects(t *testing.T) {
+	// arrange
+	server := &electrumXServer{}
+	x := &ElectrumX{dial: server.dial}
+	defer x.Close()
+
+	_, err := x.Call("disconnect", "")
+	require.Error(t, err)
+
+	// act
+	results := make([]string, 2)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, x.CallFor(&results[i], "echo", "again"))
+		}(i)
+	}
+	wg.Wait()
+
+	// assert
+	assert.Equal(t, []string{"again", "again"}, results)
+	assert.Equal(t, 2, server.dials)
+}
+
+func TestElectrumXClosesWhileDialing(t *testing.T) {
+	// arrange
+	dialed, release := make(chan struct{}), make(chan struct{})
+	x := &ElectrumX{dial: func() (net.Conn, error) {
+		close(dialed)
+		<-release
+		return (&electrumXServer{}).dial()
+	}}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := x.Call("echo", "")
+		errs <- err
+	}()
+	<-dialed
+	go func() {
+		_, err := x.Call("echo", "")
+		errs <- err
+	}()
+
+	// act
+	x.Close()
+	close(release)
+
+	// assert
+	assert.Equal(t, ErrElectrumXClosed, <-errs)
+	assert.Equal(t, ErrElectrumXClosed, <-errs)
+}
+
+func TestElectrumXWriteTimesOut(t *testing.T) {
+	// arrange, the server never reads the requests
+	defer func(timeout time.Duration) { ElectrumXCallTimeout = timeout }(ElectrumXCallTimeout)
+	ElectrumXCallTimeout = 50 * time.Millisecond
+	x := &ElectrumX{dial: func() (net.Conn, error) {
+		client, _ := net.Pipe()
+		return client, nil
+	}}
+	defer x.Close()
+
+	// act
+	_, err := x.Call("echo", "")
+
+	// assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write request")
+	x.mu.Lock()
+	assert.Nil(t, x.conn)
+	x.mu.Unlock()
+}
+
+func TestElectrumXFailsAfterClose(t *testing.T) {
+	// arrange
+	x := &ElectrumX{dial: (&electrumXServer{}).dial}
+
+	// act
+	x.Close()
+	_, err := x.Call("echo", "")
+
+	// assert
+	assert.Equal(t, ErrElectrumXClosed, err)
+}
+
+// headerServer answers header subscriptions with tip and announces the next
+// block right after
+type headerServer struct {
+	tip int64
+	mu  sync.Mutex
+}
+
+func (s *headerServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *headerServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var request jsonrpc.RPCRequest
+		if json.Unmarshal(line, &request) != nil || request.Method != "blockchain.headers.subscribe" {
+			return
+		}
+
+		s.mu.Lock()
+		tip := s.tip
+		s.tip++
+		s.mu.Unlock()
+
+		response, _ := json.Marshal(jsonrpc.RPCResponse{JSONRPC: "2.0", ID: request.ID, Result: ElectrumXHeader{Height: tip}})
+		params, _ := json.Marshal([]ElectrumXHeader{{Height: tip + 1}})
+		notification, _ := json.Marshal(electrumXNotification{Method: request.Method, Params: params})
+		_, err = conn.Write(append(append(response, '\n'), append(notification, '\n')...))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestElectrumXDeliversHeaders(t *testing.T) {
+	// arrange
+	x := &ElectrumX{dial: (&headerServer{tip: 100}).dial}
+	defer x.Close()
+
+	// act
+	headers, err := x.SubscribeHeaders()
+	require.NoError(t, err)
+	first, announced := <-headers, <-headers
+
+	// assert
+	assert.Equal(t, int64(100), first.Height)
+	assert.Equal(t, int64(101), announced.Height)
+}
+
+// scriptHashServer answers script hash subscriptions with the status "old"
+// and announces the status "new" right after. Scripts hashes starting with 0
+// have no history.
+type scriptHashServer struct{}
+
+func (s scriptHashServer) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+func (s scriptHashServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		var request jsonrpc.RPCRequest
+		if json.Unmarshal(line, &request) != nil || request.Method != "blockchain.scripthash.subscribe" {
+			return
+		}
+
+		scriptHash := request.Params.([]interface{})[0].(string)
+		var status interface{} = "old"
+		if scriptHash[0] == '0' {
+			status = nil
+		}
+
+		response, _ := json.Marshal(jsonrpc.RPCResponse{JSONRPC: "2.0", ID: request.ID, Result: status})
+		params, _ := json.Marshal([]string{scriptHash, "new"})
+		notification, _ := json.Marshal(electrumXNotification{Method: request.Method, Params: params})
+		_, err = conn.Write(append(append(response, '\n'), append(notification, '\n')...))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestElectrumXDeliversScriptHashStatuses(t *testing.T) {
+	// arrange
+	x := &ElectrumX{dial: scriptHashServer{}.dial}
+	defer x.Close()
+	statuses := x.ScriptHashStatuses()
+
+	// act
+	status, err := x.SubscribeScriptHash("abc")
+	require.NoError(t, err)
+	empty, err := x.SubscribeScriptHash("0ab")
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, "old", status)
+	assert.Equal(t, "", empty)
+	assert.Equal(t, ElectrumXStatus{ScriptHash: "abc", Status: "new"}, <-statuses)
+	assert.Equal(t, ElectrumXStatus{ScriptHash: "0ab", Status: "new"}, <-statuses)
+}
+
+func TestElectrumXRenewsScriptHashSubscriptions(t *testing.T) {
+	// arrange
+	x := &ElectrumX{dial: scriptHashServer{}.dial}
+	defer x.Close()
+	statuses := x.ScriptHashStatuses()
+	_, err := x.SubscribeScriptHash("abc")
+	require.NoError(t, err)
+	<-statuses
+
+	conn, err := scriptHashServer{}.dial()
+	require.NoError(t, err)
+
+	// act
+	x.mu.Lock()
+	x.drop(x.conn, errors.New("broken"))
+	x.connect(conn)
+	x.mu.Unlock()
+
+	// assert, the status of the renewed subscription and the change in any
+	// order
+	renewed := []ElectrumXStatus{<-statuses, <-statuses}
+	assert.ElementsMatch(t, []ElectrumXStatus{
+		{ScriptHash: "abc", Status: "old"},
+		{ScriptHash: "abc", Status: "new"},
+	}, renewed)
+}
+
+func TestElectrumXPoolMergesHeaders(t *testing.T) {
+	// arrange
+	first := &ElectrumX{dial: (&headerServer{tip: 100}).dial}
+	second := &ElectrumX{dial: (&headerServer{tip: 100}).dial}
+	pool, err := newElectrumXPool([]string{"first", "second"}, []jsonrpc.RPCClient{first, second})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	// act
+	headers, err := pool.SubscribeHeaders()
+	require.NoError(t, err)
+	tip, next := <-headers, <-headers
+
+	// assert
+	assert.Equal(t, int64(100), tip.Height)
+	assert.Equal(t, int64(101), next.Height)
+	select {
+	case duplicate := <-headers:
+		t.Fatalf("header %d delivered twice", duplicate.Height)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
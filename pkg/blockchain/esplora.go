@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	// EsploraCallTimeout is how long a request to an esplora API may take
+	EsploraCallTimeout = 30 * time.Second
+)
+
+// EsploraUTXOManager looks up UTXOs on the REST API of an esplora instance,
+// e.g. https://blockstream.info/api or https://mempool.space/api. It needs
+// neither an Electrum server nor a node.
+type EsploraUTXOManager struct {
+	baseURL    string
+	httpClient *http.Client
+	params     *chaincfg.Params // network of the addresses
+}
+
+// NewEsploraUTXOManager creates a UTXOManager for addresses of the network of
+// params using the esplora API at baseURL
+func NewEsploraUTXOManager(baseURL string, params *chaincfg.Params) *EsploraUTXOManager {
+	return &EsploraUTXOManager{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: EsploraCallTimeout},
+		params:     params,
+	}
+}
+
+// GetUTXOs gets all UTXOs of a given address
+func (e *EsploraUTXOManager) GetUTXOs(address string) ([]*common.UTXO, error) {
+	script, err := payToAddrScript(address, e.params)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create script")
+	}
+
+	// https://github.com/Blockstream/esplora/blob/master/API.md#get-addressaddressutxo
+	type UTXO struct {
+		TxID   string `json:"txid"`
+		Vout   int64  `json:"vout"`
+		Value  int64  `json:"value"` // satoshis
+		Status struct {
+			Confirmed   bool  `json:"confirmed"`
+			BlockHeight int64 `json:"block_height"`
+		} `json:"status"`
+	}
+
+	var eutxos []UTXO
+	err = e.getJSON(fmt.Sprintf("/address/%s/utxo", address), &eutxos)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get UTXOs from esplora")
+	}
+
+	tip, err := e.tipHeight()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tip height from esplora")
+	}
+
+	// copy UTXOs
+	utxos := make([]*common.UTXO, 0, len(eutxos))
+	for _, u := range eutxos {
+		utxo := &common.UTXO{
+			Index:        big.NewInt(u.Vout),
+			Value:        u.Value,
+			Hash:         u.TxID,
+			ScriptPubKey: hex.EncodeToString(script),
+			ScriptType:   scriptType(script),
+		}
+		if u.Status.Confirmed {
+			utxo.Height = u.Status.BlockHeight
+			utxo.Confirmations = tip - u.Status.BlockHeight + 1
+		}
+
+		// only outputs of recent coinbase txs are immature, the tx is not
+		// fetched for the others
+		if utxo.Confirmations > 0 && utxo.Confirmations < common.CoinbaseMaturity {
+			utxo.Coinbase, err = e.isCoinbase(u.TxID)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get tx %s from esplora", u.TxID)
+			}
+		}
+
+		// unconfirmed outputs of txs spending from the address are change
+		if utxo.Confirmations == 0 {
+			utxo.FromSelf, err = e.spendsScript(u.TxID, script)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get tx %s from esplora", u.TxID)
+			}
+		}
+
+		utxos = append(utxos, utxo)
+	}
+
+	return utxos, nil // OK
+}
+
+// esploraTx is a tx of the esplora API
+// https://github.com/Blockstream/esplora/blob/master/API.md#get-txtxid
+type esploraTx struct {
+	Vin []struct {
+		IsCoinbase bool `json:"is_coinbase"`
+		Prevout    *struct {
+			ScriptPubKey string `json:"scriptpubkey"` // hex
+		} `json:"prevout"` // nil for coinbase inputs
+	} `json:"vin"`
+}
+
+// isCoinbase reports whether the tx with the given id is a coinbase tx
+func (e *EsploraUTXOManager) isCoinbase(txID string) (bool, error) {
+	var tx esploraTx
+	err := e.getJSON(fmt.Sprintf("/tx/%s", txID), &tx)
+	if err != nil {
+		return false, err
+	}
+
+	return len(tx.Vin) == 1 && tx.Vin[0].IsCoinbase, nil // OK
+}
+
+// spendsScript reports whether the tx with the given id spends an output
+// locked with script
+func (e *EsploraUTXOManager) spendsScript(txID string, script []byte) (bool, error) {
+	var tx esploraTx
+	err := e.getJSON(fmt.Sprintf("/tx/%s", txID), &tx)
+	if err != nil {
+		return false, err
+	}
+
+	scriptPubKey := hex.EncodeToString(script)
+	for _, in := range tx.Vin {
+		if in.Prevout != nil && in.Prevout.ScriptPubKey == scriptPubKey {
+			return true, nil
+		}
+	}
+
+	return false, nil // OK
+}
+
+// tipHeight returns the height of the best block
+func (e *EsploraUTXOManager) tipHeight() (int64, error) {
+	body, err := e.get("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+}
+
+func (e *EsploraUTXOManager) getJSON(path string, out interface{}) error {
+	body, err := e.get(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func (e *EsploraUTXOManager) get(path string) ([]byte, error) {
+	resp, err := e.httpClient.Get(e.baseURL + path)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.IgnoreErrorOn(resp.Body.Close)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("esplora %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil // OK
+}
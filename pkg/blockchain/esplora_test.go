@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEsploraUTXOManagerGetUTXOs(t *testing.T) {
+	// arrange
+	address := "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/address/%s/utxo", address), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"txid":"a","vout":1,"value":5000,"status":{"confirmed":true,"block_height":98}},
+			{"txid":"b","vout":0,"value":700,"status":{"confirmed":false}},
+			{"txid":"c","vout":0,"value":800,"status":{"confirmed":false}}
+		]`)
+	})
+	mux.HandleFunc("/tx/a", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"txid":"a","vin":[{"is_coinbase":true}]}`)
+	})
+	// b is change of a tx spending from the address, c is paid by others
+	mux.HandleFunc("/tx/b", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"txid":"b","vin":[{"prevout":{"scriptpubkey":"0014751e76e8199196d454941c45d1b3a323f1433bd6"}}]}`)
+	})
+	mux.HandleFunc("/tx/c", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"txid":"c","vin":[{"prevout":{"scriptpubkey":"76a914000000000000000000000000000000000000000088ac"}}]}`)
+	})
+	mux.HandleFunc("/blocks/tip/height", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "100")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	utxos, err := NewUTXOManager(UTXOManagerConfig{
+		Backend: UTXOBackendEsplora,
+		URLs:    []string{server.URL + "/"},
+		Params:  &chaincfg.TestNet3Params,
+	})
+	require.NoError(t, err)
+
+	// act
+	set, err := utxos.GetUTXOs(address)
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, set, 3)
+	assert.Equal(t, &common.UTXO{
+		Index:         big.NewInt(1),
+		Value:         5000,
+		Hash:          "a",
+		Height:        98,
+		Confirmations: 3,
+		ScriptPubKey:  "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		ScriptType:    common.ScriptTypeP2WPKH,
+		Coinbase:      true,
+	}, set[0])
+	assert.False(t, set[0].Mature())
+	assert.Equal(t, int64(0), set[1].Confirmations)
+	assert.Equal(t, int64(0), set[1].Height)
+	assert.Equal(t, common.ScriptTypeP2WPKH, set[1].ScriptType)
+	assert.False(t, set[1].Coinbase)
+	assert.True(t, set[1].FromSelf)
+	assert.False(t, set[2].FromSelf)
+}
+
+func TestEsploraUTXOManagerFailsOnErrorStatus(t *testing.T) {
+	// arrange
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	utxos := NewEsploraUTXOManager(server.URL, &chaincfg.MainNetParams)
+
+	// act
+	_, err := utxos.GetUTXOs("1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2")
+
+	// assert
+	assert.Error(t, err)
+}
+
+func TestNewUTXOManagerRejectsUnknownBackend(t *testing.T) {
+	// act
+	_, err := NewUTXOManager(UTXOManagerConfig{Backend: "node"})
+
+	// assert
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/pkg/errors"
+)
+
+// bech32Charset maps the characters of bech32 strings to 5 bit values
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// checksum constants of bech32 (BIP173), used by witness version 0, and of
+// bech32m (BIP350), used by the later witness versions like taproot
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// payToAddrScript returns the scriptPubKey paying to address on the network
+// of params. Segwit addresses of every witness version are supported,
+// including taproot (bech32m) addresses btcutil cannot decode yet.
+func payToAddrScript(address string, params *chaincfg.Params) ([]byte, error) {
+	hrp := strings.ToLower(address)
+	if i := strings.LastIndexByte(hrp, '1'); i > 0 && hrp[:i] == params.Bech32HRPSegwit {
+		return segwitScript(address, params.Bech32HRPSegwit)
+	}
+
+	// decode address
+	decodedAddress, err := btcutil.DecodeAddress(address, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode address")
+	}
+	if !decodedAddress.IsForNet(params) {
+		return nil, errors.Errorf("address %s is not for %s", address, params.Name)
+	}
+
+	// Create public key script
+	return txscript.PayToAddrScript(decodedAddress)
+}
+
+// segwitScript returns the scriptPubKey of the segwit address with the given
+// human readable part: the witness version followed by the witness program
+func segwitScript(address string, hrp string) ([]byte, error) {
+	version, program, err := decodeSegwitAddress(address, hrp)
+	if err != nil {
+		return nil, err
+	}
+
+	opcode := byte(txscript.OP_0)
+	if version > 0 {
+		opcode = txscript.OP_1 + version - 1
+	}
+
+	script := append([]byte{opcode, byte(len(program))}, program...)
+	return script, nil // OK
+}
+
+// scriptType returns the type of the scriptPubKey script
+func scriptType(script []byte) common.ScriptType {
+	switch {
+	case len(script) == 25 && script[0] == txscript.OP_DUP && script[1] == txscript.OP_HASH160 &&
+		script[2] == 20 && script[23] == txscript.OP_EQUALVERIFY && script[24] == txscript.OP_CHECKSIG:
+		return common.ScriptTypeP2PKH
+	case len(script) == 23 && script[0] == txscript.OP_HASH160 && script[1] == 20 && script[22] == txscript.OP_EQUAL:
+		return common.ScriptTypeP2SH
+	case len(script) == 22 && script[0] == txscript.OP_0 && script[1] == 20:
+		return common.ScriptTypeP2WPKH
+	case len(script) == 34 && script[0] == txscript.OP_0 && script[1] == 32:
+		return common.ScriptTypeP2WSH
+	case len(script) == 34 && script[0] == txscript.OP_1 && script[1] == 32:
+		return common.ScriptTypeP2TR
+	}
+
+	return common.ScriptTypeUnknown
+}
+
+// decodeSegwitAddress returns the witness version and program of a segwit
+// address, see BIP173 and BIP350
+func decodeSegwitAddress(address string, hrp string) (byte, []byte, error) {
+	if strings.ToLower(address) != address && strings.ToUpper(address) != address {
+		return 0, nil, errors.New("mixed case address")
+	}
+	address = strings.ToLower(address)
+
+	separator := strings.LastIndexByte(address, '1')
+	if separator < 1 || separator+7 > len(address) || len(address) > 90 {
+		return 0, nil, errors.New("invalid bech32 address length")
+	}
+	if address[:separator] != hrp {
+		return 0, nil, errors.Errorf("address is not for %s", hrp)
+	}
+
+	data := make([]byte, 0, len(address)-separator-1)
+	for _, c := range address[separator+1:] {
+		value := strings.IndexRune(bech32Charset, c)
+		if value < 0 {
+			return 0, nil, errors.Errorf("invalid bech32 character %q", c)
+		}
+		data = append(data, byte(value))
+	}
+
+	checksum := bech32Polymod(hrp, data)
+	data = data[:len(data)-6]
+	if len(data) < 1 {
+		return 0, nil, errors.New("no witness version")
+	}
+
+	version := data[0]
+	switch {
+	case version > 16:
+		return 0, nil, errors.Errorf("invalid witness version %d", version)
+	case version == 0 && checksum != bech32Const:
+		return 0, nil, errors.New("invalid bech32 checksum")
+	case version > 0 && checksum != bech32mConst:
+		return 0, nil, errors.New("invalid bech32m checksum")
+	}
+
+	program, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "failed to decode witness program")
+	}
+	if len(program) < 2 || len(program) > 40 || (version == 0 && len(program) != 20 && len(program) != 32) {
+		return 0, nil, errors.Errorf("invalid witness program length %d", len(program))
+	}
+
+	return version, program, nil // OK
+}
+
+// bech32Polymod computes the checksum of the human readable part and the
+// data including its checksum, see BIP173
+func bech32Polymod(hrp string, data []byte) int {
+	generator := [5]int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	values := make([]byte, 0, len(hrp)*2+1+len(data))
+	for _, c := range []byte(hrp) {
+		values = append(values, c>>5)
+	}
+	values = append(values, 0)
+	for _, c := range []byte(hrp) {
+		values = append(values, c&31)
+	}
+	values = append(values, data...)
+
+	chk := 1
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ int(v)
+		for i, g := range generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+
+	return chk
+}
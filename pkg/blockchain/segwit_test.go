@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayToAddrScript(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		params  *chaincfg.Params
+		script  string
+	}{
+		{"p2pkh", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &chaincfg.MainNetParams, "76a91477bff20c60e522dfaa3350c39b030a5d004e839a88ac"},
+		{"p2sh", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", &chaincfg.MainNetParams, "a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+		{"p2wpkh", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", &chaincfg.MainNetParams, "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"p2wsh testnet", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", &chaincfg.TestNet3Params, "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"p2tr", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", &chaincfg.MainNetParams, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"p2tr regtest", "bcrt1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqc8gma6", &chaincfg.RegressionNetParams, "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"witness v16", "BC1SW50QGDZ25J", &chaincfg.MainNetParams, "6002751e"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			script, err := payToAddrScript(test.address, test.params)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, test.script, hex.EncodeToString(script))
+		})
+	}
+}
+
+func TestScriptType(t *testing.T) {
+	tests := []struct {
+		address    string
+		scriptType common.ScriptType
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", common.ScriptTypeP2PKH},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", common.ScriptTypeP2SH},
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", common.ScriptTypeP2WPKH},
+		{"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", common.ScriptTypeP2WSH},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", common.ScriptTypeP2TR},
+		{"BC1SW50QGDZ25J", common.ScriptTypeUnknown},
+	}
+
+	for _, test := range tests {
+		// arrange
+		script, err := payToAddrScript(test.address, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+
+		// act & assert
+		assert.Equal(t, test.scriptType, scriptType(script), test.address)
+	}
+}
+
+func TestPayToAddrScriptRejectsInvalidAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		params  *chaincfg.Params
+	}{
+		{"other network", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", &chaincfg.TestNet3Params},
+		{"base58 of other network", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", &chaincfg.TestNet3Params},
+		{"v1 with bech32 checksum", "bc1zw508d6qejxtdg4y5r3zarvaryvg6kdaj", &chaincfg.MainNetParams},
+		{"v16 with bech32 checksum", "BC1SW50QA3JX3S", &chaincfg.MainNetParams},
+		{"v0 with bech32m checksum", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh", &chaincfg.MainNetParams},
+		{"mixed case", "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sL5k7", &chaincfg.TestNet3Params},
+		{"invalid checksum", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", &chaincfg.MainNetParams},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			_, err := payToAddrScript(test.address, test.params)
+
+			// assert
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCreateElectrumXScriptHash(t *testing.T) {
+	// arrange, example of the electrumx docs
+	address := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+
+	// act
+	scriptHash, err := createElectrumXScriptHash(address, &chaincfg.MainNetParams)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "8b01df4e368ea28f8dc0423bcf7a4923e3a12d307c875e47a0cfbf90b5c39161", scriptHash)
+}
@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+var (
+	// DefaultUTXOCacheTTL is for how long the UTXOs of an address are cached
+	DefaultUTXOCacheTTL = time.Minute
+
+	// MaxCachedAddresses is the number of addresses CachingUTXOManager keeps
+	// the UTXOs of at most
+	MaxCachedAddresses = 10000
+)
+
+// ScriptHashSubscriber announces changes of the status of script hashes, it
+// is implemented by ElectrumX and ElectrumXPool
+type ScriptHashSubscriber interface {
+	SubscribeScriptHash(scriptHash string) (string, error)
+	ScriptHashStatuses() <-chan ElectrumXStatus
+}
+
+// CachingUTXOManager caches the UTXOs of addresses looked up on another
+// UTXOManager. Cached UTXOs are looked up again after the TTL or, with
+// subscriptions, as soon as the status of the script of the address changes.
+// The confirmations of cached UTXOs are not updated with new blocks, they may
+// lag behind by up to the TTL.
+type CachingUTXOManager struct {
+	utxos      UTXOManager
+	cache      *utils.Cache         // []*common.UTXO by address
+	subscriber ScriptHashSubscriber // nil if UTXOs are only looked up again after the TTL
+	params     *chaincfg.Params     // network of the addresses
+
+	addresses  map[string]string // subscribed addresses by script hash
+	subscribed map[string]bool   // by address
+	versions   map[string]int    // by address, incremented on every status change
+	done       chan struct{}
+	closeOnce  sync.Once
+	mu         sync.Mutex
+}
+
+// NewCachingUTXOManager caches the UTXOs looked up on utxos for ttl
+func NewCachingUTXOManager(utxos UTXOManager, ttl time.Duration) *CachingUTXOManager {
+	return &CachingUTXOManager{
+		utxos:      utxos,
+		cache:      utils.NewCache(utils.CacheOptions{MaxItems: MaxCachedAddresses, TTL: ttl}),
+		params:     btcDefaultNet,
+		addresses:  make(map[string]string),
+		subscribed: make(map[string]bool),
+		versions:   make(map[string]int),
+		done:       make(chan struct{}),
+	}
+}
+
+// NewCachingUTXOManagerWithSubscriptions caches the UTXOs looked up on utxos
+// for ttl or until subscriber announces a change of the script of the address
+// on the network of params. The manager must be closed to stop watching the
+// subscriptions.
+func NewCachingUTXOManagerWithSubscriptions(utxos UTXOManager, subscriber ScriptHashSubscriber, params *chaincfg.Params, ttl time.Duration) *CachingUTXOManager {
+	m := NewCachingUTXOManager(utxos, ttl)
+	m.subscriber = subscriber
+	m.params = params
+
+	go m.watch(subscriber.ScriptHashStatuses())
+	return m
+}
+
+// GetUTXOs gets all UTXOs of a given address
+func (m *CachingUTXOManager) GetUTXOs(address string) ([]*common.UTXO, error) {
+	if cached, ok := m.cache.Get(address); ok {
+		return copyUTXOs(cached.([]*common.UTXO)), nil
+	}
+
+	// subscribe before looking up so that no change is missed
+	m.subscribe(address)
+
+	m.mu.Lock()
+	version := m.versions[address]
+	m.mu.Unlock()
+
+	utxos, err := m.utxos.GetUTXOs(address)
+	if err != nil {
+		return nil, err
+	}
+
+	// a change announced during the lookup may not be part of utxos yet
+	m.mu.Lock()
+	if m.versions[address] == version {
+		m.cache.Set(address, utxos)
+	}
+	m.mu.Unlock()
+
+	return copyUTXOs(utxos), nil // OK
+}
+
+// subscribe subscribes to the status of the script of address once. If that
+// fails the UTXOs of address are only looked up again after the TTL.
+func (m *CachingUTXOManager) subscribe(address string) {
+	if m.subscriber == nil {
+		return
+	}
+
+	m.mu.Lock()
+	if m.subscribed[address] {
+		m.mu.Unlock()
+		return
+	}
+	m.subscribed[address] = true
+	m.mu.Unlock()
+
+	scriptHash, err := createElectrumXScriptHash(address, m.params)
+	if err == nil {
+		m.mu.Lock()
+		m.addresses[scriptHash] = address
+		m.mu.Unlock()
+
+		_, err = m.subscriber.SubscribeScriptHash(scriptHash)
+	}
+
+	if err != nil {
+		m.mu.Lock()
+		delete(m.subscribed, address)
+		delete(m.addresses, scriptHash)
+		m.mu.Unlock()
+	}
+}
+
+// watch drops the UTXOs of the addresses the status of which changed
+func (m *CachingUTXOManager) watch(statuses <-chan ElectrumXStatus) {
+	for {
+		select {
+		case status := <-statuses:
+			m.invalidate(status.ScriptHash)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// invalidate drops the UTXOs of the address with the script hash
+func (m *CachingUTXOManager) invalidate(scriptHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	address, ok := m.addresses[scriptHash]
+	if !ok {
+		return
+	}
+
+	m.versions[address]++
+	m.cache.Remove(address)
+}
+
+// Close stops watching the subscriptions
+func (m *CachingUTXOManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// copyUTXOs copies utxos so that callers cannot modify the cached ones
+func copyUTXOs(utxos []*common.UTXO) []*common.UTXO {
+	copied := make([]*common.UTXO, len(utxos))
+	for i, utxo := range utxos {
+		u := *utxo
+		copied[i] = &u
+	}
+
+	return copied
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingUTXOManager returns a UTXO of the number of lookups so far
+type countingUTXOManager struct {
+	lookups int
+	mu      sync.Mutex
+}
+
+func (m *countingUTXOManager) GetUTXOs(address string) ([]*common.UTXO, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lookups++
+	return []*common.UTXO{{Value: int64(m.lookups)}}, nil
+}
+
+// fakeSubscriber records the subscribed script hashes
+type fakeSubscriber struct {
+	statuses     chan ElectrumXStatus
+	scriptHashes []string
+	err          error
+}
+
+func (s *fakeSubscriber) SubscribeScriptHash(scriptHash string) (string, error) {
+	s.scriptHashes = append(s.scriptHashes, scriptHash)
+	return "", s.err
+}
+
+func (s *fakeSubscriber) ScriptHashStatuses() <-chan ElectrumXStatus {
+	return s.statuses
+}
+
+func TestCachingUTXOManagerCachesForTTL(t *testing.T) {
+	// arrange
+	address := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	utxos := &countingUTXOManager{}
+	manager := NewCachingUTXOManager(utxos, 20*time.Millisecond)
+
+	// act
+	first, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+	first[0].Value = 100
+	cached, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	expired, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, int64(1), cached[0].Value, "cached UTXOs must not be modified by callers")
+	assert.Equal(t, int64(2), expired[0].Value)
+	assert.Equal(t, 2, utxos.lookups)
+}
+
+func TestCachingUTXOManagerRefreshesOnStatusChange(t *testing.T) {
+	// arrange
+	address := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	scriptHash := "8b01df4e368ea28f8dc0423bcf7a4923e3a12d307c875e47a0cfbf90b5c39161"
+	utxos := &countingUTXOManager{}
+	subscriber := &fakeSubscriber{statuses: make(chan ElectrumXStatus)}
+	manager := NewCachingUTXOManagerWithSubscriptions(utxos, subscriber, &chaincfg.MainNetParams, time.Hour)
+	defer manager.Close()
+
+	_, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+	cached, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+
+	// act
+	subscriber.statuses <- ElectrumXStatus{ScriptHash: "other"}
+	subscriber.statuses <- ElectrumXStatus{ScriptHash: scriptHash, Status: "changed"}
+	subscriber.statuses <- ElectrumXStatus{} // processed once the change is
+	refreshed, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, []string{scriptHash}, subscriber.scriptHashes)
+	assert.Equal(t, int64(1), cached[0].Value)
+	assert.Equal(t, int64(2), refreshed[0].Value)
+}
+
+func TestCachingUTXOManagerRetriesFailedSubscriptions(t *testing.T) {
+	// arrange
+	address := "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	subscriber := &fakeSubscriber{statuses: make(chan ElectrumXStatus), err: errors.New("unsupported")}
+	manager := NewCachingUTXOManagerWithSubscriptions(&countingUTXOManager{}, subscriber, &chaincfg.MainNetParams, time.Millisecond)
+	defer manager.Close()
+
+	// act
+	_, err := manager.GetUTXOs(address)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = manager.GetUTXOs(address)
+	require.NoError(t, err)
+
+	// assert
+	assert.Len(t, subscriber.scriptHashes, 2)
+}
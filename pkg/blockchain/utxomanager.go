@@ -1,10 +1,17 @@
 package blockchain
 
 import (
+	"bytes"
+	"encoding/hex"
 	"math/big"
+	"time"
 
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/pkg/errors"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
 	"github.com/ybbus/jsonrpc"
 )
 
@@ -12,9 +19,71 @@ type UTXOManager interface {
 	GetUTXOs(address string) ([]*common.UTXO, error)
 }
 
+// The backends a UTXOManager can be created for with NewUTXOManager
+const (
+	UTXOBackendElectrum = "electrum"
+	UTXOBackendEsplora  = "esplora"
+)
+
+// UTXOManagerConfig selects and configures the backend of a UTXOManager
+type UTXOManagerConfig struct {
+	Backend string           // UTXOBackendElectrum or UTXOBackendEsplora
+	URLs    []string         // Electrum server urls or the esplora api url
+	Params  *chaincfg.Params // network of the addresses, mainnet if nil
+
+	// CacheTTL caches the UTXOs of an address for that long, see
+	// CachingUTXOManager. Electrum servers announce changes of the cached
+	// addresses so that they are looked up again right away. 0 disables
+	// caching.
+	CacheTTL time.Duration
+
+	// Proxy is the SOCKS5 proxy the backend is connected to through, e.g.
+	// Tor, nil connects directly
+	Proxy *utils.Proxy
+}
+
+// NewUTXOManager creates a UTXOManager for the backend of config
+func NewUTXOManager(config UTXOManagerConfig) (UTXOManager, error) {
+	params := config.Params
+	if params == nil {
+		params = btcDefaultNet
+	}
+
+	switch config.Backend {
+	case UTXOBackendElectrum:
+		pool, err := NewElectrumXPoolWithProxy(config.URLs, config.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create ElectrumX pool")
+		}
+
+		utxos := &ElectrumxUTXOManager{
+			electrumX: pool,
+			params:    params,
+		}
+		if config.CacheTTL > 0 {
+			return NewCachingUTXOManagerWithSubscriptions(utxos, pool, params, config.CacheTTL), nil
+		}
+		return utxos, nil
+	case UTXOBackendEsplora:
+		if len(config.URLs) != 1 {
+			return nil, errors.Errorf("esplora needs exactly one url, got %d", len(config.URLs))
+		}
+
+		utxos := NewEsploraUTXOManager(config.URLs[0], params)
+		utxos.httpClient = utils.NewHTTPClient(config.Proxy, EsploraCallTimeout)
+		if config.CacheTTL > 0 {
+			return NewCachingUTXOManager(utxos, config.CacheTTL), nil
+		}
+		return utxos, nil
+	}
+
+	return nil, errors.Errorf("unknown utxo backend %q", config.Backend)
+}
+
 type ElectrumxUTXOManager struct {
 	electrumX jsonrpc.RPCClient
 	btcClient jsonrpc.RPCClient
+	params    *chaincfg.Params // network of the addresses
 }
 
 // NewElectrumxUTXOManager creates new NewUTXOManager instance
@@ -27,22 +96,39 @@ func NewElectrumxUTXOManager() (UTXOManager, error) {
 
 	return &ElectrumxUTXOManager{
 		electrumX: electrumX,
+		params:    btcDefaultNet,
+	}, nil // OK
+}
+
+// NewElectrumxUTXOManagerWithServers creates a UTXOManager looking up UTXOs
+// of the network of params on a pool of the Electrum servers at targetURLs,
+// see ElectrumXPool
+func NewElectrumxUTXOManagerWithServers(targetURLs []string, params *chaincfg.Params) (UTXOManager, error) {
+	pool, err := NewElectrumXPool(targetURLs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ElectrumX pool")
+	}
+
+	return &ElectrumxUTXOManager{
+		electrumX: pool,
+		params:    params,
 	}, nil // OK
 }
 
 // GetUTXOs gets all UTXOs of a given address
 func (s *ElectrumxUTXOManager) GetUTXOs(address string) ([]*common.UTXO, error) {
-	scriptHash, err := createElectrumXScriptHash(address)
+	script, err := payToAddrScript(address, s.params)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create ElectrumX script hash")
+		return nil, errors.Wrap(err, "failed to create script")
 	}
+	scriptHash := electrumXScriptHash(script)
 
 	// ElectrumX response
 	type UTXO struct {
 		TxPos  *big.Int `json:"tx_pos"`
 		Value  *big.Int `json:"value"` // satoshis
 		TxHash string   `json:"tx_hash"`
-		Height *big.Int `json:"height"`
+		Height *big.Int `json:"height"` // 0 or -1 if unconfirmed
 	}
 
 	// JSON RPC request
@@ -52,17 +138,124 @@ func (s *ElectrumxUTXOManager) GetUTXOs(address string) ([]*common.UTXO, error)
 		return nil, errors.Wrap(err, "failed to get UTXOs from ElectrumX")
 	}
 
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-headers-subscribe
+	var tip struct {
+		Height int64 `json:"height"`
+	}
+	err = s.electrumX.CallFor(&tip, "blockchain.headers.subscribe")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tip height from ElectrumX")
+	}
+
 	// copy UTXOs
 	utxos := make([]*common.UTXO, 0, len(eutxos))
 	for _, u := range eutxos {
-		utxos = append(utxos,
-			&common.UTXO{
-				Index:  u.TxPos,
-				Value:  u.Value.Int64(),
-				Hash:   u.TxHash,
-				Height: u.Height.Int64(),
-			})
+		utxo := &common.UTXO{
+			Index:        u.TxPos,
+			Value:        u.Value.Int64(),
+			Hash:         u.TxHash,
+			Height:       u.Height.Int64(),
+			ScriptPubKey: hex.EncodeToString(script),
+			ScriptType:   scriptType(script),
+		}
+		if utxo.Height > 0 {
+			utxo.Confirmations = tip.Height - utxo.Height + 1
+		}
+
+		// only outputs of recent coinbase txs are immature, the tx is not
+		// fetched for the others
+		if utxo.Confirmations > 0 && utxo.Confirmations < common.CoinbaseMaturity {
+			utxo.Coinbase, err = s.isCoinbase(u.TxHash)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get tx %s from ElectrumX", u.TxHash)
+			}
+		}
+
+		// unconfirmed outputs of txs spending from the address are change
+		if utxo.Confirmations == 0 {
+			utxo.FromSelf, err = s.spendsScript(u.TxHash, script)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get inputs of tx %s from ElectrumX", u.TxHash)
+			}
+		}
+
+		utxos = append(utxos, utxo)
 	}
 
 	return utxos, nil // OK
 }
+
+// isCoinbase reports whether the tx with the given hash is a coinbase tx
+func (s *ElectrumxUTXOManager) isCoinbase(txHash string) (bool, error) {
+	tx, err := s.getTx(txHash)
+	if err != nil {
+		return false, err
+	}
+
+	return isCoinbaseTx(tx), nil // OK
+}
+
+// spendsScript reports whether the tx with the given hash spends an output
+// locked with script
+func (s *ElectrumxUTXOManager) spendsScript(txHash string, script []byte) (bool, error) {
+	tx, err := s.getTx(txHash)
+	if err != nil {
+		return false, err
+	}
+	if isCoinbaseTx(tx) {
+		return false, nil
+	}
+
+	previousTxs := make(map[chainhash.Hash]*wire.MsgTx)
+	for _, in := range tx.TxIn {
+		previous := in.PreviousOutPoint
+		previousTx, ok := previousTxs[previous.Hash]
+		if !ok {
+			previousTx, err = s.getTx(previous.Hash.String())
+			if err != nil {
+				return false, err
+			}
+			previousTxs[previous.Hash] = previousTx
+		}
+
+		if int(previous.Index) < len(previousTx.TxOut) && bytes.Equal(previousTx.TxOut[previous.Index].PkScript, script) {
+			return true, nil
+		}
+	}
+
+	return false, nil // OK
+}
+
+// getTx returns the tx with the given hash
+func (s *ElectrumxUTXOManager) getTx(txHash string) (*wire.MsgTx, error) {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-transaction-get
+	var rawTx string
+	err := s.electrumX.CallFor(&rawTx, "blockchain.transaction.get", txHash)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode tx")
+	}
+
+	var tx wire.MsgTx
+	err = tx.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to deserialize tx")
+	}
+
+	return &tx, nil // OK
+}
+
+// isCoinbaseTx reports whether tx is a coinbase tx, the only input of which
+// spends no previous output
+func isCoinbaseTx(tx *wire.MsgTx) bool {
+	if len(tx.TxIn) != 1 {
+		return false
+	}
+
+	previous := tx.TxIn[0].PreviousOutPoint
+	return previous.Index == wire.MaxPrevOutIndex && previous.Hash == chainhash.Hash{}
+}
@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// utxoElectrumX answers the calls of ElectrumxUTXOManager with the results
+// by method, or the raw txs by hash, and records the calls
+type utxoElectrumX struct {
+	jsonrpc.RPCClient
+	results map[string]string // JSON by method
+	txs     map[string]string // raw tx hex by hash
+	calls   []string
+}
+
+func (f *utxoElectrumX) CallFor(out interface{}, method string, params ...interface{}) error {
+	f.calls = append(f.calls, method)
+	if method == "blockchain.transaction.get" {
+		return json.Unmarshal([]byte(`"`+f.txs[params[0].(string)]+`"`), out)
+	}
+
+	return json.Unmarshal([]byte(f.results[method]), out)
+}
+
+// rawTx returns the serialized tx spending previous as hex
+func rawTx(t *testing.T, previous wire.OutPoint) string {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&previous, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(5000, nil))
+
+	return serializeTx(t, tx)
+}
+
+// serializeTx returns tx serialized as hex
+func serializeTx(t *testing.T, tx *wire.MsgTx) string {
+	var buf bytes.Buffer
+	require.NoError(t, tx.Serialize(&buf))
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestElectrumxUTXOManagerGetUTXOs(t *testing.T) {
+	// arrange
+	coinbase := rawTx(t, wire.OutPoint{Index: wire.MaxPrevOutIndex})
+	script, err := hex.DecodeString("0014751e76e8199196d454941c45d1b3a323f1433bd6")
+	require.NoError(t, err)
+	funding := wire.NewMsgTx(wire.TxVersion)
+	funding.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil))
+	funding.AddTxOut(wire.NewTxOut(1000, nil))
+	funding.AddTxOut(wire.NewTxOut(2000, script))
+	fundingHash := funding.TxHash()
+	// b is change of a tx spending from the address
+	change := rawTx(t, wire.OutPoint{Hash: fundingHash, Index: 1})
+
+	electrumX := &utxoElectrumX{results: map[string]string{
+		"blockchain.scripthash.listunspent": `[
+			{"tx_pos":0,"value":5000,"tx_hash":"a","height":98},
+			{"tx_pos":1,"value":700,"tx_hash":"b","height":0},
+			{"tx_pos":2,"value":900,"tx_hash":"c","height":1}
+		]`,
+		"blockchain.headers.subscribe": `{"height":100,"hex":"00"}`,
+	}, txs: map[string]string{
+		"a":                  coinbase,
+		"b":                  change,
+		fundingHash.String(): serializeTx(t, funding),
+	}}
+	utxos := &ElectrumxUTXOManager{electrumX: electrumX, params: &chaincfg.MainNetParams}
+
+	// act
+	set, err := utxos.GetUTXOs("bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4")
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, set, 3)
+	assert.Equal(t, &common.UTXO{
+		Index:         big.NewInt(0),
+		Value:         5000,
+		Hash:          "a",
+		Height:        98,
+		Confirmations: 3,
+		ScriptPubKey:  "0014751e76e8199196d454941c45d1b3a323f1433bd6",
+		ScriptType:    common.ScriptTypeP2WPKH,
+		Coinbase:      true,
+	}, set[0])
+	assert.Equal(t, int64(0), set[1].Confirmations)
+	assert.False(t, set[1].Coinbase)
+	assert.True(t, set[1].FromSelf)
+	assert.Equal(t, int64(100), set[2].Confirmations)
+	assert.False(t, set[2].Coinbase)
+	assert.False(t, set[2].FromSelf)
+	assert.Equal(t, 3, countCalls(electrumX.calls, "blockchain.transaction.get"))
+}
+
+func TestIsCoinbaseTx(t *testing.T) {
+	// arrange
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: wire.MaxPrevOutIndex}, nil, nil))
+	spend := wire.NewMsgTx(wire.TxVersion)
+	spend.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.Hash{1}}, nil, nil))
+
+	// act & assert
+	assert.True(t, isCoinbaseTx(coinbase))
+	assert.False(t, isCoinbaseTx(spend))
+}
+
+func countCalls(calls []string, method string) int {
+	count := 0
+	for _, call := range calls {
+		if call == method {
+			count++
+		}
+	}
+
+	return count
+}
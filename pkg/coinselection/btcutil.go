@@ -7,8 +7,9 @@ import (
 )
 
 // MinIndexCoinSelector is a CoinSelector that attempts to construct a
-// selection of coins whose total value is at least targetValue and prefers
-// any number of lower indexes (as in the ordered array) over higher ones.
+// selection of coins whose total effective value is at least targetValue and
+// prefers any number of lower indexes (as in the ordered array) over higher
+// ones. Coins not worth spending at the fee rate are skipped.
 type MinIndexCoinSelector struct {
 	MaxInputs       int
 	MinChangeAmount int64
@@ -17,14 +18,17 @@ type MinIndexCoinSelector struct {
 // SelectCoins will attempt to select coins using the algorithm described
 // in the MinIndexCoinSelector struct.
 func (s MinIndexCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
-	set := &ResultSet{}
-	for n := 0; n < len(utxos) && n < s.MaxInputs; n++ {
-		set.Coins = append(set.Coins, utxos[n])
-		if SatisfiesTargetValue(target, s.MinChangeAmount, set.Coins) {
-			return set, nil
+	utxos = SpendableUTXOs(utxos, feeRate)
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		var coins []*common.UTXO
+		for n := 0; n < len(utxos) && n < s.MaxInputs; n++ {
+			coins = append(coins, utxos[n])
+			if SatisfiesTargetEffectiveValue(target, s.MinChangeAmount, coins, feeRate) {
+				return coins, nil
+			}
 		}
-	}
-	return nil, ErrCoinsNoSelectionAvailable
+		return nil, ErrCoinsNoSelectionAvailable
+	})
 }
 
 // MinNumberCoinSelector is a CoinSelector that attempts to construct
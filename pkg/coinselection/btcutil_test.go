@@ -25,7 +25,7 @@ type coinSelectTest struct {
 
 func testCoinSelector(tests []coinSelectTest, t *testing.T) {
 	for _, test := range tests {
-		set, err := test.selector.SelectCoins(test.inputCoins, test.targetValue)
+		set, err := test.selector.SelectCoins(test.inputCoins, test.targetValue, 0)
 		if test.expectedError != nil {
 			assert.Equal(t, test.expectedError, err)
 			continue
@@ -0,0 +1,69 @@
+package coinselection
+
+import (
+	"sort"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// DefaultConsolidationInputs is the number of inputs the consolidation
+// strategy created by NewStrategy spends at most
+const DefaultConsolidationInputs = 50
+
+// ConsolidationCoinSelector consolidates the coins while fees are low: at
+// fee rates up to MaxFeeRate it selects the largest coins until they reach
+// the target and then sweeps the smallest remaining ones as well, up to
+// MaxInputs coins in total, into the change. Spending them now is cheaper
+// than later at higher fee rates. Above MaxFeeRate it uses Fallback. Coins
+// not worth spending at the fee rate are skipped.
+type ConsolidationCoinSelector struct {
+	MaxInputs       int // 0 sweeps all coins
+	MinChangeAmount int64
+
+	// MaxFeeRate is the fee rate in satoshi per kB up to which the selector
+	// consolidates, LongTermFeeRate if 0
+	MaxFeeRate int64
+
+	// Fallback selects the coins above MaxFeeRate, a
+	// LargestFirstCoinSelector with MinChangeAmount if nil
+	Fallback Strategy
+}
+
+// SelectCoins will attempt to select coins using the algorithm described
+// in the ConsolidationCoinSelector struct.
+func (s ConsolidationCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	maxFeeRate := s.MaxFeeRate
+	if maxFeeRate == 0 {
+		maxFeeRate = LongTermFeeRate
+	}
+
+	if feeRate > maxFeeRate {
+		fallback := s.Fallback
+		if fallback == nil {
+			fallback = LargestFirstCoinSelector{MinChangeAmount: s.MinChangeAmount}
+		}
+		return fallback.SelectCoins(utxos, target, feeRate)
+	}
+
+	utxos = SpendableUTXOs(utxos, feeRate)
+	sort.Sort(sort.Reverse(ByAmount(utxos)))
+	largestFirst := LargestFirstCoinSelector{MaxInputs: s.MaxInputs, MinChangeAmount: s.MinChangeAmount}
+
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		coins, err := largestFirst.selectCoins(utxos, target, feeRate)
+		if err != nil {
+			return nil, err
+		}
+
+		// sweep the smallest coins, utxos starts with the selected ones
+		selected := len(coins)
+		for i := len(utxos) - 1; i >= selected; i-- {
+			if s.MaxInputs > 0 && len(coins) == s.MaxInputs {
+				break
+			}
+			coins = append(coins, utxos[i])
+		}
+
+		return coins, nil
+	})
+}
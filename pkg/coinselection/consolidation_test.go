@@ -0,0 +1,54 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsolidationSelector(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(2000), NewUTXO(50000), NewUTXO(1000), NewUTXO(40000), NewUTXO(3000)}
+	tests := []struct {
+		name     string
+		selector ConsolidationCoinSelector
+		feeRate  int64
+		expected []int64
+	}{
+		{"sweeps the smallest coins", ConsolidationCoinSelector{MaxInputs: 4, MaxFeeRate: 5000}, 1000, []int64{50000, 1000, 2000, 3000}},
+		{"max inputs", ConsolidationCoinSelector{MaxInputs: 2, MaxFeeRate: 5000}, 1000, []int64{50000, 1000}},
+		{"sweeps all coins", ConsolidationCoinSelector{MaxFeeRate: 5000}, 5000, []int64{50000, 1000, 2000, 3000, 40000}},
+		{"above the fee rate", ConsolidationCoinSelector{MaxInputs: 4, MaxFeeRate: 5000}, 5001, []int64{50000}},
+		{"long term fee rate", ConsolidationCoinSelector{}, LongTermFeeRate, []int64{50000, 2000, 3000, 40000}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			set, err := test.selector.SelectCoins(coins, 45000, test.feeRate)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, values(set.Coins))
+			assert.GreaterOrEqual(t, set.Fee, MinimalFee(set.Coins, test.feeRate))
+		})
+	}
+}
+
+func TestConsolidationSelectorUsesFallback(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(10000), NewUTXO(50000)}
+	selector := ConsolidationCoinSelector{
+		MaxFeeRate: 1000,
+		Fallback:   MinIndexCoinSelector{MaxInputs: 2},
+	}
+
+	// act
+	set, err := selector.SelectCoins(coins, 50000, 2000)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10000, 50000}, values(set.Coins))
+}
@@ -0,0 +1,147 @@
+package coinselection
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// DefaultKnapsackIterations is the number of random passes of the knapsack
+// selector unless it is configured otherwise, like in bitcoin core
+const DefaultKnapsackIterations = 1000
+
+// KnapsackCoinSelector is the stochastic approximation bitcoin core used
+// before branch and bound and still falls back to. It looks for the subset
+// of the coins smaller than target+MinChangeAmount that comes closest to the
+// target, or target+MinChangeAmount, in several random passes and picks the
+// smallest single larger coin instead if that is closer. Coins are compared
+// by their effective value at the fee rate, coins not worth spending are
+// skipped.
+type KnapsackCoinSelector struct {
+	MinChangeAmount int64
+
+	// Iterations is the number of random passes, DefaultKnapsackIterations
+	// if 0
+	Iterations int
+
+	// Source makes the random passes, seeded with the current time if nil
+	Source rand.Source
+}
+
+// SelectCoins will attempt to select coins using the algorithm described
+// in the KnapsackCoinSelector struct.
+func (s KnapsackCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	r := newRand(s.Source)
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		return s.selectCoins(r, utxos, target, feeRate)
+	})
+}
+
+// selectCoins runs the knapsack algorithm once with the randomness of r
+func (s KnapsackCoinSelector) selectCoins(r *rand.Rand, utxos []*common.UTXO, target int64, feeRate int64) ([]*common.UTXO, error) {
+	iterations := s.Iterations
+	if iterations <= 0 {
+		iterations = DefaultKnapsackIterations
+	}
+
+	// split the coins into the ones smaller than target+MinChangeAmount and
+	// the smallest one larger than that
+	var lower []*common.UTXO
+	var lowestLarger *common.UTXO
+	var totalLower int64
+	for _, utxo := range shuffleWith(r, SpendableUTXOs(utxos, feeRate)) {
+		value := EffectiveValue(utxo, feeRate)
+		switch {
+		case value == target:
+			return []*common.UTXO{utxo}, nil
+		case value < target+s.MinChangeAmount:
+			lower = append(lower, utxo)
+			totalLower += value
+		case lowestLarger == nil || utxo.Value < lowestLarger.Value:
+			lowestLarger = utxo
+		}
+	}
+
+	if totalLower == target {
+		return lower, nil
+	}
+
+	if totalLower < target {
+		if lowestLarger == nil {
+			return nil, ErrInsufficientFunds
+		}
+		return []*common.UTXO{lowestLarger}, nil
+	}
+
+	// look for the subset of the smaller coins closest to the target and,
+	// unless it hits it exactly, to the target with change
+	sort.Sort(sort.Reverse(ByAmount(lower)))
+	best, bestValue := approximateBestSubset(r, lower, totalLower, target, iterations, feeRate)
+	if bestValue != target && totalLower >= target+s.MinChangeAmount {
+		best, bestValue = approximateBestSubset(r, lower, totalLower, target+s.MinChangeAmount, iterations, feeRate)
+	}
+
+	// the smallest larger coin wins if the subset leaves too little change or
+	// if it is not larger than the subset
+	if lowestLarger != nil &&
+		((bestValue != target && bestValue < target+s.MinChangeAmount) || EffectiveValue(lowestLarger, feeRate) <= bestValue) {
+		return []*common.UTXO{lowestLarger}, nil
+	}
+
+	return best, nil
+}
+
+// approximateBestSubset returns the subset of utxos, sorted by descending
+// value, with the smallest total effective value at feeRate of at least
+// target it finds in iterations random passes, and that total. Every pass
+// includes each coin with a chance of one half and then the remaining ones in
+// order until the target is reached.
+func approximateBestSubset(r *rand.Rand, utxos []*common.UTXO, total int64, target int64, iterations int, feeRate int64) ([]*common.UTXO, int64) {
+	best := make([]bool, len(utxos))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := total
+
+	included := make([]bool, len(utxos))
+	for iteration := 0; iteration < iterations && bestValue != target; iteration++ {
+		for i := range included {
+			included[i] = false
+		}
+
+		var value int64
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, utxo := range utxos {
+				// the first pass picks coins at random, the second one
+				// adds the ones left out in order
+				if (pass == 0 && r.Intn(2) == 0) || (pass == 1 && !included[i]) {
+					value += EffectiveValue(utxo, feeRate)
+					included[i] = true
+					if value >= target {
+						reachedTarget = true
+						if value < bestValue {
+							bestValue = value
+							copy(best, included)
+						}
+
+						// try to reach the target with the next coins
+						// instead
+						value -= EffectiveValue(utxo, feeRate)
+						included[i] = false
+					}
+				}
+			}
+		}
+	}
+
+	subset := make([]*common.UTXO, 0, len(utxos))
+	for i, utxo := range utxos {
+		if best[i] {
+			subset = append(subset, utxo)
+		}
+	}
+
+	return subset, bestValue
+}
@@ -0,0 +1,75 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func values(utxos []*common.UTXO) []int64 {
+	result := make([]int64, len(utxos))
+	for i, utxo := range utxos {
+		result[i] = utxo.Value
+	}
+
+	return result
+}
+
+func TestKnapsackSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		coins     []int64
+		target    int64
+		minChange int64
+		expected  []int64
+	}{
+		{"exact coin", []int64{1, 2, 5, 10}, 5, 100, []int64{5}},
+		{"all smaller coins hit the target", []int64{1, 2, 3}, 6, 10, []int64{1, 2, 3}},
+		{"smaller coins are not enough", []int64{1, 2, 50, 30}, 10, 5, []int64{30}},
+		{"subset leaves too little change", []int64{5, 6, 20}, 10, 5, []int64{20}},
+		{"subset with change", []int64{40, 30, 20, 1000}, 45, 15, []int64{40, 20}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// arrange
+			coins := make([]*common.UTXO, len(test.coins))
+			for i, value := range test.coins {
+				coins[i] = NewUTXO(value)
+			}
+
+			// act
+			set, err := KnapsackCoinSelector{MinChangeAmount: test.minChange}.SelectCoins(coins, test.target, 0)
+
+			// assert
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, values(set.Coins))
+		})
+	}
+}
+
+func TestKnapsackSelectorFindsExactSubset(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(6), NewUTXO(4), NewUTXO(3), NewUTXO(8), NewUTXO(100)}
+
+	// act
+	set, err := KnapsackCoinSelector{MinChangeAmount: 1000}.SelectCoins(coins, 10, 0)
+
+	// assert
+	require.NoError(t, err)
+	var total int64
+	for _, value := range values(set.Coins) {
+		total += value
+	}
+	assert.Equal(t, int64(10), total)
+}
+
+func TestKnapsackSelectorInsufficientFunds(t *testing.T) {
+	// act
+	_, err := KnapsackCoinSelector{MinChangeAmount: 1}.SelectCoins([]*common.UTXO{NewUTXO(1), NewUTXO(2)}, 10, 0)
+
+	// assert
+	assert.Equal(t, ErrInsufficientFunds, err)
+}
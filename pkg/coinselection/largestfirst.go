@@ -0,0 +1,45 @@
+package coinselection
+
+import (
+	"sort"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// LargestFirstCoinSelector adds the coins from the largest to the smallest
+// until their effective value reaches the target, and at least
+// MinChangeAmount of change unless it is hit exactly. It uses as few inputs
+// as possible, which keeps the fee low at high fee rates. Coins not worth
+// spending at the fee rate are skipped.
+type LargestFirstCoinSelector struct {
+	MaxInputs       int // 0 does not limit the inputs
+	MinChangeAmount int64
+}
+
+// SelectCoins will attempt to select coins using the algorithm described
+// in the LargestFirstCoinSelector struct.
+func (s LargestFirstCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	utxos = SpendableUTXOs(utxos, feeRate)
+	sort.Sort(sort.Reverse(ByAmount(utxos)))
+
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		return s.selectCoins(utxos, target, feeRate)
+	})
+}
+
+// selectCoins adds the utxos, sorted by descending value, in their order
+func (s LargestFirstCoinSelector) selectCoins(utxos []*common.UTXO, target int64, feeRate int64) ([]*common.UTXO, error) {
+	var coins []*common.UTXO
+	for _, utxo := range utxos {
+		if s.MaxInputs > 0 && len(coins) == s.MaxInputs {
+			return nil, ErrCoinsNoSelectionAvailable
+		}
+
+		coins = append(coins, utxo)
+		if SatisfiesTargetEffectiveValue(target, s.MinChangeAmount, coins, feeRate) {
+			return coins, nil
+		}
+	}
+
+	return nil, ErrInsufficientFunds
+}
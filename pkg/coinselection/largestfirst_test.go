@@ -0,0 +1,53 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLargestFirstSelectorPrefersLargestCoins(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(10000), NewUTXO(70000), NewUTXO(20000), NewUTXO(50000), NewUTXO(30000)}
+	selector := LargestFirstCoinSelector{}
+
+	// act
+	set, err := selector.SelectCoins(coins, 100000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{70000, 50000}, values(set.Coins))
+	assert.Equal(t, int64(20000), set.Change)
+}
+
+func TestLargestFirstSelectorCoversFee(t *testing.T) {
+	// arrange, the effective value of the two largest coins reaches the
+	// target but not the fee for the tx overhead and output
+	feeRate := int64(10000)
+	coins := []*common.UTXO{NewUTXO(60000), NewUTXO(40000), NewUTXO(5000)}
+	selector := LargestFirstCoinSelector{}
+
+	// act
+	set, err := selector.SelectCoins(coins, 97000, feeRate)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{60000, 40000, 5000}, values(set.Coins))
+	assert.Equal(t, MinimalFeeWithChange(set.Coins, feeRate), set.Fee)
+	assert.Equal(t, 105000-97000-set.Fee, set.Change)
+}
+
+func TestLargestFirstSelectorErrors(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(30000), NewUTXO(40000), NewUTXO(50000)}
+
+	// act
+	_, insufficient := LargestFirstCoinSelector{}.SelectCoins(coins, 200000, 0)
+	_, limited := LargestFirstCoinSelector{MaxInputs: 2}.SelectCoins(coins, 100000, 0)
+
+	// assert
+	assert.Equal(t, ErrInsufficientFunds, insufficient)
+	assert.Equal(t, ErrCoinsNoSelectionAvailable, limited)
+}
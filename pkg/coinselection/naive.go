@@ -10,16 +10,31 @@ import (
 type RandomCoinSelector struct {
 	MaxInputs       int
 	MinChangeAmount int64
+
+	// Source shuffles the coins, seeded with the current time if nil. A
+	// seeded source replays the same selections.
+	Source rand.Source
 }
 
 func (s RandomCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
-	shuffledUtxos := shuffle(utxos)
+	shuffledUtxos := shuffleWith(newRand(s.Source), utxos)
+
+	selector := MinIndexCoinSelector{MaxInputs: s.MaxInputs, MinChangeAmount: s.MinChangeAmount}
+	return selector.SelectCoins(shuffledUtxos, target, feeRate)
+}
+
+// newRand returns a rand.Rand of source, or of a source seeded with the
+// current time if it is nil
+func newRand(source rand.Source) *rand.Rand {
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
+	}
 
-	return MinIndexCoinSelector(s).SelectCoins(shuffledUtxos, target, feeRate)
+	return rand.New(source)
 }
 
-func shuffle(utxos []*common.UTXO) []*common.UTXO {
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+// shuffleWith returns the utxos in the random order of r
+func shuffleWith(r *rand.Rand, utxos []*common.UTXO) []*common.UTXO {
 	res := make([]*common.UTXO, len(utxos))
 	perm := r.Perm(len(utxos))
 	for i, randIndex := range perm {
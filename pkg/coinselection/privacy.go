@@ -0,0 +1,130 @@
+package coinselection
+
+import (
+	"sort"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// PrivacyCoinSelector avoids partial spends like the avoidpartialspends
+// option of bitcoin core: it groups the coins into clusters, e.g. the ones
+// paying to the same address, and spends whole clusters only, largest first,
+// so no coins linked to the spent ones are left behind. It uses Fallback
+// instead if that selection costs more than MaxExtraFee above the one of
+// Fallback or if it fails. Coins not worth spending at the fee rate are
+// spent along with their cluster, clusters not worth spending as a whole are
+// skipped.
+type PrivacyCoinSelector struct {
+	MaxInputs       int // 0 does not limit the inputs
+	MinChangeAmount int64
+
+	// MaxExtraFee is the fee in satoshi spending whole clusters may cost
+	// more than the selection of Fallback, it trades fees for privacy. 0
+	// spends whole clusters if it does not cost more, a negative value never
+	// does.
+	MaxExtraFee int64
+
+	// Cluster returns the cluster of a coin, ByScriptPubKey if nil. Coins of
+	// the empty cluster are not linked to any others.
+	Cluster func(utxo *common.UTXO) string
+
+	// Fallback selects coins regardless of their clusters, a
+	// LargestFirstCoinSelector with MaxInputs and MinChangeAmount if nil
+	Fallback Strategy
+}
+
+// ByScriptPubKey clusters coins by their scriptPubKey, i.e. by address
+func ByScriptPubKey(utxo *common.UTXO) string {
+	return utxo.ScriptPubKey
+}
+
+// SelectCoins will attempt to select coins using the algorithm described
+// in the PrivacyCoinSelector struct.
+func (s PrivacyCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = LargestFirstCoinSelector{MaxInputs: s.MaxInputs, MinChangeAmount: s.MinChangeAmount}
+	}
+
+	partial, partialErr := fallback.SelectCoins(utxos, target, feeRate)
+	if s.MaxExtraFee < 0 {
+		return partial, partialErr
+	}
+
+	whole, err := s.selectClusters(utxos, target, feeRate)
+	if err != nil {
+		return partial, partialErr
+	}
+
+	if partialErr == nil && whole.Fee > partial.Fee+s.MaxExtraFee {
+		return partial, nil
+	}
+
+	return whole, nil
+}
+
+// selectClusters selects whole clusters from the one with the largest
+// effective value to the smallest. The coins of a cluster are not filtered
+// by SpendableUTXOs, a coin left behind would still be linked to the spent
+// ones.
+func (s PrivacyCoinSelector) selectClusters(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	var clusters [][]*common.UTXO
+	for _, cluster := range s.clusters(utxos) {
+		if clusterValue(cluster, feeRate) > 0 {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusterValue(clusters[i], feeRate) > clusterValue(clusters[j], feeRate)
+	})
+
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		var coins []*common.UTXO
+		for _, cluster := range clusters {
+			if s.MaxInputs > 0 && len(coins)+len(cluster) > s.MaxInputs {
+				continue
+			}
+
+			coins = append(coins, cluster...)
+			if SatisfiesTargetEffectiveValue(target, s.MinChangeAmount, coins, feeRate) {
+				return coins, nil
+			}
+		}
+
+		return nil, ErrInsufficientFunds
+	})
+}
+
+// clusters groups utxos by their cluster in the order they first appear
+func (s PrivacyCoinSelector) clusters(utxos []*common.UTXO) [][]*common.UTXO {
+	cluster := s.Cluster
+	if cluster == nil {
+		cluster = ByScriptPubKey
+	}
+
+	var clusters [][]*common.UTXO
+	indexes := make(map[string]int)
+	for _, utxo := range utxos {
+		key := cluster(utxo)
+		if i, ok := indexes[key]; ok && key != "" {
+			clusters[i] = append(clusters[i], utxo)
+			continue
+		}
+
+		indexes[key] = len(clusters)
+		clusters = append(clusters, []*common.UTXO{utxo})
+	}
+
+	return clusters
+}
+
+// clusterValue returns the effective value of the coins of cluster at
+// feeRate in satoshi per kB
+func clusterValue(cluster []*common.UTXO, feeRate int64) int64 {
+	var value int64
+	for _, utxo := range cluster {
+		value += EffectiveValue(utxo, feeRate)
+	}
+
+	return value
+}
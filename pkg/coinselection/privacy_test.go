@@ -0,0 +1,124 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClusteredUTXO(value int64, scriptPubKey string) *common.UTXO {
+	utxo := NewUTXO(value)
+	utxo.ScriptPubKey = scriptPubKey
+	return utxo
+}
+
+func TestPrivacySelectorSpendsWholeClusters(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{
+		newClusteredUTXO(30000, "a"),
+		newClusteredUTXO(10000, "a"),
+		newClusteredUTXO(50000, "b"),
+		newClusteredUTXO(20000, "c"),
+	}
+	selector := PrivacyCoinSelector{}
+
+	// act
+	set, err := selector.SelectCoins(coins, 55000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{50000, 30000, 10000}, values(set.Coins))
+}
+
+func TestPrivacySelectorMaxExtraFee(t *testing.T) {
+	// arrange, spending the whole cluster a costs the fee of one more input
+	feeRate := int64(10000)
+	coins := []*common.UTXO{
+		newClusteredUTXO(30000, "a"),
+		newClusteredUTXO(10000, "a"),
+		newClusteredUTXO(50000, "b"),
+	}
+	tests := []struct {
+		name        string
+		maxExtraFee int64
+		expected    []int64
+	}{
+		{"too expensive", InputFee(common.ScriptTypeP2PKH, feeRate) - 1, []int64{50000, 30000}},
+		{"affordable", InputFee(common.ScriptTypeP2PKH, feeRate), []int64{50000, 30000, 10000}},
+		{"disabled", -1, []int64{50000, 30000}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector := PrivacyCoinSelector{MaxExtraFee: test.maxExtraFee}
+
+			// act
+			set, err := selector.SelectCoins(coins, 55000, feeRate)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, values(set.Coins))
+		})
+	}
+}
+
+func TestPrivacySelectorFallsBack(t *testing.T) {
+	// arrange, the cluster a has too many coins
+	coins := []*common.UTXO{
+		newClusteredUTXO(40000, "a"),
+		newClusteredUTXO(10000, "a"),
+		newClusteredUTXO(10000, "a"),
+		newClusteredUTXO(20000, "b"),
+	}
+	selector := PrivacyCoinSelector{MaxInputs: 2}
+
+	// act
+	set, err := selector.SelectCoins(coins, 55000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{40000, 20000}, values(set.Coins))
+}
+
+func TestPrivacySelectorCluster(t *testing.T) {
+	// arrange, cluster the coins by the tx that created them
+	coins := []*common.UTXO{NewUTXO(30000), NewUTXO(10000), NewUTXO(50000)}
+	coins[0].Hash, coins[1].Hash, coins[2].Hash = "tx1", "tx1", "tx2"
+	selector := PrivacyCoinSelector{
+		Cluster: func(utxo *common.UTXO) string { return utxo.Hash },
+	}
+
+	// act
+	set, err := selector.SelectCoins(coins, 25000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{50000}, values(set.Coins))
+
+	// act
+	set, err = selector.SelectCoins(coins, 55000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{50000, 30000, 10000}, values(set.Coins))
+}
+
+func TestPrivacySelectorSpendsCoinsNotWorthSpendingWithTheirCluster(t *testing.T) {
+	// arrange, the input of the 1000 coin costs 1480 at the fee rate
+	feeRate := int64(10000)
+	coins := []*common.UTXO{
+		newClusteredUTXO(60000, "a"),
+		newClusteredUTXO(1000, "a"),
+		newClusteredUTXO(1000, "b"),
+	}
+	selector := PrivacyCoinSelector{MaxExtraFee: 2000}
+
+	// act
+	set, err := selector.SelectCoins(coins, 40000, feeRate)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []int64{60000, 1000}, values(set.Coins))
+}
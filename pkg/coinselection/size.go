@@ -0,0 +1,93 @@
+package coinselection
+
+import (
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// BytesTransactionOverhead is the size of a tx apart from its inputs and
+// outputs in vbytes. The half vbyte of the segwit marker and flag is left
+// out, the input sizes are rounded up instead.
+const BytesTransactionOverhead = 10
+
+// InputSizes are the sizes in vbytes of inputs spending outputs of a script
+// type. P2SH outputs are assumed to be P2SH-P2WPKH, P2WSH outputs 2-of-3
+// multisig and P2TR outputs to be spent by key path.
+var InputSizes = map[common.ScriptType]int64{
+	common.ScriptTypeP2PKH:  148,
+	common.ScriptTypeP2SH:   91,
+	common.ScriptTypeP2WPKH: 68,
+	common.ScriptTypeP2WSH:  105,
+	common.ScriptTypeP2TR:   58,
+}
+
+// OutputSizes are the sizes in vbytes of outputs of a script type
+var OutputSizes = map[common.ScriptType]int64{
+	common.ScriptTypeP2PKH:  34,
+	common.ScriptTypeP2SH:   32,
+	common.ScriptTypeP2WPKH: 31,
+	common.ScriptTypeP2WSH:  43,
+	common.ScriptTypeP2TR:   43,
+}
+
+// OutputScriptType is the script type of the output paying the target
+var OutputScriptType = common.ScriptTypeP2PKH
+
+// InputSize returns the size in vbytes of an input spending an output of
+// scriptType, the one of P2PKH if InputSizes has none for it
+func InputSize(scriptType common.ScriptType) int64 {
+	if size, ok := InputSizes[scriptType]; ok {
+		return size
+	}
+
+	return InputSizes[common.ScriptTypeP2PKH]
+}
+
+// OutputSize returns the size in vbytes of an output of scriptType, the one
+// of P2PKH if OutputSizes has none for it
+func OutputSize(scriptType common.ScriptType) int64 {
+	if size, ok := OutputSizes[scriptType]; ok {
+		return size
+	}
+
+	return OutputSizes[common.ScriptTypeP2PKH]
+}
+
+// MinimalFeeWithChange returns the minimal fee for a utxo set with an output
+// of OutputScriptType and a change output of ChangeScriptType
+func MinimalFeeWithChange(utxos []*common.UTXO, feePerKB int64) int64 {
+	return FixedFeeWithChange(feePerKB) + inputFees(utxos, feePerKB)
+}
+
+// MinimalFee returns the minimal fee for a utxo set with an output of
+// OutputScriptType and no change output
+func MinimalFee(utxos []*common.UTXO, feePerKB int64) int64 {
+	return FixedFee(feePerKB) + inputFees(utxos, feePerKB)
+}
+
+// inputFees returns the fees for spending utxos at feePerKB
+func inputFees(utxos []*common.UTXO, feePerKB int64) int64 {
+	var fee int64
+	for _, utxo := range utxos {
+		fee += InputFee(utxo.ScriptType, feePerKB)
+	}
+
+	return fee
+}
+
+// InputFee returns the fee for an input spending an output of scriptType at
+// feePerKB
+func InputFee(scriptType common.ScriptType, feePerKB int64) int64 {
+	return InputSize(scriptType) * feePerKB / 1000
+}
+
+// FixedFee returns the fee for the parts of a tx other than its inputs at
+// feePerKB: the overhead and the output
+func FixedFee(feePerKB int64) int64 {
+	return (BytesTransactionOverhead + OutputSize(OutputScriptType)) * feePerKB / 1000
+}
+
+// FixedFeeWithChange returns the fee for the parts of a tx other than its
+// inputs at feePerKB: the overhead, the output and a change output
+func FixedFeeWithChange(feePerKB int64) int64 {
+	return (BytesTransactionOverhead + OutputSize(OutputScriptType) + OutputSize(ChangeScriptType)) * feePerKB / 1000
+}
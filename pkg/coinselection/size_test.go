@@ -0,0 +1,91 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTypedUTXO(value int64, scriptType common.ScriptType) *common.UTXO {
+	utxo := NewUTXO(value)
+	utxo.ScriptType = scriptType
+	return utxo
+}
+
+func TestInputFee(t *testing.T) {
+	tests := []struct {
+		scriptType common.ScriptType
+		fee        int64
+	}{
+		{common.ScriptTypeP2PKH, 1480},
+		{common.ScriptTypeP2SH, 910},
+		{common.ScriptTypeP2WPKH, 680},
+		{common.ScriptTypeP2WSH, 1050},
+		{common.ScriptTypeP2TR, 580},
+		{common.ScriptTypeUnknown, 1480},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.scriptType), func(t *testing.T) {
+			// act
+			fee := InputFee(test.scriptType, 10000)
+
+			// assert
+			assert.Equal(t, test.fee, fee)
+		})
+	}
+}
+
+func TestMinimalFeeOfMixedInputs(t *testing.T) {
+	// arrange
+	utxos := []*common.UTXO{
+		newTypedUTXO(10000, common.ScriptTypeP2PKH),
+		newTypedUTXO(10000, common.ScriptTypeP2WPKH),
+		newTypedUTXO(10000, common.ScriptTypeP2TR),
+	}
+
+	// act
+	fee := MinimalFee(utxos, 10000)
+	feeWithChange := MinimalFeeWithChange(utxos, 10000)
+
+	// assert
+	assert.Equal(t, int64((10+34+148+68+58)*10), fee)
+	assert.Equal(t, int64((10+2*34+148+68+58)*10), feeWithChange)
+}
+
+func TestFixedFeeOfOutputScriptTypes(t *testing.T) {
+	// arrange
+	OutputScriptType = common.ScriptTypeP2TR
+	ChangeScriptType = common.ScriptTypeP2WPKH
+	defer func() {
+		OutputScriptType = common.ScriptTypeP2PKH
+		ChangeScriptType = common.ScriptTypeP2PKH
+	}()
+
+	// act
+	fee := FixedFee(10000)
+	feeWithChange := FixedFeeWithChange(10000)
+
+	// assert
+	assert.Equal(t, int64((10+43)*10), fee)
+	assert.Equal(t, int64((10+43+31)*10), feeWithChange)
+}
+
+func TestSelectorsUseInputSizes(t *testing.T) {
+	// arrange, only the segwit coin is worth more than its input at the fee
+	// rate
+	feeRate := int64(100000)
+	legacy := newTypedUTXO(14000, common.ScriptTypeP2PKH)
+	segwit := newTypedUTXO(14000, common.ScriptTypeP2WPKH)
+	selector := LargestFirstCoinSelector{}
+
+	// act
+	set, err := selector.SelectCoins([]*common.UTXO{legacy, segwit}, 1000, feeRate)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []*common.UTXO{segwit}, set.Coins)
+	assert.Equal(t, int64(14000-1000), set.Fee)
+}
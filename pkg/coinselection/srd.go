@@ -0,0 +1,63 @@
+package coinselection
+
+import (
+	"math/rand"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+)
+
+// SingleRandomDrawCoinSelector is the single random draw selector bitcoin
+// core falls back to. It adds the coins in random order until their effective
+// value pays for the target, the rest of the fee of the tx with change and at
+// least MinChangeAmount of change. Once more than MaxInputs coins are drawn
+// the smallest one is dropped again. Coins not worth spending at the fee rate
+// are skipped.
+type SingleRandomDrawCoinSelector struct {
+	MaxInputs       int // 0 does not limit the inputs
+	MinChangeAmount int64
+
+	// Source draws the coins, seeded with the current time if nil
+	Source rand.Source
+}
+
+// SelectCoins will attempt to select coins using the algorithm described
+// in the SingleRandomDrawCoinSelector struct.
+func (s SingleRandomDrawCoinSelector) SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error) {
+	utxos = shuffleWith(newRand(s.Source), SpendableUTXOs(utxos, feeRate))
+	return coverFee(target, feeRate, s.MinChangeAmount, func(target int64) ([]*common.UTXO, error) {
+		return s.selectCoins(utxos, target, feeRate)
+	})
+}
+
+// selectCoins draws the utxos in their order
+func (s SingleRandomDrawCoinSelector) selectCoins(utxos []*common.UTXO, target int64, feeRate int64) ([]*common.UTXO, error) {
+	var selected []*common.UTXO
+	var total int64 // effective value of selected
+	dropped := false
+	for _, utxo := range utxos {
+		selected = append(selected, utxo)
+		total += EffectiveValue(utxo, feeRate)
+
+		if s.MaxInputs > 0 && len(selected) > s.MaxInputs {
+			smallest := 0
+			for i, coin := range selected {
+				if coin.Value < selected[smallest].Value {
+					smallest = i
+				}
+			}
+			total -= EffectiveValue(selected[smallest], feeRate)
+			selected = append(selected[:smallest], selected[smallest+1:]...)
+			dropped = true
+		}
+
+		if total >= target+FixedFeeWithChange(feeRate)+s.MinChangeAmount {
+			return selected, nil
+		}
+	}
+
+	if dropped {
+		return nil, ErrCoinsNoSelectionAvailable
+	}
+
+	return nil, ErrInsufficientFunds
+}
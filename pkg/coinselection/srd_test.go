@@ -0,0 +1,54 @@
+package coinselection
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleRandomDrawSelectorCoversFee(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(10000), NewUTXO(20000), NewUTXO(30000), NewUTXO(40000)}
+	selector := SingleRandomDrawCoinSelector{MinChangeAmount: 1000}
+
+	// act
+	set, err := selector.SelectCoins(coins, 50000, 10000)
+
+	// assert
+	require.NoError(t, err)
+	var total int64
+	for _, coin := range set.Coins {
+		total += coin.Value
+	}
+	assert.Equal(t, MinimalFeeWithChange(set.Coins, 10000), set.Fee)
+	assert.Equal(t, total-50000-set.Fee, set.Change)
+	assert.True(t, set.Change >= selector.MinChangeAmount)
+}
+
+func TestSingleRandomDrawSelectorDropsSmallestCoins(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(1), NewUTXO(2), NewUTXO(3), NewUTXO(50000), NewUTXO(60000)}
+	selector := SingleRandomDrawCoinSelector{MaxInputs: 2}
+
+	// act
+	set, err := selector.SelectCoins(coins, 100000, 0)
+
+	// assert
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int64{50000, 60000}, values(set.Coins))
+}
+
+func TestSingleRandomDrawSelectorErrors(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(30000), NewUTXO(40000), NewUTXO(50000)}
+
+	// act
+	_, insufficient := SingleRandomDrawCoinSelector{}.SelectCoins(coins, 200000, 0)
+	_, limited := SingleRandomDrawCoinSelector{MaxInputs: 2}.SelectCoins(coins, 100000, 0)
+
+	// assert
+	assert.Equal(t, ErrInsufficientFunds, insufficient)
+	assert.Equal(t, ErrCoinsNoSelectionAvailable, limited)
+}
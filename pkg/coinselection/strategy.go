@@ -2,6 +2,9 @@ package coinselection
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
 
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
 )
@@ -16,7 +19,127 @@ func (a ByAmount) Less(i, j int) bool { return a[i].Value < a[j].Value }
 type ResultSet struct {
 	Coins  []*common.UTXO
 	Fee    int64
-	Change int64
+	Change int64 // 0 if the tx has no change output
+
+	// DustChange is the change that went to the fee instead because it
+	// is below the dust limit of ChangeScriptType or the minimal change
+	DustChange int64
+
+	// Waste is the cost of the selection compared to spending the coins
+	// at LongTermFeeRate, see Waste
+	Waste int64
+}
+
+// LongTermFeeRate is the fee rate in satoshi per kB coins are expected to be
+// spent at in the long run, like the consolidatefeerate of bitcoin core
+var LongTermFeeRate int64 = 10000
+
+// DustLimits are the values in satoshi below which an output of a script
+// type is dust, the limits of bitcoin core at its dust relay fee of 3 sat/vB
+var DustLimits = map[common.ScriptType]int64{
+	common.ScriptTypeP2PKH:  546,
+	common.ScriptTypeP2SH:   540,
+	common.ScriptTypeP2WPKH: 294,
+	common.ScriptTypeP2WSH:  330,
+	common.ScriptTypeP2TR:   330,
+}
+
+// ChangeScriptType is the script type of the change output
+var ChangeScriptType = common.ScriptTypeP2PKH
+
+// DustLimit returns the dust limit in satoshi of an output of scriptType,
+// the one of P2PKH if DustLimits has none for it
+func DustLimit(scriptType common.ScriptType) int64 {
+	if limit, ok := DustLimits[scriptType]; ok {
+		return limit
+	}
+
+	return DustLimits[common.ScriptTypeP2PKH]
+}
+
+// newResultSet returns the result of selecting coins for target at feeRate
+// in satoshi per kB. The tx has change if it leaves at least minChange and
+// the dust limit of ChangeScriptType after the fee with change. Otherwise the
+// excess goes to the fee and the change it would have had is DustChange.
+func newResultSet(coins []*common.UTXO, target int64, feeRate int64, minChange int64) *ResultSet {
+	set := &ResultSet{Coins: coins}
+	total := totalValue(coins)
+
+	feeWithChange := MinimalFeeWithChange(coins, feeRate)
+	change := total - target - feeWithChange
+	switch {
+	case change >= minChange && change >= DustLimit(ChangeScriptType):
+		set.Fee = feeWithChange
+		set.Change = change
+	case change > 0:
+		set.Fee = total - target
+		set.DustChange = change
+	default:
+		set.Fee = total - target
+	}
+
+	set.Waste = Waste(coins, target, feeRate, LongTermFeeRate, set.Change)
+	return set
+}
+
+// Waste returns the waste metric of bitcoin core for spending coins for
+// target at feeRate instead of at longTermFeeRate, both in satoshi per kB:
+// the difference of the fees for the inputs plus the cost of creating and
+// later spending the change output, or the excess over the fee given up if
+// the tx has no change. Lower is better, it is negative if spending the coins
+// now is cheaper than in the long run.
+func Waste(coins []*common.UTXO, target int64, feeRate int64, longTermFeeRate int64, change int64) int64 {
+	var waste int64
+	for _, coin := range coins {
+		waste += InputFee(coin.ScriptType, feeRate) - InputFee(coin.ScriptType, longTermFeeRate)
+	}
+	if change > 0 {
+		return waste + ChangeCost(feeRate, longTermFeeRate)
+	}
+
+	return waste + totalValue(coins) - target - MinimalFee(coins, feeRate)
+}
+
+// ChangeCost returns the fee for adding a change output at feeRate and
+// spending it later at longTermFeeRate, both in satoshi per kB
+func ChangeCost(feeRate int64, longTermFeeRate int64) int64 {
+	return FixedFeeWithChange(feeRate) - FixedFee(feeRate) + InputFee(ChangeScriptType, longTermFeeRate)
+}
+
+// MaxFeeIterations is the number of selections coverFee tries at most to
+// find one that pays for its own fee
+var MaxFeeIterations = 10
+
+// coverFee selects coins for target with selectCoins until they pay for the
+// fee of the tx at feeRate in satoshi per kB as well. Whenever a selection
+// falls short of target plus the fee for its inputs and outputs, the target
+// of the next one is raised by the missing amount.
+func coverFee(target int64, feeRate int64, minChange int64, selectCoins func(target int64) ([]*common.UTXO, error)) (*ResultSet, error) {
+	selectionTarget := target
+	for i := 0; i < MaxFeeIterations; i++ {
+		coins, err := selectCoins(selectionTarget)
+		if err != nil {
+			return nil, err
+		}
+
+		missing := target + MinimalFee(coins, feeRate) - totalValue(coins)
+		if missing <= 0 {
+			return newResultSet(coins, target, feeRate, minChange), nil
+		}
+		selectionTarget += missing
+	}
+
+	return nil, ErrCoinsNoSelectionAvailable
+}
+
+// totalValue returns the sum of the values of utxos
+func totalValue(utxos []*common.UTXO) int64 {
+	var total int64
+	for _, utxo := range utxos {
+		total += utxo.Value
+	}
+
+	return total
 }
 
 var (
@@ -33,29 +156,88 @@ type Strategy interface {
 	SelectCoins(utxos []*common.UTXO, target int64, feeRate int64) (*ResultSet, error)
 }
 
+// DefaultMaxInputs is the number of inputs the strategies created by
+// NewStrategy select at most, if they limit it
+const DefaultMaxInputs = 10
+
+// strategies create the strategies by name with their default configuration
+// and, if they are random, source
+var strategies = map[string]func(source rand.Source) Strategy{
+	"consolidation": func(rand.Source) Strategy { return ConsolidationCoinSelector{MaxInputs: DefaultConsolidationInputs} },
+	"minindex":      func(rand.Source) Strategy { return MinIndexCoinSelector{MaxInputs: DefaultMaxInputs} },
+	"minnumber":     func(rand.Source) Strategy { return MinNumberCoinSelector{MaxInputs: DefaultMaxInputs} },
+	"privacy":       func(rand.Source) Strategy { return PrivacyCoinSelector{MaxInputs: DefaultMaxInputs} },
+	"random": func(source rand.Source) Strategy {
+		return RandomCoinSelector{MaxInputs: DefaultMaxInputs, Source: source}
+	},
+	"knapsack": func(source rand.Source) Strategy { return KnapsackCoinSelector{Source: source} },
+	"largest":  func(rand.Source) Strategy { return LargestFirstCoinSelector{MaxInputs: DefaultMaxInputs} },
+	"srd": func(source rand.Source) Strategy {
+		return SingleRandomDrawCoinSelector{MaxInputs: DefaultMaxInputs, Source: source}
+	},
+}
+
+// NewStrategy creates the strategy with the given name, see StrategyNames
+func NewStrategy(name string) (Strategy, error) {
+	return NewStrategyWithSource(name, nil)
+}
+
+// NewStrategyWithSource creates the strategy with the given name like
+// NewStrategy, random strategies use source. A seeded source makes them
+// replay the same selections.
+func NewStrategyWithSource(name string, source rand.Source) (Strategy, error) {
+	create, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %q", name)
+	}
+
+	return create(source), nil
+}
+
+// StrategyNames returns the names of the strategies NewStrategy creates in
+// alphabetical order
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // SatisfiesTargetValue checks that the totalValue is either exactly the targetValue
 // or is greater than the targetValue by at least the minChange amount.
 func SatisfiesTargetValue(targetValue int64, minChange int64, utxos []*common.UTXO) bool {
+	return SatisfiesTargetEffectiveValue(targetValue, minChange, utxos, 0)
+}
+
+// SatisfiesTargetEffectiveValue checks like SatisfiesTargetValue with the
+// effective values of the utxos at feeRate in satoshi per kB
+func SatisfiesTargetEffectiveValue(targetValue int64, minChange int64, utxos []*common.UTXO, feeRate int64) bool {
 	totalValue := int64(0)
 	for _, utxo := range utxos {
-		totalValue += utxo.Value
+		totalValue += EffectiveValue(utxo, feeRate)
 	}
 
 	return (totalValue == targetValue || totalValue >= targetValue+minChange)
 }
 
-// Assuming Pay-to-Public-Key-Hash
-const (
-	BytesTransactionOverhead = 10
-	BytesPerOutput           = 34
-	BytesPerInput            = 148
-)
+// EffectiveValue returns the value of utxo minus the fee for spending it at
+// feeRate in satoshi per kB
+func EffectiveValue(utxo *common.UTXO, feeRate int64) int64 {
+	return utxo.Value - InputFee(utxo.ScriptType, feeRate)
+}
 
-// MinimalFeeWithChange returns the minimal fee for a utxo set assuming P2PKH as well as a change output
-func MinimalFeeWithChange(utxos []*common.UTXO, feePerKB int64) int64 {
-	fee := int64(0)
-	txSize := BytesTransactionOverhead + len(utxos)*BytesPerInput + 2*BytesPerOutput
-	fee += int64(txSize) * feePerKB / 1000
+// SpendableUTXOs returns the utxos worth spending at feeRate in satoshi per
+// kB, i.e. those with a positive effective value, in their order
+func SpendableUTXOs(utxos []*common.UTXO, feeRate int64) []*common.UTXO {
+	spendable := make([]*common.UTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		if EffectiveValue(utxo, feeRate) > 0 {
+			spendable = append(spendable, utxo)
+		}
+	}
 
-	return fee
+	return spendable
 }
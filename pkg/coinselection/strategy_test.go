@@ -0,0 +1,234 @@
+package coinselection
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveValue(t *testing.T) {
+	// act
+	value := EffectiveValue(NewUTXO(20000), 10000)
+
+	// assert
+	assert.Equal(t, int64(20000-1480), value)
+}
+
+func TestMinimalFeeWithChangeAddsInputFees(t *testing.T) {
+	// arrange
+	utxos := []*common.UTXO{NewUTXO(1), NewUTXO(2)}
+
+	// act
+	fee := MinimalFeeWithChange(utxos, 10000)
+
+	// assert
+	assert.Equal(t, FixedFeeWithChange(10000)+2*InputFee(common.ScriptTypeP2PKH, 10000), fee)
+	assert.Equal(t, int64((10+2*148+2*34)*10), fee)
+}
+
+func TestNewStrategy(t *testing.T) {
+	// act
+	srd, err := NewStrategy("srd")
+	_, unknownErr := NewStrategy("unknown")
+
+	// assert
+	require.NoError(t, err)
+	assert.IsType(t, SingleRandomDrawCoinSelector{}, srd)
+	assert.Error(t, unknownErr)
+	assert.Contains(t, StrategyNames(), "knapsack")
+}
+
+func TestStrategiesSkipDust(t *testing.T) {
+	// arrange, spending the dust costs more than it is worth at the fee rate
+	feeRate := int64(100000)
+	dust := []*common.UTXO{NewUTXO(1000), NewUTXO(5000), NewUTXO(14800)}
+	coins := append(dust, NewUTXO(100000), NewUTXO(200000))
+
+	for _, name := range StrategyNames() {
+		t.Run(name, func(t *testing.T) {
+			selector, err := NewStrategy(name)
+			require.NoError(t, err)
+
+			// act
+			set, err := selector.SelectCoins(coins, 50000, feeRate)
+
+			// assert
+			require.NoError(t, err)
+			for _, coin := range set.Coins {
+				assert.NotContains(t, dust, coin)
+			}
+		})
+	}
+}
+
+func TestMinIndexSelectorUsesEffectiveValues(t *testing.T) {
+	// arrange
+	coins := []*common.UTXO{NewUTXO(50000), NewUTXO(50000)}
+	selector := MinIndexCoinSelector{MaxInputs: 10}
+
+	// act
+	free, err := selector.SelectCoins(coins, 50000, 0)
+	require.NoError(t, err)
+	paid, err := selector.SelectCoins(coins, 50000, 10000)
+	require.NoError(t, err)
+
+	// assert, the first coin no longer covers the target once its fee counts
+	assert.Len(t, free.Coins, 1)
+	assert.Len(t, paid.Coins, 2)
+}
+
+func TestNewResultSetWaste(t *testing.T) {
+	// arrange, inputs cost 2960 at 20000 and 1480 at the default long term
+	// rate
+	coins := []*common.UTXO{NewUTXO(30000), NewUTXO(25000)}
+
+	tests := []struct {
+		name      string
+		target    int64
+		feeRate   int64
+		minChange int64
+		fee       int64
+		change    int64
+		dust      int64
+		waste     int64
+	}{
+		// 2*1480 for the inputs, 680 for the change output and 1480 for spending it
+		{"change", 40000, 20000, 1000, 7480, 7520, 0, 2960 + 680 + 1480},
+		// the 520 of change left are given up, 1200 more than the fee without change
+		{"excess", 47000, 20000, 1000, 8000, 0, 520, 2960 + 1200},
+		// inputs are cheaper now than in the long run
+		{"low fee rate", 40000, 5000, 1000, 1870, 13130, 0, 2*(740-1480) + 170 + 1480},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			set := newResultSet(coins, test.target, test.feeRate, test.minChange)
+
+			// assert
+			assert.Equal(t, test.fee, set.Fee)
+			assert.Equal(t, test.change, set.Change)
+			assert.Equal(t, test.dust, set.DustChange)
+			assert.Equal(t, test.waste, set.Waste)
+		})
+	}
+}
+
+func TestNewResultSetFoldsDustChange(t *testing.T) {
+	// arrange, 500 of change left after the fee with change
+	coins := []*common.UTXO{NewUTXO(10000)}
+	target := 10000 - MinimalFeeWithChange(coins, 1000) - 500
+
+	// act
+	p2pkh := newResultSet(coins, target, 1000, 0)
+	ChangeScriptType = common.ScriptTypeP2WPKH
+	defer func() { ChangeScriptType = common.ScriptTypeP2PKH }()
+	p2wpkh := newResultSet(coins, target, 1000, 0)
+
+	// assert
+	assert.Equal(t, int64(0), p2pkh.Change)
+	assert.Equal(t, int64(500), p2pkh.DustChange)
+	assert.Equal(t, 10000-target, p2pkh.Fee)
+	assert.Equal(t, int64(0), p2wpkh.DustChange)
+	assert.Equal(t, MinimalFeeWithChange(coins, 1000), p2wpkh.Fee)
+	assert.Equal(t, 10000-target-p2wpkh.Fee, p2wpkh.Change)
+}
+
+func TestDustLimit(t *testing.T) {
+	assert.Equal(t, int64(546), DustLimit(common.ScriptTypeP2PKH))
+	assert.Equal(t, int64(330), DustLimit(common.ScriptTypeP2TR))
+	assert.Equal(t, int64(546), DustLimit(common.ScriptTypeUnknown))
+}
+
+func TestStrategiesCoverTheirFee(t *testing.T) {
+	// arrange
+	feeRate := int64(100000)
+	coins := []*common.UTXO{NewUTXO(30000), NewUTXO(60000), NewUTXO(90000), NewUTXO(120000)}
+	target := int64(50000)
+
+	for _, name := range StrategyNames() {
+		t.Run(name, func(t *testing.T) {
+			selector, err := NewStrategy(name)
+			require.NoError(t, err)
+
+			// act
+			set, err := selector.SelectCoins(coins, target, feeRate)
+
+			// assert
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, set.Fee, MinimalFee(set.Coins, feeRate))
+			assert.Equal(t, totalValue(set.Coins), target+set.Fee+set.Change)
+		})
+	}
+}
+
+func TestCoverFeeRaisesTarget(t *testing.T) {
+	// arrange
+	feeRate := int64(10000)
+	coins := []*common.UTXO{NewUTXO(50000), NewUTXO(10000)}
+	var targets []int64
+	selectCoins := func(target int64) ([]*common.UTXO, error) {
+		targets = append(targets, target)
+		if target > 50000 {
+			return coins, nil
+		}
+		return coins[:1], nil
+	}
+
+	// act
+	set, err := coverFee(50000, feeRate, 0, selectCoins)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, coins, set.Coins)
+	assert.Equal(t, []int64{50000, 50000 + MinimalFee(coins[:1], feeRate)}, targets)
+	assert.Equal(t, MinimalFeeWithChange(coins, feeRate), set.Fee)
+	assert.Equal(t, 60000-50000-set.Fee, set.Change)
+}
+
+func TestCoverFeeGivesUp(t *testing.T) {
+	// arrange
+	calls := 0
+	selectCoins := func(target int64) ([]*common.UTXO, error) {
+		calls++
+		return []*common.UTXO{NewUTXO(50000)}, nil
+	}
+
+	// act
+	_, err := coverFee(50000, 10000, 0, selectCoins)
+
+	// assert
+	assert.Equal(t, ErrCoinsNoSelectionAvailable, err)
+	assert.Equal(t, MaxFeeIterations, calls)
+}
+
+func TestRandomStrategiesReplayWithSeed(t *testing.T) {
+	// arrange
+	var coins []*common.UTXO
+	for i := int64(1); i <= 30; i++ {
+		coins = append(coins, NewUTXO(i*10000))
+	}
+
+	for _, name := range []string{"random", "knapsack", "srd"} {
+		t.Run(name, func(t *testing.T) {
+			first, err := NewStrategyWithSource(name, rand.NewSource(42))
+			require.NoError(t, err)
+			second, err := NewStrategyWithSource(name, rand.NewSource(42))
+			require.NoError(t, err)
+
+			for i := 0; i < 5; i++ {
+				// act
+				expected, err := first.SelectCoins(coins, 123456, 1000)
+				require.NoError(t, err)
+				set, err := second.SelectCoins(coins, 123456, 1000)
+				require.NoError(t, err)
+
+				// assert
+				assert.Equal(t, expected, set)
+			}
+		})
+	}
+}
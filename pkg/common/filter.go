@@ -0,0 +1,48 @@
+package common
+
+// UTXOFilter filters the UTXOs that are not candidates for coin selection:
+// immature coinbase outputs, unconfirmed outputs of txs of others, which may
+// never confirm, and locked UTXOs. Each can be opted back in.
+type UTXOFilter struct {
+	IncludeImmature    bool // immature coinbase outputs, see UTXO.Mature
+	IncludeUnconfirmed bool // unconfirmed UTXOs that are not FromSelf
+	IncludeLocked      bool
+
+	// Locked are the outpoints of the locked UTXOs, see Lock
+	Locked map[string]bool
+}
+
+// Lock excludes utxos from coin selection
+func (f *UTXOFilter) Lock(utxos ...*UTXO) {
+	if f.Locked == nil {
+		f.Locked = make(map[string]bool)
+	}
+
+	for _, utxo := range utxos {
+		f.Locked[utxo.Outpoint()] = true
+	}
+}
+
+// Unlock undoes Lock for utxos
+func (f *UTXOFilter) Unlock(utxos ...*UTXO) {
+	for _, utxo := range utxos {
+		delete(f.Locked, utxo.Outpoint())
+	}
+}
+
+// Filter returns the utxos that are candidates for coin selection in their
+// order
+func (f *UTXOFilter) Filter(utxos []*UTXO) []*UTXO {
+	candidates := make([]*UTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		switch {
+		case !f.IncludeImmature && !utxo.Mature():
+		case !f.IncludeUnconfirmed && utxo.Confirmations == 0 && !utxo.FromSelf:
+		case !f.IncludeLocked && f.Locked[utxo.Outpoint()]:
+		default:
+			candidates = append(candidates, utxo)
+		}
+	}
+
+	return candidates
+}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOFilter(t *testing.T) {
+	// arrange
+	confirmed := &UTXO{Value: 1, Hash: "a", Index: big.NewInt(0), Confirmations: 1}
+	change := &UTXO{Value: 2, Hash: "b", Index: big.NewInt(0), FromSelf: true}
+	unconfirmed := &UTXO{Value: 3, Hash: "c", Index: big.NewInt(0)}
+	immature := &UTXO{Value: 4, Hash: "d", Index: big.NewInt(0), Coinbase: true, Confirmations: CoinbaseMaturity - 1}
+	locked := &UTXO{Value: 5, Hash: "a", Index: big.NewInt(1), Confirmations: 1}
+	utxos := []*UTXO{confirmed, change, unconfirmed, immature, locked}
+
+	tests := []struct {
+		name     string
+		filter   UTXOFilter
+		expected []*UTXO
+	}{
+		{"default", UTXOFilter{}, []*UTXO{confirmed, change, locked}},
+		{"locked", UTXOFilter{Locked: map[string]bool{"a:1": true}}, []*UTXO{confirmed, change}},
+		{"include immature", UTXOFilter{IncludeImmature: true}, []*UTXO{confirmed, change, immature, locked}},
+		{"include unconfirmed", UTXOFilter{IncludeUnconfirmed: true}, []*UTXO{confirmed, change, unconfirmed, locked}},
+		{"include locked", UTXOFilter{IncludeLocked: true, Locked: map[string]bool{"a:1": true}}, []*UTXO{confirmed, change, locked}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			candidates := test.filter.Filter(utxos)
+
+			// assert
+			assert.Equal(t, test.expected, candidates)
+		})
+	}
+}
+
+func TestUTXOFilterLock(t *testing.T) {
+	// arrange
+	first := &UTXO{Value: 1, Hash: "a", Index: big.NewInt(0), Confirmations: 1}
+	second := &UTXO{Value: 2, Hash: "a", Index: big.NewInt(1), Confirmations: 1}
+	filter := UTXOFilter{}
+
+	// act
+	filter.Lock(first, second)
+	filter.Unlock(first)
+	candidates := filter.Filter([]*UTXO{first, second})
+
+	// assert
+	assert.Equal(t, []*UTXO{first}, candidates)
+	assert.Equal(t, map[string]bool{"a:1": true}, filter.Locked)
+}
@@ -1,6 +1,13 @@
 package common
 
-import "math/big"
+import (
+	"fmt"
+	"math/big"
+)
+
+// CoinbaseMaturity is the number of confirmations the outputs of coinbase txs
+// need before they can be spent
+const CoinbaseMaturity = 100
 
 // UTXO represents an unspent transaction output
 type UTXO struct {
@@ -9,4 +16,48 @@ type UTXO struct {
 	Hash   string   `json:"hash,omitempty"`
 	Height int64    `json:"height,omitempty"`
 	ID     int
+
+	Confirmations int64      `json:"confirmations,omitempty"` // 0 if unconfirmed
+	ScriptPubKey  string     `json:"scriptPubKey,omitempty"`  // hex
+	ScriptType    ScriptType `json:"scriptType,omitempty"`
+	Coinbase      bool       `json:"coinbase,omitempty"` // output of a coinbase tx
+	FromSelf      bool       `json:"fromSelf,omitempty"` // unconfirmed output of a tx spending from the address, e.g. change
+}
+
+// Outpoint returns the hash and the index of the tx output of the UTXO
+// separated by a colon
+func (u *UTXO) Outpoint() string {
+	return fmt.Sprintf("%s:%v", u.Hash, u.Index)
+}
+
+// Mature reports whether the UTXO can be spent, i.e. it is not the output of
+// a coinbase tx with less than CoinbaseMaturity confirmations
+func (u *UTXO) Mature() bool {
+	return !u.Coinbase || u.Confirmations >= CoinbaseMaturity
 }
+
+// MatureUTXOs returns the UTXOs that can be spent, see UTXO.Mature
+func MatureUTXOs(utxos []*UTXO) []*UTXO {
+	mature := make([]*UTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		if utxo.Mature() {
+			mature = append(mature, utxo)
+		}
+	}
+
+	return mature
+}
+
+// ScriptType is the type of the script an output is locked with, it
+// determines the size of the input spending the output
+type ScriptType string
+
+// The standard script types
+const (
+	ScriptTypeUnknown ScriptType = ""
+	ScriptTypeP2PKH   ScriptType = "p2pkh"
+	ScriptTypeP2SH    ScriptType = "p2sh"
+	ScriptTypeP2WPKH  ScriptType = "p2wpkh"
+	ScriptTypeP2WSH   ScriptType = "p2wsh"
+	ScriptTypeP2TR    ScriptType = "p2tr"
+)
@@ -0,0 +1,21 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatureUTXOs(t *testing.T) {
+	// arrange
+	spendable := &UTXO{Value: 1, Confirmations: 1}
+	unconfirmed := &UTXO{Value: 2}
+	immature := &UTXO{Value: 3, Coinbase: true, Confirmations: CoinbaseMaturity - 1}
+	mature := &UTXO{Value: 4, Coinbase: true, Confirmations: CoinbaseMaturity}
+
+	// act
+	utxos := MatureUTXOs([]*UTXO{spendable, unconfirmed, immature, mature})
+
+	// assert
+	assert.Equal(t, []*UTXO{spendable, unconfirmed, mature}, utxos)
+}
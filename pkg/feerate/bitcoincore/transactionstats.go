@@ -2,16 +2,18 @@ package bitcoincore
 
 import (
 	"log"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
 )
 
 type TransactionStats struct {
 	scale                          int
-	confirmationsPerBlock          [][]int
+	confirmationsPerBlock          []int
 	buckets                        []int
-	unconfirmedTransactions        [][]int
+	unconfirmedTransactions        *feerate.UnconfirmedRing
 	oldUnconfirmedTransactions     []int
 	decay                          float64
-	failAverage                    [][]int
+	failAverage                    []int
 	feeSumPerBucket                []float64
 	maxPeriods                     int
 	confirmedTransactionsPerBucket []int
@@ -38,14 +40,17 @@ func NewTransactionStats(buckets []int, maxPeriods int, decay float64, scale int
 	/**
 	 * Count the total # of txs confirmed within Y blocks in each bucket
 	 * Track the historical moving feeSumPerBucket of theses totals over blocks
+	 * Stored as one flat period-major array (see periodIndex) so that the
+	 * per-block decay is a single linear pass.
 	 */
-	stats.confirmationsPerBlock = MakeSlice2D(maxPeriods, len(stats.buckets))
+	stats.confirmationsPerBlock = make([]int, maxPeriods*len(stats.buckets))
 
 	/**
 	 * Track moving avg of txs which have been evicted from the mempool
 	 * after failing to be confirmed within Y blocks
+	 * Flat period-major array, same layout as confirmationsPerBlock.
 	 */
-	stats.failAverage = MakeSlice2D(maxPeriods, len(stats.buckets))
+	stats.failAverage = make([]int, maxPeriods*len(stats.buckets))
 
 	/**
 	 * Sum the total feerate of all tx's in each bucket
@@ -58,7 +63,7 @@ func NewTransactionStats(buckets []int, maxPeriods int, decay float64, scale int
 	 * For each bucket X, track the number of transactions in the mempool
 	 * that are unconfirmed for each possible confirmation value Y
 	 */
-	stats.unconfirmedTransactions = MakeSlice2D(stats.getMaxConfirms(), len(stats.buckets))
+	stats.unconfirmedTransactions = feerate.NewUnconfirmedRing(stats.getMaxConfirms(), len(stats.buckets))
 
 	/**
 	 * Transactions count still unconfirmed after GetMaxConfirms for each bucket.
@@ -81,8 +86,8 @@ func (ts TransactionStats) record(blocksToConfirm int, val float64) {
 	periodsToConfirm := (blocksToConfirm + ts.scale - 1) / ts.scale
 	//TODO error handling if bucket index is -1
 	bucketIndex := lowerBound(ts.buckets, int(val))
-	for i := periodsToConfirm; i <= len(ts.confirmationsPerBlock); i++ {
-		ts.confirmationsPerBlock[i-1][bucketIndex]++
+	for i := periodsToConfirm; i <= ts.maxPeriods; i++ {
+		ts.confirmationsPerBlock[ts.periodIndex(i-1, bucketIndex)]++
 	}
 	ts.confirmedTransactionsPerBucket[bucketIndex]++
 	ts.feeSumPerBucket[bucketIndex] += val
@@ -100,26 +105,21 @@ func (ts TransactionStats) removeTx(transactionHeight int, bestSeenHeight int, b
 		panic("Blockpolicy error, blocks ago is negative for mempool tx")
 	}
 
-	if blocksAgo >= len(ts.unconfirmedTransactions) {
+	if blocksAgo >= ts.unconfirmedTransactions.Len() {
 		if ts.oldUnconfirmedTransactions[bucketIndex] > 0 {
 			ts.oldUnconfirmedTransactions[bucketIndex]--
 		} else {
 			log.Printf("Mempool tx removed from > %v blocks, bucketIndex = %v already", blocksAgo, bucketIndex)
 		}
-	} else {
-		blockIndex := transactionHeight % len(ts.unconfirmedTransactions)
-		if ts.unconfirmedTransactions[blockIndex][bucketIndex] > 0 {
-			ts.unconfirmedTransactions[blockIndex][bucketIndex]--
-		} else {
-			log.Printf("Can't remove tx: transactions at blockIndex = %v, bucketIndex = %v already empty", blockIndex, bucketIndex)
-		}
+	} else if !ts.unconfirmedTransactions.Remove(transactionHeight, bucketIndex) {
+		log.Printf("Can't remove tx: transactions at height = %v, bucketIndex = %v already empty", transactionHeight, bucketIndex)
 	}
 
 	// Only counts as a failure if not confirmed for entire period
 	if !inBlock && blocksAgo >= ts.scale {
 		periodsAgo := blocksAgo / ts.scale
-		for i := 0; i < periodsAgo && i < len(ts.failAverage); i++ {
-			ts.failAverage[i][bucketIndex]++
+		for i := 0; i < periodsAgo && i < ts.maxPeriods; i++ {
+			ts.failAverage[ts.periodIndex(i, bucketIndex)]++
 		}
 	}
 }
@@ -131,13 +131,18 @@ func (ts TransactionStats) removeTx(transactionHeight int, bestSeenHeight int, b
  */
 func (ts TransactionStats) addTx(blockHeight int, feeInSatoshisPerK float64) int {
 	bucketIndex := lowerBound(ts.buckets, int(feeInSatoshisPerK)) //TODO overflow
-	blockIndex := blockHeight % len(ts.unconfirmedTransactions)
-	ts.unconfirmedTransactions[blockIndex][bucketIndex]++
+	ts.unconfirmedTransactions.Add(blockHeight, bucketIndex)
 	return bucketIndex
 }
 
 func (ts TransactionStats) getMaxConfirms() int {
-	return int(ts.scale) * len(ts.confirmationsPerBlock)
+	return int(ts.scale) * ts.maxPeriods
+}
+
+// periodIndex returns the position of (period, bucketIndex) in the flat
+// confirmationsPerBlock and failAverage arrays.
+func (ts TransactionStats) periodIndex(period int, bucketIndex int) int {
+	return period*len(ts.buckets) + bucketIndex
 }
 
 func (ts TransactionStats) estimateMedianVal(confTarget int, sufficientTxVal float64, minimumSuccessRate float64, requireLowestPossibleFee bool, blockHeight int) (int, *EstimationResult) {
@@ -173,7 +178,6 @@ func (ts TransactionStats) estimateMedianVal(confTarget int, sufficientTxVal flo
 	bestFarBucket := startBucket
 
 	foundAnswer := false
-	bins := len(ts.unconfirmedTransactions)
 	newBucketRange := true
 	passing := true
 	passBucket := NewEstimatorBucket(-1, -1, 0, 0, 0, 0)
@@ -186,12 +190,12 @@ func (ts TransactionStats) estimateMedianVal(confTarget int, sufficientTxVal flo
 		}
 
 		curFarBucket = bucketIndex
-		confirmedTransactionCount += ts.confirmationsPerBlock[periodTarget-1][bucketIndex]
+		confirmedTransactionCount += ts.confirmationsPerBlock[ts.periodIndex(periodTarget-1, bucketIndex)]
 		confirmedTransactionForAllTime += ts.confirmedTransactionsPerBucket[bucketIndex]
-		neverConfirmedTransactionsLeavedMempool += ts.failAverage[periodTarget-1][bucketIndex]
+		neverConfirmedTransactionsLeavedMempool += ts.failAverage[ts.periodIndex(periodTarget-1, bucketIndex)]
 
 		for confirmationsCount := confTarget; confirmationsCount < ts.getMaxConfirms(); confirmationsCount++ {
-			transactionsWithSameTargetStillInMempool += ts.unconfirmedTransactions[Abs(blockHeight-confirmationsCount)%bins][bucketIndex]
+			transactionsWithSameTargetStillInMempool += ts.unconfirmedTransactions.At(blockHeight-confirmationsCount, bucketIndex)
 		}
 
 		transactionsWithSameTargetStillInMempool += ts.oldUnconfirmedTransactions[bucketIndex]
@@ -303,23 +307,28 @@ func (ts TransactionStats) estimateMedianVal(confTarget int, sufficientTxVal flo
 	return median, result
 }
 
+// clearCurrent rolls the unconfirmed transactions ring buffer to blockHeight,
+// moving whatever was left in the reused slot to oldUnconfirmedTransactions
 func (ts TransactionStats) clearCurrent(blockHeight int) {
-	blockIndex := blockHeight % len(ts.unconfirmedTransactions)
-	for j := 0; j < len(ts.buckets); j++ {
-		ts.oldUnconfirmedTransactions[j] += ts.unconfirmedTransactions[blockIndex][j]
-		ts.unconfirmedTransactions[blockIndex][j] = 0
-	}
+	ts.unconfirmedTransactions.Rotate(blockHeight, ts.oldUnconfirmedTransactions)
 }
 
+// updateMovingAverages decays all exponential averages by one block.
+// The period counters are flat arrays, so this is a linear pass over
+// contiguous memory instead of a periods x buckets walk of nested slices.
 func (ts TransactionStats) updateMovingAverages() {
-	for j := 0; j < len(ts.buckets); j++ {
-		for i := 0; i < len(ts.confirmationsPerBlock); i++ {
-			ts.confirmationsPerBlock[i][j] = int(float64(ts.confirmationsPerBlock[i][j]) * ts.decay) //TODO overflow
-		}
-		for i := 0; i < len(ts.failAverage); i++ {
-			ts.failAverage[i][j] = int(float64(ts.failAverage[i][j]) * ts.decay) //TODO overflow
+	decayInts(ts.confirmationsPerBlock, ts.decay)
+	decayInts(ts.failAverage, ts.decay)
+	decayInts(ts.confirmedTransactionsPerBucket, ts.decay)
+	for j := range ts.feeSumPerBucket {
+		ts.feeSumPerBucket[j] *= ts.decay
+	}
+}
+
+func decayInts(values []int, decay float64) {
+	for i, v := range values {
+		if v != 0 {
+			values[i] = int(float64(v) * decay) //TODO overflow
 		}
-		ts.feeSumPerBucket[j] = ts.feeSumPerBucket[j] * float64(ts.decay)
-		ts.confirmedTransactionsPerBucket[j] = int(float64(ts.confirmedTransactionsPerBucket[j]) * ts.decay) //TODO overflow
 	}
 }
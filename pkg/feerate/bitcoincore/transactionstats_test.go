@@ -105,6 +105,39 @@ func (suite *TransactionStatsTestSuite) TestShouldGiveCorrectEstimations() {
 	assert.NotNil(suite.T(), estimationStats)
 }
 
+func (suite *TransactionStatsTestSuite) TestShouldCountConfirmationsForAllLongerPeriods() {
+	// arrange
+	stats := NewTransactionStats(suite.buckets, suite.BLOCK_PERIODS, suite.DECAY, suite.SCALE)
+
+	// act
+	stats.record(2, 2200)
+
+	// assert
+	assert.Equal(suite.T(), 0, stats.confirmationsPerBlock[stats.periodIndex(0, 1)])
+	for period := 1; period < suite.BLOCK_PERIODS; period++ {
+		assert.Equal(suite.T(), 1, stats.confirmationsPerBlock[stats.periodIndex(period, 1)])
+		assert.Equal(suite.T(), 0, stats.confirmationsPerBlock[stats.periodIndex(period, 0)])
+	}
+}
+
 func TestTransactionStatsTestSuite(t *testing.T) {
 	suite.Run(t, new(TransactionStatsTestSuite))
 }
+
+func BenchmarkUpdateMovingAveragesLongStats(b *testing.B) {
+	buckets := make([]int, 0)
+	for boundary := float64(MIN_BUCKET_FEERATE); boundary <= MAX_BUCKET_FEERATE; boundary *= FEE_SPACING {
+		buckets = append(buckets, int(boundary))
+	}
+
+	// 1008 periods of one block each, i.e. the full long horizon at block resolution
+	stats := NewTransactionStats(buckets, LONG_BLOCK_PERIODS*LONG_SCALE, LONG_DECAY, 1)
+	for i := 1; i <= LONG_BLOCK_PERIODS*LONG_SCALE; i++ {
+		stats.record(i, float64(buckets[i%len(buckets)]))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stats.updateMovingAverages()
+	}
+}
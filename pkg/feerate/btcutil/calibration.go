@@ -0,0 +1,141 @@
+package btcutil
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	// CalibrationStep is how much a single outcome moves the calibration
+	// factor of a target. Zero disables calibration.
+	CalibrationStep = 0.02
+
+	// CalibrationMin is the lowest calibration factor
+	CalibrationMin = 0.8
+
+	// CalibrationMax is the highest calibration factor
+	CalibrationMax = 1.5
+
+	// CalibrationTargetSuccess is the share of estimates that should
+	// confirm within their target. The factor is stable once it is reached.
+	CalibrationTargetSuccess = 0.9
+
+	// calibrationMaxHigherShare is the max percentage of txs in a block
+	// that may pay more than an estimate for a tx paying the estimate to
+	// count as confirmed in that block
+	calibrationMaxHigherShare = 50.0
+)
+
+// calibration adjusts the estimates for each confirmation target by how
+// often earlier estimates for the target actually confirmed in time.
+type calibration struct {
+	mu      sync.Mutex
+	factors map[int]float64 //target->factor
+}
+
+func newCalibration() *calibration {
+	return &calibration{
+		factors: make(map[int]float64),
+	}
+}
+
+// factor returns the factor estimates for target are multiplied with
+func (c *calibration) factor(target int) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.factors[target]
+	if !ok {
+		return 1
+	}
+
+	return f
+}
+
+// apply returns the calibrated rate for target
+func (c *calibration) apply(target int, rate float64) float64 {
+	return rate * c.factor(target)
+}
+
+// feedback records whether an estimate for target confirmed in time. A miss
+// raises the factor, a hit lowers it slightly, so that it settles where
+// CalibrationTargetSuccess of the estimates confirm.
+func (c *calibration) feedback(target int, confirmed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	outcome := 0.0
+	if confirmed {
+		outcome = 1
+	}
+
+	f, ok := c.factors[target]
+	if !ok {
+		f = 1
+	}
+
+	f *= 1 + CalibrationStep*(CalibrationTargetSuccess-outcome)
+	if f < CalibrationMin {
+		f = CalibrationMin
+	}
+	if f > CalibrationMax {
+		f = CalibrationMax
+	}
+
+	c.factors[target] = f
+}
+
+// calibrate feeds the outcome of every prediction whose target window has
+// been scored completely into c. Each prediction and target is only fed once.
+func (s *scores) calibrate(c *calibration) {
+	heights := make([]int, 0, len(s.predictions))
+	for height := range s.predictions {
+		heights = append(heights, height)
+	}
+	sort.Ints(heights)
+
+	targets := map[int]func(*score) float64{
+		BlockCountEconomical: func(sc *score) float64 { return sc.ScoreEconomical },
+		BlockCountStandard:   func(sc *score) float64 { return sc.ScoreStandard },
+		BlockCountFast:       func(sc *score) float64 { return sc.ScoreFast },
+	}
+
+	for _, height := range heights {
+		p := s.predictions[height]
+		if p.evaluated == nil {
+			p.evaluated = make(map[int]bool)
+		}
+
+		for target, higherShare := range targets {
+			if p.evaluated[target] {
+				continue
+			}
+
+			confirmed, complete := p.confirmedWithin(target, higherShare)
+			if !complete {
+				continue
+			}
+
+			c.feedback(target, confirmed)
+			p.evaluated[target] = true
+		}
+	}
+}
+
+// confirmedWithin reports whether a tx paying the prediction would have
+// been mined in one of the target blocks following it. complete is false
+// while not all of these blocks have been scored.
+func (p *prediction) confirmedWithin(target int, higherShare func(*score) float64) (confirmed bool, complete bool) {
+	for i := p.height + 1; i <= p.height+target; i++ {
+		sc, ok := p.scores[i]
+		if !ok {
+			return false, false
+		}
+
+		if higherShare(sc) <= calibrationMaxHigherShare {
+			confirmed = true
+		}
+	}
+
+	return confirmed, true
+}
@@ -0,0 +1,54 @@
+package btcutil
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCalibrationFactorIsBounded(t *testing.T) {
+	// arrange
+	c := newCalibration()
+
+	// act
+	for i := 0; i < 1000; i++ {
+		c.feedback(BlockCountFast, false)
+		c.feedback(BlockCountStandard, true)
+	}
+
+	// assert
+	assert.Equal(t, CalibrationMax, c.factor(BlockCountFast))
+	assert.Equal(t, CalibrationMin, c.factor(BlockCountStandard))
+	assert.Equal(t, 1.0, c.factor(BlockCountEconomical))
+	assert.Equal(t, 2*CalibrationMax, c.apply(BlockCountFast, 2))
+}
+
+func TestScoresCalibrateMissedFastEstimate(t *testing.T) {
+	// arrange
+	s := newScores(zap.NewNop())
+	c := newCalibration()
+
+	// the fast estimate of 5 sat/byte is below most txs of the next blocks
+	s.addPrediction(100, &feerate.FeeRates{Rates: []float64{1, 2, 3}, NumberOfTxs: 3}, 1, 3, 5, -1)
+	s.addPrediction(101, &feerate.FeeRates{Rates: []float64{4, 10, 20, 30}, NumberOfTxs: 4}, 1, 3, 5, -1)
+	for num, pred := range s.predictions {
+		s.comparePredictionToNext10Blocks(num, pred)
+	}
+
+	// act
+	s.calibrate(c)
+	assert.Equal(t, 1.0, c.factor(BlockCountFast), "the fast window is not complete yet")
+
+	s.addPrediction(102, &feerate.FeeRates{Rates: []float64{6, 10, 20, 30}, NumberOfTxs: 4}, 1, 3, 5, -1)
+	for num, pred := range s.predictions {
+		s.comparePredictionToNext10Blocks(num, pred)
+	}
+	s.calibrate(c)
+	s.calibrate(c)
+
+	// assert
+	assert.InDelta(t, 1+CalibrationStep*CalibrationTargetSuccess, c.factor(BlockCountFast), 1e-9)
+	assert.Equal(t, 1.0, c.factor(BlockCountStandard))
+}
@@ -1,6 +1,7 @@
 package btcutil
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/mining"
@@ -50,9 +52,22 @@ var (
 	// EstimateFeeDatabaseKey is the key that we use to
 	// store the fee estimator in the database.
 	EstimateFeeDatabaseKey = []byte("estimatefee")
+
+	// ErrNotEnoughBlocks is returned by EstimateFee while fewer blocks than
+	// the minimum have been registered
+	ErrNotEnoughBlocks = errors.New("not enough blocks have been observed")
+
+	// ErrZeroBlockTarget is returned by EstimateFee for a target of zero
+	// blocks
+	ErrZeroBlockTarget = errors.New("cannot confirm transaction in zero blocks")
+
+	// ErrTargetTooDeep is returned by EstimateFee for targets beyond the
+	// depth of the estimator, see FeeEstimator.Depth. It is wrapped together
+	// with the target and the depth.
+	ErrTargetTooDeep = errors.New("confirmation target exceeds the estimator depth")
 )
 
-// SatoshiPerByte is number with units of satoshis per byte.
+// SatoshiPerByte is number with units of satoshis per virtual byte.
 type SatoshiPerByte float64
 
 // BtcPerKilobyte is number with units of bitcoins per kilobyte.
@@ -92,7 +107,7 @@ type observedTransaction struct {
 	// A transaction hash.
 	hash chainhash.Hash
 
-	// The fee per byte of the transaction in satoshis.
+	// The fee per virtual byte of the transaction in satoshis.
 	feeRate SatoshiPerByte
 
 	// The block height when it was observed.
@@ -101,6 +116,48 @@ type observedTransaction struct {
 	// The height of the block in which it was mined.
 	// If the transaction has not yet been mined, it is zero.
 	mined int32
+
+	// The unix time when the transaction was first observed.
+	observedTime int64
+
+	// The unix time of the block in which it was mined.
+	// If the transaction has not yet been mined, it is zero.
+	minedTime int64
+}
+
+// Serialize writes the observedTransaction to w.
+func (o *observedTransaction) Serialize(w io.Writer) {
+	binary.Write(w, binary.BigEndian, o.hash)
+	binary.Write(w, binary.BigEndian, o.feeRate)
+	binary.Write(w, binary.BigEndian, o.observed)
+	binary.Write(w, binary.BigEndian, o.mined)
+	binary.Write(w, binary.BigEndian, o.observedTime)
+	binary.Write(w, binary.BigEndian, o.minedTime)
+}
+
+func deserializeObservedTransaction(r io.Reader) (*observedTransaction, error) {
+	ot := observedTransaction{}
+
+	// The first 32 bytes should be a hash, the next 8 are SatoshiPerByte,
+	// followed by two int32's and two int64 unix times.
+	err := readState(r, &ot.hash, &ot.feeRate, &ot.observed, &ot.mined, &ot.observedTime, &ot.minedTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ot, nil
+}
+
+// readState reads the values in order from the serialized state in r
+func readState(r io.Reader, values ...interface{}) error {
+	for _, v := range values {
+		err := binary.Read(r, binary.BigEndian, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // registeredBlock has the hash of a block and the list of transactions
@@ -118,6 +175,10 @@ type FeeEstimator struct {
 	maxRollback uint32
 	binSize     int32
 
+	// The maximum number of blocks before a transaction is confirmed that
+	// are tracked. Default is estimateFeeDepth
+	depth int32
+
 	// The maximum number of replacements that can be made in a single
 	// bin per block. Default is estimateFeeMaxReplacements
 	maxReplacements int32
@@ -132,9 +193,12 @@ type FeeEstimator struct {
 	// The number of blocks that have been registered.
 	numBlocksRegistered uint32
 
+	// The network the blocks were registered on.
+	network Network
+
 	mtx      sync.RWMutex
 	observed map[chainhash.Hash]*observedTransaction
-	bin      [estimateFeeDepth][]*observedTransaction
+	bin      [][]*observedTransaction
 
 	// The cached estimates.
 	cached []SatoshiPerByte
@@ -144,21 +208,175 @@ type FeeEstimator struct {
 	dropped []*registeredBlock
 }
 
+// FeeEstimatorOptions configures a FeeEstimator. Zero values are replaced by
+// the package defaults.
+type FeeEstimatorOptions struct {
+	// MaxRollback is the number of blocks that can be unregistered.
+	MaxRollback uint32
+
+	// MinRegisteredBlocks is the number of blocks that must be registered
+	// before estimates are returned.
+	MinRegisteredBlocks uint32
+
+	// Depth is the maximum confirmation target that is tracked.
+	Depth int32
+
+	// BinSize is the number of txs stored for every confirmation target.
+	BinSize int32
+
+	// MaxReplacements is the max number of txs a single block may replace
+	// per bin. It is capped at BinSize.
+	MaxReplacements int32
+
+	// Network is the network the fee estimator runs on. A restored state
+	// of another network is rejected by ApplyOptions.
+	Network Network
+}
+
+// withDefaults returns the options with the zero values replaced by the
+// package defaults
+func (opts FeeEstimatorOptions) withDefaults() FeeEstimatorOptions {
+	if opts.Depth <= 0 {
+		opts.Depth = estimateFeeDepth
+	}
+	if opts.BinSize <= 0 {
+		opts.BinSize = estimateFeeBinSize
+	}
+	if opts.MaxReplacements <= 0 {
+		opts.MaxReplacements = estimateFeeMaxReplacements
+	}
+	if opts.MaxReplacements > opts.BinSize {
+		opts.MaxReplacements = opts.BinSize
+	}
+
+	return opts
+}
+
+// Network is a bitcoin network the fee estimator runs on
+type Network int
+
+const (
+	// MainNet is the bitcoin main network
+	MainNet Network = iota
+
+	// TestNet is the bitcoin test network (version 3)
+	TestNet
+
+	// RegTest is the local regression test network
+	RegTest
+)
+
+// ParseNetwork returns the network with the given name, using the names of
+// the chaincfg params (mainnet, testnet3, regtest)
+func ParseNetwork(name string) (Network, error) {
+	switch strings.ToLower(name) {
+	case "mainnet", "main":
+		return MainNet, nil
+	case "testnet3", "testnet", "test":
+		return TestNet, nil
+	case "regtest":
+		return RegTest, nil
+	}
+
+	return MainNet, fmt.Errorf("unknown network %q", name)
+}
+
+// String returns the chaincfg name of the network
+func (n Network) String() string {
+	switch n {
+	case TestNet:
+		return "testnet3"
+	case RegTest:
+		return "regtest"
+	}
+
+	return "mainnet"
+}
+
+// NetworkOptions returns sensible FeeEstimatorOptions for the given network.
+// Testnet sees deeper reorgs than mainnet, so more blocks can be rolled back.
+// On regtest blocks are generated on demand, so estimates are returned after
+// a single block.
+func NetworkOptions(n Network) FeeEstimatorOptions {
+	switch n {
+	case TestNet:
+		return FeeEstimatorOptions{
+			MaxRollback:         6,
+			MinRegisteredBlocks: DefaultEstimateFeeMinRegisteredBlocks,
+			Network:             n,
+		}
+	case RegTest:
+		return FeeEstimatorOptions{
+			MaxRollback:         DefaultEstimateFeeMaxRollback,
+			MinRegisteredBlocks: 1,
+			Network:             n,
+		}
+	}
+
+	return FeeEstimatorOptions{
+		MaxRollback:         DefaultEstimateFeeMaxRollback,
+		MinRegisteredBlocks: DefaultEstimateFeeMinRegisteredBlocks,
+		Network:             MainNet,
+	}
+}
+
 // NewFeeEstimator creates a FeeEstimator for which at most maxRollback blocks
 // can be unregistered and which returns an error unless minRegisteredBlocks
 // have been registered with it.
 func NewFeeEstimator(maxRollback, minRegisteredBlocks uint32) *FeeEstimator {
+	return NewFeeEstimatorWithOptions(FeeEstimatorOptions{
+		MaxRollback:         maxRollback,
+		MinRegisteredBlocks: minRegisteredBlocks,
+	})
+}
+
+// NewFeeEstimatorWithOptions creates a FeeEstimator tracking opts.Depth
+// confirmation targets with opts.BinSize txs each.
+func NewFeeEstimatorWithOptions(opts FeeEstimatorOptions) *FeeEstimator {
+	opts = opts.withDefaults()
+
 	return &FeeEstimator{
-		maxRollback:         maxRollback,
-		minRegisteredBlocks: minRegisteredBlocks,
+		maxRollback:         opts.MaxRollback,
+		minRegisteredBlocks: opts.MinRegisteredBlocks,
 		LastKnownHeight:     mining.UnminedHeight,
-		binSize:             estimateFeeBinSize,
-		maxReplacements:     estimateFeeMaxReplacements,
+		depth:               opts.Depth,
+		binSize:             opts.BinSize,
+		maxReplacements:     opts.MaxReplacements,
+		network:             opts.Network,
 		observed:            make(map[chainhash.Hash]*observedTransaction),
-		dropped:             make([]*registeredBlock, 0, maxRollback),
+		bin:                 make([][]*observedTransaction, opts.Depth),
+		dropped:             make([]*registeredBlock, 0, opts.MaxRollback),
 	}
 }
 
+// ApplyOptions applies opts to a restored FeeEstimator. The rollback window,
+// the number of blocks needed for estimates and the replacement limit are
+// changed, while the network, the depth and the bin size of the state must
+// match opts.
+func (ef *FeeEstimator) ApplyOptions(opts FeeEstimatorOptions) error {
+	opts = opts.withDefaults()
+
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	if opts.Network != ef.network {
+		return fmt.Errorf("state of network %v cannot be used on %v", ef.network, opts.Network)
+	}
+	if opts.Depth != ef.depth || opts.BinSize != ef.binSize {
+		return fmt.Errorf("state of depth %d and bin size %d does not match depth %d and bin size %d",
+			ef.depth, ef.binSize, opts.Depth, opts.BinSize)
+	}
+
+	ef.maxRollback = opts.MaxRollback
+	ef.minRegisteredBlocks = opts.MinRegisteredBlocks
+	ef.maxReplacements = opts.MaxReplacements
+	if uint32(len(ef.dropped)) > ef.maxRollback {
+		ef.dropped = ef.dropped[uint32(len(ef.dropped))-ef.maxRollback:]
+	}
+
+	return nil
+}
+
 // ObserveTransaction is called when a new transaction is observed in the mempool.
 func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 	ef.mtx.Lock()
@@ -172,18 +390,43 @@ func (ef *FeeEstimator) ObserveTransaction(t *TxDesc) {
 
 	hash := *t.Hash
 	if _, ok := ef.observed[hash]; !ok {
-		//size := uint32(GetTxVirtualSize(t.Tx))
+		size := t.virtualSize()
+		if size <= 0 {
+			return
+		}
+
+		observedTime := t.Time
+		if observedTime.IsZero() {
+			observedTime = time.Now()
+		}
 
-		size := t.Size
 		ef.observed[hash] = &observedTransaction{
-			hash:     hash,
-			feeRate:  NewSatoshiPerByte(btcutil.Amount(t.Fee), uint32(size)),
-			observed: int32(t.Height),
-			mined:    mining.UnminedHeight,
+			hash:         hash,
+			feeRate:      NewSatoshiPerByte(btcutil.Amount(t.Fee), uint32(size)),
+			observed:     int32(t.Height),
+			mined:        mining.UnminedHeight,
+			observedTime: observedTime.Unix(),
 		}
 	}
 }
 
+// RemoveTransaction is called when a tx left the mempool without being
+// mined, e.g. because it was replaced or evicted. The observation is dropped
+// right away instead of lingering until it is older than the depth. It
+// returns false if the tx is unknown or has already been mined.
+func (ef *FeeEstimator) RemoveTransaction(hash *chainhash.Hash) bool {
+	ef.mtx.Lock()
+	defer ef.mtx.Unlock()
+
+	o, ok := ef.observed[*hash]
+	if !ok || o.mined != mining.UnminedHeight {
+		return false
+	}
+
+	delete(ef.observed, *hash)
+	return true
+}
+
 // RegisterBlock informs the fee estimator of a new block to take into account.
 func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 	ef.mtx.Lock()
@@ -210,7 +453,7 @@ func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 
 	// Count the number of replacements we make per bin so that we don't
 	// replace too many.
-	var replacementCounts [estimateFeeDepth]int
+	replacementCounts := make([]int, ef.depth)
 
 	// Keep track of which txs were dropped in case of an orphan block.
 	dropped := &registeredBlock{
@@ -240,7 +483,7 @@ func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 
 		// This shouldn't happen but check just in case to avoid
 		// an out-of-bounds array index later.
-		if blocksToConfirm >= estimateFeeDepth {
+		if blocksToConfirm >= ef.depth {
 			continue
 		}
 
@@ -250,16 +493,18 @@ func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 		}
 
 		o.mined = height
+		o.minedTime = block.MsgBlock().Header.Timestamp.Unix()
 
 		replacementCounts[blocksToConfirm]++
 
 		bin := ef.bin[blocksToConfirm]
 
-		// Remove a random element and replace it with this new tx.
+		// Remove an element from a dense fee rate region and replace it
+		// with this new tx.
 		if len(bin) == int(ef.binSize) {
 			// Don't drop transactions we have just added from this same block.
 			l := int(ef.binSize) - replacementCounts[blocksToConfirm]
-			drop := rand.Intn(l)
+			drop := evictionIndex(bin[:l])
 			dropped.transactions = append(dropped.transactions, bin[drop])
 
 			bin[drop] = bin[l-1]
@@ -272,11 +517,14 @@ func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 
 	// Go through the mempool for txs that have been in too long.
 	for hash, o := range ef.observed {
-		if o.mined == mining.UnminedHeight && height-o.observed >= estimateFeeDepth {
+		if o.mined == mining.UnminedHeight && height-o.observed >= ef.depth {
 			delete(ef.observed, hash)
 		}
 	}
 
+	// Precompute the estimates so that EstimateFee only needs a read lock.
+	ef.cached = ef.estimates()
+
 	// Add dropped list to history.
 	if ef.maxRollback == 0 {
 		return nil
@@ -293,12 +541,70 @@ func (ef *FeeEstimator) RegisterBlock(block *btcutil.Block) error {
 
 // GetLastKnownHeight returns the height of the last block which was registered.
 func (ef *FeeEstimator) GetLastKnownHeight() int32 {
-	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
 
 	return ef.LastKnownHeight
 }
 
+// Depth returns the maximum confirmation target that can be estimated
+func (ef *FeeEstimator) Depth() uint32 {
+	return uint32(ef.depth)
+}
+
+// BlockHash returns the hash of the block registered at the given height.
+// Only the last maxRollback blocks are remembered, ok is false for any
+// other height.
+func (ef *FeeEstimator) BlockHash(height int32) (hash *chainhash.Hash, ok bool) {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	n := int(ef.LastKnownHeight - height)
+	if ef.LastKnownHeight == mining.UnminedHeight || n < 0 || n >= len(ef.dropped) {
+		return nil, false
+	}
+
+	registered := ef.dropped[len(ef.dropped)-1-n].hash
+	return &registered, true
+}
+
+// evictionIndex picks the tx to drop from a full bin. Instead of dropping a
+// uniformly random tx, which may throw away the few txs at the ends of the
+// fee rate range, every tx is weighted by how close its nearest neighbour in
+// fee rate is. Txs in crowded regions are therefore dropped first, while the
+// lowest and the highest fee rate are never dropped. This keeps the spread
+// of a bin and with it the median of the high targets stable.
+func evictionIndex(bin []*observedTransaction) int {
+	if len(bin) < 3 {
+		return rand.Intn(len(bin))
+	}
+
+	sorted := make([]int, len(bin))
+	for i := range sorted {
+		sorted[i] = i
+	}
+	sort.Slice(sorted, func(i, j int) bool { return bin[sorted[i]].feeRate < bin[sorted[j]].feeRate })
+
+	weights := make([]float64, len(sorted))
+	total := 0.0
+	for i := 1; i < len(sorted)-1; i++ {
+		rate := bin[sorted[i]].feeRate
+		gap := math.Min(float64(rate-bin[sorted[i-1]].feeRate), float64(bin[sorted[i+1]].feeRate-rate))
+		weights[i] = 1 / (1 + gap)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i := 1; i < len(sorted)-1; i++ {
+		r -= weights[i]
+		if r < 0 {
+			return sorted[i]
+		}
+	}
+
+	return sorted[len(sorted)-2]
+}
+
 // Rollback unregisters a recently registered block from the FeeEstimator.
 // This can be used to reverse the effect of an orphaned block on the fee
 // estimator. The maximum number of rollbacks allowed is given by
@@ -327,6 +633,7 @@ func (ef *FeeEstimator) Rollback(hash *chainhash.Hash) error {
 	for i := 0; i < n; i++ {
 		ef.rollback()
 	}
+	ef.cached = ef.estimates()
 
 	return nil
 }
@@ -349,7 +656,7 @@ func (ef *FeeEstimator) rollback() {
 	dropped := ef.dropped[last]
 
 	// where we are in each bin as we replace txs?
-	var replacementCounters [estimateFeeDepth]int
+	replacementCounters := make([]int, ef.depth)
 
 	// Go through the txs in the dropped block.
 	for _, o := range dropped.transactions {
@@ -371,6 +678,7 @@ func (ef *FeeEstimator) rollback() {
 
 			if prev.mined == ef.LastKnownHeight {
 				prev.mined = mining.UnminedHeight
+				prev.minedTime = 0
 
 				bin[counter] = o
 
@@ -397,6 +705,7 @@ func (ef *FeeEstimator) rollback() {
 
 			if prev.mined == ef.LastKnownHeight {
 				prev.mined = mining.UnminedHeight
+				prev.minedTime = 0
 
 				newBin := append(ef.bin[i][0:j], ef.bin[i][j+1:l]...)
 				// TODO This line should prevent an unintentional memory
@@ -422,7 +731,7 @@ func (ef *FeeEstimator) rollback() {
 // by the fee per kb rate.
 type estimateFeeSet struct {
 	feeRate []SatoshiPerByte
-	bin     [estimateFeeDepth]uint32
+	bin     []uint32
 }
 
 func (b *estimateFeeSet) Len() int { return len(b.feeRate) }
@@ -443,7 +752,7 @@ func (b *estimateFeeSet) estimateFee(confirmations int) SatoshiPerByte {
 		return SatoshiPerByte(math.Inf(1))
 	}
 
-	if confirmations > estimateFeeDepth {
+	if confirmations > len(b.bin) {
 		return 0
 	}
 
@@ -472,7 +781,9 @@ func (b *estimateFeeSet) estimateFee(confirmations int) SatoshiPerByte {
 // newEstimateFeeSet creates a temporary data structure that
 // can be used to find all fee estimates.
 func (ef *FeeEstimator) newEstimateFeeSet() *estimateFeeSet {
-	set := &estimateFeeSet{}
+	set := &estimateFeeSet{
+		bin: make([]uint32, ef.depth),
+	}
 
 	capacity := 0
 	for i, b := range ef.bin {
@@ -496,68 +807,212 @@ func (ef *FeeEstimator) newEstimateFeeSet() *estimateFeeSet {
 	return set
 }
 
-// estimates returns the set of all fee estimates from 1 to estimateFeeDepth
-// confirmations from now.
+// estimates returns the set of all fee estimates from 1 to the configured
+// depth confirmations from now.
 func (ef *FeeEstimator) estimates() []SatoshiPerByte {
 	set := ef.newEstimateFeeSet()
 
-	estimates := make([]SatoshiPerByte, estimateFeeDepth)
-	for i := 0; i < estimateFeeDepth; i++ {
+	estimates := make([]SatoshiPerByte, ef.depth)
+	for i := 0; i < int(ef.depth); i++ {
 		estimates[i] = set.estimateFee(i + 1)
 	}
 
 	return estimates
 }
 
+// confidence returns the fraction of the observed txs paying at least rate
+// that confirmed within numBlocks. Txs still in the mempool count as failed
+// once they have waited numBlocks blocks. It returns -1 if no tx pays rate.
+func (ef *FeeEstimator) confidence(rate SatoshiPerByte, numBlocks uint32) float64 {
+	var confirmed, total int
+	for i, bin := range ef.bin {
+		for _, o := range bin {
+			if o.feeRate < rate {
+				continue
+			}
+
+			total++
+			if uint32(i) < numBlocks {
+				confirmed++
+			}
+		}
+	}
+
+	for _, o := range ef.observed {
+		if o.mined != mining.UnminedHeight || o.feeRate < rate {
+			continue
+		}
+
+		if ef.LastKnownHeight-o.observed >= int32(numBlocks) {
+			total++
+		}
+	}
+
+	if total == 0 {
+		return -1
+	}
+
+	return float64(confirmed) / float64(total)
+}
+
 // EstimateFee estimates the fee per byte to have a tx confirmed a given
-// number of blocks from now.
-func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (BtcPerKilobyte, error) {
-	ef.mtx.Lock()
-	defer ef.mtx.Unlock()
+// number of blocks from now. Alongside the estimate it returns the fraction
+// of the observed txs paying at least the estimate that confirmed within
+// numBlocks, or -1 if there are no such txs.
+func (ef *FeeEstimator) EstimateFee(numBlocks uint32) (BtcPerKilobyte, float64, error) {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
 
 	// If the number of registered blocks is below the minimum, return
 	// an error.
 	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
-		return -1, errors.New("not enough blocks have been observed")
+		return -1, -1, ErrNotEnoughBlocks
 	}
 
 	if numBlocks == 0 {
-		return -1, errors.New("cannot confirm transaction in zero blocks")
+		return -1, -1, ErrZeroBlockTarget
+	}
+
+	if numBlocks > uint32(ef.depth) {
+		return -1, -1, fmt.Errorf("%w: target %d, depth %d", ErrTargetTooDeep, numBlocks, ef.depth)
+	}
+
+	// The estimates are precomputed whenever a block is registered or
+	// rolled back. They are only missing if registering a block failed
+	// halfway, compute them without storing them as we only hold the
+	// read lock.
+	estimates := ef.cached
+	if estimates == nil {
+		estimates = ef.estimates()
+	}
+
+	rate := estimates[int(numBlocks)-1]
+	if rate < 0 {
+		return rate.ToBtcPerKb(), -1, nil
+	}
+
+	return rate.ToBtcPerKb(), ef.confidence(rate, numBlocks), nil
+}
+
+// EstimateAll returns the estimates for every confirmation target from 1 to
+// Depth, the estimate for n blocks is at index n-1. Use it instead of calling
+// EstimateFee for each target.
+func (ef *FeeEstimator) EstimateAll() ([]BtcPerKilobyte, error) {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
+		return nil, ErrNotEnoughBlocks
 	}
 
-	if numBlocks > estimateFeeDepth {
-		return -1, fmt.Errorf(
-			"can only estimate fees for up to %d blocks from now",
-			estimateFeeBinSize)
+	estimates := ef.cached
+	if estimates == nil {
+		estimates = ef.estimates()
 	}
 
-	// If there are no cached results, generate them.
-	if ef.cached == nil {
-		ef.cached = ef.estimates()
+	all := make([]BtcPerKilobyte, len(estimates))
+	for i, rate := range estimates {
+		all[i] = rate.ToBtcPerKb()
 	}
 
-	return ef.cached[int(numBlocks)-1].ToBtcPerKb(), nil
+	return all, nil
+}
+
+// EstimateConfirmationTime estimates how long a tx paying the given fee rate
+// waits until it is mined. It returns the median wait time of the binSize
+// mined txs whose fee rate is closest to rate.
+func (ef *FeeEstimator) EstimateConfirmationTime(rate SatoshiPerByte) (time.Duration, error) {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	if ef.numBlocksRegistered < ef.minRegisteredBlocks {
+		return -1, ErrNotEnoughBlocks
+	}
+
+	type waitTime struct {
+		distance float64
+		wait     int64
+	}
+
+	var waits []waitTime
+	for _, bin := range ef.bin {
+		for _, o := range bin {
+			if o.observedTime == 0 || o.minedTime == 0 {
+				continue
+			}
+
+			wait := o.minedTime - o.observedTime
+			if wait < 0 {
+				// block timestamps may be up to two hours off
+				wait = 0
+			}
+
+			waits = append(waits, waitTime{
+				distance: math.Abs(float64(o.feeRate - rate)),
+				wait:     wait,
+			})
+		}
+	}
+
+	if len(waits) == 0 {
+		return -1, errors.New("no timestamped transactions have been observed")
+	}
+
+	sort.Slice(waits, func(i, j int) bool { return waits[i].distance < waits[j].distance })
+	if len(waits) > int(ef.binSize) {
+		waits = waits[:ef.binSize]
+	}
+
+	sort.Slice(waits, func(i, j int) bool { return waits[i].wait < waits[j].wait })
+	return time.Duration(waits[len(waits)/2].wait) * time.Second, nil
 }
 
 // In case the format for the serialized version of the FeeEstimator changes,
 // we use a version number. If the version number changes, it does not make
 // sense to try to upgrade a previous version to a new version. Instead, just
 // start fee estimation over.
-const estimateFeeSaveVersion = 1
+const estimateFeeSaveVersion = 4
+
+// observedTransactionSize is the size of a serialized observedTransaction
+const observedTransactionSize = chainhash.HashSize + 8 + 4 + 4 + 8 + 8
+
+func (rb *registeredBlock) serialize(w io.Writer, txs map[*observedTransaction]uint32) {
+	binary.Write(w, binary.BigEndian, rb.hash)
+
+	binary.Write(w, binary.BigEndian, uint32(len(rb.transactions)))
+	for _, o := range rb.transactions {
+		binary.Write(w, binary.BigEndian, txs[o])
+	}
+}
 
 func deserializeRegisteredBlock(r io.Reader, txs map[uint32]*observedTransaction) (*registeredBlock, error) {
 	var lenTransactions uint32
 
 	rb := &registeredBlock{}
-	binary.Read(r, binary.BigEndian, &rb.hash)
-	binary.Read(r, binary.BigEndian, &lenTransactions)
+	err := readState(r, &rb.hash, &lenTransactions)
+	if err != nil {
+		return nil, err
+	}
+
+	// a block only references observed transactions
+	if int(lenTransactions) > len(txs) {
+		return nil, fmt.Errorf("invalid number of block transactions %d", lenTransactions)
+	}
 
 	rb.transactions = make([]*observedTransaction, lenTransactions)
 
 	for i := uint32(0); i < lenTransactions; i++ {
 		var index uint32
-		binary.Read(r, binary.BigEndian, &index)
-		rb.transactions[i] = txs[index]
+		err = readState(r, &index)
+		if err != nil {
+			return nil, err
+		}
+
+		var exists bool
+		rb.transactions[i], exists = txs[index]
+		if !exists {
+			return nil, fmt.Errorf("invalid transaction reference %d", index)
+		}
 	}
 
 	return rb, nil
@@ -581,3 +1036,174 @@ func (q observedTxSet) Less(i, j int) bool {
 func (q observedTxSet) Swap(i, j int) {
 	q[i], q[j] = q[j], q[i]
 }
+
+// Save records the current state of the FeeEstimator to a []byte that
+// can be restored later.
+func (ef *FeeEstimator) Save() FeeEstimatorState {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	w := bytes.NewBuffer(make([]byte, 0))
+
+	binary.Write(w, binary.BigEndian, uint32(estimateFeeSaveVersion))
+
+	// Insert basic parameters.
+	binary.Write(w, binary.BigEndian, &ef.maxRollback)
+	binary.Write(w, binary.BigEndian, &ef.depth)
+	binary.Write(w, binary.BigEndian, &ef.binSize)
+	binary.Write(w, binary.BigEndian, &ef.maxReplacements)
+	binary.Write(w, binary.BigEndian, &ef.minRegisteredBlocks)
+	binary.Write(w, binary.BigEndian, &ef.LastKnownHeight)
+	binary.Write(w, binary.BigEndian, &ef.numBlocksRegistered)
+	binary.Write(w, binary.BigEndian, int32(ef.network))
+
+	// Put all the observed transactions in a sorted list.
+	var txCount uint32
+	ots := make([]*observedTransaction, len(ef.observed))
+	for hash := range ef.observed {
+		ots[txCount] = ef.observed[hash]
+		txCount++
+	}
+
+	sort.Sort(observedTxSet(ots))
+
+	txCount = 0
+	observed := make(map[*observedTransaction]uint32)
+	binary.Write(w, binary.BigEndian, uint32(len(ef.observed)))
+	for _, ot := range ots {
+		ot.Serialize(w)
+		observed[ot] = txCount
+		txCount++
+	}
+
+	// Save all the right bins.
+	for _, list := range ef.bin {
+		binary.Write(w, binary.BigEndian, uint32(len(list)))
+
+		for _, o := range list {
+			binary.Write(w, binary.BigEndian, observed[o])
+		}
+	}
+
+	// Dropped transactions.
+	binary.Write(w, binary.BigEndian, uint32(len(ef.dropped)))
+	for _, registered := range ef.dropped {
+		registered.serialize(w, observed)
+	}
+
+	return FeeEstimatorState(w.Bytes())
+}
+
+// RestoreFeeEstimator takes a FeeEstimatorState that was previously
+// returned by Save and restores it to a FeeEstimator
+func RestoreFeeEstimator(data FeeEstimatorState) (*FeeEstimator, error) {
+	r := bytes.NewReader([]byte(data))
+
+	// Check version
+	var version uint32
+	err := readState(r, &version)
+	if err != nil {
+		return nil, err
+	}
+	if version != estimateFeeSaveVersion {
+		return nil, fmt.Errorf("incorrect version: expected %d found %d", estimateFeeSaveVersion, version)
+	}
+
+	ef := &FeeEstimator{
+		observed: make(map[chainhash.Hash]*observedTransaction),
+	}
+
+	// Read basic parameters.
+	err = readState(r, &ef.maxRollback, &ef.depth, &ef.binSize, &ef.maxReplacements,
+		&ef.minRegisteredBlocks, &ef.LastKnownHeight, &ef.numBlocksRegistered)
+	if err != nil {
+		return nil, err
+	}
+
+	var network int32
+	err = readState(r, &network)
+	if err != nil {
+		return nil, err
+	}
+	ef.network = Network(network)
+
+	// Every bin takes at least 4 bytes, a larger depth cannot be read and
+	// must not be allocated.
+	if ef.depth <= 0 || int(ef.depth) > r.Len()/4 {
+		return nil, fmt.Errorf("invalid depth %d", ef.depth)
+	}
+	if ef.binSize <= 0 {
+		return nil, fmt.Errorf("invalid bin size %d", ef.binSize)
+	}
+	if ef.maxReplacements <= 0 || ef.maxReplacements > ef.binSize {
+		return nil, fmt.Errorf("invalid max replacements %d", ef.maxReplacements)
+	}
+
+	// Read transactions.
+	var numObserved uint32
+	observed := make(map[uint32]*observedTransaction)
+	err = readState(r, &numObserved)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(numObserved)*observedTransactionSize > uint64(r.Len()) {
+		return nil, fmt.Errorf("invalid number of observed transactions %d", numObserved)
+	}
+	for i := uint32(0); i < numObserved; i++ {
+		ot, err := deserializeObservedTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+		observed[i] = ot
+		ef.observed[ot.hash] = ot
+	}
+
+	// Read bins.
+	ef.bin = make([][]*observedTransaction, ef.depth)
+	for i := 0; i < int(ef.depth); i++ {
+		var numTransactions uint32
+		err = readState(r, &numTransactions)
+		if err != nil {
+			return nil, err
+		}
+		if numTransactions > uint32(ef.binSize) {
+			return nil, fmt.Errorf("invalid number of transactions %d in bin %d", numTransactions, i)
+		}
+		bin := make([]*observedTransaction, numTransactions)
+		for j := uint32(0); j < numTransactions; j++ {
+			var index uint32
+			err = readState(r, &index)
+			if err != nil {
+				return nil, err
+			}
+
+			var exists bool
+			bin[j], exists = observed[index]
+			if !exists {
+				return nil, fmt.Errorf("invalid transaction reference %d", index)
+			}
+		}
+		ef.bin[i] = bin
+	}
+
+	// Read dropped transactions.
+	var numDropped uint32
+	err = readState(r, &numDropped)
+	if err != nil {
+		return nil, err
+	}
+	if numDropped > ef.maxRollback {
+		return nil, fmt.Errorf("invalid number of dropped blocks %d", numDropped)
+	}
+	ef.dropped = make([]*registeredBlock, numDropped, ef.maxRollback)
+	for i := uint32(0); i < numDropped; i++ {
+		var err error
+		ef.dropped[int(i)], err = deserializeRegisteredBlock(r, observed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ef.cached = ef.estimates()
+
+	return ef, nil
+}
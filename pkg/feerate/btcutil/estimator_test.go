@@ -0,0 +1,425 @@
+package btcutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTx creates a unique transaction, lockTime is used to vary the hash
+func newTestTx(lockTime uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.LockTime = lockTime
+	return tx
+}
+
+func newTestBlock(height int32, txs ...*wire.MsgTx) *btcutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{Nonce: uint32(height)})
+	for _, tx := range txs {
+		msgBlock.AddTransaction(tx)
+	}
+
+	block := btcutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	return block
+}
+
+func observeTestTx(ef *FeeEstimator, tx *wire.MsgTx, height int64, fee int64) {
+	hash := tx.TxHash()
+	ef.ObserveTransaction(&TxDesc{
+		Hash:   &hash,
+		Height: height,
+		Fee:    fee,
+		Size:   int32(tx.SerializeSize()),
+	})
+}
+
+// newTestFeeEstimator registers a few blocks confirming observed transactions
+func newTestFeeEstimator(t *testing.T) *FeeEstimator {
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	lockTime := uint32(1)
+	for height := int32(101); height <= 105; height++ {
+		var txs []*wire.MsgTx
+		for i := 0; i < 5; i++ {
+			tx := newTestTx(lockTime)
+			observeTestTx(ef, tx, int64(height-1), int64(1000*lockTime))
+			txs = append(txs, tx)
+			lockTime++
+		}
+
+		// leave one tx per block in the mempool
+		require.NoError(t, ef.RegisterBlock(newTestBlock(height, txs[1:]...)))
+	}
+
+	return ef
+}
+
+func TestSaveAndRestoreFeeEstimator(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+
+	// act
+	restored, err := RestoreFeeEstimator(ef.Save())
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, ef.Save(), restored.Save())
+	assert.Equal(t, ef.GetLastKnownHeight(), restored.GetLastKnownHeight())
+	for target := uint32(1); target <= 3; target++ {
+		expected, _, err := ef.EstimateFee(target)
+		require.NoError(t, err)
+		actual, _, err := restored.EstimateFee(target)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	// the restored estimator must still be able to roll back the last block
+	lastBlock := restored.dropped[len(restored.dropped)-1].hash
+	assert.NoError(t, restored.Rollback(&lastBlock))
+}
+
+func TestRestoreFeeEstimatorRejectsOtherVersion(t *testing.T) {
+	// arrange
+	state := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1).Save()
+	state[3] = estimateFeeSaveVersion + 1
+
+	// act
+	_, err := RestoreFeeEstimator(state)
+
+	// assert
+	assert.Error(t, err)
+}
+
+func TestRestoreFeeEstimatorRejectsTruncatedState(t *testing.T) {
+	// arrange
+	state := newTestFeeEstimator(t).Save()
+
+	for length := 0; length < len(state); length++ {
+		// act
+		_, err := RestoreFeeEstimator(state[:length])
+
+		// assert
+		assert.Error(t, err, "length %d", length)
+	}
+}
+
+func TestRestoreFeeEstimatorRejectsTooManyDroppedBlocks(t *testing.T) {
+	// arrange
+	state := newTestFeeEstimator(t).Save()
+	binary.BigEndian.PutUint32(state[4:8], 0) // max rollback
+
+	// act
+	_, err := RestoreFeeEstimator(state)
+
+	// assert
+	assert.EqualError(t, err, "invalid number of dropped blocks 2")
+}
+
+func TestApplyOptionsToRestoredFeeEstimator(t *testing.T) {
+	// arrange
+	restored, err := RestoreFeeEstimator(newTestFeeEstimator(t).Save())
+	require.NoError(t, err)
+
+	// act
+	err = restored.ApplyOptions(FeeEstimatorOptions{MaxRollback: 2, MinRegisteredBlocks: 10})
+
+	// assert
+	require.NoError(t, err)
+	assert.Len(t, restored.dropped, 2)
+	_, _, err = restored.EstimateFee(1)
+	assert.Equal(t, ErrNotEnoughBlocks, err)
+}
+
+func TestApplyOptionsRejectsOtherNetwork(t *testing.T) {
+	// arrange
+	restored, err := RestoreFeeEstimator(newTestFeeEstimator(t).Save())
+	require.NoError(t, err)
+
+	// act
+	err = restored.ApplyOptions(NetworkOptions(TestNet))
+
+	// assert
+	assert.EqualError(t, err, "state of network mainnet cannot be used on testnet3")
+}
+
+func TestApplyOptionsRejectsOtherDepth(t *testing.T) {
+	// arrange
+	restored, err := RestoreFeeEstimator(newTestFeeEstimator(t).Save())
+	require.NoError(t, err)
+
+	// act
+	err = restored.ApplyOptions(FeeEstimatorOptions{Depth: 5})
+
+	// assert
+	assert.Error(t, err)
+}
+
+func TestFeeEstimatorWithOptionsUsesConfiguredDepth(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimatorWithOptions(FeeEstimatorOptions{
+		MaxRollback:         DefaultEstimateFeeMaxRollback,
+		MinRegisteredBlocks: 1,
+		Depth:               50,
+		BinSize:             10,
+		MaxReplacements:     20,
+	})
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	// act
+	_, _, errWithinDepth := ef.EstimateFee(50)
+	_, _, errBeyondDepth := ef.EstimateFee(51)
+
+	// assert
+	assert.Equal(t, int32(10), ef.maxReplacements)
+	assert.NoError(t, errWithinDepth)
+	assert.True(t, errors.Is(errBeyondDepth, ErrTargetTooDeep))
+	assert.EqualError(t, errBeyondDepth, "confirmation target exceeds the estimator depth: target 51, depth 50")
+	assert.Equal(t, uint32(50), ef.Depth())
+}
+
+func TestObserveTransactionUsesVirtualSize(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	tx := newTestTx(1)
+	tx.AddTxIn(&wire.TxIn{Witness: wire.TxWitness{make([]byte, 400)}})
+	tx.AddTxOut(wire.NewTxOut(1000, make([]byte, 22)))
+	segwitTx := btcutil.NewTx(tx)
+	hash := tx.TxHash()
+	vsize := mempool.GetTxVirtualSize(segwitTx)
+	require.True(t, vsize < int64(tx.SerializeSize()))
+
+	// act
+	ef.ObserveTransaction(&TxDesc{
+		Hash:   &hash,
+		Height: 100,
+		Fee:    vsize * 10,
+		Size:   int32(tx.SerializeSize()),
+		Tx:     segwitTx,
+	})
+
+	// assert
+	assert.Equal(t, SatoshiPerByte(10), ef.observed[hash].feeRate)
+}
+
+func TestBlockHashWithinRollbackWindow(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+	expected := ef.dropped[len(ef.dropped)-1].hash
+
+	// act
+	hash, ok := ef.BlockHash(105)
+	_, okBeyondWindow := ef.BlockHash(105 - DefaultEstimateFeeMaxRollback)
+	_, okFuture := ef.BlockHash(106)
+
+	// assert
+	require.True(t, ok)
+	assert.Equal(t, expected, *hash)
+	assert.False(t, okBeyondWindow)
+	assert.False(t, okFuture)
+
+	require.NoError(t, ef.Rollback(hash))
+	assert.Equal(t, int32(104), ef.GetLastKnownHeight())
+}
+
+func TestEstimateConfirmationTime(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	start := time.Unix(1500000000, 0)
+	var txs []*wire.MsgTx
+	for i := uint32(1); i <= 3; i++ {
+		tx := newTestTx(i)
+		hash := tx.TxHash()
+		ef.ObserveTransaction(&TxDesc{
+			Hash:   &hash,
+			Height: 100,
+			Fee:    int64(10 * tx.SerializeSize()),
+			Size:   int32(tx.SerializeSize()),
+			Time:   start.Add(time.Duration(i) * time.Minute),
+		})
+		txs = append(txs, tx)
+	}
+
+	block := newTestBlock(101, txs...)
+	block.MsgBlock().Header.Timestamp = start.Add(10 * time.Minute)
+	require.NoError(t, ef.RegisterBlock(block))
+
+	// act
+	wait, err := ef.EstimateConfirmationTime(10)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, 8*time.Minute, wait)
+}
+
+func TestEvictionIndexKeepsFeeRateSpread(t *testing.T) {
+	// arrange
+	rates := []SatoshiPerByte{50, 1, 10, 10.5, 200, 11}
+	bin := make([]*observedTransaction, len(rates))
+	for i, rate := range rates {
+		bin[i] = &observedTransaction{feeRate: rate}
+	}
+
+	// act
+	evicted := make([]int, len(bin))
+	for i := 0; i < 1000; i++ {
+		evicted[evictionIndex(bin)]++
+	}
+
+	// assert
+	assert.Zero(t, evicted[1], "lowest fee rate must not be evicted")
+	assert.Zero(t, evicted[4], "highest fee rate must not be evicted")
+	assert.True(t, evicted[3] > evicted[0], "crowded fee rates are evicted first")
+}
+
+func TestEstimateFeeReturnsConfidence(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	var txs []*wire.MsgTx
+	for i := uint32(1); i <= 4; i++ {
+		tx := newTestTx(i)
+		observeTestTx(ef, tx, 100, int64(10*int(i)*tx.SerializeSize()))
+		txs = append(txs, tx)
+	}
+
+	// the tx paying 40 sat/byte stays in the mempool
+	require.NoError(t, ef.RegisterBlock(newTestBlock(101, txs[0], txs[1])))
+	require.NoError(t, ef.RegisterBlock(newTestBlock(102, txs[2])))
+
+	// act
+	_, confidenceOneBlock, err := ef.EstimateFee(1)
+	require.NoError(t, err)
+	rate, confidenceTwoBlocks, err := ef.EstimateFee(2)
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, SatoshiPerByte(10).ToBtcPerKb(), rate)
+	assert.Equal(t, 0.0, confidenceOneBlock)
+	assert.Equal(t, 0.75, confidenceTwoBlocks)
+}
+
+func TestRegTestEstimatesAfterOneBlock(t *testing.T) {
+	// arrange
+	network, err := ParseNetwork("regtest")
+	require.NoError(t, err)
+	ef := NewFeeEstimatorWithOptions(NetworkOptions(network))
+	mainNet := NewFeeEstimatorWithOptions(NetworkOptions(MainNet))
+
+	// act
+	require.NoError(t, ef.RegisterBlock(newTestBlock(1)))
+	require.NoError(t, mainNet.RegisterBlock(newTestBlock(1)))
+
+	// assert
+	_, _, err = ef.EstimateFee(1)
+	assert.NoError(t, err)
+	_, _, err = mainNet.EstimateFee(1)
+	assert.Error(t, err)
+}
+
+func TestParseNetwork(t *testing.T) {
+	for _, network := range []Network{MainNet, TestNet, RegTest} {
+		parsed, err := ParseNetwork(network.String())
+		assert.NoError(t, err)
+		assert.Equal(t, network, parsed)
+	}
+
+	_, err := ParseNetwork("simnet")
+	assert.Error(t, err)
+}
+
+func TestRemoveTransactionDropsOnlyUnminedObservations(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	mined, evicted := newTestTx(1), newTestTx(2)
+	observeTestTx(ef, mined, 100, 1000)
+	observeTestTx(ef, evicted, 100, 1000)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(101, mined)))
+
+	minedHash, evictedHash := mined.TxHash(), evicted.TxHash()
+
+	// act & assert
+	assert.False(t, ef.RemoveTransaction(&minedHash))
+	assert.True(t, ef.RemoveTransaction(&evictedHash))
+	assert.False(t, ef.RemoveTransaction(&evictedHash))
+	assert.Equal(t, 0, ef.Snapshot().Observed)
+	assert.Equal(t, 1, ef.Snapshot().Bins[0].Count)
+}
+
+func TestEstimateFeeReturnsSentinelErrors(t *testing.T) {
+	// arrange
+	ef := NewFeeEstimator(DefaultEstimateFeeMaxRollback, 2)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(100)))
+
+	// act
+	_, _, errNotEnoughBlocks := ef.EstimateFee(1)
+	require.NoError(t, ef.RegisterBlock(newTestBlock(101)))
+	_, _, errZeroBlocks := ef.EstimateFee(0)
+	_, _, errTooDeep := ef.EstimateFee(ef.Depth() + 1)
+
+	// assert
+	assert.Equal(t, ErrNotEnoughBlocks, errNotEnoughBlocks)
+	assert.Equal(t, ErrZeroBlockTarget, errZeroBlocks)
+	assert.True(t, errors.Is(errTooDeep, ErrTargetTooDeep))
+}
+
+func TestRegisterBlockPrecomputesEstimates(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+	require.NotNil(t, ef.cached)
+	expected, _, err := ef.EstimateFee(1)
+	require.NoError(t, err)
+
+	// act: concurrent readers only need the read lock
+	results := make(chan BtcPerKilobyte, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			rate, _, _ := ef.EstimateFee(1)
+			results <- rate
+		}()
+	}
+
+	// assert
+	for i := 0; i < cap(results); i++ {
+		assert.Equal(t, expected, <-results)
+	}
+
+	lastBlock := ef.dropped[len(ef.dropped)-1].hash
+	require.NoError(t, ef.Rollback(&lastBlock))
+	assert.Equal(t, ef.estimates(), ef.cached)
+}
+
+func TestEstimateAllMatchesEstimateFee(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+
+	// act
+	all, err := ef.EstimateAll()
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, all, int(ef.Depth()))
+	for target := uint32(1); target <= ef.Depth(); target++ {
+		expected, _, err := ef.EstimateFee(target)
+		require.NoError(t, err)
+		assert.Equal(t, expected, all[target-1])
+	}
+
+	_, err = NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1).EstimateAll()
+	assert.Equal(t, ErrNotEnoughBlocks, err)
+}
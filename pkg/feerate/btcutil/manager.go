@@ -1,6 +1,11 @@
 package btcutil
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,6 +15,7 @@ import (
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/mining"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 	"go.uber.org/zap"
@@ -25,6 +31,41 @@ const (
 	BlockCountFast = 2
 )
 
+var (
+	// StateFile is the file the fee estimator state is persisted to and restored from
+	StateFile = "./output/btcutil/feeestimator.dat"
+
+	// EstimatorNetwork is the network the estimator runs on, it determines
+	// the rollback window and the number of blocks needed for estimates
+	EstimatorNetwork = MainNet
+
+	// PredictionsFile is the file the predictions are persisted to, so that
+	// they can still be scored after a restart
+	PredictionsFile = "./output/btcutil/predictions.json"
+
+	// DefaultInterval defines how often fees are estimated if Run is not
+	// given an interval
+	DefaultInterval = time.Second * 30
+
+	// MaxConsecutiveErrors is the number of estimations in a row that may
+	// fail before Run gives up
+	MaxConsecutiveErrors = 5
+
+	// SaveInterval defines how often the fee estimator state is persisted
+	SaveInterval = time.Minute * 5
+
+	// MaxCatchUpBlocks is the number of missed blocks that are registered
+	// when the estimator fell behind. If more blocks were missed the
+	// estimator starts over at the current block.
+	MaxCatchUpBlocks int32 = 1000
+
+	// CatchUpBatchSize is the number of missed blocks registered at once
+	CatchUpBatchSize int32 = 10
+
+	// CatchUpDelay is the pause between two batches of missed blocks
+	CatchUpDelay = time.Second
+)
+
 type Estimator struct {
 	logger         *zap.Logger
 	client         *utils.CachedRPCClient
@@ -33,47 +74,172 @@ type Estimator struct {
 	feeEstimator   *FeeEstimator
 
 	mempoolCache *feerate.MempoolCache
-	scores       *scores
 	ratesCache   *feerate.RateCache
+	scores       *scores
+	calibration  *calibration
+
+	// evictedHeight is the first height whose txs that left the mempool
+	// without being mined may not have been removed yet
+	evictedHeight int32
+
+	// statusMu guards status and replacing feeEstimator, which Status reads
+	statusMu sync.Mutex
+	status   Status
 }
 
 func NewEstimator(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache, mempoolCache *feerate.MempoolCache) *Estimator {
-	feeEstimator := NewFeeEstimator(
-		mempool.DefaultEstimateFeeMaxRollback,
-		mempool.DefaultEstimateFeeMinRegisteredBlocks)
+	feeEstimator, err := loadFeeEstimator(StateFile)
+	if err == nil {
+		err = feeEstimator.ApplyOptions(NetworkOptions(EstimatorNetwork))
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("could not restore fee estimator, starting over", zap.Error(err))
+		}
+
+		feeEstimator = NewFeeEstimatorWithOptions(NetworkOptions(EstimatorNetwork))
+	} else {
+		logger.Info("restored fee estimator", zap.Int32("height", feeEstimator.GetLastKnownHeight()))
+	}
+
+	scores, err := loadScores(PredictionsFile, logger)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Error("could not restore predictions, starting over", zap.Error(err))
+		}
+
+		scores = newScores(logger)
+	} else {
+		logger.Info("restored predictions", zap.Int("count", len(scores.predictions)))
+	}
 
-	return &Estimator{
+	e := &Estimator{
 		feeEstimator: feeEstimator,
 		client:       client,
 		logger:       logger,
 		mempoolCache: mempoolCache,
 		ratesCache:   ratesCache,
-		scores:       newScores(logger),
+		scores:       scores,
+		calibration:  newCalibration(),
+	}
+
+	// replay the outcomes of the restored predictions
+	scores.calibrate(e.calibration)
+
+	if height := feeEstimator.GetLastKnownHeight(); height != mining.UnminedHeight {
+		e.lastSeenHeight = height
 	}
+
+	return e
 }
 
-// Run starts the main event loop for estimating fees
-func (e *Estimator) Run() error {
-	ticker := time.NewTicker(time.Second * 30)
+// Run starts the main event loop for estimating fees. It estimates fees every
+// interval, or every DefaultInterval if interval is not positive, until ctx
+// is cancelled or MaxConsecutiveErrors estimations in a row failed. The
+// errors that occurred are returned together.
+func (e *Estimator) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	errorChannel := make(chan error)
-	go func() {
-		err := e.doWork()
+	saveTicker := time.NewTicker(SaveInterval)
+	defer saveTicker.Stop()
+
+	var errs runErrors
+	consecutive := 0
+	work := func() {
+		err := e.doWork(ctx)
+		if ctx.Err() != nil {
+			return // stopped while estimating
+		}
+
+		e.recordResult(err)
 		if err != nil {
-			errorChannel <- err
+			e.logger.Error("fee estimation failed", zap.Error(err))
+			errs = append(errs, err)
+			consecutive++
+			return
 		}
-		for {
-			select {
-			case <-ticker.C:
-				err := e.doWork()
-				if err != nil {
-					errorChannel <- err
-				}
+
+		consecutive = 0
+	}
+
+	work()
+	for consecutive < MaxConsecutiveErrors {
+		select {
+		case <-ctx.Done():
+			e.logger.Info("stopping fee estimation")
+			err := e.save()
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+			return errs.errorOrNil()
+		case <-ticker.C:
+			work()
+		case <-saveTicker.C:
+			err := e.save()
+			if err != nil {
+				e.logger.Error("could not save fee estimator", zap.Error(err))
 			}
 		}
-	}()
-	return <-errorChannel
+	}
+
+	return errs.errorOrNil()
+}
+
+// runErrors collects the errors that occurred while running the estimator
+type runErrors []error
+
+func (errs runErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d error(s) occurred: %s", len(errs), strings.Join(messages, "; "))
+}
+
+func (errs runErrors) errorOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// savePredictions persists the predictions to PredictionsFile
+func (e *Estimator) savePredictions() error {
+	data, err := e.scores.save()
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFile(PredictionsFile, data)
+}
+
+// save persists the fee estimator state to StateFile
+func (e *Estimator) save() error {
+	err := utils.WriteFile(StateFile, e.feeEstimator.Save())
+	if err != nil {
+		return err
+	}
+
+	e.logger.Info("saved fee estimator", zap.Int32("height", e.feeEstimator.GetLastKnownHeight()))
+	return nil
+}
+
+// loadFeeEstimator restores a fee estimator previously persisted by save
+func loadFeeEstimator(fileName string) (*FeeEstimator, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return RestoreFeeEstimator(data)
 }
 
 // These are the multipliers for bitcoin denominations.
@@ -101,13 +267,43 @@ type TxDesc struct {
 	// FeePerKB is the fee the transaction pays in Satoshi per 1000 bytes.
 	FeePerKB int64
 
+	// Size is the serialized size of the transaction including witness data.
 	Size int32
 
+	// VirtualSize is the size of the transaction in virtual bytes
+	// (weight / 4). If it is not set it is computed from Tx.
+	VirtualSize int32
+
+	// Tx is the transaction itself, it is optional if VirtualSize is set.
+	Tx *btcutil.Tx
+
+	// Time is when the transaction entered the pool. If it is not set the
+	// time it is observed by the fee estimator is used.
+	Time time.Time
+
 	Hash *chainhash.Hash
 }
 
-func (e *Estimator) doWork() error {
-	info, err := e.client.GetBlockChainInfo()
+// virtualSize returns the virtual size of the transaction, falling back to
+// the raw size if neither VirtualSize nor Tx is known.
+func (t *TxDesc) virtualSize() int32 {
+	if t.VirtualSize > 0 {
+		return t.VirtualSize
+	}
+
+	if t.Tx != nil {
+		return int32(mempool.GetTxVirtualSize(t.Tx))
+	}
+
+	return t.Size
+}
+
+func (e *Estimator) doWork(ctx context.Context) error {
+	var info *btcjson.GetBlockChainInfoResult
+	err := e.retry(ctx, "get blockchain info", func() (err error) {
+		info, err = e.client.GetBlockChainInfo()
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -119,7 +315,7 @@ func (e *Estimator) doWork() error {
 			return nil
 		}
 
-		return err
+		return &utils.TransientError{Op: "get mempool", Err: err}
 	}
 
 	for hash, memTx := range pool {
@@ -129,34 +325,25 @@ func (e *Estimator) doWork() error {
 		}
 	}
 
+	if e.lastSeenHeight != 0 {
+		err = e.handleReorg(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	if e.lastSeenHeight < info.Blocks {
 		if e.lastSeenHeight != 0 && info.Blocks != e.lastSeenHeight+1 {
-			//TODO treat orphaned blocks
-			estimatorHeight := e.lastSeenHeight
-			diff := info.Blocks - estimatorHeight
-			if diff < 10 {
-				e.logger.Info("getting missed blocks", zap.Any("diff", diff))
-				for i := info.Blocks - diff + 1; i < info.Blocks; i++ {
-					hash, err := e.client.GetBlockHash(int64(i))
-					if err != nil {
-						return err
-					}
-
-					block, err := e.getBlockByHash(hash)
-					if err != nil {
-						return err
-					}
-
-					b := btcutil.NewBlock(block)
-					b.SetHeight(i)
-					err = e.feeEstimator.RegisterBlock(b)
-					if err != nil {
-						e.logger.Error("block could not be registered", zap.String("error", err.Error()))
-						return nil
-					}
-				}
+			missed := info.Blocks - e.lastSeenHeight - 1
+			if missed > MaxCatchUpBlocks {
+				e.logger.Error("too many blocks missed, starting over", zap.Any("last seen", e.lastSeenHeight), zap.Any("current", info.Blocks))
+				e.resetFeeEstimator()
 			} else {
-				e.logger.Error("too many blocks missed", zap.Any("last seen", e.lastSeenHeight), zap.Any("current", info.Blocks))
+				err = e.catchUp(ctx, e.lastSeenHeight+1, info.Blocks-1)
+				if err != nil {
+					e.logger.Error("missed blocks could not be registered", zap.String("error", err.Error()))
+					return e.handleStateError(err)
+				}
 			}
 		}
 
@@ -167,7 +354,11 @@ func (e *Estimator) doWork() error {
 			return err
 		}
 
-		block, err := e.getBlockByHash(hash)
+		var block *wire.MsgBlock
+		err = e.retry(ctx, "get block", func() (err error) {
+			block, err = e.getBlockByHash(hash)
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -177,45 +368,217 @@ func (e *Estimator) doWork() error {
 		err = e.feeEstimator.RegisterBlock(b)
 		if err != nil {
 			e.logger.Error("block could not be registered", zap.String("error", err.Error()))
-			return nil
+			return e.handleStateError(&stateError{op: "register block", err: err})
 		}
 
 		e.lastSeenHeight = info.Blocks
 	}
 
-	economicalFeeRate, err := e.feeEstimator.EstimateFee(BlockCountEconomical)
-	if err != nil {
-		e.logger.Error("economical fee could not be estimated", zap.String("error", err.Error()))
+	if e.lastSeenHeight == info.Blocks {
+		e.removeEvictedTxs(info.Blocks)
+	}
+
+	economicalFeeRate, economicalConfidence, err := e.feeEstimator.EstimateFee(BlockCountEconomical)
+	if err == ErrNotEnoughBlocks {
+		e.logger.Info("waiting for more blocks before estimating fees", zap.Int32("height", e.lastSeenHeight))
 		return nil
 	}
+	if err != nil {
+		return fmt.Errorf("economical fee could not be estimated: %v", err)
+	}
 
-	standardFeeRate, err := e.feeEstimator.EstimateFee(BlockCountStandard)
+	standardFeeRate, standardConfidence, err := e.feeEstimator.EstimateFee(BlockCountStandard)
 	if err != nil {
-		e.logger.Error("standard fee could not be estimated", zap.String("error", err.Error()))
-		return nil
+		return fmt.Errorf("standard fee could not be estimated: %v", err)
+	}
+
+	fastFeeRate, fastConfidence, err := e.feeEstimator.EstimateFee(BlockCountFast)
+	if err != nil {
+		return fmt.Errorf("fast fee could not be estimated: %v", err)
 	}
 
-	fastFeeRate, err := e.feeEstimator.EstimateFee(BlockCountFast)
+	economical := e.calibration.apply(BlockCountEconomical, float64((economicalFeeRate*BTC)/1000))
+	standard := e.calibration.apply(BlockCountStandard, float64((standardFeeRate*BTC)/1000))
+	fast := e.calibration.apply(BlockCountFast, float64((fastFeeRate*BTC)/1000))
+
+	e.logger.Info("estimated fee", zap.Any("economical satoshi per byte", economical), zap.Any("standard satoshi per byte", standard), zap.Any("fast satoshi per byte", fast))
+	e.logger.Info("estimate confidence", zap.Float64("economical", economicalConfidence), zap.Float64("standard", standardConfidence), zap.Float64("fast", fastConfidence))
+	e.logger.Info("calibration factors", zap.Float64("economical", e.calibration.factor(BlockCountEconomical)), zap.Float64("standard", e.calibration.factor(BlockCountStandard)), zap.Float64("fast", e.calibration.factor(BlockCountFast)))
+
+	standardWait, err := e.feeEstimator.EstimateConfirmationTime(SatoshiPerByte(standard))
 	if err != nil {
-		e.logger.Error("fast fee could not be estimated", zap.String("error", err.Error()))
+		e.logger.Info("confirmation time could not be estimated", zap.String("error", err.Error()))
 	} else {
-		e.logger.Info("estimated fee", zap.Any("economical satoshi per byte", (economicalFeeRate*BTC)/1000), zap.Any("standard satoshi per byte", (standardFeeRate*BTC)/1000), zap.Any("fast satoshi per byte", (fastFeeRate*BTC)/1000))
+		e.logger.Info("estimated confirmation time", zap.Duration("standard", standardWait))
+	}
+
+	var feeRates *feerate.FeeRates
+	err = e.retry(ctx, "get fee rates", func() (err error) {
+		feeRates, err = e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	e.scores.addPrediction(int(info.Blocks), feeRates, economical, standard, fast, standardWait)
+	err = e.scores.predictScores()
+	if err != nil {
+		return err
+	}
+
+	e.scores.calibrate(e.calibration)
+	e.scores.prune(int(info.Blocks))
+
+	err = e.savePredictions()
+	if err != nil {
+		e.logger.Error("could not save predictions", zap.Error(err))
+	}
+
+	return nil
+}
+
+// handleReorg checks whether the registered blocks are still part of the best
+// chain. Orphaned blocks are rolled back so that the blocks of the new branch
+// get registered again as missed blocks. If the fork is deeper than the
+// rollback window the fee estimator is started over.
+func (e *Estimator) handleReorg(ctx context.Context) error {
+	height := e.feeEstimator.GetLastKnownHeight()
+
+	var orphaned *chainhash.Hash
+	for i := height; ; i-- {
+		registered, ok := e.feeEstimator.BlockHash(i)
+		if !ok {
+			if orphaned == nil {
+				// nothing registered that could be compared
+				return nil
+			}
+
+			e.logger.Error("reorg is deeper than the rollback window, starting over", zap.Int32("height", height), zap.Int32("fork below", i+1))
+			e.resetFeeEstimator()
+			return nil
+		}
+
+		var current *chainhash.Hash
+		err := e.retry(ctx, "get block hash", func() (err error) {
+			current, err = e.client.GetBlockHash(int64(i))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if registered.IsEqual(current) {
+			break
+		}
+
+		orphaned = registered
+	}
+
+	if orphaned == nil {
+		return nil
+	}
+
+	err := e.feeEstimator.Rollback(orphaned)
+	if err != nil {
+		return e.handleStateError(&stateError{op: "roll back orphaned blocks", err: err})
+	}
+
+	e.lastSeenHeight = e.feeEstimator.GetLastKnownHeight()
+	e.logger.Info("rolled back orphaned blocks", zap.Int32("from", height), zap.Int32("to", e.lastSeenHeight))
+	return nil
+}
+
+// handleStateError starts the fee estimator over if err means that its state
+// does not match the chain any more, so that the next round can recover
+// instead of failing on the same block forever. err is returned unchanged.
+func (e *Estimator) handleStateError(err error) error {
+	if _, ok := err.(*stateError); ok {
+		e.logger.Error("fee estimator state is invalid, starting over", zap.Error(err))
+		e.resetFeeEstimator()
+	}
+
+	return err
+}
+
+// resetFeeEstimator discards all collected data and starts over at the next block
+func (e *Estimator) resetFeeEstimator() {
+	feeEstimator := NewFeeEstimatorWithOptions(NetworkOptions(EstimatorNetwork))
+
+	e.statusMu.Lock()
+	e.feeEstimator = feeEstimator
+	e.statusMu.Unlock()
+
+	e.lastSeenHeight = 0
+}
+
+// catchUp registers the missed blocks from..to (inclusive) in batches of
+// CatchUpBatchSize, pausing CatchUpDelay between batches to not flood the node.
+// It stops early when ctx is cancelled.
+func (e *Estimator) catchUp(ctx context.Context, from int32, to int32) error {
+	e.logger.Info("getting missed blocks", zap.Int32("from", from), zap.Int32("to", to))
+	for batchStart := from; batchStart <= to; batchStart += CatchUpBatchSize {
+		if batchStart != from {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(CatchUpDelay):
+			}
+		}
+
+		batchEnd := batchStart + CatchUpBatchSize - 1
+		if batchEnd > to {
+			batchEnd = to
+		}
+
+		for height := batchStart; height <= batchEnd; height++ {
+			err := e.registerBlockAt(ctx, height)
+			if err != nil {
+				return err
+			}
+
+			e.lastSeenHeight = height
+		}
+
+		e.logger.Info("registered missed blocks", zap.Int32("height", batchEnd), zap.Int32("remaining", to-batchEnd))
+	}
+
+	return nil
+}
 
-		feeRates, err := e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+// registerBlockAt registers the block of the best chain at the given height
+func (e *Estimator) registerBlockAt(ctx context.Context, height int32) error {
+	var block *wire.MsgBlock
+	err := e.retry(ctx, "get block", func() error {
+		hash, err := e.client.GetBlockHash(int64(height))
 		if err != nil {
 			return err
 		}
 
-		e.scores.addPrediction(int(info.Blocks), feeRates, float64((economicalFeeRate*BTC)/1000), float64((standardFeeRate*BTC)/1000), float64((fastFeeRate*BTC)/1000))
-		return e.scores.predictScores()
+		block, err = e.getBlockByHash(hash)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	b := btcutil.NewBlock(block)
+	b.SetHeight(height)
+	err = e.feeEstimator.RegisterBlock(b)
+	if err != nil {
+		return &stateError{op: "register block", err: err}
 	}
 
 	return nil
 }
 
-func (e *Estimator) registerTx(hash string, memTx btcjson.GetRawMempoolVerboseResult) error {
+func (e *Estimator) registerTx(hash string, memTx utils.MempoolEntry) error {
 	feeInSatoshi := int64(memTx.Fee * BTC)
-	rate := (feeInSatoshi / int64(memTx.Size))
+	vsize := memTx.Vsize
+	if vsize <= 0 {
+		vsize = memTx.Size
+	}
+	rate := (feeInSatoshi / int64(vsize))
 	txHash := new(chainhash.Hash)
 	//e.logger.Info("registering tx", zap.Any("fee", feeInSatoshi), zap.Any("rate", rate))
 	err := chainhash.Decode(txHash, hash)
@@ -230,11 +593,52 @@ func (e *Estimator) registerTx(hash string, memTx btcjson.GetRawMempoolVerboseRe
 		Hash:             txHash,
 		StartingPriority: memTx.StartingPriority,
 		Size:             memTx.Size,
+		VirtualSize:      memTx.Vsize,
+	}
+	if memTx.Time > 0 {
+		txDesc.Time = time.Unix(memTx.Time, 0)
 	}
 	e.feeEstimator.ObserveTransaction(txDesc)
 	return nil
 }
 
+// removeEvictedTxs drops the observations of txs the mempool cache saw leave
+// the mempool without being mined up to height. The heights since the last
+// call are checked again as the cache may have recorded more txs for them
+// since.
+func (e *Estimator) removeEvictedTxs(height int32) {
+	from := e.evictedHeight
+	if from == 0 || from > height {
+		from = height
+	}
+
+	removed := 0
+	for h := from; h <= height; h++ {
+		evicted, err := e.mempoolCache.Evicted(h)
+		if err != nil {
+			continue // the cache did not see the height
+		}
+
+		for hash := range evicted {
+			txHash := new(chainhash.Hash)
+			err := chainhash.Decode(txHash, hash)
+			if err != nil {
+				continue
+			}
+
+			if e.feeEstimator.RemoveTransaction(txHash) {
+				removed++
+			}
+		}
+	}
+
+	if removed > 0 {
+		e.logger.Info("removed txs that left the mempool unconfirmed", zap.Int("count", removed))
+	}
+
+	e.evictedHeight = height
+}
+
 func (e *Estimator) registerBlock() error {
 	return nil
 }
@@ -2,7 +2,10 @@ package btcutil
 
 import (
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"sort"
 	"strconv"
@@ -18,6 +21,7 @@ type score struct {
 	ScoreStandard   float64
 	ScoreFast       float64
 	NumberOfTxs     int
+	MinutesElapsed  float64 //minutes between the prediction and the target block
 }
 
 type prediction struct {
@@ -26,11 +30,15 @@ type prediction struct {
 	economicalFeeRate float64
 	standardFeeRate   float64
 	fastFeeRate       float64
+	standardWait      time.Duration //expected confirmation time of the standard fee rate, negative if unknown
+	time              time.Time
 	scores            map[int]*score
+	evaluated         map[int]bool //targets that have been fed into the calibration
 }
 
 type scores struct {
 	predictions map[int]*prediction //blockheight->predictions
+	finished    [][]string          //csv records of the pruned predictions, by height
 
 	logger *zap.Logger
 }
@@ -42,17 +50,128 @@ func newScores(logger *zap.Logger) *scores {
 	}
 }
 
-func (s *scores) addPrediction(height int, rates *feerate.FeeRates, economicalFeeRate float64, standardFeeRate float64, fastFeeRate float64) {
+// savedPrediction is the persisted form of a prediction
+type savedPrediction struct {
+	Height            int            `json:"height"`
+	FeeRates          []float64      `json:"feeRates"`
+	NumberOfTxs       int            `json:"numberOfTxs"`
+	EconomicalFeeRate float64        `json:"economicalFeeRate"`
+	StandardFeeRate   float64        `json:"standardFeeRate"`
+	FastFeeRate       float64        `json:"fastFeeRate"`
+	StandardWait      time.Duration  `json:"standardWait"`
+	Time              time.Time      `json:"time"`
+	Scores            map[int]*score `json:"scores"`
+}
+
+// savedScores is the persisted form of scores
+type savedScores struct {
+	Predictions []savedPrediction `json:"predictions"`
+	Finished    [][]string        `json:"finished"`
+}
+
+// save returns the predictions made so far in a form that can be restored
+// by restoreScores, so that scores of earlier predictions can still be
+// computed after a restart.
+func (s *scores) save() ([]byte, error) {
+	saved := make([]savedPrediction, 0, len(s.predictions))
+	for _, p := range s.predictions {
+		saved = append(saved, savedPrediction{
+			Height:            p.height,
+			FeeRates:          p.feeRates.Rates,
+			NumberOfTxs:       p.feeRates.NumberOfTxs,
+			EconomicalFeeRate: p.economicalFeeRate,
+			StandardFeeRate:   p.standardFeeRate,
+			FastFeeRate:       p.fastFeeRate,
+			StandardWait:      p.standardWait,
+			Time:              p.time,
+			Scores:            p.scores,
+		})
+	}
+
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Height < saved[j].Height })
+	return json.Marshal(savedScores{Predictions: saved, Finished: s.finished})
+}
+
+// restoreScores restores predictions persisted by save
+func restoreScores(data []byte, logger *zap.Logger) (*scores, error) {
+	var saved savedScores
+	var err error
+	if len(data) > 0 && data[0] == '[' {
+		// saved before the finished predictions were kept
+		err = json.Unmarshal(data, &saved.Predictions)
+	} else {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := newScores(logger)
+	s.finished = saved.Finished
+	for _, p := range saved.Predictions {
+		if p.Scores == nil {
+			p.Scores = make(map[int]*score)
+		}
+
+		s.predictions[p.Height] = &prediction{
+			height: p.Height,
+			feeRates: &feerate.FeeRates{
+				Rates:       p.FeeRates,
+				NumberOfTxs: p.NumberOfTxs,
+			},
+			economicalFeeRate: p.EconomicalFeeRate,
+			standardFeeRate:   p.StandardFeeRate,
+			fastFeeRate:       p.FastFeeRate,
+			standardWait:      p.StandardWait,
+			time:              p.Time,
+			scores:            p.Scores,
+		}
+	}
+
+	return s, nil
+}
+
+// loadScores restores the predictions persisted to fileName
+func loadScores(fileName string, logger *zap.Logger) (*scores, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return restoreScores(data, logger)
+}
+
+func (s *scores) addPrediction(height int, rates *feerate.FeeRates, economicalFeeRate float64, standardFeeRate float64, fastFeeRate float64, standardWait time.Duration) {
 	s.predictions[height] = &prediction{
 		height:            height,
 		feeRates:          rates,
 		economicalFeeRate: economicalFeeRate,
 		standardFeeRate:   standardFeeRate,
 		fastFeeRate:       fastFeeRate,
+		standardWait:      standardWait,
+		time:              time.Now(),
 		scores:            make(map[int]*score),
 	}
 }
 
+// prune drops the predictions whose scoring window ended by height, they are
+// fully scored and already fed into the calibration. Only their csv records
+// are kept, so that flush still writes them.
+func (s *scores) prune(height int) {
+	var pruned []int
+	for h := range s.predictions {
+		if h+feerate.ScoredBlocks <= height {
+			pruned = append(pruned, h)
+		}
+	}
+
+	sort.Ints(pruned)
+	for _, h := range pruned {
+		s.finished = append(s.finished, s.record(h, s.predictions[h]))
+		delete(s.predictions, h)
+	}
+}
+
 func (s *scores) predictScores() error {
 	for num, pred := range s.predictions {
 		s.comparePredictionToNext10Blocks(num, pred)
@@ -69,90 +188,121 @@ func (s *scores) flush() error {
 	}
 	defer f.Close()
 
-	w := csv.NewWriter(f)
-	err = w.Write([]string{
+	return s.write(f)
+}
+
+// write writes the records of the finished and the current predictions as
+// csv to out, ordered by height
+func (s *scores) write(out io.Writer) error {
+	w := csv.NewWriter(out)
+	err := w.Write([]string{
 		"block_number",
 		"priceEconomical",
 		"priceStandard",
 		"priceFast",
 		"numberOfTxs",
+		"expectedMinutesStandard",
 
 		"scoreEconomicalPlus1",
 		"scoreStandardPlus1",
 		"scoreFastPlus1",
+		"minutesPlus1",
 
 		"scoreEconomicalPlus2",
 		"scoreStandardPlus2",
 		"scoreFastPlus2",
+		"minutesPlus2",
 
 		"scoreEconomicalPlus3",
 		"scoreStandardPlus3",
 		"scoreFastPlus3",
+		"minutesPlus3",
 
 		"scoreEconomicalPlus4",
 		"scoreStandardPlus4",
 		"scoreFastPlus4",
+		"minutesPlus4",
 
 		"scoreEconomicalPlus5",
 		"scoreStandardPlus5",
 		"scoreFastPlus5",
+		"minutesPlus5",
 
 		"scoreEconomicalPlus6",
 		"scoreStandardPlus6",
 		"scoreFastPlus6",
+		"minutesPlus6",
 
 		"scoreEconomicalPlus7",
 		"scoreStandardPlus7",
 		"scoreFastPlus7",
+		"minutesPlus7",
 
 		"scoreEconomicalPlus8",
 		"scoreStandardPlus8",
 		"scoreFastPlus8",
+		"minutesPlus8",
 
 		"scoreEconomicalPlus9",
 		"scoreStandardPlus9",
 		"scoreFastPlus9",
+		"minutesPlus9",
 
 		"scoreEconomicalPlus10",
 		"scoreStandardPlus10",
 		"scoreFastPlus10",
+		"minutesPlus10",
 	})
 
 	if err != nil {
 		return err
 	}
 
-	var records [][]string
-	for blockHeight, prediction := range s.predictions {
-		record := []string{
-			strconv.Itoa(blockHeight),
-			strconv.FormatFloat(prediction.economicalFeeRate, 'f', 3, 64),
-			strconv.FormatFloat(prediction.standardFeeRate, 'f', 3, 64),
-			strconv.FormatFloat(prediction.fastFeeRate, 'f', 3, 64),
-			strconv.Itoa(prediction.feeRates.NumberOfTxs),
-		}
-		for i := blockHeight + 1; i < blockHeight+11; i++ {
-			score, ok := prediction.scores[i]
-			if !ok {
-				record = append(record, strconv.Itoa(-1))
-				record = append(record, strconv.Itoa(-1))
-				record = append(record, strconv.Itoa(-1))
-			} else {
-				record = append(record, strconv.FormatFloat(score.ScoreEconomical, 'f', 3, 64))
-				record = append(record, strconv.FormatFloat(score.ScoreStandard, 'f', 3, 64))
-				record = append(record, strconv.FormatFloat(score.ScoreFast, 'f', 3, 64))
-			}
-		}
+	heights := make([]int, 0, len(s.predictions))
+	for height := range s.predictions {
+		heights = append(heights, height)
+	}
+	sort.Ints(heights)
 
-		records = append(records, record)
+	records := append([][]string{}, s.finished...)
+	for _, height := range heights {
+		records = append(records, s.record(height, s.predictions[height]))
 	}
 
 	s.logger.Info("prediction score", zap.Any("scores", records))
 	return w.WriteAll(records)
 }
 
+// record returns the csv record of the prediction made at blockHeight
+func (s *scores) record(blockHeight int, prediction *prediction) []string {
+	record := []string{
+		strconv.Itoa(blockHeight),
+		strconv.FormatFloat(prediction.economicalFeeRate, 'f', 3, 64),
+		strconv.FormatFloat(prediction.standardFeeRate, 'f', 3, 64),
+		strconv.FormatFloat(prediction.fastFeeRate, 'f', 3, 64),
+		strconv.Itoa(prediction.feeRates.NumberOfTxs),
+		strconv.FormatFloat(prediction.standardWait.Minutes(), 'f', 3, 64),
+	}
+	for i := blockHeight + 1; i <= blockHeight+feerate.ScoredBlocks; i++ {
+		score, ok := prediction.scores[i]
+		if !ok {
+			record = append(record, strconv.Itoa(-1))
+			record = append(record, strconv.Itoa(-1))
+			record = append(record, strconv.Itoa(-1))
+			record = append(record, strconv.Itoa(-1))
+		} else {
+			record = append(record, strconv.FormatFloat(score.ScoreEconomical, 'f', 3, 64))
+			record = append(record, strconv.FormatFloat(score.ScoreStandard, 'f', 3, 64))
+			record = append(record, strconv.FormatFloat(score.ScoreFast, 'f', 3, 64))
+			record = append(record, strconv.FormatFloat(score.MinutesElapsed, 'f', 3, 64))
+		}
+	}
+
+	return record
+}
+
 func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *prediction) {
-	for i := blockNumber + 1; i < blockNumber+11; i++ {
+	for i := blockNumber + 1; i <= blockNumber+feerate.ScoredBlocks; i++ {
 		_, ok := predict.scores[i]
 		if !ok {
 			targetPrediction, targetPredictionOk := s.predictions[i]
@@ -161,27 +311,16 @@ func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *predi
 				continue
 			}
 
-			scoreEconomical := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.economicalFeeRate)
-			scoreStandard := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.standardFeeRate)
-			scoreFast := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.fastFeeRate)
+			scoreEconomical := feerate.HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.economicalFeeRate)
+			scoreStandard := feerate.HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.standardFeeRate)
+			scoreFast := feerate.HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.fastFeeRate)
 			predict.scores[i] = &score{
 				ScoreEconomical: scoreEconomical,
 				ScoreStandard:   scoreStandard,
 				ScoreFast:       scoreFast,
 				NumberOfTxs:     targetPrediction.feeRates.NumberOfTxs,
+				MinutesElapsed:  targetPrediction.time.Sub(predict.time).Minutes(),
 			}
 		}
 	}
 }
-
-func (s *scores) getPercentageOfTxsWithHigherFeeRate(feeRates []int, prediction float64) float64 {
-	sort.Ints(feeRates)
-	for idx, feeRate := range feeRates {
-		if float64(feeRate) > prediction {
-			percentage := (1.0 - (float64(idx) / float64(len(feeRates)))) * 100.0 //(1-idx/txs)*100
-			return percentage
-		}
-	}
-
-	return 0
-}
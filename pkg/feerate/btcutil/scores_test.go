@@ -0,0 +1,88 @@
+package btcutil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSaveAndRestoreScores(t *testing.T) {
+	// arrange
+	s := newScores(zap.NewNop())
+	s.addPrediction(100, &feerate.FeeRates{Rates: []float64{1, 5, 10, 20}, NumberOfTxs: 4}, 2, 6, 12, 10*time.Minute)
+
+	data, err := s.save()
+	require.NoError(t, err)
+
+	// act
+	restored, err := restoreScores(data, zap.NewNop())
+	require.NoError(t, err)
+	restored.addPrediction(101, &feerate.FeeRates{Rates: []float64{3, 4, 8, 30}, NumberOfTxs: 4}, 2, 6, 12, -1)
+	for num, pred := range restored.predictions {
+		restored.comparePredictionToNext10Blocks(num, pred)
+	}
+
+	// assert: the prediction made before the restart is scored by the next block
+	require.Contains(t, restored.predictions, 100)
+	p := restored.predictions[100]
+	assert.Equal(t, 6.0, p.standardFeeRate)
+	assert.Equal(t, 10*time.Minute, p.standardWait)
+	require.Contains(t, p.scores, 101)
+	assert.Equal(t, 50.0, p.scores[101].ScoreStandard)
+	assert.Equal(t, 4, p.scores[101].NumberOfTxs)
+}
+
+func TestScoresPruneDropsScoredPredictions(t *testing.T) {
+	// arrange
+	s := newScores(zap.NewNop())
+	for height := 100; height <= 115; height++ {
+		s.addPrediction(height, &feerate.FeeRates{Rates: []float64{1, 5, 10, 20}, NumberOfTxs: 4}, 2, 6, 12, -1)
+	}
+
+	// act
+	s.prune(115)
+	data, err := s.save()
+	require.NoError(t, err)
+	restored, err := restoreScores(data, zap.NewNop())
+	require.NoError(t, err)
+
+	// assert
+	assert.Len(t, restored.predictions, 10)
+	assert.NotContains(t, restored.predictions, 105)
+	assert.Contains(t, restored.predictions, 106)
+}
+
+func TestScoresFlushKeepsPrunedPredictions(t *testing.T) {
+	// arrange
+	s := newScores(zap.NewNop())
+	for height := 100; height <= 115; height++ {
+		s.addPrediction(height, &feerate.FeeRates{Rates: []float64{1, 5, 10, 20}, NumberOfTxs: 4}, 2, 6, 12, -1)
+	}
+	for num, pred := range s.predictions {
+		s.comparePredictionToNext10Blocks(num, pred)
+	}
+	s.prune(115)
+	data, err := s.save()
+	require.NoError(t, err)
+	restored, err := restoreScores(data, zap.NewNop())
+	require.NoError(t, err)
+
+	// act
+	var out bytes.Buffer
+	err = restored.write(&out)
+
+	// assert
+	require.NoError(t, err)
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1+16)
+	assert.Equal(t, "100", records[1][0])
+	assert.Equal(t, "50.000", records[1][7])
+	assert.Equal(t, "115", records[16][0])
+}
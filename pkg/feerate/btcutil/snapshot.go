@@ -0,0 +1,90 @@
+package btcutil
+
+import (
+	"sort"
+
+	"github.com/btcsuite/btcd/mining"
+)
+
+// FeeEstimatorSnapshot is a point in time copy of the internal state of a
+// FeeEstimator. It is meant for debugging and tests and can be serialized
+// to json.
+type FeeEstimatorSnapshot struct {
+	LastKnownHeight     int32                  `json:"lastKnownHeight"`
+	BlocksRegistered    uint32                 `json:"blocksRegistered"`
+	MinRegisteredBlocks uint32                 `json:"minRegisteredBlocks"`
+	Observed            int                    `json:"observed"` // txs seen in the mempool but not mined yet
+	Bins                []BinSnapshot          `json:"bins"`
+	Dropped             []DroppedBlockSnapshot `json:"dropped"`
+}
+
+// BinSnapshot describes the txs that were mined a given number of blocks
+// after they have been observed.
+type BinSnapshot struct {
+	Confirmations int              `json:"confirmations"`
+	Count         int              `json:"count"`
+	FeeRates      []SatoshiPerByte `json:"feeRates"` // ascending
+	Min           SatoshiPerByte   `json:"min"`
+	Median        SatoshiPerByte   `json:"median"`
+	Max           SatoshiPerByte   `json:"max"`
+}
+
+// DroppedBlockSnapshot describes a registered block that can still be
+// rolled back and the number of txs it evicted from full bins.
+type DroppedBlockSnapshot struct {
+	Height  int32  `json:"height"`
+	Hash    string `json:"hash"`
+	Evicted int    `json:"evicted"`
+}
+
+// Snapshot returns a copy of the bins and the rollback history of the
+// estimator. Modifying the result does not affect the estimator.
+func (ef *FeeEstimator) Snapshot() *FeeEstimatorSnapshot {
+	ef.mtx.RLock()
+	defer ef.mtx.RUnlock()
+
+	snapshot := &FeeEstimatorSnapshot{
+		LastKnownHeight:     ef.LastKnownHeight,
+		BlocksRegistered:    ef.numBlocksRegistered,
+		MinRegisteredBlocks: ef.minRegisteredBlocks,
+		Bins:                make([]BinSnapshot, len(ef.bin)),
+		Dropped:             make([]DroppedBlockSnapshot, len(ef.dropped)),
+	}
+
+	for _, o := range ef.observed {
+		if o.mined == mining.UnminedHeight {
+			snapshot.Observed++
+		}
+	}
+
+	for i, bin := range ef.bin {
+		rates := make([]SatoshiPerByte, len(bin))
+		for j, o := range bin {
+			rates[j] = o.feeRate
+		}
+		sort.Slice(rates, func(a, b int) bool { return rates[a] < rates[b] })
+
+		binSnapshot := BinSnapshot{
+			Confirmations: i + 1,
+			Count:         len(rates),
+			FeeRates:      rates,
+		}
+		if len(rates) > 0 {
+			binSnapshot.Min = rates[0]
+			binSnapshot.Median = rates[len(rates)/2]
+			binSnapshot.Max = rates[len(rates)-1]
+		}
+		snapshot.Bins[i] = binSnapshot
+	}
+
+	// the last dropped block is the one at LastKnownHeight
+	for i, rb := range ef.dropped {
+		snapshot.Dropped[i] = DroppedBlockSnapshot{
+			Height:  ef.LastKnownHeight - int32(len(ef.dropped)-1-i),
+			Hash:    rb.hash.String(),
+			Evicted: len(rb.transactions),
+		}
+	}
+
+	return snapshot
+}
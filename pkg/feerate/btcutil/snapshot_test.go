@@ -0,0 +1,57 @@
+package btcutil
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotReportsBinsAndDroppedBlocks(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+
+	// act
+	snapshot := ef.Snapshot()
+
+	// assert
+	assert.Equal(t, int32(105), snapshot.LastKnownHeight)
+	assert.Equal(t, 5, snapshot.Observed)
+	require.Len(t, snapshot.Bins, estimateFeeDepth)
+	assert.Equal(t, 1, snapshot.Bins[0].Confirmations)
+	assert.Equal(t, 20, snapshot.Bins[0].Count)
+	assert.True(t, snapshot.Bins[0].Min <= snapshot.Bins[0].Median)
+	assert.True(t, snapshot.Bins[0].Median <= snapshot.Bins[0].Max)
+	assert.Equal(t, 0, snapshot.Bins[1].Count)
+
+	require.Len(t, snapshot.Dropped, DefaultEstimateFeeMaxRollback)
+	assert.Equal(t, int32(104), snapshot.Dropped[0].Height)
+	assert.Equal(t, int32(105), snapshot.Dropped[1].Height)
+	assert.Equal(t, 0, snapshot.Dropped[1].Evicted) // bins are not full yet
+	hash, ok := ef.BlockHash(105)
+	require.True(t, ok)
+	assert.Equal(t, hash.String(), snapshot.Dropped[1].Hash)
+
+	_, err := json.Marshal(snapshot)
+	assert.NoError(t, err)
+}
+
+func TestSnapshotIsACopy(t *testing.T) {
+	// arrange
+	ef := newTestFeeEstimator(t)
+	expected, _, err := ef.EstimateFee(1)
+	require.NoError(t, err)
+
+	// act
+	snapshot := ef.Snapshot()
+	for i := range snapshot.Bins[0].FeeRates {
+		snapshot.Bins[0].FeeRates[i] = 0
+	}
+	ef.cached = nil
+
+	// assert
+	actual, _, err := ef.EstimateFee(1)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,66 @@
+package btcutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+var (
+	// RetryAttempts is the number of times a failed rpc call is tried again
+	// before the estimation round is given up
+	RetryAttempts = 3
+
+	// RetryBackoff is the pause before the first retry, it doubles with
+	// every further retry
+	RetryBackoff = time.Second
+)
+
+// stateError means that the fee estimator is in a state that does not match
+// the chain any more, e.g. because a block could not be registered. It does
+// not go away by trying again.
+type stateError struct {
+	op  string
+	err error
+}
+
+func (e *stateError) Error() string {
+	return e.op + ": " + e.err.Error()
+}
+
+// retry calls fn until it succeeds or RetryAttempts retries failed. Errors
+// of fn are considered transient, calls the rpc client gave up on already are
+// not retried, see utils.Retry. It stops waiting for the next attempt when
+// ctx is cancelled.
+func (e *Estimator) retry(ctx context.Context, op string, fn func() error) error {
+	return utils.Retry(ctx, e.logger, op, RetryAttempts, RetryBackoff, nil, fn)
+}
+
+// Status describes the health of an Estimator
+type Status struct {
+	utils.RunStatus
+	LastKnownHeight int32 `json:"lastKnownHeight"`
+	StateFailures   int   `json:"stateFailures"`
+}
+
+// Status returns the health of the estimator
+func (e *Estimator) Status() Status {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+
+	status := e.status
+	status.LastKnownHeight = e.feeEstimator.GetLastKnownHeight()
+	return status
+}
+
+// recordResult updates the status with the outcome of an estimation round
+func (e *Estimator) recordResult(err error) {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+
+	e.status.Record(err, time.Now())
+	if _, ok := err.(*stateError); ok {
+		e.status.StateFailures++
+	}
+}
@@ -0,0 +1,133 @@
+package btcutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/mining"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestEstimator() *Estimator {
+	return &Estimator{
+		logger:       zap.NewNop(),
+		feeEstimator: NewFeeEstimator(DefaultEstimateFeeMaxRollback, 1),
+	}
+}
+
+func TestRetryGivesUpWithTransientError(t *testing.T) {
+	// arrange
+	e := newTestEstimator()
+	backoff := RetryBackoff
+	RetryBackoff = 0
+	defer func() { RetryBackoff = backoff }()
+
+	calls := 0
+	fail := errors.New("connection refused")
+
+	// act
+	err := e.retry(context.Background(), "get block", func() error {
+		calls++
+		return fail
+	})
+
+	// assert
+	assert.Equal(t, RetryAttempts+1, calls)
+	assert.True(t, utils.IsTransient(err))
+	assert.Contains(t, err.Error(), "get block")
+}
+
+func TestRetryStopsOnSuccess(t *testing.T) {
+	// arrange
+	e := newTestEstimator()
+	backoff := RetryBackoff
+	RetryBackoff = 0
+	defer func() { RetryBackoff = backoff }()
+
+	calls := 0
+
+	// act
+	err := e.retry(context.Background(), "get block", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("timeout")
+		}
+		return nil
+	})
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	// arrange
+	e := newTestEstimator()
+	backoff := RetryBackoff
+	RetryBackoff = time.Hour
+	defer func() { RetryBackoff = backoff }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	// act
+	err := e.retry(ctx, "get block", func() error {
+		calls++
+		cancel()
+		return errors.New("timeout")
+	})
+
+	// assert
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestStatusCountsFailures(t *testing.T) {
+	// arrange
+	e := newTestEstimator()
+	require.NoError(t, e.feeEstimator.RegisterBlock(newTestBlock(100)))
+
+	// act
+	e.recordResult(&utils.TransientError{Op: "get block", Err: errors.New("timeout")})
+	e.recordResult(e.handleStateError(&stateError{op: "register block", err: errors.New("out of order")}))
+	status := e.Status()
+
+	// assert
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Equal(t, 1, status.TransientFailures)
+	assert.Equal(t, 1, status.StateFailures)
+	assert.Equal(t, "register block: out of order", status.LastError)
+	assert.Equal(t, int32(mining.UnminedHeight), status.LastKnownHeight, "the estimator starts over on state errors")
+
+	e.recordResult(nil)
+	assert.Equal(t, 0, e.Status().ConsecutiveFailures)
+	assert.False(t, e.Status().LastSuccess.IsZero())
+}
+
+func TestStatusWhileStartingOver(t *testing.T) {
+	// arrange
+	e := newTestEstimator()
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	// act
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			e.resetFeeEstimator()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		e.Status()
+	}
+	wg.Wait()
+
+	// assert
+	assert.Equal(t, int32(mining.UnminedHeight), e.Status().LastKnownHeight)
+}
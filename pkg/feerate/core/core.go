@@ -1,6 +1,10 @@
 package core
 
-import "log"
+import (
+	"log"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+)
 
 // TxConfirmStats used to track transactions that were included in a block. We will lump transactions into a bucket according to their
 // approximate feerate and then track how long it took for those txs to be included in a block
@@ -42,7 +46,7 @@ type TxConfirmStats struct {
 	// Mempool counts of outstanding transactions
 	// For each bucket X, track the number of transactions in the mempool
 	// that are unconfirmed for each possible confirmation value Y
-	unconfTxs [][]int
+	unconfTxs *feerate.UnconfirmedRing
 
 	// transactions still unconfirmed after GetMaxConfirms for each bucket
 	oldUnconfTxs []int
@@ -82,10 +86,7 @@ func NewTxConfirmStats(defaultBuckets []float64, defaultBucketMap map[float64]in
 }
 
 func (s *TxConfirmStats) ResizeInMemoryCounters(newBuckets int) {
-	s.unconfTxs = make([][]int, s.GetMaxConfirms())
-	for i := 0; i < len(s.unconfTxs); i++ {
-		s.unconfTxs[i] = make([]int, newBuckets)
-	}
+	s.unconfTxs = feerate.NewUnconfirmedRing(int(s.GetMaxConfirms()), newBuckets)
 
 	s.oldUnconfTxs = make([]int, newBuckets)
 }
@@ -96,10 +97,7 @@ func (s *TxConfirmStats) GetMaxConfirms() uint {
 
 // ClearCurrent roll the unconfirmed txs circular buffer
 func (s *TxConfirmStats) ClearCurrent(nBlockHeight uint) {
-	for i := 0; i < len(s.buckets); i++ {
-		s.oldUnconfTxs[i] += s.unconfTxs[nBlockHeight%uint(len(s.unconfTxs))][i]
-		s.unconfTxs[nBlockHeight%uint(len(s.unconfTxs))][i] = 0
-	}
+	s.unconfTxs.Rotate(int(nBlockHeight), s.oldUnconfTxs)
 }
 
 func (s *TxConfirmStats) Record(blocksToConfirm uint, val float64) {
@@ -179,7 +177,6 @@ func (s *TxConfirmStats) EstimateMedianVal(confTarget uint, sufficientTxVal floa
 	bestFarBucket := startbucket
 
 	foundAnswer := false
-	bins := uint(len(s.unconfTxs))
 	newBucketRange := true
 	passing := true
 	passBucket := new(EstimatorBucket)
@@ -196,7 +193,7 @@ func (s *TxConfirmStats) EstimateMedianVal(confTarget uint, sufficientTxVal floa
 		totalNum += s.txCtAvg[bucket]
 		failNum += s.failAvg[periodTarget-1][bucket]
 		for confct := confTarget; confct < s.GetMaxConfirms(); confct++ {
-			extraNum += s.unconfTxs[(nBlockHeight-confct)%bins][bucket]
+			extraNum += s.unconfTxs.At(int(nBlockHeight)-int(confct), bucket)
 		}
 
 		extraNum += s.oldUnconfTxs[bucket]
@@ -315,8 +312,7 @@ func (s *TxConfirmStats) EstimateMedianVal(confTarget uint, sufficientTxVal floa
 
 func (s *TxConfirmStats) NewTx(nBlockHeight uint, val float64) int {
 	bucketindex := lowerBound(s.bucketMap, val)
-	blockIndex := nBlockHeight % uint(len(s.unconfTxs))
-	s.unconfTxs[blockIndex][bucketindex]++
+	s.unconfTxs.Add(int(nBlockHeight), bucketindex)
 	return bucketindex
 }
 
@@ -332,19 +328,14 @@ func (s *TxConfirmStats) RemoveTx(entryHeight uint, nBestSeenHeight uint, bucket
 		return //This can't happen because we call this with our best seen height, no entries can have higher
 	}
 
-	if blocksAgo >= uint(len(s.unconfTxs)) {
+	if blocksAgo >= uint(s.unconfTxs.Len()) {
 		if s.oldUnconfTxs[bucketindex] > 0 {
 			s.oldUnconfTxs[bucketindex]--
 		} else {
 			log.Printf("Blockpolicy error, mempool tx removed from >25 blocks,bucketIndex=%v already\n", bucketindex)
 		}
-	} else {
-		blockIndex := entryHeight % uint(len(s.unconfTxs))
-		if s.unconfTxs[blockIndex][bucketindex] > 0 {
-			s.unconfTxs[blockIndex][bucketindex]--
-		} else {
-			log.Printf("Blockpolicy error, mempool tx removed from blockIndex=%v,bucketIndex=%v already\n", blockIndex, bucketindex)
-		}
+	} else if !s.unconfTxs.Remove(int(entryHeight), bucketindex) {
+		log.Printf("Blockpolicy error, mempool tx removed from height=%v,bucketIndex=%v already\n", entryHeight, bucketindex)
 	}
 
 	if !inBlock && uint(blocksAgo) >= s.scale { // Only counts as a failure if not confirmed for entire period
@@ -14,7 +14,7 @@ type RPCEstimator struct {
 	client             *utils.CachedRPCClient
 	logger             *zap.Logger
 	lastObservedHeight int32
-	scores             *scores
+	scores             *feerate.Scorer
 	ratesCache         *feerate.RateCache
 }
 
@@ -23,7 +23,7 @@ func NewRPCEstimator(logger *zap.Logger, client *utils.CachedRPCClient, ratesCac
 	return &RPCEstimator{
 		client:     client,
 		logger:     logger,
-		scores:     newScores(logger),
+		scores:     feerate.NewScorer(logger, "core"),
 		ratesCache: ratesCache,
 	}
 }
@@ -137,8 +137,8 @@ func (e *RPCEstimator) estimateSmartFee() error {
 		}
 
 		e.lastObservedHeight = info.Blocks
-		e.scores.addPrediction(int(info.Blocks), feeRates, economical, standard, fast)
-		return e.scores.predictScores()
+		e.scores.AddPrediction(int(info.Blocks), feeRates, economical, standard, fast)
+		return e.scores.PredictScores()
 	}
 
 	return nil
@@ -0,0 +1,188 @@
+package electrum
+
+import (
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/blockchain"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/ybbus/jsonrpc"
+
+	"go.uber.org/zap"
+)
+
+// Used for Fee estimation: Amount of Blocks remaining till confirmation
+const (
+	// BlockCountEconomical
+	BlockCountEconomical = 10
+	// BlockCountStandard
+	BlockCountStandard = 6
+	// BlockCountFast
+	BlockCountFast = 2
+)
+
+// Estimator estimates fees from the mempool fee histogram of Electrum
+// servers, so that no full node of its own is needed for the estimates. The
+// node is only used to score them.
+type Estimator struct {
+	electrum           jsonrpc.RPCClient
+	client             *utils.CachedRPCClient
+	logger             *zap.Logger
+	lastObservedHeight int32
+	scores             *feerate.Scorer
+	serverScores       *feerate.Scorer // of the estimates of the servers themselves
+	ratesCache         *feerate.RateCache
+}
+
+// NewEstimator creates a new estimator calling the Electrum servers of
+// electrum, see blockchain.NewElectrumXPool
+func NewEstimator(logger *zap.Logger, electrum jsonrpc.RPCClient, client *utils.CachedRPCClient, ratesCache *feerate.RateCache) *Estimator {
+	return &Estimator{
+		electrum:     electrum,
+		client:       client,
+		logger:       logger,
+		scores:       feerate.NewScorer(logger, "electrum"),
+		serverScores: feerate.NewScorer(logger, "electrumserver"),
+		ratesCache:   ratesCache,
+	}
+}
+
+// headerSubscriber is implemented by Electrum clients announcing new chain
+// tips, see blockchain.ElectrumX
+type headerSubscriber interface {
+	SubscribeHeaders() (<-chan blockchain.ElectrumXHeader, error)
+}
+
+// Run starts the main event loop for estimating fees. If the Electrum client
+// announces new chain tips, an estimation round also runs for each of them.
+func (e *Estimator) Run() error {
+	ticker := time.NewTicker(time.Minute * 1)
+	defer ticker.Stop()
+
+	var headers <-chan blockchain.ElectrumXHeader
+	if subscriber, ok := e.electrum.(headerSubscriber); ok {
+		var err error
+		headers, err = subscriber.SubscribeHeaders()
+		if err != nil {
+			e.logger.Warn("could not subscribe to headers, polling instead", zap.Error(err))
+		}
+	}
+
+	errorChannel := make(chan error)
+	go func() {
+		err := e.EstimateFee()
+		if err != nil {
+			errorChannel <- err
+		}
+		for {
+			select {
+			case <-ticker.C:
+				err := e.EstimateFee()
+				if err != nil {
+					errorChannel <- err
+				}
+			case header := <-headers:
+				e.logger.Info("new block announced", zap.Int64("height", header.Height))
+				err := e.EstimateFee()
+				if err != nil {
+					errorChannel <- err
+				}
+			}
+		}
+	}()
+
+	return <-errorChannel
+}
+
+// Rates are estimated rates in satoshi per virtual byte
+type Rates struct {
+	Economical float64 `json:"economical"`
+	Standard   float64 `json:"standard"`
+	Fast       float64 `json:"fast"`
+
+	// MinFeeRate is the relay fee of the server the rates are floored at
+	MinFeeRate float64 `json:"minFeeRate"`
+}
+
+// Estimate projects the rates of the confirmation targets from the current
+// fee histogram
+func (e *Estimator) Estimate() (*Rates, error) {
+	histogram, err := getFeeHistogram(e.electrum)
+	if err != nil {
+		return nil, err
+	}
+
+	minFeeRate, err := getRelayFee(e.electrum)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rates{
+		Economical: projectCutoff(histogram, BlockCountEconomical, minFeeRate),
+		Standard:   projectCutoff(histogram, BlockCountStandard, minFeeRate),
+		Fast:       projectCutoff(histogram, BlockCountFast, minFeeRate),
+		MinFeeRate: minFeeRate,
+	}, nil
+}
+
+// ServerRates returns the rates the server estimates for the confirmation
+// targets itself, for comparison with the projected ones
+func (e *Estimator) ServerRates() (*Rates, error) {
+	minFeeRate, err := getRelayFee(e.electrum)
+	if err != nil {
+		return nil, err
+	}
+
+	rates := &Rates{MinFeeRate: minFeeRate}
+	targets := map[int]*float64{
+		BlockCountEconomical: &rates.Economical,
+		BlockCountStandard:   &rates.Standard,
+		BlockCountFast:       &rates.Fast,
+	}
+	for target, rate := range targets {
+		*rate, err = getEstimateFee(e.electrum, target, minFeeRate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rates, nil
+}
+
+// EstimateFee runs the estimation and scores the estimates once per block
+func (e *Estimator) EstimateFee() error {
+	info, err := e.client.GetBlockChainInfo()
+	if err != nil {
+		return err
+	}
+
+	rates, err := e.Estimate()
+	if err != nil {
+		return err
+	}
+
+	serverRates, err := e.ServerRates()
+	if err != nil {
+		return err
+	}
+	e.logger.Info("got electrum rates", zap.Any("rates", rates), zap.Any("server rates", serverRates))
+
+	if e.lastObservedHeight < info.Blocks {
+		feeRates, err := e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+		if err != nil {
+			return err
+		}
+
+		e.lastObservedHeight = info.Blocks
+		e.scores.AddPrediction(int(info.Blocks), feeRates, rates.Economical, rates.Standard, rates.Fast)
+		e.serverScores.AddPrediction(int(info.Blocks), feeRates, serverRates.Economical, serverRates.Standard, serverRates.Fast)
+		err = e.scores.PredictScores()
+		if err != nil {
+			return err
+		}
+
+		return e.serverScores.PredictScores()
+	}
+
+	return nil
+}
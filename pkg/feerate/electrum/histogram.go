@@ -0,0 +1,97 @@
+package electrum
+
+import (
+	"sort"
+
+	"github.com/ybbus/jsonrpc"
+)
+
+var (
+	// BlockVsize is the virtual size of the txs a block holds at most
+	BlockVsize int64 = 1000000
+)
+
+// HistogramBin is a bin of the fee histogram of an Electrum server
+type HistogramBin struct {
+	// FeeRate is the lowest fee rate in satoshi per virtual byte of the txs
+	// in the bin
+	FeeRate float64
+
+	// Vsize is the total virtual size of the txs in the bin
+	Vsize int64
+}
+
+// getFeeHistogram returns the fee histogram of the mempool of the server,
+// highest fee rates first
+func getFeeHistogram(client jsonrpc.RPCClient) ([]HistogramBin, error) {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#mempool-get-fee-histogram
+	var pairs [][2]float64
+	err := client.CallFor(&pairs, "mempool.get_fee_histogram")
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := make([]HistogramBin, len(pairs))
+	for i, pair := range pairs {
+		histogram[i] = HistogramBin{FeeRate: pair[0], Vsize: int64(pair[1])}
+	}
+	sort.Slice(histogram, func(i, j int) bool {
+		return histogram[i].FeeRate > histogram[j].FeeRate
+	})
+
+	return histogram, nil
+}
+
+// getRelayFee returns the lowest fee rate in satoshi per virtual byte the
+// server relays txs at
+func getRelayFee(client jsonrpc.RPCClient) (float64, error) {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-relayfee
+	var relayFee float64 // BTC/kB
+	err := client.CallFor(&relayFee, "blockchain.relayfee")
+	if err != nil {
+		return 0, err
+	}
+
+	return relayFee * 1e5, nil
+}
+
+// getEstimateFee returns the fee rate in satoshi per virtual byte the server
+// estimates for confirmation within target blocks, but at least minFeeRate.
+// Servers that cannot estimate yet answer with -1, minFeeRate is returned
+// then.
+func getEstimateFee(client jsonrpc.RPCClient, target int, minFeeRate float64) (float64, error) {
+	// https://electrumx.readthedocs.io/en/latest/protocol-methods.html#blockchain-estimatefee
+	var fee float64 // BTC/kB
+	err := client.CallFor(&fee, "blockchain.estimatefee", target)
+	if err != nil {
+		return 0, err
+	}
+
+	rate := fee * 1e5
+	if rate < minFeeRate {
+		return minFeeRate, nil
+	}
+
+	return rate, nil
+}
+
+// projectCutoff returns the lowest fee rate that makes it into the next
+// target blocks if they are filled from histogram highest fee rate first. If
+// the mempool fits into target blocks any tx is expected to make it and
+// minFeeRate is returned.
+func projectCutoff(histogram []HistogramBin, target int, minFeeRate float64) float64 {
+	capacity := BlockVsize * int64(target)
+	var vsize int64
+	for _, bin := range histogram {
+		vsize += bin.Vsize
+		if vsize >= capacity {
+			if bin.FeeRate < minFeeRate {
+				return minFeeRate
+			}
+
+			return bin.FeeRate
+		}
+	}
+
+	return minFeeRate
+}
@@ -0,0 +1,66 @@
+package electrum
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+func TestProjectCutoff(t *testing.T) {
+	// arrange
+	histogram := []HistogramBin{
+		{FeeRate: 50, Vsize: 400000},
+		{FeeRate: 20, Vsize: 700000},
+		{FeeRate: 5, Vsize: 1500000},
+		{FeeRate: 0.5, Vsize: 2000000},
+	}
+
+	// act
+	first := projectCutoff(histogram, 1, 1)
+	second := projectCutoff(histogram, 2, 1)
+	third := projectCutoff(histogram, 3, 1)
+	cleared := projectCutoff(histogram, 10, 1)
+
+	// assert
+	assert.Equal(t, 20.0, first)
+	assert.Equal(t, 5.0, second)
+	assert.Equal(t, 1.0, third, "rates below the relay fee are raised to it")
+	assert.Equal(t, 1.0, cleared, "the mempool clears within the target")
+}
+
+// fakeElectrum answers estimatefee with fee and relayfee with relayFee, both
+// in BTC/kB
+type fakeElectrum struct {
+	jsonrpc.RPCClient
+	fee      float64
+	relayFee float64
+}
+
+func (f fakeElectrum) CallFor(out interface{}, method string, params ...interface{}) error {
+	switch method {
+	case "blockchain.estimatefee":
+		*out.(*float64) = f.fee
+	case "blockchain.relayfee":
+		*out.(*float64) = f.relayFee
+	}
+
+	return nil
+}
+
+func TestServerRatesAreFlooredAtRelayFee(t *testing.T) {
+	// arrange
+	estimating := &Estimator{electrum: fakeElectrum{fee: 0.0002, relayFee: 0.00001}}
+	unable := &Estimator{electrum: fakeElectrum{fee: -1, relayFee: 0.00001}}
+
+	// act
+	rates, err := estimating.ServerRates()
+	require.NoError(t, err)
+	floored, err := unable.ServerRates()
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, Rates{Economical: 20, Standard: 20, Fast: 20, MinFeeRate: 1}, *rates)
+	assert.Equal(t, Rates{Economical: 1, Standard: 1, Fast: 1, MinFeeRate: 1}, *floored)
+}
@@ -0,0 +1,62 @@
+package feerate
+
+import (
+	"sync"
+)
+
+// eventBuffer is the number of events queued per subscriber. Events for a
+// subscriber that falls behind further are dropped.
+const eventBuffer = 16
+
+// Event announces that new data is available for a height: a new mempool
+// snapshot on the channels of MempoolCache.Subscribe, new block rates on the
+// ones of RateCache.Subscribe
+type Event struct {
+	Height int32
+}
+
+// subscribers fans out events to the channels returned by subscribe
+type subscribers struct {
+	channels map[chan Event]struct{}
+	mu       sync.Mutex
+}
+
+// subscribe returns a channel receiving the published events and a function
+// that unsubscribes and closes it
+func (s *subscribers) subscribe() (<-chan Event, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.channels == nil {
+		s.channels = make(map[chan Event]struct{})
+	}
+
+	events := make(chan Event, eventBuffer)
+	s.channels[events] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			delete(s.channels, events)
+			close(events)
+		})
+	}
+
+	return events, unsubscribe
+}
+
+// publish sends event to all subscribers without blocking on slow ones
+func (s *subscribers) publish(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for events := range s.channels {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribersReceivePublishedEvents(t *testing.T) {
+	// arrange
+	var s subscribers
+	first, unsubscribeFirst := s.subscribe()
+	second, unsubscribeSecond := s.subscribe()
+	defer unsubscribeSecond()
+
+	// act
+	s.publish(Event{Height: 100})
+	unsubscribeFirst()
+	s.publish(Event{Height: 101})
+
+	// assert
+	assert.Equal(t, Event{Height: 100}, <-first)
+	_, open := <-first
+	assert.False(t, open)
+	assert.Equal(t, Event{Height: 100}, <-second)
+	assert.Equal(t, Event{Height: 101}, <-second)
+}
+
+func TestSubscribersDropEventsForSlowSubscribers(t *testing.T) {
+	// arrange
+	var s subscribers
+	events, unsubscribe := s.subscribe()
+
+	// act
+	for height := int32(0); height < eventBuffer+5; height++ {
+		s.publish(Event{Height: height})
+	}
+	unsubscribe()
+	unsubscribe()
+
+	// assert
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, eventBuffer, received)
+}
@@ -0,0 +1,163 @@
+package feerate
+
+import (
+	"sort"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+const (
+	// ratesVersion changes whenever the way rates are computed changes,
+	// stored rates of another version are computed again
+	ratesVersion = 2
+
+	// subsidyHalvingInterval is the number of blocks after which the block
+	// subsidy halves
+	subsidyHalvingInterval = 210000
+)
+
+// FeeRatePercentiles are the percentiles FeeRates.Percentiles are taken at,
+// the same as the feerate_percentiles of getblockstats
+var FeeRatePercentiles = []float64{10, 25, 50, 75, 90}
+
+// FeeRates are the fee rates of the txs of a block in satoshi per virtual
+// byte together with block level fee stats
+type FeeRates struct {
+	Version     int       `json:"version"`
+	Height      int32     `json:"height"`
+	Rates       []float64 `json:"rates"`
+	NumberOfTxs int       `json:"numberOfTxs"`
+
+	// ChildRates are the rates of the txs in Rates that spend an output of
+	// another tx in the same block. They were likely paying for their parent
+	// (CPFP), so their own rate overstates what was needed.
+	ChildRates []float64 `json:"childRates"`
+
+	// BlockHash identifies the block the rates are from, so that stored
+	// rates of a block that was reorganized away are not used
+	BlockHash string `json:"blockHash"`
+
+	// TotalFees is the sum of the fees of the txs whose fee is known in
+	// satoshi
+	TotalFees int64 `json:"totalFees"`
+
+	// TotalWeight is the weight of all txs of the block including the
+	// coinbase in WU
+	TotalWeight int64 `json:"totalWeight"`
+
+	// Median is the median of Rates
+	Median float64 `json:"median"`
+
+	// Percentiles are the rates at FeeRatePercentiles weighted by vsize,
+	// i.e. the rates paid for the block space at those percentiles
+	Percentiles []float64 `json:"percentiles"`
+
+	// Subsidy is the newly minted amount the coinbase may claim in satoshi
+	Subsidy int64 `json:"subsidy"`
+}
+
+// txFee is the fee paid by a tx of a block
+type txFee struct {
+	fee   int64 // satoshi
+	vsize int64
+	child bool // spends an output of a tx in the same block
+}
+
+func (t txFee) rate() float64 {
+	return float64(t.fee) / float64(t.vsize)
+}
+
+// newFeeRates computes the rates and stats of the block at height from the
+// fees of its txs. Txs paying no fee count towards the totals but are left
+// out of the rates.
+func newFeeRates(height int32, hash string, numberOfTxs int, weight int64, fees []txFee) *FeeRates {
+	rates := &FeeRates{
+		Version:     ratesVersion,
+		Height:      height,
+		Rates:       make([]float64, 0, len(fees)),
+		NumberOfTxs: numberOfTxs,
+		ChildRates:  make([]float64, 0),
+		BlockHash:   hash,
+		TotalWeight: weight,
+		Subsidy:     blockSubsidy(height),
+	}
+
+	paying := make([]txFee, 0, len(fees))
+	for _, fee := range fees {
+		rates.TotalFees += fee.fee
+		if fee.fee <= 0 || fee.vsize <= 0 {
+			continue
+		}
+
+		paying = append(paying, fee)
+		rates.Rates = append(rates.Rates, fee.rate())
+		if fee.child {
+			rates.ChildRates = append(rates.ChildRates, fee.rate())
+		}
+	}
+
+	rates.Median = median(rates.Rates)
+	rates.Percentiles = weightedPercentiles(paying, FeeRatePercentiles)
+	return rates
+}
+
+// median returns the median of rates without reordering them, 0 if there are
+// none
+func median(rates []float64) float64 {
+	if len(rates) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, rates...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	return sorted[mid]
+}
+
+// weightedPercentiles returns the rates of fees at percentiles weighted by
+// vsize, all 0 if there are no fees
+func weightedPercentiles(fees []txFee, percentiles []float64) []float64 {
+	result := make([]float64, len(percentiles))
+	if len(fees) == 0 {
+		return result
+	}
+
+	sorted := append([]txFee{}, fees...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].rate() < sorted[j].rate()
+	})
+
+	var total int64
+	for _, fee := range sorted {
+		total += fee.vsize
+	}
+
+	for p, percentile := range percentiles {
+		threshold := float64(total) * percentile / 100
+		var cumulative int64
+		result[p] = sorted[len(sorted)-1].rate()
+		for _, fee := range sorted {
+			cumulative += fee.vsize
+			if float64(cumulative) >= threshold {
+				result[p] = fee.rate()
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// blockSubsidy returns the subsidy of the block at height in satoshi
+func blockSubsidy(height int32) int64 {
+	halvings := uint(height / subsidyHalvingInterval)
+	if halvings >= 64 {
+		return 0
+	}
+
+	return int64(50*utils.BTC) >> halvings
+}
@@ -0,0 +1,37 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFeeRates(t *testing.T) {
+	// arrange
+	fees := []txFee{
+		{fee: 1000, vsize: 100},              // 10 sat/vB
+		{fee: 200, vsize: 100},               // 2 sat/vB
+		{fee: 4000, vsize: 200, child: true}, // 20 sat/vB
+		{fee: 0, vsize: 100},
+	}
+
+	// act
+	rates := newFeeRates(420000, "00ab", 5, 2400, fees)
+
+	// assert
+	assert.Equal(t, []float64{10, 2, 20}, rates.Rates)
+	assert.Equal(t, []float64{20}, rates.ChildRates)
+	assert.Equal(t, int64(5200), rates.TotalFees)
+	assert.Equal(t, int64(2400), rates.TotalWeight)
+	assert.Equal(t, float64(10), rates.Median)
+	assert.Equal(t, []float64{2, 2, 10, 20, 20}, rates.Percentiles)
+	assert.Equal(t, int64(12.5*1e8), rates.Subsidy)
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	// act & assert
+	assert.Equal(t, int64(50*1e8), blockSubsidy(0))
+	assert.Equal(t, int64(25*1e8), blockSubsidy(210000))
+	assert.Equal(t, int64(3.125*1e8), blockSubsidy(840000))
+	assert.Equal(t, int64(0), blockSubsidy(64*210000))
+}
@@ -1,15 +1,11 @@
 package feerate
 
 import (
-	"encoding/csv"
 	"errors"
-	"fmt"
 	"os"
-	"strconv"
 	"sync"
 	"time"
 
-	"github.com/btcsuite/btcd/btcjson"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -17,27 +13,108 @@ import (
 // MempoolCache caches the mempool for a given block height
 type MempoolCache struct {
 	client             *utils.CachedRPCClient
-	mempoolCache       map[int32]map[string]btcjson.GetRawMempoolVerboseResult
+	mempoolCache       map[int32]map[string]utils.MempoolEntry
 	logger             *zap.Logger
 	lastRecordedHeight int32
+	dir                string // where snapshots are persisted
+	retention          int32  // number of most recent heights kept, 0 keeps all
+
+	// lastPool is the previous raw mempool snapshot, evicted holds the txs
+	// that left the mempool without being mined by the height they left at
+	lastPool map[string]utils.MempoolEntry
+	evicted  map[int32]map[string]utils.MempoolEntry
+
+	// seen records when the cache first saw a tx and the last height it
+	// was in the mempool at
+	seen map[string]*seenTx
+
+	subscribers subscribers
 
 	mu sync.Mutex
 }
 
 func NewMempoolCache(logger *zap.Logger, client *utils.CachedRPCClient) *MempoolCache {
+	return NewMempoolCacheWithDir(logger, client, DefaultMempoolDir)
+}
+
+// NewMempoolCacheWithDir returns a mempool cache persisting its snapshots in
+// dir
+func NewMempoolCacheWithDir(logger *zap.Logger, client *utils.CachedRPCClient, dir string) *MempoolCache {
 	return &MempoolCache{
 		client:       client,
 		logger:       logger,
-		mempoolCache: make(map[int32]map[string]btcjson.GetRawMempoolVerboseResult),
+		dir:          dir,
+		retention:    int32(DefaultMempoolRetention),
+		mempoolCache: make(map[int32]map[string]utils.MempoolEntry),
+		evicted:      make(map[int32]map[string]utils.MempoolEntry),
+		seen:         make(map[string]*seenTx),
 		mu:           sync.Mutex{},
 	}
 }
 
 var (
 	ErrCacheNotExists = errors.New("cache does not exist")
+
+	// DefaultMempoolRetention is the number of most recent heights whose
+	// mempool a MempoolCache keeps by default
+	DefaultMempoolRetention = 50
 )
 
-func (c *MempoolCache) GetCacheAt(height int32) (map[string]btcjson.GetRawMempoolVerboseResult, error) {
+// SetRetention sets the number of most recent heights whose mempool is kept,
+// 0 keeps all heights. Older heights are dropped right away.
+func (c *MempoolCache) SetRetention(heights int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.retention = int32(heights)
+	c.prune()
+}
+
+// prune drops the heights that are older than the retention allows together
+// with their snapshot files. The caller must hold mu.
+func (c *MempoolCache) prune() {
+	if c.retention <= 0 {
+		return
+	}
+
+	oldest := c.lastRecordedHeight - c.retention + 1
+	dropped := 0
+	for height := range c.mempoolCache {
+		if height < oldest {
+			delete(c.mempoolCache, height)
+			delete(c.evicted, height)
+			c.removeSnapshot(height)
+			dropped++
+		}
+	}
+	for height := range c.evicted {
+		if height < oldest {
+			delete(c.evicted, height)
+		}
+	}
+	for hash, seen := range c.seen {
+		if seen.lastHeight < oldest {
+			delete(c.seen, hash)
+		}
+	}
+
+	if dropped > 0 {
+		c.logger.Info("dropped old mempool snapshots", zap.Int("snapshots", dropped), zap.Int32("oldest kept", oldest))
+	}
+}
+
+// removeSnapshot deletes the persisted snapshot of height, so that it is not
+// loaded again after a restart
+func (c *MempoolCache) removeSnapshot(height int32) {
+	err := os.Remove(c.snapshotFileName(height))
+	if err != nil && !os.IsNotExist(err) {
+		c.logger.Error("could not remove mempool snapshot", zap.Int32("height", height), zap.Error(err))
+	}
+}
+
+// GetCacheAt returns a copy of the mempool recorded while the chain tip was
+// at height, the cache keeps adding txs to the one of the current height
+func (c *MempoolCache) GetCacheAt(height int32) (map[string]utils.MempoolEntry, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -51,54 +128,122 @@ func (c *MempoolCache) GetCacheAt(height int32) (map[string]btcjson.GetRawMempoo
 	}
 
 	c.logger.Info("using cached mempool", zap.Any("unconfirmed txs", len(cachedPool)), zap.Any("height", height))
-	return cachedPool, nil
+	return copyPool(cachedPool), nil
 }
 
-func (c *MempoolCache) Run() error {
-	ticker := time.NewTicker(time.Second * 30)
-	defer ticker.Stop()
+// Evicted returns the txs that left the mempool without being mined while the
+// chain tip was at height, e.g. because they were replaced, expired or
+// evicted from a full mempool
+func (c *MempoolCache) Evicted(height int32) (map[string]utils.MempoolEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	errorChannel := make(chan error)
-	go func() {
-		err := c.run()
-		if err != nil {
-			errorChannel <- err
-		}
-		for {
-			select {
-			case <-ticker.C:
-				err := c.run()
-				if err != nil {
-					errorChannel <- err
-				}
-			}
-		}
-	}()
+	if _, ok := c.mempoolCache[height]; !ok {
+		return nil, ErrCacheNotExists
+	}
 
-	return <-errorChannel
+	return copyPool(c.evicted[height]), nil
 }
 
-func (c *MempoolCache) run() error {
+// Subscribe returns a channel receiving an event whenever the cache records
+// the mempool at a new height, and a function that unsubscribes from it
+func (c *MempoolCache) Subscribe() (<-chan Event, func()) {
+	return c.subscribers.subscribe()
+}
+
+type seenTx struct {
+	first      time.Time
+	lastHeight int32
+}
+
+// FirstSeen returns when the cache first saw the tx with the given hash in
+// the mempool. Txs are forgotten together with the last height they were in
+// the mempool at.
+func (c *MempoolCache) FirstSeen(hash string) (time.Time, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	//TODO use websockets in the future https://github.com/btcsuite/btcd/blob/master/rpcclient/examples/btcdwebsockets/main.go
+	seen, ok := c.seen[hash]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return seen.first, true
+}
+
+// Age returns for how long the tx with the given hash has been known to the
+// cache at now
+func (c *MempoolCache) Age(hash string, now time.Time) (time.Duration, bool) {
+	first, ok := c.FirstSeen(hash)
+	if !ok {
+		return 0, false
+	}
+
+	return now.Sub(first), true
+}
+
+// recordSeen records the txs of pool as seen at height and now. The caller
+// must hold mu.
+func (c *MempoolCache) recordSeen(height int32, pool map[string]utils.MempoolEntry, now time.Time) {
+	for hash := range pool {
+		seen, ok := c.seen[hash]
+		if !ok {
+			c.seen[hash] = &seenTx{first: now, lastHeight: height}
+			continue
+		}
+
+		seen.lastHeight = height
+	}
+}
+
+// Run polls the mempool every 30 seconds and records it at the current
+// height. A failed poll is logged and tried again with the next one.
+func (c *MempoolCache) Run() {
+	ticker := time.NewTicker(time.Second * 30)
+	defer ticker.Stop()
+
+	c.poll()
+	for range ticker.C {
+		c.poll()
+	}
+}
+
+// poll records the current mempool, errors are only logged as the node may
+// be back by the next poll
+func (c *MempoolCache) poll() {
+	err := c.run()
+	if err != nil {
+		c.logger.Error("could not update mempool cache", zap.Error(err))
+	}
+}
+
+func (c *MempoolCache) run() error {
 	info, err := c.client.GetBlockChainInfo()
 	if err != nil {
-		c.logger.Error("could not get blockchain info", zap.Error(err), zap.Any("height", info.Blocks))
 		return err
 	}
 
-	pool, err := c.client.GetRawMempoolVerbose()
+	pool, err := c.client.GetRawMempoolEntries()
 	if err != nil {
-		c.logger.Error("could not get raw mempool", zap.Error(err), zap.Any("height", info.Blocks))
 		return err
 	}
 	c.logger.Info("updating mempool cache", zap.Any("unconfirmed txs", len(pool)), zap.Any("height", info.Blocks))
+
+	mined, err := c.minedSince(info.Blocks)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recordEvicted(info.Blocks, pool, mined)
 	c.lastRecordedHeight = info.Blocks
+	c.recordSeen(info.Blocks, pool, time.Now())
 	_, ok := c.mempoolCache[info.Blocks]
 	if !ok { //new block
 		c.mempoolCache[info.Blocks] = pool
+		c.subscribers.publish(Event{Height: info.Blocks})
 	} else { //only add new txs
 		for hash, memTx := range pool {
 			_, ok := c.mempoolCache[info.Blocks][hash]
@@ -108,41 +253,95 @@ func (c *MempoolCache) run() error {
 		}
 	}
 
+	c.prune()
 	return c.flush(info.Blocks)
 }
 
-func (c *MempoolCache) flush(bestHeight int32) error {
-	fileName := fmt.Sprintf("mempoolcache%v.csv", bestHeight)
-	f, err := os.OpenFile("./output/mempool/"+fileName, os.O_CREATE|os.O_RDWR, 0660)
-	if err != nil {
-		return err
+// minedSince returns the txs mined after the last recorded height up to
+// height. It returns nil if there is no previous snapshot or more than one
+// block was mined since, e.g. after a restart: the txs that left the mempool
+// in between cannot be told apart then, and fetching every missed block is
+// not worth it.
+func (c *MempoolCache) minedSince(height int32) (map[string]struct{}, error) {
+	c.mu.Lock()
+	last := c.lastRecordedHeight
+	recorded := c.lastPool != nil
+	c.mu.Unlock()
+
+	if !recorded {
+		return nil, nil
 	}
-	defer f.Close()
 
-	w := csv.NewWriter(f)
-	cols := []string{
-		"block_number",
-		"rates",
+	if height-last > 1 {
+		c.logger.Info("blocks were missed, not recording evicted txs", zap.Int32("last recorded", last), zap.Int32("height", height))
+		return nil, nil
+	}
+
+	return c.minedTxs(last+1, height)
+}
+
+// recordEvicted records the txs of the previous snapshot that are neither in
+// pool nor mined as evicted at height. Nothing is recorded if mined is nil.
+// The caller must hold mu.
+func (c *MempoolCache) recordEvicted(height int32, pool map[string]utils.MempoolEntry, mined map[string]struct{}) {
+	previous := c.lastPool
+	c.lastPool = pool
+	if previous == nil || mined == nil {
+		return
 	}
 
-	var records [][]string
-	for height, pool := range c.mempoolCache {
-		record := []string{
-			strconv.Itoa(int(height)),
+	evicted := evictedTxs(previous, pool, mined)
+	if len(evicted) == 0 {
+		return
+	}
+
+	if c.evicted[height] == nil {
+		c.evicted[height] = make(map[string]utils.MempoolEntry)
+	}
+	for hash, entry := range evicted {
+		c.evicted[height][hash] = entry
+	}
+
+	c.logger.Info("txs left the mempool unconfirmed", zap.Int("count", len(evicted)), zap.Int32("height", height))
+}
+
+// minedTxs returns the hashes of the txs mined in the blocks from height from
+// up to height to
+func (c *MempoolCache) minedTxs(from int32, to int32) (map[string]struct{}, error) {
+	mined := make(map[string]struct{})
+	for h := from; h <= to; h++ {
+		hash, err := c.client.GetBlockHash(int64(h))
+		if err != nil {
+			return nil, err
 		}
-		for _, entry := range pool {
-			feeInSatoshi := int64(entry.Fee * utils.BTC)
-			ratePerByte := (float64(feeInSatoshi) / float64(entry.Size))
-			record = append(record, strconv.FormatFloat(ratePerByte, 'f', 3, 64))
+
+		block, err := c.client.GetBlock(hash)
+		if err != nil {
+			return nil, err
 		}
 
-		records = append(records, record)
+		for _, tx := range block.Transactions {
+			mined[tx.TxHash().String()] = struct{}{}
+		}
 	}
 
-	err = w.Write(cols)
-	if err != nil {
-		return err
+	return mined, nil
+}
+
+// evictedTxs returns the txs of previous that are neither in current nor
+// mined
+func evictedTxs(previous map[string]utils.MempoolEntry, current map[string]utils.MempoolEntry, mined map[string]struct{}) map[string]utils.MempoolEntry {
+	evicted := make(map[string]utils.MempoolEntry)
+	for hash, entry := range previous {
+		if _, ok := current[hash]; ok {
+			continue
+		}
+		if _, ok := mined[hash]; ok {
+			continue
+		}
+
+		evicted[hash] = entry
 	}
 
-	return w.WriteAll(records)
+	return evicted
 }
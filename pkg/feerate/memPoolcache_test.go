@@ -0,0 +1,208 @@
+package feerate
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestEvictedTxsIgnoresMinedAndRemainingTxs(t *testing.T) {
+	// arrange
+	previous := map[string]utils.MempoolEntry{
+		"mined":    {Fee: 0.0001},
+		"evicted":  {Fee: 0.0002},
+		"remained": {Fee: 0.0003},
+	}
+	current := map[string]utils.MempoolEntry{
+		"remained": {Fee: 0.0003},
+		"new":      {Fee: 0.0004},
+	}
+	mined := map[string]struct{}{"mined": {}}
+
+	// act
+	evicted := evictedTxs(previous, current, mined)
+
+	// assert
+	assert.Len(t, evicted, 1)
+	assert.Contains(t, evicted, "evicted")
+}
+
+func TestMempoolCacheSkipsEvictedAfterMissedBlocks(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	c.lastRecordedHeight = 100
+	c.lastPool = map[string]utils.MempoolEntry{"stale": {Size: 250}}
+	pool := map[string]utils.MempoolEntry{"new": {Size: 300}}
+
+	// act
+	mined, err := c.minedSince(105)
+	c.recordEvicted(105, pool, mined)
+
+	// assert
+	require.NoError(t, err)
+	assert.Nil(t, mined)
+	assert.Empty(t, c.evicted)
+	assert.Equal(t, pool, c.lastPool)
+}
+
+func TestMempoolCacheSnapshotsSurviveRestart(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "mempool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, dir)
+	c.mempoolCache[100] = map[string]utils.MempoolEntry{"a": {Size: 250, Fee: 0.0001, AncestorFees: 10000, BIP125Replaceable: "yes"}}
+	c.mempoolCache[101] = map[string]utils.MempoolEntry{"b": {Size: 300, Fee: 0.0002}}
+	c.evicted[101] = map[string]utils.MempoolEntry{"c": {Size: 200, Fee: 0.00001}}
+	first := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.recordSeen(100, c.mempoolCache[100], first)
+	require.NoError(t, c.flush(100))
+	require.NoError(t, c.flush(101))
+
+	// act
+	restored := NewMempoolCacheWithDir(zap.NewNop(), nil, dir)
+	err = restored.LoadFromDisk()
+
+	// assert
+	require.NoError(t, err)
+	pool, err := restored.GetCacheAt(100)
+	require.NoError(t, err)
+	assert.Equal(t, c.mempoolCache[100], pool)
+	evicted, err := restored.Evicted(101)
+	require.NoError(t, err)
+	assert.Equal(t, c.evicted[101], evicted)
+	assert.Equal(t, int32(101), restored.lastRecordedHeight)
+	seen, ok := restored.FirstSeen("a")
+	assert.True(t, ok)
+	assert.True(t, first.Equal(seen))
+}
+
+func TestMempoolCacheLoadFromMissingDir(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "./does-not-exist")
+
+	// act & assert
+	assert.NoError(t, c.LoadFromDisk())
+}
+
+func TestMempoolCacheRetention(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	for height := int32(100); height <= 110; height++ {
+		c.mempoolCache[height] = map[string]utils.MempoolEntry{"tx": {Size: 250}}
+	}
+	c.evicted[100] = map[string]utils.MempoolEntry{"evicted": {Size: 250}}
+	c.lastRecordedHeight = 110
+
+	// act
+	c.SetRetention(5)
+	stats := c.Stats()
+
+	// assert
+	assert.Equal(t, 5, stats.Snapshots)
+	assert.Equal(t, int32(106), stats.OldestHeight)
+	assert.Equal(t, int32(110), stats.NewestHeight)
+	assert.Equal(t, 5, stats.Txs)
+	assert.Equal(t, 0, stats.EvictedTxs)
+	assert.True(t, stats.Bytes > 0)
+}
+
+func TestMempoolCacheRetentionRemovesSnapshots(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "mempool")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, dir)
+	for height := int32(100); height <= 110; height++ {
+		c.mempoolCache[height] = map[string]utils.MempoolEntry{"tx": {Size: 250}}
+		require.NoError(t, c.flush(height))
+	}
+
+	// act
+	restored := NewMempoolCacheWithDir(zap.NewNop(), nil, dir)
+	restored.retention = 5
+	err = restored.LoadFromDisk()
+
+	// assert
+	require.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 5)
+	_, err = os.Stat(c.snapshotFileName(105))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(c.snapshotFileName(106))
+	assert.NoError(t, err)
+}
+
+func TestMempoolCacheKeepsFirstSeenTime(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	first := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	pool := map[string]utils.MempoolEntry{"tx": {Size: 250}}
+
+	// act
+	c.recordSeen(100, pool, first)
+	c.recordSeen(101, pool, first.Add(10*time.Minute))
+	seen, ok := c.FirstSeen("tx")
+	age, _ := c.Age("tx", first.Add(15*time.Minute))
+	_, unknown := c.FirstSeen("other")
+
+	// assert
+	assert.True(t, ok)
+	assert.Equal(t, first, seen)
+	assert.Equal(t, 15*time.Minute, age)
+	assert.False(t, unknown)
+}
+
+func TestMempoolCacheAddsNotifiedTxs(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.addEntry("early", utils.MempoolEntry{Size: 200}, now)
+	c.lastRecordedHeight = 100
+	c.mempoolCache[100] = map[string]utils.MempoolEntry{"polled": {Size: 250}}
+	c.lastPool = map[string]utils.MempoolEntry{"polled": {Size: 250}}
+
+	// act
+	c.addEntry("notified", utils.MempoolEntry{Size: 300}, now)
+
+	// assert
+	pool, err := c.GetCacheAt(100)
+	require.NoError(t, err)
+	assert.Len(t, pool, 2)
+	assert.Equal(t, int32(300), pool["notified"].Size)
+	assert.Contains(t, c.lastPool, "notified")
+	_, ok := c.FirstSeen("notified")
+	assert.True(t, ok)
+	_, ok = c.FirstSeen("early")
+	assert.False(t, ok)
+}
+
+func TestMempoolCacheGetCacheAtReturnsCopy(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.lastRecordedHeight = 100
+	c.mempoolCache[100] = map[string]utils.MempoolEntry{"polled": {Size: 250}}
+	c.lastPool = map[string]utils.MempoolEntry{"polled": {Size: 250}}
+	pool, err := c.GetCacheAt(100)
+	require.NoError(t, err)
+
+	// act
+	c.addEntry("notified", utils.MempoolEntry{Size: 300}, now)
+	delete(pool, "polled")
+
+	// assert
+	assert.Empty(t, pool)
+	current, err := c.GetCacheAt(100)
+	require.NoError(t, err)
+	assert.Len(t, current, 2)
+}
@@ -0,0 +1,166 @@
+package mempool
+
+import (
+	"math"
+	"time"
+)
+
+var (
+	// ArrivalWindow is the number of recent blocks the mean block interval
+	// is estimated from
+	ArrivalWindow = 12
+
+	// DefaultBlockInterval is the mean block interval if it cannot be
+	// estimated from the recent blocks
+	DefaultBlockInterval = time.Minute * 10
+
+	// DeadlineConfidence is the probability with which at least the number
+	// of blocks a deadline is converted into are found before it passes
+	DeadlineConfidence = 0.9
+
+	// MaxDeadlineTarget bounds the number of blocks a deadline is converted
+	// into, one week of blocks like the highest target of bitcoin core
+	MaxDeadlineTarget = 1008
+
+	// minBlockInterval bounds the estimated interval from below, block
+	// timestamps are only roughly ordered
+	minBlockInterval = time.Minute
+)
+
+// BlockArrival models block arrivals as a Poisson process whose mean interval
+// is estimated from the timestamps of recent blocks
+type BlockArrival struct {
+	// MeanInterval is the expected time between two blocks
+	MeanInterval time.Duration
+
+	// Elapsed is the time since the last block
+	Elapsed time.Duration
+
+	// Progress is the probability that a block would have been found within
+	// Elapsed, 1-exp(-Elapsed/MeanInterval). It replaces the linear
+	// Elapsed/10min and approaches 1 instead of being cut off.
+	Progress float64
+
+	// ExpectedRemaining is the expected time until the next block. The
+	// process is memoryless, so it equals MeanInterval regardless of Elapsed.
+	ExpectedRemaining time.Duration
+}
+
+// newBlockArrival creates the model from the timestamps of the most recent
+// blocks in ascending height order, the last one being the current tip
+func newBlockArrival(timestamps []time.Time, now time.Time) *BlockArrival {
+	interval := DefaultBlockInterval
+	if n := len(timestamps); n >= 2 {
+		interval = timestamps[n-1].Sub(timestamps[0]) / time.Duration(n-1)
+		if interval < minBlockInterval {
+			interval = minBlockInterval
+		}
+	}
+
+	var elapsed time.Duration
+	if len(timestamps) > 0 {
+		elapsed = now.Sub(timestamps[len(timestamps)-1])
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return &BlockArrival{
+		MeanInterval:      interval,
+		Elapsed:           elapsed,
+		Progress:          1 - math.Exp(-float64(elapsed)/float64(interval)),
+		ExpectedRemaining: interval,
+	}
+}
+
+// blocksWithin returns the number of blocks that are found within d with a
+// probability of at least confidence. Block arrivals are a Poisson process,
+// so the number of blocks within d is Poisson distributed with mean
+// d/MeanInterval. The result is at most MaxDeadlineTarget.
+func (a *BlockArrival) blocksWithin(d time.Duration, confidence float64) int {
+	if d <= 0 {
+		return 0
+	}
+
+	mean := float64(d) / float64(a.MeanInterval)
+	logMean := math.Log(mean)
+	fewer := 0.0 // P(N<n)
+	n := 0
+	for n < MaxDeadlineTarget {
+		// P(N=n) in log space, exp(-mean) underflows for long deadlines
+		logFactorial, _ := math.Lgamma(float64(n + 1))
+		term := math.Exp(-mean + float64(n)*logMean - logFactorial)
+		if 1-(fewer+term) < confidence {
+			break
+		}
+		fewer += term
+		n++
+	}
+
+	return n
+}
+
+// fetchBlockTimes returns the timestamps of the ArrivalWindow+1 blocks up to
+// height in ascending order, together with the ones below the tip that were
+// not cached yet, see cacheBlockTimes. Timestamps below the tip are cached, a
+// reorg barely affects the mean. The tip is always fetched as it determines
+// the elapsed time. mu must not be held, it is only taken to look up the
+// cached timestamps.
+func (e *Estimator) fetchBlockTimes(height int32) ([]time.Time, map[int32]time.Time, error) {
+	from := recentBlocksFrom(height)
+
+	e.mu.Lock()
+	cached := make(map[int32]time.Time, height-from)
+	for h := from; h < height; h++ {
+		if timestamp, ok := e.blockTimes[h]; ok {
+			cached[h] = timestamp
+		}
+	}
+	e.mu.Unlock()
+
+	timestamps := make([]time.Time, 0, height-from+1)
+	fetched := make(map[int32]time.Time)
+	for h := from; h <= height; h++ {
+		timestamp, ok := cached[h]
+		if !ok {
+			var err error
+			timestamp, err = e.getLastMinedTime(h)
+			if err != nil {
+				return nil, nil, err
+			}
+			if h < height {
+				fetched[h] = timestamp
+			}
+		}
+
+		timestamps = append(timestamps, timestamp)
+	}
+
+	return timestamps, fetched, nil
+}
+
+// cacheBlockTimes caches the timestamps fetched for the blocks below height
+// and forgets the ones that left the window. The caller must hold mu.
+func (e *Estimator) cacheBlockTimes(height int32, fetched map[int32]time.Time) {
+	for h, timestamp := range fetched {
+		e.blockTimes[h] = timestamp
+	}
+
+	from := recentBlocksFrom(height)
+	for h := range e.blockTimes {
+		if h < from {
+			delete(e.blockTimes, h)
+		}
+	}
+}
+
+// recentBlocksFrom returns the lowest height of the blocks the mean block
+// interval at height is estimated from
+func recentBlocksFrom(height int32) int32 {
+	from := height - int32(ArrivalWindow)
+	if from < 0 {
+		from = 0
+	}
+
+	return from
+}
@@ -0,0 +1,63 @@
+package mempool
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockArrivalEstimatesMeanInterval(t *testing.T) {
+	// arrange
+	start := time.Unix(1500000000, 0)
+	timestamps := []time.Time{start, start.Add(4 * time.Minute), start.Add(8 * time.Minute), start.Add(15 * time.Minute)}
+
+	// act
+	arrival := newBlockArrival(timestamps, start.Add(20*time.Minute))
+
+	// assert
+	assert.Equal(t, 5*time.Minute, arrival.MeanInterval)
+	assert.Equal(t, 5*time.Minute, arrival.Elapsed)
+	assert.Equal(t, 5*time.Minute, arrival.ExpectedRemaining)
+	assert.InDelta(t, 1-math.Exp(-1), arrival.Progress, 1e-9)
+}
+
+func TestBlockArrivalFallsBackToDefaultInterval(t *testing.T) {
+	// arrange
+	tip := time.Unix(1500000000, 0)
+
+	// act
+	arrival := newBlockArrival([]time.Time{tip}, tip.Add(-time.Minute))
+
+	// assert: the tip may be ahead of the local clock
+	assert.Equal(t, DefaultBlockInterval, arrival.MeanInterval)
+	assert.Equal(t, time.Duration(0), arrival.Elapsed)
+	assert.Equal(t, 0.0, arrival.Progress)
+}
+
+func TestBlocksWithinDeadline(t *testing.T) {
+	// arrange
+	arrival := &BlockArrival{MeanInterval: 10 * time.Minute}
+
+	// act & assert
+	assert.Equal(t, 0, arrival.blocksWithin(0, 0.9))
+	assert.Equal(t, 0, arrival.blocksWithin(10*time.Minute, 0.9), "P(N>=1) is only 63%")
+	assert.Equal(t, 1, arrival.blocksWithin(10*time.Minute, 0.6))
+	// Poisson(6): P(N>=3) = 0.938, P(N>=4) = 0.849
+	assert.Equal(t, 3, arrival.blocksWithin(time.Hour, 0.9))
+	assert.Equal(t, 6, arrival.blocksWithin(time.Hour, 0.5), "the median of Poisson(6) is 6")
+}
+
+func TestBlocksWithinLongDeadline(t *testing.T) {
+	// arrange
+	arrival := &BlockArrival{MeanInterval: 10 * time.Minute}
+
+	// act
+	week := arrival.blocksWithin(7*24*time.Hour, 0.9)
+	month := arrival.blocksWithin(30*24*time.Hour, 0.9)
+
+	// assert, Poisson(1008) is about normal with a standard deviation of 31.7
+	assert.InDelta(t, 1008-1.28*31.7, week, 2)
+	assert.Equal(t, MaxDeadlineTarget, month)
+}
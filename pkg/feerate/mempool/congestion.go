@@ -0,0 +1,76 @@
+package mempool
+
+import (
+	"time"
+)
+
+// Congestion describes how congested the mempool is
+type Congestion struct {
+	Height int32 `json:"height"`
+
+	// TotalVsize is the vsize of the txs waiting to be mined
+	TotalVsize int64 `json:"totalVsize"`
+
+	// TxCount is the number of txs waiting to be mined
+	TxCount int `json:"txCount"`
+
+	// Bands split the txs by fee rate, see InflowBands
+	Bands []CongestionBand `json:"bands"`
+
+	// BacklogMinutes is how long it takes to mine all txs at the current
+	// throughput, i.e. the average block weight and block interval, if no
+	// more txs arrived. It is 0 if there is no throughput to measure.
+	BacklogMinutes float64 `json:"backlogMinutes"`
+}
+
+// CongestionBand is the part of the mempool paying a fee rate of at least
+// MinFeeRate (satoshi per virtual byte) and less than the next band
+type CongestionBand struct {
+	MinFeeRate float64 `json:"minFeeRate"`
+	Vsize      int64   `json:"vsize"`
+	TxCount    int     `json:"txCount"`
+}
+
+// Congestion returns the congestion of the current mempool. Stuck txs and
+// txs below the node's minimum fee rate are left out like in the estimation.
+func (e *Estimator) Congestion() (*Congestion, error) {
+	fetched, err := e.fetchPool()
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.loadPool(fetched)
+
+	txs := filterPoolTxs(state.txs, time.Now(), MaxTxAge, state.minFeeRate)
+	return newCongestion(state.height, txs, state.blockWeight, state.arrival.MeanInterval), nil
+}
+
+// newCongestion measures the congestion of txs. Blocks with capacity weight
+// available to txs are expected every meanInterval.
+func newCongestion(height int32, txs []poolTx, capacity int64, meanInterval time.Duration) *Congestion {
+	c := &Congestion{
+		Height:  height,
+		TxCount: len(txs),
+		Bands:   make([]CongestionBand, len(InflowBands)),
+	}
+	for i, lower := range InflowBands {
+		c.Bands[i].MinFeeRate = lower
+	}
+
+	for _, tx := range txs {
+		c.TotalVsize += tx.vsize
+		b := band(tx.feeRate)
+		c.Bands[b].Vsize += tx.vsize
+		c.Bands[b].TxCount++
+	}
+
+	throughput := float64(blockVsize(capacity)) / meanInterval.Minutes() // vsize per minute
+	if throughput > 0 {
+		c.BacklogMinutes = float64(c.TotalVsize) / throughput
+	}
+
+	return c
+}
@@ -0,0 +1,31 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCongestion(t *testing.T) {
+	// arrange: one and a half blocks of txs
+	half := blockVsize(maxTxWeight) / 2
+	txs := []poolTx{
+		{vsize: half, feeRate: 25},
+		{vsize: half, feeRate: 21},
+		{vsize: half, feeRate: 1.5},
+	}
+
+	// act
+	congestion := newCongestion(100, txs, maxTxWeight, 10*time.Minute)
+	empty := newCongestion(100, nil, 0, 10*time.Minute)
+
+	// assert
+	assert.Equal(t, int32(100), congestion.Height)
+	assert.Equal(t, 3*half, congestion.TotalVsize)
+	assert.Equal(t, 3, congestion.TxCount)
+	assert.Equal(t, CongestionBand{MinFeeRate: 20, Vsize: 2 * half, TxCount: 2}, congestion.Bands[band(20)])
+	assert.Equal(t, CongestionBand{MinFeeRate: 1, Vsize: half, TxCount: 1}, congestion.Bands[0])
+	assert.InDelta(t, 15.0, congestion.BacklogMinutes, 1e-9)
+	assert.Equal(t, 0.0, empty.BacklogMinutes)
+}
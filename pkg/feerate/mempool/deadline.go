@@ -0,0 +1,53 @@
+package mempool
+
+import (
+	"time"
+)
+
+// Deadline is the preset reported for estimates of EstimateForDeadline, its
+// target is set to the number of blocks the deadline is converted into
+var Deadline = Preset{Name: "deadline"}
+
+// EstimateForDeadline estimates the fee rate for a tx that should confirm
+// within d. The deadline is converted into the number of blocks that are
+// found within it with a probability of DeadlineConfidence and the rate at
+// the lower boundary of that projected block is returned, including the
+// inflow expected until the deadline. Deadlines too short to expect a block
+// within them are estimated for the next block.
+func (e *Estimator) EstimateForDeadline(d time.Duration) (*Estimate, error) {
+	fetched, err := e.fetchPool()
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.loadPool(fetched)
+
+	target := state.arrival.blocksWithin(d, DeadlineConfidence)
+	if target < 1 {
+		target = 1
+	}
+
+	preset := Deadline
+	preset.Target = target
+
+	txs := filterPoolTxs(state.txs, time.Now(), MaxTxAge, state.minFeeRate)
+	if uncongested(txs, state.blockWeight) {
+		estimate := floorEstimate(preset, state.minFeeRate, len(txs))
+		estimate.Provenance = state.provenance(len(txs), 0)
+		return &estimate, nil
+	}
+
+	provenance := state.provenance(len(txs), e.inflow.expectedVsize(d))
+	txs = e.inflow.withInflow(txs, d)
+	blocks := projectBlocks(txs, target, state.blockWeight)
+	if len(blocks) == 0 {
+		return nil, ErrEmptyMempool
+	}
+
+	estimate := estimatePreset(blocks, preset, state.arrival.Progress)
+	estimate.Provenance = provenance
+	return &estimate, nil
+}
@@ -0,0 +1,305 @@
+package mempool
+
+import (
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+// Estimate is the fee rate estimated for a preset
+type Estimate struct {
+	Preset Preset
+
+	// Rate is the estimated fee rate in satoshi per virtual byte
+	Rate float64
+
+	// Percentile is the percentile the rate was picked at
+	Percentile float64
+
+	// SampleSize is the number of txs in the projected block the rate was
+	// picked from
+	SampleSize int
+
+	// Position is the index of the rate within the projected block, in
+	// ascending order of fee rate
+	Position int
+
+	// Block is the projected block the rate was picked from (1 based). It is
+	// lower than the target if the mempool does not fill target blocks.
+	Block int
+
+	// Uncongested is set if the mempool holds less than a block's worth of
+	// txs. Percentiles of so few txs are noise, so Rate is the lowest rate
+	// the node accepts instead.
+	Uncongested bool
+
+	// Provenance describes the mempool snapshot and model the estimate was
+	// made from
+	Provenance *Provenance
+}
+
+// Provenance records what an estimate was made from
+type Provenance struct {
+	// Height is the height of the chain tip the mempool snapshot belongs to
+	Height int32 `json:"height"`
+
+	// SnapshotTime is when the mempool snapshot was loaded for estimation
+	SnapshotTime time.Time `json:"snapshotTime"`
+
+	// TxCount is the number of mempool txs considered, i.e. after leaving
+	// out replaced, stuck and below minimum fee txs
+	TxCount int `json:"txCount"`
+
+	Model Model `json:"model"`
+}
+
+// Model holds the parameters of the estimation model at the time of an
+// estimate
+type Model struct {
+	MinFeeRate   float64       `json:"minFeeRate"`   // satoshi per virtual byte
+	BlockWeight  int64         `json:"blockWeight"`  // weight available to txs in a projected block
+	MeanInterval time.Duration `json:"meanInterval"` // between blocks
+	Progress     float64       `json:"progress"`     // towards the next block
+	MaxTxAge     time.Duration `json:"maxTxAge"`
+	InflowVsize  int64         `json:"inflowVsize"` // expected inflow added to the projection
+	Replacements bool          `json:"replacements"`
+}
+
+// Result holds the estimates of one estimation round
+type Result struct {
+	Provenance      *Provenance
+	Arrival         *BlockArrival
+	ProjectedBlocks int
+	Estimates       []Estimate
+	Congestion      *Congestion
+}
+
+// Estimate estimates fee rates for opts.Presets, or the presets of the
+// estimator if opts has none, from the current mempool. ErrInvalidOptions is
+// returned if opts do not validate.
+func (e *Estimator) Estimate(opts Options) (*Result, error) {
+	if len(opts.Presets) == 0 {
+		opts = e.options
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	fetched, err := e.fetchPool()
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.loadPool(fetched)
+
+	e.inflow.observe(state.txs, time.Now())
+
+	txs := filterPoolTxs(state.txs, time.Now(), MaxTxAge, state.minFeeRate)
+	result := &Result{
+		Provenance: state.provenance(len(txs), 0),
+		Arrival:    state.arrival,
+		Estimates:  make([]Estimate, len(opts.Presets)),
+		Congestion: newCongestion(state.height, txs, state.blockWeight, state.arrival.MeanInterval),
+	}
+
+	if uncongested(txs, state.blockWeight) {
+		for i, preset := range opts.Presets {
+			result.Estimates[i] = floorEstimate(preset, state.minFeeRate, len(txs))
+			result.Estimates[i].Provenance = result.Provenance
+		}
+
+		return result, nil
+	}
+
+	result.Provenance.Model.InflowVsize = e.inflow.expectedVsize(state.arrival.ExpectedRemaining)
+	txs = e.inflow.withInflow(txs, state.arrival.ExpectedRemaining)
+	blocks := projectBlocks(txs, opts.maxTarget(), state.blockWeight)
+	if len(blocks) == 0 {
+		return nil, ErrEmptyMempool
+	}
+
+	result.ProjectedBlocks = len(blocks)
+	for i, preset := range opts.Presets {
+		result.Estimates[i] = estimatePreset(blocks, preset, state.arrival.Progress)
+		result.Estimates[i].Provenance = result.Provenance
+	}
+
+	return result, nil
+}
+
+// poolState is the mempool at the current height together with what is
+// needed to project blocks from it
+type poolState struct {
+	height      int32
+	time        time.Time // when the snapshot was loaded
+	arrival     *BlockArrival
+	minFeeRate  float64  // satoshi per virtual byte
+	blockWeight int64    // weight available to txs in a projected block
+	txs         []poolTx // sorted by descending fee rate, not filtered yet
+}
+
+// poolFetch is what is fetched from the node and the mempool cache to load
+// the mempool at the current height, see fetchPool
+type poolFetch struct {
+	height      int32
+	pool        map[string]utils.MempoolEntry
+	blockTimes  []time.Time
+	newTimes    map[int32]time.Time // block times below the tip not cached yet
+	minFeeRate  float64
+	blockWeight int64
+	inputs      map[string]txInputs // of the txs whose inputs were not known yet
+}
+
+// fetchPool fetches what loadPool needs. mu must not be held, it is only taken
+// briefly to look up the cached block times and inputs.
+func (e *Estimator) fetchPool() (*poolFetch, error) {
+	info, err := e.client.GetBlockChainInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := e.mempoolCache.GetCacheAt(info.Blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	blockTimes, newTimes, err := e.fetchBlockTimes(info.Blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	minFeeRate, err := e.getMinFeeRate()
+	if err != nil {
+		return nil, err
+	}
+
+	blockWeight, err := e.getBlockWeight(info.Blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := &poolFetch{
+		height:      info.Blocks,
+		pool:        pool,
+		blockTimes:  blockTimes,
+		newTimes:    newTimes,
+		minFeeRate:  minFeeRate,
+		blockWeight: blockWeight,
+	}
+	if DetectReplacements {
+		fetched.inputs = e.fetchInputs(pool)
+	}
+
+	return fetched, nil
+}
+
+// loadPool loads the mempool at the current height from what fetchPool
+// fetched and caches the fetched block times and inputs. The caller must hold
+// mu.
+func (e *Estimator) loadPool(fetched *poolFetch) *poolState {
+	e.cacheBlockTimes(fetched.height, fetched.newTimes)
+
+	pool := fetched.pool
+	if DetectReplacements {
+		e.updateInputs(pool, fetched.inputs)
+		pool = withoutReplaced(pool, e.inputs)
+	}
+
+	txs := newPoolTxs(pool)
+	e.withFirstSeen(txs)
+
+	return &poolState{
+		height:      fetched.height,
+		time:        time.Now(),
+		arrival:     newBlockArrival(fetched.blockTimes, time.Now()),
+		minFeeRate:  fetched.minFeeRate,
+		blockWeight: fetched.blockWeight,
+		txs:         txs,
+	}
+}
+
+// withFirstSeen sets the entry time of the txs the node reported none for to
+// when the mempool cache first saw them, so that MaxTxAge applies to them too
+func (e *Estimator) withFirstSeen(txs []poolTx) {
+	for i := range txs {
+		if txs[i].time > 0 {
+			continue
+		}
+
+		first, ok := e.mempoolCache.FirstSeen(txs[i].hash)
+		if ok {
+			txs[i].time = first.Unix()
+		}
+	}
+}
+
+// provenance describes an estimate from txCount txs of the snapshot with
+// inflowVsize of expected inflow added
+func (state *poolState) provenance(txCount int, inflowVsize int64) *Provenance {
+	return &Provenance{
+		Height:       state.height,
+		SnapshotTime: state.time,
+		TxCount:      txCount,
+		Model: Model{
+			MinFeeRate:   state.minFeeRate,
+			BlockWeight:  state.blockWeight,
+			MeanInterval: state.arrival.MeanInterval,
+			Progress:     state.arrival.Progress,
+			MaxTxAge:     MaxTxAge,
+			InflowVsize:  inflowVsize,
+			Replacements: DetectReplacements,
+		},
+	}
+}
+
+// estimatePreset picks the rate for preset from the projected blocks. If the
+// mempool does not fill preset.Target blocks, any tx is expected to be mined
+// in time, so the lowest rate of the last projected block is used.
+func estimatePreset(blocks [][]poolTx, preset Preset, progress float64) Estimate {
+	estimate := Estimate{
+		Preset:     preset,
+		Percentile: preset.percentile(progress),
+		Block:      preset.Target,
+	}
+
+	if preset.Target > len(blocks) {
+		estimate.Block = len(blocks)
+		rates := ascendingRates(blocks[len(blocks)-1])
+		estimate.Rate = rates[0]
+		estimate.SampleSize = len(rates)
+		return estimate
+	}
+
+	block := blocks[preset.Target-1]
+	estimate.Rate, estimate.Position = weightedPercentile(block, estimate.Percentile)
+	estimate.SampleSize = len(block)
+	return estimate
+}
+
+// uncongested reports whether txs do not fill a block with capacity weight
+// available to txs
+func uncongested(txs []poolTx, capacity int64) bool {
+	var vsize int64
+	for _, tx := range txs {
+		vsize += tx.vsize
+	}
+
+	return vsize < blockVsize(capacity)
+}
+
+// floorEstimate returns the estimate for preset if the mempool is
+// uncongested: any tx the node accepts is expected to be mined in the next
+// block
+func floorEstimate(preset Preset, minFeeRate float64, sampleSize int) Estimate {
+	return Estimate{
+		Preset:      preset,
+		Rate:        minFeeRate,
+		SampleSize:  sampleSize,
+		Block:       1,
+		Uncongested: true,
+	}
+}
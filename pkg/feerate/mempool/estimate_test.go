@@ -0,0 +1,78 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatePresetPicksRateFromTargetBlock(t *testing.T) {
+	// arrange: each tx fills half a block
+	half := int64(maxTxWeight / witnessScaleFactor / 2)
+	var txs []poolTx
+	for _, rate := range []float64{60, 50, 40, 30, 20} {
+		txs = append(txs, poolTx{vsize: half, feeRate: rate})
+	}
+	blocks := projectBlocks(txs, 6, maxTxWeight)
+
+	// act
+	next := estimatePreset(blocks, Preset{Target: 1, Percentile: 100}, 0)
+	second := estimatePreset(blocks, Preset{Target: 2, Percentile: 80, Range: 80}, 1)
+	sixth := estimatePreset(blocks, Preset{Target: 6, Percentile: 80}, 0)
+
+	// assert
+	assert.Equal(t, 60.0, next.Rate)
+	assert.Equal(t, 1, next.Position)
+	assert.Equal(t, 2, next.SampleSize)
+
+	assert.Equal(t, 30.0, second.Rate)
+	assert.Equal(t, 0.0, second.Percentile)
+
+	assert.Equal(t, 20.0, sixth.Rate, "the mempool clears before the target")
+	assert.Equal(t, 3, sixth.Block)
+}
+
+func TestUncongestedMempoolUsesFloor(t *testing.T) {
+	// arrange
+	half := blockVsize(maxTxWeight) / 2
+	small := []poolTx{{vsize: half, feeRate: 50}}
+	full := []poolTx{{vsize: half, feeRate: 50}, {vsize: half, feeRate: 40}}
+
+	// act
+	estimate := floorEstimate(Fast, 1.5, len(small))
+
+	// assert
+	assert.True(t, uncongested(nil, maxTxWeight))
+	assert.True(t, uncongested(small, maxTxWeight))
+	assert.False(t, uncongested(full, maxTxWeight))
+	assert.True(t, estimate.Uncongested)
+	assert.Equal(t, 1.5, estimate.Rate)
+	assert.Equal(t, Fast, estimate.Preset)
+}
+
+func TestProvenanceRecordsSnapshotAndModel(t *testing.T) {
+	// arrange
+	snapshot := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	state := &poolState{
+		height:      530000,
+		time:        snapshot,
+		arrival:     &BlockArrival{MeanInterval: 9 * time.Minute, Progress: 0.5},
+		minFeeRate:  1,
+		blockWeight: 3000000,
+	}
+
+	// act
+	provenance := state.provenance(1200, 5000)
+	record := provenanceRecord(provenance)
+
+	// assert
+	assert.Equal(t, int32(530000), provenance.Height)
+	assert.Equal(t, snapshot, provenance.SnapshotTime)
+	assert.Equal(t, 1200, provenance.TxCount)
+	assert.Equal(t, int64(3000000), provenance.Model.BlockWeight)
+	assert.Equal(t, 9*time.Minute, provenance.Model.MeanInterval)
+	assert.Equal(t, MaxTxAge, provenance.Model.MaxTxAge)
+	assert.Equal(t, []string{"2018-06-01T12:00:00Z", "1200", "1.000", "3000000", "5000"}, record)
+	assert.Len(t, provenanceRecord(nil), len(record))
+}
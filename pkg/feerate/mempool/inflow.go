@@ -0,0 +1,139 @@
+package mempool
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// InflowBands are the lower bounds of the fee bands (satoshi per virtual
+	// byte) the inflow is measured in
+	InflowBands = []float64{1, 2, 3, 5, 8, 10, 15, 20, 30, 50, 75, 100, 150, 200, 300, 500, 1000}
+
+	// InflowSmoothing is the weight of the latest measurement in the moving
+	// average of the inflow rates
+	InflowSmoothing = 0.3
+
+	// inflowChunkSize is the vsize of the synthetic txs the expected inflow is
+	// split into, so that it can fill up partially used blocks
+	inflowChunkSize int64 = 1000
+)
+
+// inflowModel measures how fast txs enter the mempool per fee band by diffing
+// consecutive mempool snapshots
+type inflowModel struct {
+	seen     map[string]struct{}
+	lastTime time.Time
+	rates    []float64 // vsize per second, per band
+}
+
+func newInflowModel() *inflowModel {
+	return &inflowModel{
+		rates: make([]float64, len(InflowBands)),
+	}
+}
+
+// band returns the index of the fee band of rate, rates below the lowest band
+// are counted in it
+func band(rate float64) int {
+	b := 0
+	for i, lower := range InflowBands {
+		if rate >= lower {
+			b = i
+		}
+	}
+
+	return b
+}
+
+// observe updates the inflow rates with the txs of txs that were not part of
+// the previous snapshot. The first snapshot only initializes the model.
+func (m *inflowModel) observe(txs []poolTx, now time.Time) {
+	seen := make(map[string]struct{}, len(txs))
+	for _, tx := range txs {
+		seen[tx.hash] = struct{}{}
+	}
+
+	if m.seen != nil && now.After(m.lastTime) {
+		elapsed := now.Sub(m.lastTime).Seconds()
+		arrived := make([]float64, len(InflowBands))
+		for _, tx := range txs {
+			if _, ok := m.seen[tx.hash]; !ok {
+				arrived[band(tx.feeRate)] += float64(tx.vsize)
+			}
+		}
+
+		for i := range m.rates {
+			m.rates[i] = (1-InflowSmoothing)*m.rates[i] + InflowSmoothing*arrived[i]/elapsed
+		}
+	}
+
+	m.seen = seen
+	m.lastTime = now
+}
+
+// expected returns synthetic txs for the vsize that is expected to arrive
+// within d. They are priced at the lower bound of their band so that the
+// projection errs on the side of higher estimates.
+func (m *inflowModel) expected(d time.Duration) []poolTx {
+	var txs []poolTx
+	for i, rate := range m.rates {
+		vsize := int64(rate * d.Seconds())
+		for chunk := 0; vsize > 0; chunk++ {
+			size := inflowChunkSize
+			if vsize < size {
+				size = vsize
+			}
+
+			txs = append(txs, poolTx{
+				hash:    fmt.Sprintf("inflow-%d-%d", i, chunk),
+				vsize:   size,
+				fee:     int64(InflowBands[i] * float64(size)),
+				feeRate: InflowBands[i],
+			})
+			vsize -= size
+		}
+	}
+
+	return txs
+}
+
+// expectedVsize returns the vsize that is expected to arrive within d
+func (m *inflowModel) expectedVsize(d time.Duration) int64 {
+	var vsize int64
+	for _, rate := range m.rates {
+		vsize += int64(rate * d.Seconds())
+	}
+
+	return vsize
+}
+
+// rateAbove returns the inflow in vsize per second of txs that rank above a
+// tx paying feeRate. Expected txs are priced at the lower bound of their
+// band, so only bands starting above feeRate count.
+func (m *inflowModel) rateAbove(feeRate float64) float64 {
+	var rate float64
+	for i, lower := range InflowBands {
+		if lower > feeRate {
+			rate += m.rates[i]
+		}
+	}
+
+	return rate
+}
+
+// withInflow adds the inflow expected within d to txs sorted by descending
+// fee rate and returns the result sorted again
+func (m *inflowModel) withInflow(txs []poolTx, d time.Duration) []poolTx {
+	expected := m.expected(d)
+	if len(expected) == 0 {
+		return txs
+	}
+
+	all := make([]poolTx, 0, len(txs)+len(expected))
+	all = append(all, txs...)
+	all = append(all, expected...)
+	sortByFeeRate(all)
+
+	return all
+}
@@ -0,0 +1,51 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInflowModelMeasuresArrivalsPerBand(t *testing.T) {
+	// arrange
+	smoothing := InflowSmoothing
+	InflowSmoothing = 1
+	defer func() { InflowSmoothing = smoothing }()
+
+	start := time.Unix(1500000000, 0)
+	m := newInflowModel()
+	m.observe([]poolTx{{hash: "old", vsize: 500, feeRate: 12}}, start)
+
+	// act: 6000 vB at 12 sat/vB and 3000 vB at 1 sat/vB arrive within a minute
+	m.observe([]poolTx{
+		{hash: "old", vsize: 500, feeRate: 12},
+		{hash: "new", vsize: 6000, feeRate: 12},
+		{hash: "cheap", vsize: 3000, feeRate: 1.5},
+	}, start.Add(time.Minute))
+	expected := m.expected(10 * time.Second)
+
+	// assert
+	assert.Equal(t, 100.0, m.rates[band(12)])
+	assert.Equal(t, 50.0, m.rates[band(1.5)])
+	assert.Len(t, expected, 2)
+	assert.Equal(t, int64(500), expected[0].vsize)
+	assert.Equal(t, 1.0, expected[0].feeRate)
+	assert.Equal(t, 10.0, expected[1].feeRate)
+}
+
+func TestWithInflowKeepsOrder(t *testing.T) {
+	// arrange
+	m := newInflowModel()
+	m.rates[band(20)] = 100
+
+	// act
+	txs := m.withInflow([]poolTx{{hash: "a", vsize: 100, feeRate: 50}, {hash: "b", vsize: 100, feeRate: 5}}, 20*time.Second)
+
+	// assert
+	assert.Len(t, txs, 4)
+	assert.Equal(t, "a", txs[0].hash)
+	assert.Equal(t, 20.0, txs[1].feeRate)
+	assert.Equal(t, 20.0, txs[2].feeRate)
+	assert.Equal(t, "b", txs[3].hash)
+}
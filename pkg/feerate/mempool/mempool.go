@@ -1,11 +1,13 @@
 package mempool
 
 import (
-	"sort"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/btcsuite/btcd/btcjson"
-
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
 	"go.uber.org/zap"
@@ -19,170 +21,263 @@ type Estimator struct {
 	scores             *scores
 	ratesCache         *feerate.RateCache
 	mempoolCache       *feerate.MempoolCache
+	blockTimes         map[int32]time.Time //height->timestamp of recent blocks
+	options            Options
+	inflow             *inflowModel
+	inputs             map[string]txInputs //hash->inputs of mempool txs, see DetectReplacements
+
+	mu sync.Mutex // guards the state above used by the estimation
+
+	statusMu sync.Mutex
+	status   Status
 }
 
-// NewEstimator creates a new naive bitcoin fee estimator
+// NewEstimator creates a new mempool based bitcoin fee estimator with the default options
 func NewEstimator(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache, mempoolCache *feerate.MempoolCache) *Estimator {
+	return newEstimator(logger, client, ratesCache, mempoolCache, DefaultOptions())
+}
+
+// NewEstimatorWithOptions creates a new mempool based bitcoin fee estimator,
+// options without presets estimate the default ones. ErrInvalidOptions is
+// returned if the options do not validate.
+func NewEstimatorWithOptions(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache, mempoolCache *feerate.MempoolCache, options Options) (*Estimator, error) {
+	if len(options.Presets) == 0 {
+		options = DefaultOptions()
+	}
+
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return newEstimator(logger, client, ratesCache, mempoolCache, options), nil
+}
+
+func newEstimator(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache, mempoolCache *feerate.MempoolCache, options Options) *Estimator {
 	return &Estimator{
 		client:       client,
 		logger:       logger,
 		scores:       newScores(logger),
 		ratesCache:   ratesCache,
 		mempoolCache: mempoolCache,
+		blockTimes:   make(map[int32]time.Time),
+		options:      options,
+		inflow:       newInflowModel(),
+		inputs:       make(map[string]txInputs),
 	}
 }
 
-// Run starts the main event loop for estimating fees
-func (e *Estimator) Run() error {
-	ticker := time.NewTicker(time.Minute * 1)
+// Run estimates fees every interval, or every DefaultInterval if interval is
+// not positive, until ctx is cancelled or MaxConsecutiveErrors estimations in
+// a row failed. The errors that occurred are returned together.
+func (e *Estimator) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	errorChannel := make(chan error)
-	go func() {
-		err := e.EstimateFee()
+	// estimate right away when the mempool at a new height is recorded
+	// instead of waiting for the next tick
+	snapshots, unsubscribe := e.mempoolCache.Subscribe()
+	defer unsubscribe()
+
+	var errs runErrors
+	consecutive := 0
+	work := func() {
+		err := e.estimateFee(ctx)
+		if err != nil && ctx.Err() != nil {
+			return // stopped while estimating
+		}
 		if err != nil {
-			errorChannel <- err
+			e.logger.Error("mempool fee estimation failed", zap.Error(err))
+			errs = append(errs, err)
+			consecutive++
+			return
 		}
-		for {
-			select {
-			case <-ticker.C:
-				err := e.EstimateFee()
-				if err != nil {
-					errorChannel <- err
-				}
-			}
+
+		consecutive = 0
+	}
+
+	work()
+	for consecutive < MaxConsecutiveErrors {
+		select {
+		case <-ctx.Done():
+			e.logger.Info("stopping mempool fee estimation")
+			return errs.errorOrNil()
+		case <-ticker.C:
+			work()
+		case <-snapshots:
+			work()
 		}
-	}()
+	}
+
+	return errs.errorOrNil()
+}
+
+// runErrors collects the errors that occurred while running the estimator
+type runErrors []error
+
+func (errs runErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d error(s) occurred: %s", len(errs), strings.Join(messages, "; "))
+}
+
+func (errs runErrors) errorOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
 
-	return <-errorChannel
+	return errs
 }
 
 //EstimateFee runs the estimation
 func (e *Estimator) EstimateFee() error {
-	info, err := e.client.GetBlockChainInfo()
-	if err != nil {
-		return err
-	}
+	return e.estimateFee(context.Background())
+}
 
-	pool, err := e.mempoolCache.GetCacheAt(info.Blocks)
+// estimateFee runs the estimation, waiting for the fee rates of the block
+// until ctx is done
+func (e *Estimator) estimateFee(ctx context.Context) (err error) {
+	var height int32
+	defer func() {
+		e.recordResult(height, err)
+	}()
+
+	var result *Result
+	err = e.retry(ctx, "estimate", func() error {
+		var err error
+		result, err = e.Estimate(e.options)
+		return err
+	})
 	if err != nil {
-		if err == feerate.ErrCacheNotExists {
-			e.logger.Info("mem cache does not exist", zap.Any("height", info.Blocks))
+		if err == feerate.ErrCacheNotExists || err == ErrEmptyMempool {
+			e.logger.Info("mempool cannot be estimated from", zap.Error(err))
 			return nil
 		}
 
 		return err
 	}
+	height = result.Provenance.Height
+
+	arrival := result.Arrival
+	e.logger.Info("block arrival", zap.Duration("mean interval", arrival.MeanInterval), zap.Duration("elapsed", arrival.Elapsed), zap.Float64("progress", arrival.Progress), zap.Duration("expected remaining", arrival.ExpectedRemaining))
 
-	avgBlockSize, lastMined, err := e.getAverageBlockSize(int(info.Blocks))
+	congestion := result.Congestion
+	e.logger.Info("mempool congestion", zap.Int64("total vsize", congestion.TotalVsize), zap.Int("txs", congestion.TxCount), zap.Float64("backlog minutes", congestion.BacklogMinutes))
+
+	var feeRates *feerate.FeeRates
+	err = e.retry(ctx, "fee rates", func() error {
+		var err error
+		feeRates, err = e.ratesCache.GetFeeRatesForBlockContext(ctx, height)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	diff := time.Now().Sub(lastMined)
-	powProgress := (float64(1) / float64(10)) * float64(diff.Minutes())
-	e.logger.Info("time", zap.Any("pow", powProgress), zap.Any("now", time.Now()), zap.Any("lastMined", lastMined), zap.Any("diff", diff))
-	if powProgress > 1 {
-		powProgress = 1
+	for _, estimate := range result.Estimates {
+		e.logger.Info("estimated mempool rate", zap.String("preset", estimate.Preset.Name), zap.Int("target", estimate.Preset.Target), zap.Any("rate", estimate.Rate), zap.Any("percentile", estimate.Percentile), zap.Int("txs", estimate.SampleSize), zap.Any("projected blocks", result.ProjectedBlocks), zap.Bool("uncongested", estimate.Uncongested))
+		e.scores.addPrediction(int(height), feeRates, estimate)
 	}
 
-	poolRates := e.getPoolRates(pool)
-	sort.Float64s(poolRates)
+	e.scores.predictScores()
+	return nil
+}
+
+// getMinFeeRate returns the lowest fee rate in satoshi per virtual byte the
+// node accepts into its mempool
+func (e *Estimator) getMinFeeRate() (float64, error) {
+	info, err := e.client.GetMempoolInfo()
+	if err != nil {
+		return 0, err
+	}
 
-	idx := len(poolRates) - avgBlockSize
-	if idx < 0 {
-		idx = 0
+	minFee := info.MempoolMinFee
+	if info.MinRelayTxFee > minFee {
+		minFee = info.MinRelayTxFee
 	}
 
-	blockWindowRates := poolRates[idx:]
-	verificationPercentile := float64(Percentile) - float64(Range)*powProgress
-	estimate := blockWindowRates[(len(blockWindowRates)-1)*int(verificationPercentile)/100]
-	e.logger.Info("estimated mempool rate", zap.Any("rate", estimate), zap.Any("percentile", verificationPercentile), zap.Any("txs", len(blockWindowRates)))
+	// BTC/kB to satoshi/B
+	return minFee * utils.BTC / 1000, nil
+}
 
-	feeRates, err := e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+// getLastMinedTime returns the timestamp of the block at the given height
+func (e *Estimator) getLastMinedTime(height int32) (time.Time, error) {
+	hash, err := e.client.GetBlockHash(int64(height))
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
-	e.scores.addPrediction(int(info.Blocks), feeRates, estimate)
-	e.scores.predictScores()
-	return nil
+	header, err := e.client.GetBlockHeader(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return header.Timestamp, nil
 }
 
-func (e *Estimator) getAverageBlockSize(height int) (int, time.Time, error) {
-	numberOfBlocks := 5
-	numberOfTxs := 0
-	var time time.Time
-	for i := height; i > height-numberOfBlocks; i-- {
-		hash, err := e.client.GetBlockHash(int64(i))
+// getBlockWeight returns the average weight of the txs in the
+// BlockWeightWindow blocks up to height, bounded by the weight a block can
+// hold. Miners do not always fill blocks, so this is the capacity a projected
+// block actually has. Block stats are cached by the client.
+func (e *Estimator) getBlockWeight(height int32) (int64, error) {
+	var total, blocks int64
+	for h := height; h > height-int32(BlockWeightWindow) && h >= 0; h-- {
+		hash, err := e.client.GetBlockHash(int64(h))
 		if err != nil {
-			return 0, time, err
+			return 0, err
 		}
 
-		block, err := e.client.GetBlock(hash)
+		stats, err := e.client.GetBlockStats(hash)
 		if err != nil {
-			return 0, time, err
+			return 0, err
 		}
 
-		if i == height {
-			time = block.Header.Timestamp
-		}
-		numberOfTxs = numberOfTxs + len(block.Transactions)
+		total += stats.TotalWeight
+		blocks++
 	}
 
-	return numberOfTxs / numberOfBlocks, time, nil
+	return averageBlockWeight(total, blocks), nil
 }
 
-func (e *Estimator) getPoolRates(pool map[string]btcjson.GetRawMempoolVerboseResult) []float64 {
-	var rates []float64
-	for _, entry := range pool {
-		feeInSatoshi := int64(entry.Fee * utils.BTC)
-		ratePerByte := (float64(feeInSatoshi) / float64(entry.Size))
-		rates = append(rates, ratePerByte)
+// averageBlockWeight returns the average of total over blocks, falling back
+// to the full block if the recent blocks were empty
+func averageBlockWeight(total, blocks int64) int64 {
+	if blocks == 0 || total <= 0 {
+		return maxTxWeight
 	}
 
-	return rates
-}
-
-var (
-	//Percentile defines the position where the fee rate is estimated
-	//e.g. 50 means median value, 60 means a fee that is a little bit higher than the median
-	Percentile = 80
-	Range      = 60
-)
-
-func (e *Estimator) estimateFee() (float64, error) {
-	info, err := e.client.GetBlockChainInfo()
-	if err != nil {
-		return 0, err
+	average := total / blocks
+	if average > maxTxWeight {
+		return maxTxWeight
 	}
 
-	pool, err := e.mempoolCache.GetCacheAt(info.Blocks)
-	if err != nil {
-		return 0, err
-	}
+	return average
+}
 
-	avgBlockSize, lastMined, err := e.getAverageBlockSize(int(info.Blocks))
-	if err != nil {
-		return 0, err
-	}
+var (
+	// ErrEmptyMempool is returned if there are no txs to estimate from
+	ErrEmptyMempool = errors.New("mempool is empty")
 
-	diff := time.Now().Sub(lastMined)
-	powProgress := (float64(1) / float64(10)) * float64(diff.Minutes())
-	if powProgress > 1 {
-		powProgress = 1
-	}
+	// DefaultInterval defines how often fees are estimated if Run is not
+	// given an interval
+	DefaultInterval = time.Minute
 
-	poolRates := e.getPoolRates(pool)
-	sort.Float64s(poolRates)
+	// MaxConsecutiveErrors is the number of estimations in a row that may
+	// fail before Run gives up
+	MaxConsecutiveErrors = 5
 
-	idx := len(poolRates) - avgBlockSize
-	if idx < 0 {
-		idx = 0
-	}
+	// BlockWeightWindow is the number of recent blocks whose average weight
+	// is the capacity of a projected block
+	BlockWeightWindow = 5
 
-	blockWindowRates := poolRates[idx:]
-	verificationPercentile := float64(Percentile) - float64(Range)*powProgress
-	estimate := blockWindowRates[(len(blockWindowRates)-1)*int(verificationPercentile)/100]
-	return estimate, nil
-}
+	// MaxTxAge is the age after which a tx is considered stuck and left out
+	// of the estimation, 0 keeps txs of any age
+	MaxTxAge = time.Hour * 24
+)
@@ -0,0 +1,91 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Preset describes how the fee rate for a priority is picked from the
+// projected blocks
+type Preset struct {
+	// Name identifies the preset in logs and scores
+	Name string
+
+	// Target is the confirmation target in blocks, the rate is picked from
+	// the Target-th projected block
+	Target int
+
+	// Percentile defines the position where the fee rate is estimated
+	// e.g. 50 means median value, 60 means a fee that is a little bit higher than the median.
+	// Txs are weighted by vsize, so it is the share of block space paying less.
+	Percentile int
+
+	// Range is how far the percentile drops as the next block becomes due
+	Range int
+}
+
+var (
+	// Economical is the preset for txs that should confirm within 6 blocks,
+	// about an hour
+	Economical = Preset{Name: "economical", Target: 6, Percentile: 50, Range: 40}
+
+	// Standard is the preset for txs that should confirm within 3 blocks
+	Standard = Preset{Name: "standard", Target: 3, Percentile: 80, Range: 60}
+
+	// Fast is the preset for txs that should confirm in the next block
+	Fast = Preset{Name: "fast", Target: 1, Percentile: 90, Range: 40}
+)
+
+// Options configures an Estimator
+type Options struct {
+	// Presets are the priorities that are estimated
+	Presets []Preset
+}
+
+// DefaultOptions returns the options estimating the same three priorities
+// as the other estimators
+func DefaultOptions() Options {
+	return Options{
+		Presets: []Preset{Economical, Standard, Fast},
+	}
+}
+
+// ErrInvalidOptions is returned for options that cannot be estimated with
+var ErrInvalidOptions = errors.New("invalid mempool estimator options")
+
+// Validate checks that every preset targets at least the next block and
+// picks its rate at a percentile between 0 and 100, also when it drops by
+// Range
+func (o Options) Validate() error {
+	for _, p := range o.Presets {
+		if p.Target < 1 {
+			return fmt.Errorf("%w: target %d of preset %v is below 1", ErrInvalidOptions, p.Target, p.Name)
+		}
+		if p.Percentile < 0 || p.Percentile > 100 {
+			return fmt.Errorf("%w: percentile %d of preset %v is not within 0 and 100", ErrInvalidOptions, p.Percentile, p.Name)
+		}
+		if p.Range < 0 || p.Range > p.Percentile {
+			return fmt.Errorf("%w: range %d of preset %v is not within 0 and its percentile", ErrInvalidOptions, p.Range, p.Name)
+		}
+	}
+
+	return nil
+}
+
+// maxTarget returns the highest confirmation target of the presets
+func (o Options) maxTarget() int {
+	max := 0
+	for _, p := range o.Presets {
+		if p.Target > max {
+			max = p.Target
+		}
+	}
+
+	return max
+}
+
+// percentile returns the percentile to estimate at given the progress
+// towards the next block
+func (p Preset) percentile(progress float64) float64 {
+	return float64(p.Percentile) - float64(p.Range)*progress
+}
@@ -0,0 +1,64 @@
+package mempool
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestDefaultOptionsHaveThreePriorities(t *testing.T) {
+	// arrange
+	options := DefaultOptions()
+
+	// act & assert
+	assert.Len(t, options.Presets, 3)
+	assert.Equal(t, []int{6, 3, 1}, []int{options.Presets[0].Target, options.Presets[1].Target, options.Presets[2].Target})
+	assert.Equal(t, Economical.Target, options.maxTarget())
+	assert.Equal(t, 80.0, Standard.percentile(0))
+	assert.Equal(t, 20.0, Standard.percentile(1))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		preset Preset
+		valid  bool
+	}{
+		{"default", Standard, true},
+		{"next block", Preset{Name: "next", Target: 1, Percentile: 100, Range: 100}, true},
+		{"no target", Preset{Name: "none", Target: 0, Percentile: 50}, false},
+		{"negative target", Preset{Name: "negative", Target: -1, Percentile: 50}, false},
+		{"percentile above 100", Preset{Name: "above", Target: 1, Percentile: 101}, false},
+		{"negative percentile", Preset{Name: "below", Target: 1, Percentile: -1}, false},
+		{"range above percentile", Preset{Name: "range", Target: 1, Percentile: 50, Range: 60}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			err := Options{Presets: []Preset{test.preset}}.Validate()
+
+			// assert
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidOptions))
+			}
+		})
+	}
+}
+
+func TestEstimateRejectsInvalidOptions(t *testing.T) {
+	// arrange
+	e := NewEstimator(zap.NewNop(), nil, nil, nil)
+
+	// act
+	_, err := e.Estimate(Options{Presets: []Preset{{Name: "none", Target: 0, Percentile: 50}}})
+	_, constructErr := NewEstimatorWithOptions(zap.NewNop(), nil, nil, nil, Options{Presets: []Preset{{Name: "none", Percentile: 50}}})
+
+	// assert
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+	assert.True(t, errors.Is(constructErr, ErrInvalidOptions))
+}
@@ -0,0 +1,62 @@
+package mempool
+
+import (
+	"math"
+	"time"
+)
+
+// ProbabilityOfNextBlock returns the probability that a tx paying feeRate
+// (satoshi per virtual byte) is mined in the next block. The tx is placed
+// behind the txs in the mempool paying more than it. Higher paying txs keep
+// arriving at the rate of the inflow model, so the tx makes the next block if
+// the block is found before they push it out. With exponentially distributed
+// block intervals that is 1-exp(-t/MeanInterval), t being the time until the
+// space left ahead of the tx is used up.
+func (e *Estimator) ProbabilityOfNextBlock(feeRate float64) (float64, error) {
+	fetched, err := e.fetchPool()
+	if err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state := e.loadPool(fetched)
+
+	if feeRate < state.minFeeRate {
+		return 0, nil
+	}
+
+	txs := filterPoolTxs(state.txs, time.Now(), MaxTxAge, state.minFeeRate)
+	return nextBlockProbability(txs, e.inflow.rateAbove(feeRate), feeRate, state.arrival.MeanInterval, state.blockWeight), nil
+}
+
+// nextBlockProbability computes the probability for a tx at feeRate to make
+// the next block given the txs in the mempool and the inflow of txs paying
+// more than feeRate in vsize per second, see ProbabilityOfNextBlock. capacity
+// is the weight available to txs in a block.
+func nextBlockProbability(txs []poolTx, inflowAbove float64, feeRate float64, meanInterval time.Duration, capacity int64) float64 {
+	var ahead int64
+	for _, tx := range txs {
+		if tx.feeRate > feeRate {
+			ahead += tx.vsize
+		}
+	}
+
+	left := float64(blockVsize(capacity) - ahead)
+	if left <= 0 {
+		return 0
+	}
+
+	if inflowAbove <= 0 {
+		return 1
+	}
+
+	untilFull := left / inflowAbove
+	return 1 - math.Exp(-untilFull/meanInterval.Seconds())
+}
+
+// blockVsize converts the weight available to txs in a block to vsize
+func blockVsize(capacity int64) int64 {
+	return capacity / witnessScaleFactor
+}
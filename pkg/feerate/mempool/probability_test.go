@@ -0,0 +1,43 @@
+package mempool
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextBlockProbability(t *testing.T) {
+	// arrange: half a block pays more than 10 sat/vB
+	half := blockVsize(maxTxWeight) / 2
+	txs := []poolTx{
+		{vsize: half, feeRate: 20},
+		{vsize: half, feeRate: 5},
+	}
+	interval := 10 * time.Minute
+	// fills the remaining half block within one mean interval
+	inflow := float64(half) / interval.Seconds()
+
+	// act
+	noInflow := nextBlockProbability(txs, 0, 10, interval, maxTxWeight)
+	withInflow := nextBlockProbability(txs, inflow, 10, interval, maxTxWeight)
+	crowdedOut := nextBlockProbability(append(txs, poolTx{vsize: half, feeRate: 30}), inflow, 10, interval, maxTxWeight)
+
+	// assert
+	assert.Equal(t, 1.0, noInflow)
+	assert.InDelta(t, 1-math.Exp(-1), withInflow, 1e-9)
+	assert.Equal(t, 0.0, crowdedOut)
+}
+
+func TestInflowRateAbove(t *testing.T) {
+	// arrange
+	m := newInflowModel()
+	m.rates[band(5)] = 100
+	m.rates[band(20)] = 50
+
+	// act & assert
+	assert.Equal(t, 150.0, m.rateAbove(4))
+	assert.Equal(t, 50.0, m.rateAbove(5))
+	assert.Equal(t, 0.0, m.rateAbove(20))
+}
@@ -0,0 +1,252 @@
+package mempool
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+const (
+	// MaxBlockWeight is the consensus limit for the weight of a block in WU
+	MaxBlockWeight = 4000000
+
+	// coinbaseReservedWeight is kept free for the block header and the
+	// coinbase tx, like bitcoin core does when assembling blocks
+	coinbaseReservedWeight = 4000
+
+	// witnessScaleFactor converts between vsize and weight
+	witnessScaleFactor = 4
+
+	// maxTxWeight is the weight available to txs in a block
+	maxTxWeight = MaxBlockWeight - coinbaseReservedWeight
+)
+
+// poolTx is a mempool tx reduced to the fields needed for block projection
+type poolTx struct {
+	hash    string
+	fee     int64   // satoshi
+	vsize   int64   // virtual bytes
+	feeRate float64 // effective satoshi per virtual byte, see packageFeeRates
+	depends []string
+	time    int64 // unix time the tx entered the mempool
+
+	ancestors int // number of unconfirmed ancestors
+}
+
+// weight returns the weight of the tx in WU
+func (t poolTx) weight() int64 {
+	return t.vsize * witnessScaleFactor
+}
+
+// newPoolTxs converts a mempool snapshot into poolTxs sorted by descending fee rate
+func newPoolTxs(pool map[string]utils.MempoolEntry) []poolTx {
+	txs := make([]poolTx, 0, len(pool))
+	for hash, entry := range pool {
+		vsize := int64(entry.Vsize)
+		if vsize <= 0 {
+			vsize = int64(entry.Size)
+		}
+		if vsize <= 0 {
+			continue
+		}
+
+		fee := int64(entry.Fee * utils.BTC)
+		txs = append(txs, poolTx{
+			hash:    hash,
+			fee:     fee,
+			vsize:   vsize,
+			feeRate: float64(fee) / float64(vsize),
+			depends: entry.Depends,
+			time:    entry.Time,
+		})
+	}
+
+	packageFeeRates(txs)
+	sortByFeeRate(txs)
+	return txs
+}
+
+// packageFeeRates replaces the fee rate of every tx by the rate it is mined
+// at. Miners select txs by the fee rate of the tx together with its unconfirmed
+// ancestors (CPFP), so
+//   - a child is mined at the lower rate of its package if its parents pay less
+//   - a parent is mined at the rate of the best package of a descendant that
+//     pulls it in, if that is higher than its own
+func packageFeeRates(txs []poolTx) {
+	index := make(map[string]int, len(txs))
+	for i, tx := range txs {
+		index[tx.hash] = i
+	}
+
+	ancestorRates := make([]float64, len(txs))
+	ancestorSets := make([]map[int]struct{}, len(txs))
+	for i, tx := range txs {
+		ancestors := ancestorSet(txs, index, i)
+		ancestorSets[i] = ancestors
+
+		fee, vsize := tx.fee, tx.vsize
+		for a := range ancestors {
+			fee += txs[a].fee
+			vsize += txs[a].vsize
+		}
+		ancestorRates[i] = float64(fee) / float64(vsize)
+	}
+
+	effective := make([]float64, len(txs))
+	copy(effective, ancestorRates)
+	for i, ancestors := range ancestorSets {
+		for a := range ancestors {
+			if ancestorRates[i] > effective[a] {
+				effective[a] = ancestorRates[i]
+			}
+		}
+	}
+
+	for i := range txs {
+		txs[i].feeRate = effective[i]
+		txs[i].ancestors = len(ancestorSets[i])
+	}
+}
+
+// ancestorSet returns the indexes of all unconfirmed ancestors of txs[i].
+// Parents that are not part of txs are confirmed already and ignored.
+func ancestorSet(txs []poolTx, index map[string]int, i int) map[int]struct{} {
+	ancestors := make(map[int]struct{})
+	stack := []int{i}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, parent := range txs[current].depends {
+			p, ok := index[parent]
+			if !ok || p == i {
+				continue
+			}
+			if _, seen := ancestors[p]; seen {
+				continue
+			}
+
+			ancestors[p] = struct{}{}
+			stack = append(stack, p)
+		}
+	}
+
+	return ancestors
+}
+
+// filterPoolTxs removes txs that are unlikely to be mined at current rates
+// and would only distort the low end of the window: txs that have been in the
+// mempool for longer than maxAge and txs paying less than minFeeRate (in
+// satoshi per virtual byte). A maxAge of 0 keeps txs of any age.
+func filterPoolTxs(txs []poolTx, now time.Time, maxAge time.Duration, minFeeRate float64) []poolTx {
+	filtered := make([]poolTx, 0, len(txs))
+	for _, tx := range txs {
+		if tx.feeRate < minFeeRate {
+			continue
+		}
+
+		if maxAge > 0 && tx.time > 0 && now.Sub(time.Unix(tx.time, 0)) > maxAge {
+			continue
+		}
+
+		filtered = append(filtered, tx)
+	}
+
+	return filtered
+}
+
+// sortByFeeRate sorts txs by descending fee rate. Within a package, which
+// shares the same rate, parents come before their children. Remaining ties
+// are broken by hash so that the projection does not depend on map
+// iteration order.
+func sortByFeeRate(txs []poolTx) {
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].feeRate != txs[j].feeRate {
+			return txs[i].feeRate > txs[j].feeRate
+		}
+		if txs[i].ancestors != txs[j].ancestors {
+			return txs[i].ancestors < txs[j].ancestors
+		}
+		return txs[i].hash < txs[j].hash
+	})
+}
+
+// projectBlock fills a block with the txs sorted by descending fee rate the
+// way a miner maximizing fees would: greedily, until the next tx would exceed
+// the block weight. Txs too large for the remaining space are skipped so that
+// smaller txs can still fill it up.
+func projectBlock(txs []poolTx) []poolTx {
+	blocks := projectBlocks(txs, 1, maxTxWeight)
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	return blocks[0]
+}
+
+// projectBlocks stacks up to n projected blocks from txs sorted by
+// descending fee rate. Every tx goes into the first block it fits in, so each
+// block holds the best txs left over by the blocks before it. Blocks are only
+// returned as long as there are txs left for them. capacity is the weight
+// available to txs in each block.
+func projectBlocks(txs []poolTx, n int, capacity int64) [][]poolTx {
+	blocks := make([][]poolTx, n)
+	weights := make([]int64, n)
+
+	for _, tx := range txs {
+		for b := 0; b < n; b++ {
+			if weights[b]+tx.weight() > capacity {
+				continue
+			}
+
+			blocks[b] = append(blocks[b], tx)
+			weights[b] += tx.weight()
+			break
+		}
+	}
+
+	for len(blocks) > 0 && len(blocks[len(blocks)-1]) == 0 {
+		blocks = blocks[:len(blocks)-1]
+	}
+
+	return blocks
+}
+
+// weightedPercentile returns the fee rate at percentile of txs weighted by
+// vsize, i.e. the rate paid for the block space at that percentile, and the
+// position of the tx paying it in ascending order of fee rate
+func weightedPercentile(txs []poolTx, percentile float64) (float64, int) {
+	sorted := make([]poolTx, len(txs))
+	copy(sorted, txs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].feeRate < sorted[j].feeRate
+	})
+
+	var total int64
+	for _, tx := range sorted {
+		total += tx.vsize
+	}
+
+	threshold := float64(total) * percentile / 100
+	var cumulative int64
+	for i, tx := range sorted {
+		cumulative += tx.vsize
+		if float64(cumulative) >= threshold {
+			return tx.feeRate, i
+		}
+	}
+
+	return sorted[len(sorted)-1].feeRate, len(sorted) - 1
+}
+
+// ascendingRates returns the fee rates of txs in ascending order
+func ascendingRates(txs []poolTx) []float64 {
+	rates := make([]float64, len(txs))
+	for i, tx := range txs {
+		rates[i] = tx.feeRate
+	}
+	sort.Float64s(rates)
+
+	return rates
+}
@@ -0,0 +1,140 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPoolTxsUsesVirtualSize(t *testing.T) {
+	// arrange
+	pool := map[string]utils.MempoolEntry{
+		"a": {Fee: 0.00001, Size: 250, Vsize: 100},
+		"b": {Fee: 0.00002, Size: 100},
+	}
+
+	// act
+	txs := newPoolTxs(pool)
+
+	// assert
+	assert.Len(t, txs, 2)
+	assert.Equal(t, "b", txs[0].hash)
+	assert.Equal(t, 20.0, txs[0].feeRate)
+	assert.Equal(t, 10.0, txs[1].feeRate)
+}
+
+func TestProjectBlockFillsByWeight(t *testing.T) {
+	// arrange: two txs of a quarter block each and one of half a block
+	quarter := int64(maxTxWeight / witnessScaleFactor / 4)
+	txs := []poolTx{
+		{hash: "high", vsize: quarter, feeRate: 50},
+		{hash: "large", vsize: 3 * quarter, feeRate: 40},
+		{hash: "mid", vsize: quarter, feeRate: 30},
+		{hash: "low", vsize: quarter, feeRate: 10},
+		{hash: "lowest", vsize: quarter, feeRate: 1},
+	}
+
+	// act
+	block := projectBlock(txs)
+
+	// assert: the large tx fills the block after the first one
+	var hashes []string
+	for _, tx := range block {
+		hashes = append(hashes, tx.hash)
+	}
+	assert.Equal(t, []string{"high", "large"}, hashes)
+	assert.Equal(t, []float64{40, 50}, ascendingRates(block))
+}
+
+func TestNewPoolTxsUsesPackageFeeRates(t *testing.T) {
+	// arrange: a cheap parent pulled in by an expensive child
+	pool := map[string]utils.MempoolEntry{
+		"parent": {Fee: 0.000001, Vsize: 100},
+		"child":  {Fee: 0.000059, Vsize: 100, Depends: []string{"parent"}},
+		"single": {Fee: 0.00002, Vsize: 100},
+		"orphan": {Fee: 0.00001, Vsize: 100, Depends: []string{"confirmed"}},
+	}
+
+	// act
+	txs := newPoolTxs(pool)
+
+	// assert
+	rates := make(map[string]float64)
+	for _, tx := range txs {
+		rates[tx.hash] = tx.feeRate
+	}
+	assert.Equal(t, 30.0, rates["parent"], "the parent is mined at the package rate")
+	assert.Equal(t, 30.0, rates["child"], "the child pays for its parent")
+	assert.Equal(t, 20.0, rates["single"])
+	assert.Equal(t, 10.0, rates["orphan"])
+}
+
+func TestProjectBlocksStacksBlocks(t *testing.T) {
+	// arrange: each tx fills half a block
+	half := int64(maxTxWeight / witnessScaleFactor / 2)
+	var txs []poolTx
+	for _, rate := range []float64{60, 50, 40, 30, 20} {
+		txs = append(txs, poolTx{vsize: half, feeRate: rate})
+	}
+
+	// act
+	blocks := projectBlocks(txs, 6, maxTxWeight)
+
+	// assert
+	assert.Len(t, blocks, 3)
+	assert.Equal(t, []float64{50, 60}, ascendingRates(blocks[0]))
+	assert.Equal(t, []float64{30, 40}, ascendingRates(blocks[1]))
+	assert.Equal(t, []float64{20}, ascendingRates(blocks[2]))
+}
+
+func TestFilterPoolTxsRemovesStuckAndCheapTxs(t *testing.T) {
+	// arrange
+	now := time.Unix(1500000000, 0)
+	txs := []poolTx{
+		{hash: "fresh", feeRate: 10, time: now.Add(-time.Hour).Unix()},
+		{hash: "stuck", feeRate: 10, time: now.Add(-48 * time.Hour).Unix()},
+		{hash: "cheap", feeRate: 0.5, time: now.Unix()},
+		{hash: "unknown age", feeRate: 10},
+	}
+
+	// act
+	filtered := filterPoolTxs(txs, now, 24*time.Hour, 1)
+	unlimited := filterPoolTxs(txs, now, 0, 0)
+
+	// assert
+	var hashes []string
+	for _, tx := range filtered {
+		hashes = append(hashes, tx.hash)
+	}
+	assert.Equal(t, []string{"fresh", "unknown age"}, hashes)
+	assert.Len(t, unlimited, len(txs))
+}
+
+func TestWeightedPercentileWeightsByVsize(t *testing.T) {
+	// arrange: many tiny cheap txs and one large expensive tx
+	txs := []poolTx{{vsize: 10000, feeRate: 50}}
+	for i := 0; i < 100; i++ {
+		txs = append(txs, poolTx{vsize: 10, feeRate: 1})
+	}
+
+	// act
+	median, position := weightedPercentile(txs, 50)
+	lowest, _ := weightedPercentile(txs, 0)
+	low, _ := weightedPercentile(txs, 5)
+
+	// assert
+	assert.Equal(t, 50.0, median, "the large tx uses most of the block space")
+	assert.Equal(t, 100, position)
+	assert.Equal(t, 1.0, lowest)
+	assert.Equal(t, 1.0, low)
+}
+
+func TestAverageBlockWeight(t *testing.T) {
+	// act & assert
+	assert.Equal(t, int64(3000000), averageBlockWeight(15000000, 5))
+	assert.Equal(t, int64(maxTxWeight), averageBlockWeight(25000000, 5), "bounded by the block weight limit")
+	assert.Equal(t, int64(maxTxWeight), averageBlockWeight(0, 5), "empty blocks do not stall the projection")
+	assert.Equal(t, int64(maxTxWeight), averageBlockWeight(0, 0))
+}
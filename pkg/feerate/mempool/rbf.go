@@ -0,0 +1,236 @@
+package mempool
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	// DetectReplacements enables leaving txs replaced by fee (BIP125) out of
+	// the estimation. The mempool cache keeps every tx seen at a height, so
+	// without it both a tx and its replacement are counted. Detection fetches
+	// each mempool tx once to learn its inputs.
+	DetectReplacements = true
+
+	// maxReplaceableSequence is the highest input sequence number that
+	// signals replaceability as defined by BIP125
+	maxReplaceableSequence uint32 = 0xfffffffd
+)
+
+// txInputs are the outpoints a mempool tx spends and whether it signals
+// replaceability itself
+type txInputs struct {
+	outpoints []string
+	signals   bool
+}
+
+// fetchInputs fetches the inputs of the txs in pool that are not known yet,
+// see updateInputs. The txs are fetched in batches, txs that cannot be fetched
+// have likely left the node's mempool already and are skipped. mu must not be
+// held, it is only taken to look up the known inputs.
+func (e *Estimator) fetchInputs(pool map[string]utils.MempoolEntry) map[string]txInputs {
+	var hashes []*chainhash.Hash
+	e.mu.Lock()
+	for hash := range pool {
+		if _, ok := e.inputs[hash]; ok {
+			continue
+		}
+
+		txHash, err := chainhash.NewHashFromStr(hash)
+		if err != nil {
+			continue
+		}
+
+		hashes = append(hashes, txHash)
+	}
+	e.mu.Unlock()
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	rawTxs, err := e.client.GetRawTransactionsVerbose(hashes)
+	if err != nil {
+		e.logger.Debug("could not get inputs of mempool txs", zap.Int("txs", len(hashes)), zap.Error(err))
+		return nil
+	}
+
+	fetched := make(map[string]txInputs, len(rawTxs))
+	for i, rawTx := range rawTxs {
+		if rawTx != nil {
+			fetched[hashes[i].String()] = newTxInputs(rawTx)
+		}
+	}
+
+	return fetched
+}
+
+// updateInputs adds the fetched inputs and forgets the ones of txs that left
+// pool. The caller must hold mu.
+func (e *Estimator) updateInputs(pool map[string]utils.MempoolEntry, fetched map[string]txInputs) {
+	for hash := range e.inputs {
+		if _, ok := pool[hash]; !ok {
+			delete(e.inputs, hash)
+		}
+	}
+
+	for hash, inputs := range fetched {
+		if _, ok := pool[hash]; ok {
+			e.inputs[hash] = inputs
+		}
+	}
+}
+
+func newTxInputs(rawTx *btcjson.TxRawResult) txInputs {
+	inputs := txInputs{
+		outpoints: make([]string, 0, len(rawTx.Vin)),
+	}
+	for _, in := range rawTx.Vin {
+		inputs.outpoints = append(inputs.outpoints, fmt.Sprintf("%v:%v", in.Txid, in.Vout))
+		if in.Sequence <= maxReplaceableSequence {
+			inputs.signals = true
+		}
+	}
+
+	return inputs
+}
+
+// withoutReplaced returns the txs of pool that have not been replaced, see
+// replacedTxs
+func withoutReplaced(pool map[string]utils.MempoolEntry, inputs map[string]txInputs) map[string]utils.MempoolEntry {
+	replaced := replacedTxs(pool, inputs)
+	if len(replaced) == 0 {
+		return pool
+	}
+
+	filtered := make(map[string]utils.MempoolEntry, len(pool)-len(replaced))
+	for hash, entry := range pool {
+		if _, ok := replaced[hash]; !ok {
+			filtered[hash] = entry
+		}
+	}
+
+	return filtered
+}
+
+// replacedTxs returns the txs of pool that cannot be in the node's mempool
+// anymore because a conflicting tx spending one of their inputs replaced
+// them. Conflicting txs are replayed in the order they were seen: a later tx
+// replaces the one it conflicts with if that signals replaceability, directly
+// or through an unconfirmed ancestor, and the later tx pays a higher fee.
+// Otherwise the later tx is the one that never made it into the mempool.
+// Descendants of a replaced tx are evicted along with it.
+func replacedTxs(pool map[string]utils.MempoolEntry, inputs map[string]txInputs) map[string]struct{} {
+	hashes := make([]string, 0, len(inputs))
+	for hash := range inputs {
+		if _, ok := pool[hash]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		ti, tj := pool[hashes[i]].Time, pool[hashes[j]].Time
+		if ti != tj {
+			return ti < tj
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	replaced := make(map[string]struct{})
+	spentBy := make(map[string]string) //outpoint->hash of the tx spending it
+	for _, hash := range hashes {
+		var conflicts []string
+		for _, outpoint := range inputs[hash].outpoints {
+			if spender, ok := spentBy[outpoint]; ok {
+				conflicts = append(conflicts, spender)
+			}
+		}
+
+		replaces := true
+		for _, conflict := range conflicts {
+			if !signalsReplaceability(conflict, pool, inputs) || pool[hash].Fee <= pool[conflict].Fee {
+				replaces = false
+				break
+			}
+		}
+
+		if !replaces {
+			replaced[hash] = struct{}{}
+			continue
+		}
+
+		for _, conflict := range conflicts {
+			replaced[conflict] = struct{}{}
+			for _, outpoint := range inputs[conflict].outpoints {
+				delete(spentBy, outpoint)
+			}
+		}
+		for _, outpoint := range inputs[hash].outpoints {
+			spentBy[outpoint] = hash
+		}
+	}
+
+	return withDescendants(pool, replaced)
+}
+
+// signalsReplaceability reports whether the tx or one of its unconfirmed
+// ancestors in pool signals replaceability. The answer of the node is used if
+// it reported one, otherwise it is derived from the inputs.
+func signalsReplaceability(hash string, pool map[string]utils.MempoolEntry, inputs map[string]txInputs) bool {
+	switch pool[hash].BIP125Replaceable {
+	case "yes":
+		return true
+	case "no":
+		return false
+	}
+
+	seen := map[string]struct{}{hash: {}}
+	stack := []string{hash}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if inputs[current].signals {
+			return true
+		}
+
+		for _, parent := range pool[current].Depends {
+			if _, ok := seen[parent]; ok {
+				continue
+			}
+			if _, ok := pool[parent]; !ok {
+				continue
+			}
+
+			seen[parent] = struct{}{}
+			stack = append(stack, parent)
+		}
+	}
+
+	return false
+}
+
+// withDescendants extends txs by all their descendants in pool
+func withDescendants(pool map[string]utils.MempoolEntry, txs map[string]struct{}) map[string]struct{} {
+	for changed := len(txs) > 0; changed; {
+		changed = false
+		for hash, entry := range pool {
+			if _, ok := txs[hash]; ok {
+				continue
+			}
+
+			for _, parent := range entry.Depends {
+				if _, ok := txs[parent]; ok {
+					txs[hash] = struct{}{}
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	return txs
+}
@@ -0,0 +1,148 @@
+package mempool
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReplacedTxsDropsReplacedOriginals(t *testing.T) {
+	// arrange
+	pool := map[string]utils.MempoolEntry{
+		"original":    {Fee: 0.0001, Time: 1},
+		"child":       {Fee: 0.0001, Time: 2, Depends: []string{"original"}},
+		"replacement": {Fee: 0.0002, Time: 3},
+		"final":       {Fee: 0.0001, Time: 4},
+		"doublespend": {Fee: 0.0005, Time: 5},
+		"unrelated":   {Fee: 0.0001, Time: 6},
+	}
+	inputs := map[string]txInputs{
+		"original":    {outpoints: []string{"a:0"}, signals: true},
+		"child":       {outpoints: []string{"original:0"}},
+		"replacement": {outpoints: []string{"a:0", "b:1"}},
+		"final":       {outpoints: []string{"c:0"}},
+		"doublespend": {outpoints: []string{"c:0"}},
+		"unrelated":   {outpoints: []string{"d:0"}},
+	}
+
+	// act
+	replaced := replacedTxs(pool, inputs)
+	filtered := withoutReplaced(pool, inputs)
+
+	// assert
+	assert.Equal(t, map[string]struct{}{
+		"original":    {},
+		"child":       {},
+		"doublespend": {},
+	}, replaced)
+	assert.Len(t, filtered, 3)
+	assert.Contains(t, filtered, "replacement")
+	assert.Contains(t, filtered, "final")
+	assert.Contains(t, filtered, "unrelated")
+}
+
+func TestSignalsReplaceabilityIsInherited(t *testing.T) {
+	// arrange
+	pool := map[string]utils.MempoolEntry{
+		"parent": {},
+		"child":  {Depends: []string{"parent", "confirmed"}},
+	}
+	inputs := map[string]txInputs{
+		"parent": {signals: true},
+		"child":  {},
+	}
+
+	// act & assert
+	assert.True(t, signalsReplaceability("child", pool, inputs))
+	assert.False(t, signalsReplaceability("child", pool, map[string]txInputs{}))
+}
+
+func TestSignalsReplaceabilityPrefersNodeAnswer(t *testing.T) {
+	// arrange
+	pool := map[string]utils.MempoolEntry{
+		"yes": {BIP125Replaceable: "yes"},
+		"no":  {BIP125Replaceable: "no"},
+	}
+	inputs := map[string]txInputs{
+		"no": {signals: true},
+	}
+
+	// act & assert
+	assert.True(t, signalsReplaceability("yes", pool, inputs))
+	assert.False(t, signalsReplaceability("no", pool, inputs))
+}
+
+func TestNewTxInputs(t *testing.T) {
+	// arrange
+	rawTx := &btcjson.TxRawResult{
+		Vin: []btcjson.Vin{
+			{Txid: "a", Vout: 1, Sequence: 0xffffffff},
+			{Txid: "b", Vout: 0, Sequence: 0xfffffffd},
+		},
+	}
+
+	// act
+	inputs := newTxInputs(rawTx)
+
+	// assert
+	assert.Equal(t, []string{"a:1", "b:0"}, inputs.outpoints)
+	assert.True(t, inputs.signals)
+}
+
+func TestFetchInputsFetchesTxsInBatches(t *testing.T) {
+	// arrange
+	var batches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var requests []struct {
+			ID     int           `json:"id"`
+			Params []interface{} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		atomic.AddInt32(&batches, 1)
+		responses := make([]map[string]interface{}, len(requests))
+		for i, request := range requests {
+			responses[i] = map[string]interface{}{
+				"id": request.ID,
+				"result": btcjson.TxRawResult{
+					Txid: request.Params[0].(string),
+					Vin:  []btcjson.Vin{{Txid: "a", Vout: 1, Sequence: 0}},
+				},
+			}
+		}
+		utils.IgnoreError(json.NewEncoder(w).Encode(responses))
+	}))
+	defer server.Close()
+
+	client, err := utils.NewCachedRPCClientWithConfig(utils.NodeConfig{URL: server.URL}, zap.NewNop(), nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	e := NewEstimator(zap.NewNop(), client, nil, nil)
+	first, second := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	pool := map[string]utils.MempoolEntry{first: {}, second: {}}
+
+	// act
+	fetched := e.fetchInputs(pool)
+	e.mu.Lock()
+	e.updateInputs(pool, fetched)
+	e.mu.Unlock()
+
+	// assert
+	assert.Equal(t, int32(1), atomic.LoadInt32(&batches))
+	require.Len(t, e.inputs, 2)
+	assert.Equal(t, []string{"a:1"}, e.inputs[first].outpoints)
+	assert.True(t, e.inputs[second].signals)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
-	"sort"
 	"strconv"
 	"time"
 
@@ -20,6 +19,9 @@ type score struct {
 
 type rate struct {
 	predictedRate float64
+	preset        Preset
+	percentile    float64
+	provenance    *Provenance
 	scores        map[int]*score
 }
 
@@ -42,16 +44,24 @@ func newScores(logger *zap.Logger) *scores {
 	}
 }
 
-func (s *scores) addPrediction(height int, rates *feerate.FeeRates, predictedRate float64) {
+func (s *scores) addPrediction(height int, rates *feerate.FeeRates, estimate Estimate) {
+	predicted := rate{
+		predictedRate: estimate.Rate,
+		preset:        estimate.Preset,
+		percentile:    estimate.Percentile,
+		provenance:    estimate.Provenance,
+		scores:        make(map[int]*score),
+	}
+
 	_, ok := s.predictions[height]
 	if !ok {
 		s.predictions[height] = &prediction{
 			height:         height,
 			feeRates:       rates,
-			predictedRates: []rate{rate{predictedRate: predictedRate, scores: make(map[int]*score)}},
+			predictedRates: []rate{predicted},
 		}
 	} else {
-		s.predictions[height].predictedRates = append(s.predictions[height].predictedRates, rate{predictedRate: predictedRate, scores: make(map[int]*score)})
+		s.predictions[height].predictedRates = append(s.predictions[height].predictedRates, predicted)
 	}
 }
 
@@ -74,8 +84,16 @@ func (s *scores) flush() error {
 	w := csv.NewWriter(f)
 	err = w.Write([]string{
 		"block_number",
+		"preset",
+		"target",
 		"priceStandard",
 		"numberOfTxs",
+		"percentile",
+		"snapshotTime",
+		"consideredTxs",
+		"minFeeRate",
+		"blockWeight",
+		"inflowVsize",
 		"scoreStandardPlus1",
 		"scoreStandardPlus2",
 		"scoreStandardPlus3",
@@ -97,10 +115,14 @@ func (s *scores) flush() error {
 		for _, rate := range prediction.predictedRates {
 			record := []string{
 				strconv.Itoa(blockHeight),
+				rate.preset.Name,
+				strconv.Itoa(rate.preset.Target),
 				strconv.FormatFloat(rate.predictedRate, 'f', 3, 64),
 				strconv.Itoa(prediction.feeRates.NumberOfTxs),
+				strconv.FormatFloat(rate.percentile, 'f', 1, 64),
 			}
-			for i := blockHeight + 1; i < blockHeight+11; i++ {
+			record = append(record, provenanceRecord(rate.provenance)...)
+			for i := blockHeight + 1; i <= blockHeight+feerate.ScoredBlocks; i++ {
 				score, ok := rate.scores[i]
 				if !ok {
 					record = append(record, strconv.Itoa(-1))
@@ -118,7 +140,7 @@ func (s *scores) flush() error {
 }
 
 func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *prediction) {
-	for i := blockNumber + 1; i < blockNumber+11; i++ {
+	for i := blockNumber + 1; i <= blockNumber+feerate.ScoredBlocks; i++ {
 		for _, rate := range predict.predictedRates {
 			_, ok := rate.scores[i]
 			if !ok {
@@ -129,7 +151,7 @@ func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *predi
 				}
 
 				for _, rate := range predict.predictedRates {
-					scoreStandard := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, rate.predictedRate)
+					scoreStandard := feerate.HigherFeeRateShare(targetPrediction.feeRates.Rates, rate.predictedRate)
 					rate.scores[i] = &score{
 						ScoreStandard: scoreStandard,
 						NumberOfTxs:   targetPrediction.feeRates.NumberOfTxs,
@@ -140,14 +162,18 @@ func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *predi
 	}
 }
 
-func (s *scores) getPercentageOfTxsWithHigherFeeRate(feeRates []int, prediction float64) float64 {
-	sort.Ints(feeRates)
-	for idx, feeRate := range feeRates {
-		if float64(feeRate) > prediction {
-			percentage := (1.0 - (float64(idx) / float64(len(feeRates)))) * 100.0 //(1-idx/txs)*100
-			return percentage
-		}
+// provenanceRecord returns the csv columns describing what a prediction was
+// made from
+func provenanceRecord(p *Provenance) []string {
+	if p == nil {
+		return []string{"", "-1", "-1", "-1", "-1"}
 	}
 
-	return 0
+	return []string{
+		p.SnapshotTime.Format(time.RFC3339),
+		strconv.Itoa(p.TxCount),
+		strconv.FormatFloat(p.Model.MinFeeRate, 'f', 3, 64),
+		strconv.FormatInt(p.Model.BlockWeight, 10),
+		strconv.FormatInt(p.Model.InflowVsize, 10),
+	}
 }
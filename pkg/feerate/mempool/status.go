@@ -0,0 +1,60 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+var (
+	// RetryAttempts is the number of times a failed estimation step is tried
+	// again before the estimation round is given up
+	RetryAttempts = 3
+
+	// RetryBackoff is the pause before the first retry, it doubles with
+	// every further retry
+	RetryBackoff = time.Second
+)
+
+// retry calls fn until it succeeds or RetryAttempts retries failed. A
+// missing mempool cache, an empty mempool or invalid options are returned
+// right away, trying again does not change them before the next round. So is
+// cancellation.
+func (e *Estimator) retry(ctx context.Context, op string, fn func() error) error {
+	return utils.Retry(ctx, e.logger, op, RetryAttempts, RetryBackoff, isPermanent, fn)
+}
+
+// isPermanent reports whether err does not go away by trying again within
+// the round
+func isPermanent(err error) bool {
+	return err == feerate.ErrCacheNotExists || err == ErrEmptyMempool || err == context.Canceled || errors.Is(err, ErrInvalidOptions)
+}
+
+// Status describes the health of an Estimator
+type Status struct {
+	utils.RunStatus
+	LastHeight int32 `json:"lastHeight"`
+}
+
+// Status returns the health of the estimator
+func (e *Estimator) Status() Status {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+
+	return e.status
+}
+
+// recordResult updates the status with the outcome of an estimation round
+// at height
+func (e *Estimator) recordResult(height int32, err error) {
+	e.statusMu.Lock()
+	defer e.statusMu.Unlock()
+
+	e.status.Record(err, time.Now())
+	if err == nil && height > 0 {
+		e.status.LastHeight = height
+	}
+}
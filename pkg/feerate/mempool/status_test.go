@@ -0,0 +1,67 @@
+package mempool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRetryGivesUpWithTransientError(t *testing.T) {
+	// arrange
+	e := &Estimator{logger: zap.NewNop()}
+	backoff := RetryBackoff
+	RetryBackoff = 0
+	defer func() { RetryBackoff = backoff }()
+
+	calls := 0
+
+	// act
+	err := e.retry(context.Background(), "estimate", func() error {
+		calls++
+		return errors.New("connection refused")
+	})
+
+	// assert
+	assert.Equal(t, RetryAttempts+1, calls)
+	assert.True(t, utils.IsTransient(err))
+	assert.Contains(t, err.Error(), "estimate")
+}
+
+func TestRetryDoesNotRetryMissingCache(t *testing.T) {
+	// arrange
+	e := &Estimator{logger: zap.NewNop()}
+	calls := 0
+
+	// act
+	err := e.retry(context.Background(), "estimate", func() error {
+		calls++
+		return feerate.ErrCacheNotExists
+	})
+
+	// assert
+	assert.Equal(t, feerate.ErrCacheNotExists, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestStatusRecordsResults(t *testing.T) {
+	// arrange
+	e := &Estimator{logger: zap.NewNop()}
+
+	// act
+	e.recordResult(100, nil)
+	e.recordResult(0, &utils.TransientError{Op: "estimate", Err: errors.New("timeout")})
+	e.recordResult(0, errors.New("unexpected"))
+	status := e.Status()
+
+	// assert
+	assert.Equal(t, int32(100), status.LastHeight)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Equal(t, 1, status.TransientFailures)
+	assert.Equal(t, "unexpected", status.LastError)
+	assert.False(t, status.LastSuccess.IsZero())
+}
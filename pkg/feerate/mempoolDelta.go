@@ -0,0 +1,99 @@
+package feerate
+
+import (
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+// Delta is the difference between the mempool snapshots of two heights
+type Delta struct {
+	FromHeight int32
+	ToHeight   int32
+
+	// Added holds the txs of the later snapshot that are not in the
+	// earlier one
+	Added map[string]utils.MempoolEntry
+
+	// Confirmed holds the txs of the earlier snapshot that were mined in a
+	// block up to ToHeight
+	Confirmed map[string]utils.MempoolEntry
+
+	// Removed holds the txs of the earlier snapshot that left the mempool
+	// unmined, e.g. because they were replaced or evicted
+	Removed map[string]utils.MempoolEntry
+}
+
+// GetDelta returns the txs added to and confirmed or removed from the
+// mempool between the snapshots at fromHeight and toHeight. Confirmed txs are
+// looked up in the blocks after fromHeight up to toHeight.
+func (c *MempoolCache) GetDelta(fromHeight int32, toHeight int32) (*Delta, error) {
+	if fromHeight > toHeight {
+		return nil, ErrInvalidRange
+	}
+
+	from, to, err := c.snapshotCopies(fromHeight, toHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	mined, err := c.minedTxs(fromHeight+1, toHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := snapshotDelta(from, to, mined)
+	delta.FromHeight = fromHeight
+	delta.ToHeight = toHeight
+	return delta, nil
+}
+
+// snapshotCopies returns copies of the snapshots at both heights, so that
+// blocks can be fetched without holding mu while the cache keeps updating
+func (c *MempoolCache) snapshotCopies(fromHeight int32, toHeight int32) (map[string]utils.MempoolEntry, map[string]utils.MempoolEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, ok := c.mempoolCache[fromHeight]
+	if !ok {
+		return nil, nil, ErrCacheNotExists
+	}
+
+	to, ok := c.mempoolCache[toHeight]
+	if !ok {
+		return nil, nil, ErrCacheNotExists
+	}
+
+	return copyPool(from), copyPool(to), nil
+}
+
+func copyPool(pool map[string]utils.MempoolEntry) map[string]utils.MempoolEntry {
+	copied := make(map[string]utils.MempoolEntry, len(pool))
+	for hash, entry := range pool {
+		copied[hash] = entry
+	}
+
+	return copied
+}
+
+// snapshotDelta returns the difference between the snapshots from and to
+// given the txs mined in between
+func snapshotDelta(from map[string]utils.MempoolEntry, to map[string]utils.MempoolEntry, mined map[string]struct{}) *Delta {
+	delta := &Delta{
+		Added:     make(map[string]utils.MempoolEntry),
+		Confirmed: make(map[string]utils.MempoolEntry),
+		Removed:   evictedTxs(from, to, mined),
+	}
+
+	for hash, entry := range to {
+		if _, ok := from[hash]; !ok {
+			delta.Added[hash] = entry
+		}
+	}
+
+	for hash, entry := range from {
+		if _, ok := mined[hash]; ok {
+			delta.Confirmed[hash] = entry
+		}
+	}
+
+	return delta
+}
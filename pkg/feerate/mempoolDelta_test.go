@@ -0,0 +1,45 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestSnapshotDelta(t *testing.T) {
+	// arrange
+	from := map[string]utils.MempoolEntry{
+		"mined":    {Fee: 0.0001},
+		"replaced": {Fee: 0.0002},
+		"remained": {Fee: 0.0003},
+	}
+	to := map[string]utils.MempoolEntry{
+		"remained": {Fee: 0.0003},
+		"new":      {Fee: 0.0004},
+	}
+	mined := map[string]struct{}{"mined": {}}
+
+	// act
+	delta := snapshotDelta(from, to, mined)
+
+	// assert
+	assert.Equal(t, map[string]utils.MempoolEntry{"new": {Fee: 0.0004}}, delta.Added)
+	assert.Equal(t, map[string]utils.MempoolEntry{"mined": {Fee: 0.0001}}, delta.Confirmed)
+	assert.Equal(t, map[string]utils.MempoolEntry{"replaced": {Fee: 0.0002}}, delta.Removed)
+}
+
+func TestGetDeltaRequiresBothSnapshots(t *testing.T) {
+	// arrange
+	c := NewMempoolCacheWithDir(zap.NewNop(), nil, "")
+	c.mempoolCache[100] = map[string]utils.MempoolEntry{}
+
+	// act
+	_, missing := c.GetDelta(100, 101)
+	_, invalid := c.GetDelta(101, 100)
+
+	// assert
+	assert.Equal(t, ErrCacheNotExists, missing)
+	assert.Equal(t, ErrInvalidRange, invalid)
+}
@@ -0,0 +1,86 @@
+package feerate
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	// ResyncInterval is how often the cache polls the full mempool while it
+	// is updated from notifications, to learn about txs that left it
+	ResyncInterval = 10 * time.Minute
+)
+
+// RunWithNotifications keeps the cache up to date from the websocket
+// notifications of a btcd node: accepted txs are added to the snapshot of the
+// current height as they arrive, and the full mempool is polled whenever a
+// block is connected and every ResyncInterval. If the node cannot be
+// subscribed to, the cache falls back to polling like Run. A failed poll is
+// logged and tried again with the next one.
+func (c *MempoolCache) RunWithNotifications(config *rpcclient.ConnConfig) {
+	notifier, err := c.client.Notify(config)
+	if err != nil {
+		c.logger.Warn("could not subscribe to mempool notifications, polling instead", zap.Error(err))
+		c.Run()
+		return
+	}
+	defer notifier.Close()
+
+	c.poll()
+
+	ticker := time.NewTicker(ResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case hash := <-notifier.Txs:
+			err := c.addTx(hash.String())
+			if err != nil {
+				// the tx most likely left the mempool again already
+				c.logger.Debug("could not add accepted tx", zap.String("hash", hash.String()), zap.Error(err))
+			}
+		case <-notifier.Blocks:
+			c.poll()
+		case <-ticker.C:
+			c.poll()
+		}
+	}
+}
+
+// addTx fetches the mempool entry of an accepted tx and adds it to the
+// snapshot of the current height
+func (c *MempoolCache) addTx(hash string) error {
+	entry, err := c.client.GetMempoolEntry(hash)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addEntry(hash, *entry, time.Now())
+	return nil
+}
+
+// addEntry adds the entry to the snapshot of the last recorded height and to
+// the previous raw snapshot, so that the tx is recorded as evicted if it leaves
+// the mempool unmined before the next poll. Entries arriving before the first
+// poll are left to it. The caller must hold mu.
+func (c *MempoolCache) addEntry(hash string, entry utils.MempoolEntry, now time.Time) {
+	pool, ok := c.mempoolCache[c.lastRecordedHeight]
+	if !ok {
+		return
+	}
+
+	if _, ok := pool[hash]; ok {
+		return
+	}
+
+	pool[hash] = entry
+	if c.lastPool != nil {
+		c.lastPool[hash] = entry
+	}
+	c.recordSeen(c.lastRecordedHeight, map[string]utils.MempoolEntry{hash: entry}, now)
+}
@@ -0,0 +1,149 @@
+package feerate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const snapshotSuffix = ".json.gz"
+
+// DefaultMempoolDir is where MempoolCache persists its snapshots by default
+var DefaultMempoolDir = "./output/mempool"
+
+// mempoolSnapshot is the persisted form of the mempool cached for a height
+type mempoolSnapshot struct {
+	Height  int32                         `json:"height"`
+	Pool    map[string]utils.MempoolEntry `json:"pool"`
+	Evicted map[string]utils.MempoolEntry `json:"evicted"`
+
+	// FirstSeen is when the cache first saw the txs of Pool
+	FirstSeen map[string]time.Time `json:"firstSeen"`
+}
+
+func (c *MempoolCache) snapshotFileName(height int32) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%v%v", height, snapshotSuffix))
+}
+
+// flush persists the mempool cached for height as gzip compressed json. The
+// caller must hold mu.
+func (c *MempoolCache) flush(height int32) error {
+	snapshot := mempoolSnapshot{
+		Height:    height,
+		Pool:      c.mempoolCache[height],
+		Evicted:   c.evicted[height],
+		FirstSeen: make(map[string]time.Time, len(c.mempoolCache[height])),
+	}
+	for hash := range snapshot.Pool {
+		if seen, ok := c.seen[hash]; ok {
+			snapshot.FirstSeen[hash] = seen.first
+		}
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	err := json.NewEncoder(w).Encode(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFile(c.snapshotFileName(height), buf.Bytes())
+}
+
+// LoadFromDisk restores the snapshots persisted in the data dir, so that the
+// cache and the estimators depending on it survive restarts. Only the heights
+// the retention allows are kept. A missing data dir is not an error.
+func (c *MempoolCache) LoadFromDisk() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loaded := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), snapshotSuffix) {
+			continue
+		}
+
+		snapshot, err := readSnapshot(filepath.Join(c.dir, file.Name()))
+		if err != nil {
+			c.logger.Error("could not load mempool snapshot", zap.String("file", file.Name()), zap.Error(err))
+			continue
+		}
+
+		c.mempoolCache[snapshot.Height] = snapshot.Pool
+		for hash, first := range snapshot.FirstSeen {
+			seen, ok := c.seen[hash]
+			if !ok {
+				c.seen[hash] = &seenTx{first: first, lastHeight: snapshot.Height}
+				continue
+			}
+
+			if first.Before(seen.first) {
+				seen.first = first
+			}
+			if snapshot.Height > seen.lastHeight {
+				seen.lastHeight = snapshot.Height
+			}
+		}
+		if len(snapshot.Evicted) > 0 {
+			c.evicted[snapshot.Height] = snapshot.Evicted
+		}
+		if snapshot.Height > c.lastRecordedHeight {
+			c.lastRecordedHeight = snapshot.Height
+			c.lastPool = snapshot.Pool
+		}
+		loaded++
+	}
+
+	c.prune()
+	c.logger.Info("loaded mempool snapshots", zap.Int("snapshots", loaded), zap.Int32("last height", c.lastRecordedHeight))
+	return nil
+}
+
+func readSnapshot(fileName string) (*mempoolSnapshot, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var snapshot mempoolSnapshot
+	err = json.NewDecoder(r).Decode(&snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot.Pool == nil {
+		snapshot.Pool = make(map[string]utils.MempoolEntry)
+	}
+
+	return &snapshot, nil
+}
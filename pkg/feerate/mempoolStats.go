@@ -0,0 +1,66 @@
+package feerate
+
+import (
+	"unsafe"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+// mapEntryOverhead approximates the memory a map needs per entry on top of
+// the key and value
+const mapEntryOverhead = 48
+
+// MempoolCacheStats describe the contents of a MempoolCache for monitoring
+type MempoolCacheStats struct {
+	Snapshots    int   `json:"snapshots"`
+	OldestHeight int32 `json:"oldestHeight"`
+	NewestHeight int32 `json:"newestHeight"`
+	Txs          int   `json:"txs"`
+	EvictedTxs   int   `json:"evictedTxs"`
+
+	// Bytes is an estimate of the memory held by the cached snapshots
+	Bytes int64 `json:"bytes"`
+}
+
+// Stats returns the number of cached snapshots and txs and an estimate of
+// the memory they take up
+func (c *MempoolCache) Stats() MempoolCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := MempoolCacheStats{Snapshots: len(c.mempoolCache)}
+	for height, pool := range c.mempoolCache {
+		if stats.OldestHeight == 0 || height < stats.OldestHeight {
+			stats.OldestHeight = height
+		}
+		if height > stats.NewestHeight {
+			stats.NewestHeight = height
+		}
+
+		stats.Txs += len(pool)
+		stats.Bytes += poolBytes(pool)
+	}
+
+	for _, evicted := range c.evicted {
+		stats.EvictedTxs += len(evicted)
+		stats.Bytes += poolBytes(evicted)
+	}
+
+	return stats
+}
+
+// poolBytes estimates the memory held by pool
+func poolBytes(pool map[string]utils.MempoolEntry) int64 {
+	var entry utils.MempoolEntry
+	perEntry := int64(unsafe.Sizeof(entry)) + int64(unsafe.Sizeof("")) + mapEntryOverhead
+
+	bytes := int64(len(pool)) * perEntry
+	for hash, entry := range pool {
+		bytes += int64(len(hash))
+		for _, depends := range entry.Depends {
+			bytes += int64(unsafe.Sizeof(depends)) + int64(len(depends))
+		}
+	}
+
+	return bytes
+}
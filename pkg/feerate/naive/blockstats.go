@@ -0,0 +1,66 @@
+package naive
+
+import (
+	"math"
+)
+
+// blockStatsPercentiles are the percentiles getblockstats returns fee rates at
+var blockStatsPercentiles = []float64{10, 25, 50, 75, 90}
+
+// estimateFromBlockStats returns the fee rate at percentile over the
+// windowSize blocks up to height, taken from getblockstats. The
+// percentile of each block is weighted by the number of its txs, so that the
+// window is taken over txs like in the per tx mode.
+func (e *Estimator) estimateFromBlockStats(height int32, percentile int, windowSize int) (Suggestion, error) {
+	suggestion := Suggestion{Percentile: percentile}
+
+	var weighted float64
+	var txs int64
+	for h := height; h > height-int32(windowSize) && h >= 0; h-- {
+		hash, err := e.client.GetBlockHash(int64(h))
+		if err != nil {
+			return suggestion, err
+		}
+
+		stats, err := e.client.GetBlockStats(hash)
+		if err != nil {
+			return suggestion, err
+		}
+
+		blockTxs := stats.Txs - 1 // coinbase
+		if blockTxs <= 0 || len(stats.FeeRatePercentiles) != len(blockStatsPercentiles) {
+			continue
+		}
+
+		weighted += interpolatePercentile(stats.FeeRatePercentiles, float64(percentile)) * float64(blockTxs)
+		txs += blockTxs
+	}
+
+	suggestion.SampleSize = int(txs)
+	if txs == 0 {
+		return suggestion, nil
+	}
+
+	suggestion.Rate = int(math.Round(weighted / float64(txs)))
+	suggestion.cap(e.options.MaxFeeRate)
+	return suggestion, nil
+}
+
+// interpolatePercentile returns the fee rate at percentile from the rates at
+// blockStatsPercentiles by linear interpolation. Percentiles outside of them
+// are clamped, the rates beyond are unknown.
+func interpolatePercentile(rates []float64, percentile float64) float64 {
+	if percentile <= blockStatsPercentiles[0] {
+		return rates[0]
+	}
+
+	for i := 1; i < len(blockStatsPercentiles); i++ {
+		if percentile <= blockStatsPercentiles[i] {
+			lower, upper := blockStatsPercentiles[i-1], blockStatsPercentiles[i]
+			share := (percentile - lower) / (upper - lower)
+			return rates[i-1] + share*(rates[i]-rates[i-1])
+		}
+	}
+
+	return rates[len(rates)-1]
+}
@@ -1,6 +1,7 @@
 package naive
 
 import (
+	"math"
 	"sort"
 	"time"
 
@@ -18,15 +19,24 @@ type Estimator struct {
 	lastObservedHeight int32
 	scores             *scores
 	ratesCache         *feerate.RateCache
+	smoothed           float64 // EWMA of the estimates, 0 until the first estimate
+	options            Options
+	pinned             []int32 // heights pinned in ratesCache while being scored
 }
 
-// NewEstimator creates a new naive bitcoin fee estimator
+// NewEstimator creates a new naive bitcoin fee estimator with the default options
 func NewEstimator(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache) *Estimator {
+	return NewEstimatorWithOptions(logger, client, ratesCache, DefaultOptions())
+}
+
+// NewEstimatorWithOptions creates a new naive bitcoin fee estimator
+func NewEstimatorWithOptions(logger *zap.Logger, client *utils.CachedRPCClient, ratesCache *feerate.RateCache, options Options) *Estimator {
 	return &Estimator{
 		client:     client,
 		logger:     logger,
 		scores:     newScores(logger),
 		ratesCache: ratesCache,
+		options:    options,
 	}
 }
 
@@ -78,37 +88,245 @@ func (e *Estimator) EstimateFee() error {
 		return err
 	}
 
-	feeRates, err := e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+	var feeRates *feerate.FeeRates
+	if !e.options.BlockStats {
+		feeRates, err = e.ratesCache.GetFeeRatesForBlock(info.Blocks)
+		if err != nil {
+			return err
+		}
+	}
+
+	minFeeRate, err := e.getMinFeeRate()
 	if err != nil {
 		return err
 	}
 
+	suggestion, err := e.estimate(info.Blocks, info.BestBlockHash, feeRates, e.options.Percentile, e.options.WindowSize, minFeeRate)
+	if err != nil {
+		return err
+	}
+	rate := suggestion.Rate
+
+	tierRates := make([]int, len(e.options.Tiers))
+	for i, tier := range e.options.Tiers {
+		tierSuggestion, err := e.estimate(info.Blocks, info.BestBlockHash, feeRates, tier.Percentile, tier.WindowSize, minFeeRate)
+		if err != nil {
+			return err
+		}
+
+		tierRates[i] = tierSuggestion.Rate
+		e.logger.Info("estimated naive tier rate", zap.String("tier", tier.Name), zap.Int("target", tier.Target), zap.Int("rate", tierRates[i]), zap.Bool("floored", tierSuggestion.Floored))
+	}
+
+	blocks := int(info.Blocks - e.lastObservedHeight)
 	e.lastObservedHeight = info.Blocks
-	rate := SuggestFeeRate(feeRates.Rates)
-	e.scores.addPrediction(int(info.Blocks), feeRates, rate)
+	e.logger.Info("estimated naive rate", zap.Any("suggestion", suggestion), zap.Bool("block stats", e.options.BlockStats))
+	if e.options.SmoothingHalfLife > 0 {
+		e.smoothed = smooth(e.smoothed, float64(rate), blocks, e.options.SmoothingHalfLife)
+		e.logger.Info("smoothed naive rate", zap.Int("rate", rate), zap.Float64("smoothed", e.smoothed))
+	}
+
+	// block stats do not include the rates of the single txs the scores
+	// compare estimates with
+	if feeRates == nil {
+		return nil
+	}
+
+	e.pinScored(info.Blocks)
+	e.scores.addPrediction(int(info.Blocks), feeRates, SeriesNaive, rate)
+	if e.options.SmoothingHalfLife > 0 {
+		e.scores.addPrediction(int(info.Blocks), feeRates, SeriesSmoothed, int(math.Round(e.smoothed)))
+	}
+	for i, tier := range e.options.Tiers {
+		e.scores.addPrediction(int(info.Blocks), feeRates, SeriesNaive+"-"+tier.Name, tierRates[i])
+	}
+
 	e.scores.predictScores()
 	return nil
 }
 
-var (
-	//Percentile defines the position where the fee rate is estimated
-	//e.g. 50 means median value, 60 means a fee that is a little bit higher than the median
-	Percentile = 60
+// pinScored pins the rates of height in the rates cache while its prediction
+// is scored and releases the heights that are no longer scored
+func (e *Estimator) pinScored(height int32) {
+	e.ratesCache.Pin(height)
+	e.pinned = append(e.pinned, height)
+
+	scored := e.pinned[:0]
+	for _, h := range e.pinned {
+		if h+feerate.ScoredBlocks < height {
+			e.ratesCache.Unpin(h)
+			continue
+		}
+
+		scored = append(scored, h)
+	}
+	e.pinned = scored
+}
+
+const (
+	// SeriesNaive identifies the per block estimates in the scores
+	SeriesNaive = "naive"
+
+	// SeriesSmoothed identifies the exponentially weighted moving average
+	// of the estimates in the scores
+	SeriesSmoothed = "naive-smoothed"
 )
 
-// SuggestFeeRate returns the recommended fee rate in Satoshi per byte
-func SuggestFeeRate(feeRates []int) int {
-	if len(feeRates) > 0 {
-		sort.Ints(feeRates)
-		rate := feeRates[(len(feeRates)-1)*Percentile/100]
+// smooth adds rate to the exponentially weighted moving average previous,
+// which is blocks blocks old. The weight of previous halves every halfLife
+// blocks. A previous of 0 starts the average at rate.
+func smooth(previous float64, rate float64, blocks int, halfLife float64) float64 {
+	if previous == 0 {
+		return rate
+	}
+
+	decay := math.Pow(0.5, float64(blocks)/halfLife)
+	return decay*previous + (1-decay)*rate
+}
+
+// Suggestion is a fee rate suggested by the naive estimator
+type Suggestion struct {
+	// Rate is the suggested fee rate in satoshi per byte
+	Rate int `json:"rate"`
+
+	// Height and BlockHash identify the most recent block the rate was
+	// estimated from
+	Height    int32  `json:"height"`
+	BlockHash string `json:"blockHash"`
+
+	// SampleSize is the number of txs the percentile was taken over
+	SampleSize int `json:"sampleSize"`
+
+	// Percentile is the percentile of the fee rates that was used
+	Percentile int `json:"percentile"`
+
+	// Capped is set if the rate was lowered to the maximum fee rate
+	Capped bool `json:"capped"`
+
+	// Floored is set if the rate was raised to MinFeeRate
+	Floored bool `json:"floored"`
 
-		if rate > utils.MaxFeeRate {
-			rate = utils.MaxFeeRate
+	// MinFeeRate is the lowest rate in satoshi per byte the node accepts
+	// into its mempool
+	MinFeeRate int `json:"minFeeRate"`
+
+	// Trim reports the rates left out before taking the percentile
+	Trim TrimStats `json:"trim"`
+}
+
+// estimate suggests the fee rate at percentile of the windowSize blocks up
+// to the block at height with the given hash, but at least minFeeRate. tip
+// are the rates of the block at height, it is nil if the rates are taken
+// from block stats.
+func (e *Estimator) estimate(height int32, hash string, tip *feerate.FeeRates, percentile int, windowSize int, minFeeRate int) (*Suggestion, error) {
+	var suggestion Suggestion
+	if e.options.BlockStats {
+		var err error
+		suggestion, err = e.estimateFromBlockStats(height, percentile, windowSize)
+		if err != nil {
+			return nil, err
 		}
-		return rate
+	} else {
+		windowRates, children, err := e.getWindowRates(height, tip, windowSize)
+		if err != nil {
+			return nil, err
+		}
+
+		trimmed, stats := trimOutliers(windowRates, children, e.options.OutlierMADs)
+		suggestion = suggestFeeRate(trimmed, percentile, e.options.MaxFeeRate)
+		suggestion.Trim = stats
+	}
+
+	suggestion.Height = height
+	suggestion.BlockHash = hash
+	suggestion.MinFeeRate = minFeeRate
+	suggestion.floor()
+	return &suggestion, nil
+}
+
+// floor raises the rate to the minimum fee rate of the node, a tx paying
+// less would not even be relayed
+func (s *Suggestion) floor() {
+	if s.Rate < s.MinFeeRate {
+		s.Rate = s.MinFeeRate
+		s.Floored = true
+	}
+}
+
+// getMinFeeRate returns the lowest fee rate in satoshi per byte the node
+// accepts into its mempool, rounded up
+func (e *Estimator) getMinFeeRate() (int, error) {
+	info, err := e.client.GetMempoolInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	minFee := info.MempoolMinFee
+	if info.MinRelayTxFee > minFee {
+		minFee = info.MinRelayTxFee
+	}
+
+	// BTC/kB to satoshi/B
+	return int(math.Ceil(minFee * utils.BTC / 1000)), nil
+}
+
+// getWindowRates returns the fee rates of the windowSize blocks up to height,
+// tip being the rates of the block at height, and the rates of the CPFP
+// children among them
+func (e *Estimator) getWindowRates(height int32, tip *feerate.FeeRates, windowSize int) ([]int, []int, error) {
+	rates := appendWholeRates(nil, tip.Rates)
+	children := appendWholeRates(nil, tip.ChildRates)
+	for h := height - 1; h > height-int32(windowSize) && h >= 0; h-- {
+		feeRates, err := e.ratesCache.GetFeeRatesForBlock(h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rates = appendWholeRates(rates, feeRates.Rates)
+		children = appendWholeRates(children, feeRates.ChildRates)
 	}
 
-	return 0
+	return rates, children, nil
+}
+
+// appendWholeRates appends rates truncated to whole satoshi per byte, the
+// unit of naive estimates, to dst
+func appendWholeRates(dst []int, rates []float64) []int {
+	for _, rate := range rates {
+		dst = append(dst, int(rate))
+	}
+
+	return dst
+}
+
+// SuggestFeeRate returns the recommended fee rate in Satoshi per byte at
+// options.Percentile of feeRates, capped at options.MaxFeeRate. The block the
+// rates are from is not known here, so Height and BlockHash are not set.
+func SuggestFeeRate(feeRates []int, options Options) Suggestion {
+	return suggestFeeRate(feeRates, options.Percentile, options.MaxFeeRate)
+}
+
+func suggestFeeRate(feeRates []int, percentile int, maxFeeRate int) Suggestion {
+	suggestion := Suggestion{
+		SampleSize: len(feeRates),
+		Percentile: percentile,
+	}
+
+	if len(feeRates) > 0 {
+		sort.Ints(feeRates)
+		suggestion.Rate = feeRates[(len(feeRates)-1)*percentile/100]
+		suggestion.cap(maxFeeRate)
+	}
+
+	return suggestion
+}
+
+// cap lowers the rate to maxFeeRate, 0 disables the cap
+func (s *Suggestion) cap(maxFeeRate int) {
+	if maxFeeRate > 0 && s.Rate > maxFeeRate {
+		s.Rate = maxFeeRate
+		s.Capped = true
+	}
 }
 
 func (e *Estimator) getLatestBlockInfo() (*chainhash.Hash, int32, error) {
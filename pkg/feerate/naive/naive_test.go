@@ -0,0 +1,113 @@
+package naive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmoothHalvesWeightEveryHalfLife(t *testing.T) {
+	// act
+	first := smooth(0, 40, 1, 6)
+	halfLife := smooth(40, 20, 6, 6)
+	twoHalfLives := smooth(40, 20, 12, 6)
+
+	// assert
+	assert.Equal(t, 40.0, first, "the average starts at the first estimate")
+	assert.InDelta(t, 30.0, halfLife, 1e-9)
+	assert.InDelta(t, 25.0, twoHalfLives, 1e-9)
+}
+
+func TestSuggestFeeRateCapsUnlessDisabled(t *testing.T) {
+	// arrange
+	rates := []int{900, 10, 700, 20, 800}
+	capped := DefaultOptions()
+	capped.Percentile = 100
+	uncapped := capped
+	uncapped.MaxFeeRate = 0
+
+	// act
+	cappedSuggestion := SuggestFeeRate(rates, capped)
+	uncappedSuggestion := SuggestFeeRate(rates, uncapped)
+	empty := SuggestFeeRate(nil, capped)
+
+	// assert
+	assert.Equal(t, Suggestion{Rate: capped.MaxFeeRate, SampleSize: 5, Percentile: 100, Capped: true}, cappedSuggestion)
+	assert.Equal(t, 900, uncappedSuggestion.Rate)
+	assert.False(t, uncappedSuggestion.Capped)
+	assert.Equal(t, 0, empty.Rate)
+	assert.Equal(t, 0, empty.SampleSize)
+}
+
+func TestOptionsValidate(t *testing.T) {
+	// arrange
+	invalid := DefaultOptions()
+	invalid.Percentile = 101
+
+	// act & assert
+	assert.NoError(t, DefaultOptions().Validate())
+	assert.Error(t, invalid.Validate())
+}
+
+func TestDefaultOptionsEstimateFromTheLatestBlockOnly(t *testing.T) {
+	// act
+	options := DefaultOptions()
+
+	// assert
+	assert.Equal(t, 60, options.Percentile)
+	assert.Equal(t, 1, options.WindowSize)
+	assert.Zero(t, options.SmoothingHalfLife)
+	assert.Zero(t, options.OutlierMADs)
+	assert.False(t, options.BlockStats)
+}
+
+func TestInterpolatePercentile(t *testing.T) {
+	// arrange
+	rates := []float64{2, 5, 10, 20, 40}
+
+	// act & assert
+	assert.Equal(t, 10.0, interpolatePercentile(rates, 50))
+	assert.InDelta(t, 14.0, interpolatePercentile(rates, 60), 1e-9)
+	assert.Equal(t, 2.0, interpolatePercentile(rates, 0), "clamped below the 10th percentile")
+	assert.Equal(t, 40.0, interpolatePercentile(rates, 100), "clamped above the 90th percentile")
+}
+
+func TestOptionsValidateTiers(t *testing.T) {
+	// arrange
+	invalid := DefaultOptions()
+	invalid.Tiers = []Tier{{Name: "empty window", Percentile: 50}}
+
+	// act & assert
+	assert.Error(t, invalid.Validate())
+}
+
+func TestTrimOutliersLeavesOutChildrenAndOutliers(t *testing.T) {
+	// arrange: a consolidation paying 20 times the going rate and a CPFP child
+	rates := []int{10, 12, 9, 11, 10, 200, 13, 80, 8}
+	children := []int{80}
+
+	// act
+	trimmed, stats := trimOutliers(rates, children, 5)
+	untrimmed, _ := trimOutliers(rates, children, 0)
+
+	// assert
+	assert.ElementsMatch(t, []int{10, 12, 9, 11, 10, 13, 8}, trimmed)
+	assert.Equal(t, TrimStats{Samples: 9, Children: 1, Outliers: 1, Median: 10.5, MAD: 1.5}, stats)
+	assert.Equal(t, rates, untrimmed)
+}
+
+func TestSuggestionFloorsAtMinFeeRate(t *testing.T) {
+	// arrange
+	below := &Suggestion{Rate: 0, MinFeeRate: 1}
+	above := &Suggestion{Rate: 5, MinFeeRate: 1}
+
+	// act
+	below.floor()
+	above.floor()
+
+	// assert
+	assert.Equal(t, 1, below.Rate)
+	assert.True(t, below.Floored)
+	assert.Equal(t, 5, above.Rate)
+	assert.False(t, above.Floored)
+}
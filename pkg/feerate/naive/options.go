@@ -0,0 +1,112 @@
+package naive
+
+import (
+	"fmt"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+// Tier maps a confirmation target to the percentile and window of recent
+// blocks its fee rate is estimated at
+type Tier struct {
+	// Name identifies the tier in logs and scores
+	Name string
+
+	// Target is the confirmation target in blocks the tier is meant for
+	Target int
+
+	// Percentile of the fee rates in the window that is estimated
+	Percentile int
+
+	// WindowSize is the number of most recent blocks in the window
+	WindowSize int
+}
+
+var (
+	// Economical is the tier for txs that may wait a couple of hours
+	Economical = Tier{Name: "economical", Target: 10, Percentile: 30, WindowSize: 6}
+
+	// Standard is the tier for txs that should confirm within an hour
+	Standard = Tier{Name: "standard", Target: 6, Percentile: 60, WindowSize: 3}
+
+	// Fast is the tier for txs that should confirm within the next blocks
+	Fast = Tier{Name: "fast", Target: 2, Percentile: 90, WindowSize: 1}
+)
+
+// Options configures an Estimator
+type Options struct {
+	// Percentile defines the position where the fee rate is estimated
+	// e.g. 50 means median value, 60 means a fee that is a little bit higher than the median
+	Percentile int
+
+	// MaxFeeRate is the upper bound of estimates in satoshi per byte, 0
+	// disables the cap
+	MaxFeeRate int
+
+	// WindowSize is the number of most recent blocks whose fee rates the
+	// percentile is taken over. More than one block smooths out single
+	// block outliers like low fee weekend blocks.
+	WindowSize int
+
+	// SmoothingHalfLife is the number of blocks after which an estimate
+	// weighs half as much in the smoothed series. Zero disables smoothing.
+	SmoothingHalfLife float64
+
+	// BlockStats takes the fee rates from the feerate_percentiles of
+	// getblockstats instead of computing the rate of every tx. It does not
+	// need a node with txindex and is far cheaper, but estimates are not
+	// scored as the rates of single txs are unknown.
+	BlockStats bool
+
+	// OutlierMADs is the number of median absolute deviations from the
+	// median beyond which fee rates are left out, together with the rates of
+	// CPFP children. Zero disables trimming. Block stats are not trimmed.
+	OutlierMADs float64
+
+	// Tiers are estimated in addition, so that the naive estimator is
+	// comparable with the three priorities of the other estimators
+	Tiers []Tier
+}
+
+// DefaultOptions returns the options the naive estimator was designed with:
+// the percentile of the latest block only, neither smoothed nor trimmed.
+// Windows, smoothing and trimming are opted in to.
+func DefaultOptions() Options {
+	return Options{
+		Percentile: 60,
+		MaxFeeRate: utils.MaxFeeRate,
+		WindowSize: 1,
+		Tiers:      []Tier{Economical, Standard, Fast},
+	}
+}
+
+// Validate returns an error if the options cannot be estimated with
+func (o Options) Validate() error {
+	if o.Percentile < 0 || o.Percentile > 100 {
+		return fmt.Errorf("percentile %v is not within 0 and 100", o.Percentile)
+	}
+
+	if o.MaxFeeRate < 0 {
+		return fmt.Errorf("max fee rate %v is negative", o.MaxFeeRate)
+	}
+
+	if o.WindowSize < 1 {
+		return fmt.Errorf("window size %v is less than one block", o.WindowSize)
+	}
+
+	if o.SmoothingHalfLife < 0 {
+		return fmt.Errorf("smoothing half-life %v is negative", o.SmoothingHalfLife)
+	}
+
+	if o.OutlierMADs < 0 {
+		return fmt.Errorf("outlier threshold %v is negative", o.OutlierMADs)
+	}
+
+	for _, tier := range o.Tiers {
+		if tier.Percentile < 0 || tier.Percentile > 100 || tier.WindowSize < 1 {
+			return fmt.Errorf("tier %v needs a percentile within 0 and 100 and a window of at least one block", tier.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package naive
+
+import (
+	"math"
+	"sort"
+)
+
+// madScale makes the median absolute deviation comparable to the standard
+// deviation of normally distributed rates
+const madScale = 1.4826
+
+// TrimStats reports which fee rates were left out before taking a percentile
+type TrimStats struct {
+	// Samples is the number of rates before trimming
+	Samples int `json:"samples"`
+
+	// Children is the number of CPFP children left out
+	Children int `json:"children"`
+
+	// Outliers is the number of rates left out for being too far from the
+	// median
+	Outliers int `json:"outliers"`
+
+	// Median and MAD of the rates without CPFP children in satoshi per byte
+	Median float64 `json:"median"`
+	MAD    float64 `json:"mad"`
+}
+
+// trimOutliers leaves out the rates of CPFP children and rates that deviate
+// more than mads scaled median absolute deviations from the median, like
+// consolidations paying 20 times the going rate. Txs without fee are never
+// part of the rates, see feerate.RateCache. A mads of 0 disables trimming.
+func trimOutliers(rates []int, children []int, mads float64) ([]int, TrimStats) {
+	stats := TrimStats{Samples: len(rates)}
+	if mads <= 0 {
+		return rates, stats
+	}
+
+	remaining := make(map[int]int, len(children))
+	for _, child := range children {
+		remaining[child]++
+	}
+
+	parents := make([]int, 0, len(rates))
+	for _, rate := range rates {
+		if remaining[rate] > 0 {
+			remaining[rate]--
+			stats.Children++
+			continue
+		}
+
+		parents = append(parents, rate)
+	}
+
+	if len(parents) == 0 {
+		return parents, stats
+	}
+
+	values := make([]float64, len(parents))
+	for i, rate := range parents {
+		values[i] = float64(rate)
+	}
+	stats.Median = median(values)
+
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - stats.Median)
+	}
+	stats.MAD = median(deviations)
+	if stats.MAD == 0 {
+		return parents, stats
+	}
+
+	limit := mads * madScale * stats.MAD
+	trimmed := make([]int, 0, len(parents))
+	for i, rate := range parents {
+		if deviations[i] > limit {
+			stats.Outliers++
+			continue
+		}
+
+		trimmed = append(trimmed, rate)
+	}
+
+	return trimmed, stats
+}
+
+// median returns the median of values, which must not be empty
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
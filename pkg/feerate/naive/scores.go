@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"fmt"
 	"os"
-	"sort"
 	"strconv"
 	"time"
 
@@ -19,13 +18,18 @@ type score struct {
 	NumberOfTxs   int
 }
 
-type prediction struct {
-	feeRates      *feerate.FeeRates
-	height        int
+type rate struct {
+	series        string
 	predictedRate int
 	scores        map[int]*score
 }
 
+type prediction struct {
+	feeRates       *feerate.FeeRates
+	height         int
+	predictedRates []rate
+}
+
 type scores struct {
 	predictions map[int]*prediction //blockheight->predictions
 
@@ -39,13 +43,23 @@ func newScores(logger *zap.Logger) *scores {
 	}
 }
 
-func (s *scores) addPrediction(height int, rates *feerate.FeeRates, predictedRate int) {
-	s.predictions[height] = &prediction{
-		height:        height,
-		feeRates:      rates,
+func (s *scores) addPrediction(height int, rates *feerate.FeeRates, series string, predictedRate int) {
+	predicted := rate{
+		series:        series,
 		predictedRate: predictedRate,
 		scores:        make(map[int]*score),
 	}
+
+	_, ok := s.predictions[height]
+	if !ok {
+		s.predictions[height] = &prediction{
+			height:         height,
+			feeRates:       rates,
+			predictedRates: []rate{predicted},
+		}
+	} else {
+		s.predictions[height].predictedRates = append(s.predictions[height].predictedRates, predicted)
+	}
 }
 
 func (s *scores) predictScores() error {
@@ -67,6 +81,7 @@ func (s *scores) flush() error {
 	w := csv.NewWriter(f)
 	err = w.Write([]string{
 		"block_number",
+		"series",
 		"priceStandard",
 		"numberOfTxs",
 		"scoreStandardPlus1",
@@ -87,21 +102,24 @@ func (s *scores) flush() error {
 
 	var records [][]string
 	for blockHeight, prediction := range s.predictions {
-		record := []string{
-			strconv.Itoa(blockHeight),
-			strconv.Itoa(prediction.predictedRate),
-			strconv.Itoa(prediction.feeRates.NumberOfTxs),
-		}
-		for i := blockHeight + 1; i < blockHeight+11; i++ {
-			score, ok := prediction.scores[i]
-			if !ok {
-				record = append(record, strconv.Itoa(-1))
-			} else {
-				record = append(record, strconv.FormatFloat(score.ScoreStandard, 'f', 3, 64))
+		for _, rate := range prediction.predictedRates {
+			record := []string{
+				strconv.Itoa(blockHeight),
+				rate.series,
+				strconv.Itoa(rate.predictedRate),
+				strconv.Itoa(prediction.feeRates.NumberOfTxs),
+			}
+			for i := blockHeight + 1; i <= blockHeight+feerate.ScoredBlocks; i++ {
+				score, ok := rate.scores[i]
+				if !ok {
+					record = append(record, strconv.Itoa(-1))
+				} else {
+					record = append(record, strconv.FormatFloat(score.ScoreStandard, 'f', 3, 64))
+				}
 			}
-		}
 
-		records = append(records, record)
+			records = append(records, record)
+		}
 	}
 
 	s.logger.Info("prediction score", zap.Any("scores", records))
@@ -109,33 +127,25 @@ func (s *scores) flush() error {
 }
 
 func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *prediction) {
-	for i := blockNumber + 1; i < blockNumber+11; i++ {
-		_, ok := predict.scores[i]
-		if !ok {
-			targetPrediction, targetPredictionOk := s.predictions[i]
-			if !targetPredictionOk {
-				//target prediction does not yet exist
+	for i := blockNumber + 1; i <= blockNumber+feerate.ScoredBlocks; i++ {
+		targetPrediction, targetPredictionOk := s.predictions[i]
+		if !targetPredictionOk {
+			//target prediction does not yet exist
+			continue
+		}
+
+		for _, rate := range predict.predictedRates {
+			_, ok := rate.scores[i]
+			if ok {
 				continue
 			}
 
-			scoreStandard := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.predictedRate)
-			predict.scores[i] = &score{
-				FeeRate:       predict.predictedRate,
+			scoreStandard := feerate.HigherFeeRateShare(targetPrediction.feeRates.Rates, float64(rate.predictedRate))
+			rate.scores[i] = &score{
+				FeeRate:       rate.predictedRate,
 				ScoreStandard: scoreStandard,
 				NumberOfTxs:   targetPrediction.feeRates.NumberOfTxs,
 			}
 		}
 	}
 }
-
-func (s *scores) getPercentageOfTxsWithHigherFeeRate(feeRates []int, prediction int) float64 {
-	sort.Ints(feeRates)
-	for idx, feeRate := range feeRates {
-		if feeRate > prediction {
-			percentage := (1.0 - (float64(idx) / float64(len(feeRates)))) * 100.0 //(1-idx/txs)*100
-			return percentage
-		}
-	}
-
-	return 0
-}
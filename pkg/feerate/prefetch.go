@@ -0,0 +1,117 @@
+package feerate
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrInvalidRange is returned by PrefetchRange and GetDelta if from is
+	// above to
+	ErrInvalidRange = errors.New("invalid height range")
+
+	// PrefetchConcurrency is the number of blocks PrefetchRange computes
+	// concurrently
+	PrefetchConcurrency = 4
+)
+
+// PrefetchProgress reports how far a PrefetchRange call has come
+type PrefetchProgress struct {
+	From    int32
+	To      int32
+	Done    int // number of heights whose rates are available
+	Total   int
+	Elapsed time.Duration
+}
+
+// SetPrefetchProgress sets a function that is called after every height
+// PrefetchRange completes. It is called from several goroutines, but never
+// concurrently.
+func (c *RateCache) SetPrefetchProgress(progress func(PrefetchProgress)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prefetchProgress = progress
+}
+
+// PrefetchRange computes the fee rates of the blocks from from to to
+// inclusive, PrefetchConcurrency blocks at a time, so that later calls of
+// GetFeeRatesForBlock are served from the cache or the store. Ranges larger
+// than the cache are only kept entirely if the cache has a store. It stops at
+// the first height whose rates could not be computed.
+func (c *RateCache) PrefetchRange(from, to int32) error {
+	return c.PrefetchRangeContext(context.Background(), from, to)
+}
+
+// PrefetchRangeContext is PrefetchRange, but stops once ctx is done
+func (c *RateCache) PrefetchRangeContext(ctx context.Context, from, to int32) error {
+	if from > to || from < 0 {
+		return ErrInvalidRange
+	}
+
+	c.mu.Lock()
+	report := c.prefetchProgress
+	c.mu.Unlock()
+
+	progress := PrefetchProgress{From: from, To: to, Total: int(to-from) + 1}
+	start := time.Now()
+
+	heights := make(chan int32)
+	stop := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < PrefetchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				_, err := c.GetFeeRatesForBlockContext(ctx, height)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+						close(stop)
+					}
+					mu.Unlock()
+					continue
+				}
+
+				progress.Done++
+				progress.Elapsed = time.Since(start)
+				if report != nil {
+					report(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	func() {
+		defer close(heights)
+		for height := from; height <= to; height++ {
+			select {
+			case heights <- height:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+
+	c.logger.Info("prefetched rates", zap.Int32("from", from), zap.Int32("to", to), zap.Int("done", progress.Done), zap.Duration("elapsed", time.Since(start)), zap.Error(firstErr))
+	return firstErr
+}
@@ -0,0 +1,41 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPrefetchRangeReportsProgress(t *testing.T) {
+	// arrange
+	c := NewRateCache(nil, zap.NewNop())
+	for h := int32(10); h <= 20; h++ {
+		c.add(h, &FeeRates{})
+	}
+
+	var reports []PrefetchProgress
+	c.SetPrefetchProgress(func(progress PrefetchProgress) {
+		reports = append(reports, progress)
+	})
+
+	// act
+	err := c.PrefetchRange(10, 20)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Len(t, reports, 11)
+	assert.Equal(t, 11, reports[len(reports)-1].Done)
+	assert.Equal(t, 11, reports[len(reports)-1].Total)
+}
+
+func TestPrefetchRangeRejectsInvalidRange(t *testing.T) {
+	// arrange
+	c := NewRateCache(nil, zap.NewNop())
+
+	// act
+	err := c.PrefetchRange(20, 10)
+
+	// assert
+	assert.Equal(t, ErrInvalidRange, err)
+}
@@ -1,28 +1,49 @@
 package feerate
 
 import (
+	"container/list"
+	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
+	"time"
 
+	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
 	"go.uber.org/zap"
 )
 
-// RateCache caches fee rates for a given block height
+// DefaultMaxCachedHeights is the number of heights a RateCache keeps in
+// memory by default, about two weeks of blocks
+var DefaultMaxCachedHeights = 2016
+
+// RateCache caches fee rates for a given block height. Once more than
+// maxHeights heights are cached, the least recently used heights that are not
+// pinned are evicted.
 type RateCache struct {
-	rpcClient *utils.CachedRPCClient
-	cache     map[int32]*FeeRates
-	logger    *zap.Logger
+	rpcClient  *utils.CachedRPCClient
+	cache      map[int32]*list.Element
+	lru        *list.List // of *cacheEntry, most recently used first
+	pins       map[int32]int
+	maxHeights int // 0 keeps all heights
+	logger     *zap.Logger
+	store      RateStore // optional, persists rates across restarts
+	workers    int       // txs processed concurrently when looking up inputs
+	metrics    []FetchMetrics
+
+	prefetchProgress func(PrefetchProgress)
+	subscribers      subscribers
 
 	heightMutex *utils.Mutex
-	mu          sync.RWMutex
+	mu          sync.Mutex
 }
 
-type FeeRates struct {
-	Rates       []int
-	NumberOfTxs int
+type cacheEntry struct {
+	height int32
+	rates  *FeeRates
 }
 
 // NewRateCache returns a new fee rate cache
@@ -35,132 +56,429 @@ func NewRateCache(rpcClient *utils.CachedRPCClient, logger *zap.Logger) *RateCac
 
 	return &RateCache{
 		rpcClient:   rpcClient,
-		cache:       make(map[int32]*FeeRates),
+		cache:       make(map[int32]*list.Element),
+		lru:         list.New(),
+		pins:        make(map[int32]int),
+		maxHeights:  DefaultMaxCachedHeights,
+		workers:     DefaultWorkers,
 		logger:      logger,
 		heightMutex: utils.NewCustomizedMapMutex(maxRetry, maxDelay, baseDelay, factor, jitter),
-		mu:          sync.RWMutex{},
+		mu:          sync.Mutex{},
 	}
 }
 
-// GetFeeRatesForBlock returns fee rates for given block in Sathoshi per Byte
+// NewRateCacheWithStore returns a new fee rate cache that persists rates in
+// store and lazily loads them from it before computing them
+func NewRateCacheWithStore(rpcClient *utils.CachedRPCClient, logger *zap.Logger, store RateStore) *RateCache {
+	c := NewRateCache(rpcClient, logger)
+	c.store = store
+	return c
+}
+
+// GetFeeRatesForBlock returns fee rates for given block in satoshi per
+// virtual byte
 func (c *RateCache) GetFeeRatesForBlock(height int32) (*FeeRates, error) {
-	c.logger.Info("getting rates for block", zap.Int32("block", height))
-	c.mu.RLock()
+	return c.GetFeeRatesForBlockContext(context.Background(), height)
+}
 
-	rates, ok := c.cache[height]
-	c.mu.RUnlock()
+// GetFeeRatesForBlockContext returns fee rates for given block in satoshi per
+// virtual byte. It gives up with the error of ctx once ctx is done, a request
+// already sent to the node is completed first. Rates of cancelled calls are
+// neither cached nor stored.
+func (c *RateCache) GetFeeRatesForBlockContext(ctx context.Context, height int32) (*FeeRates, error) {
+	c.logger.Info("getting rates for block", zap.Int32("block", height))
+	rates, ok := c.get(height)
 	if ok {
 		c.logger.Info("already cached rates", zap.Int32("block", height))
 		return rates, nil
 	}
 
-	gotLock := c.heightMutex.TryLock(height)
+	gotLock := c.heightMutex.TryLockContext(ctx, height)
 	if !gotLock {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
 		return nil, errors.New("could not lock fee rates for height")
 	}
 	defer c.heightMutex.Unlock(height)
 
-	rates, err := c.getFeeRates(height)
+	rates, err := c.loadStored(height)
 	if err != nil {
 		return nil, err
 	}
 
-	c.mu.Lock()
-	c.cache[height] = rates
-	c.mu.Unlock()
+	if rates == nil {
+		rates, err = c.getFeeRates(ctx, height)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.store != nil {
+			err = c.store.Store(height, rates)
+			if err != nil {
+				c.logger.Error("could not store rates", zap.Int32("block", height), zap.Error(err))
+			}
+		}
+	}
+
+	c.add(height, rates)
+	c.subscribers.publish(Event{Height: height})
 
 	c.logger.Info("got rates", zap.Any("rates", rates))
 	return rates, nil
 }
 
-func (c *RateCache) getFeeRates(height int32) (*FeeRates, error) {
+// Subscribe returns a channel receiving an event whenever the rates of a
+// block are added to the cache, and a function that unsubscribes from it
+func (c *RateCache) Subscribe() (<-chan Event, func()) {
+	return c.subscribers.subscribe()
+}
+
+// SetMaxHeights sets the number of heights kept in memory, 0 keeps all
+// heights. Surplus heights are evicted right away.
+func (c *RateCache) SetMaxHeights(maxHeights int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxHeights = maxHeights
+	c.evict()
+}
+
+// Pin keeps the rates of height in memory until Unpin is called as often as
+// Pin was. Heights can be pinned before their rates are cached.
+func (c *RateCache) Pin(height int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pins[height]++
+}
+
+// Unpin releases a pin of height, which becomes subject to eviction again
+// once all its pins are released
+func (c *RateCache) Unpin(height int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pins[height] <= 1 {
+		delete(c.pins, height)
+	} else {
+		c.pins[height]--
+	}
+
+	c.evict()
+}
+
+// get returns the cached rates of height and marks them as most recently used
+func (c *RateCache) get(height int32) (*FeeRates, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.cache[height]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).rates, true
+}
+
+// add caches the rates of height as most recently used
+func (c *RateCache) add(height int32, rates *FeeRates) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.cache[height]
+	if ok {
+		element.Value.(*cacheEntry).rates = rates
+		c.lru.MoveToFront(element)
+	} else {
+		c.cache[height] = c.lru.PushFront(&cacheEntry{height: height, rates: rates})
+	}
+
+	c.evict()
+}
+
+// evict removes the least recently used heights that are not pinned until at
+// most maxHeights heights are cached. Pinned heights may exceed maxHeights.
+// The most recently used height is never evicted. The caller must hold mu.
+func (c *RateCache) evict() {
+	if c.maxHeights <= 0 {
+		return
+	}
+
+	element := c.lru.Back()
+	for len(c.cache) > c.maxHeights && element != c.lru.Front() {
+		previous := element.Prev()
+		entry := element.Value.(*cacheEntry)
+		if c.pins[entry.height] == 0 {
+			c.lru.Remove(element)
+			delete(c.cache, entry.height)
+			c.logger.Debug("evicted rates", zap.Int32("block", entry.height))
+		}
+
+		element = previous
+	}
+}
+
+// loadStored returns the stored rates of the block at height or nil if there
+// are none for the block currently at that height
+func (c *RateCache) loadStored(height int32) (*FeeRates, error) {
+	if c.store == nil {
+		return nil, nil
+	}
+
+	rates, err := c.store.Load(height)
+	if err != nil {
+		if err != ErrRatesNotStored {
+			c.logger.Error("could not load stored rates", zap.Int32("block", height), zap.Error(err))
+		}
+
+		return nil, nil
+	}
+
 	hash, err := c.rpcClient.GetBlockHash(int64(height))
 	if err != nil {
 		return nil, err
 	}
 
-	block, err := c.rpcClient.GetBlock(hash)
+	if rates.Version != ratesVersion {
+		c.logger.Info("stored rates are outdated", zap.Int32("block", height), zap.Int("version", rates.Version))
+		return nil, nil
+	}
+
+	if rates.BlockHash != hash.String() {
+		c.logger.Info("stored rates are from a stale block", zap.Int32("block", height), zap.String("stored", rates.BlockHash))
+		return nil, nil
+	}
+
+	return rates, nil
+}
+
+// getFeeRates computes the fee rates of the block at height. It takes the
+// fees from a single getblock call if the node reports them and falls back to
+// looking up the inputs of every tx otherwise.
+func (c *RateCache) getFeeRates(ctx context.Context, height int32) (*FeeRates, error) {
+	hash, err := c.rpcClient.GetBlockHashCtx(ctx, int64(height))
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	start := time.Now()
+	if VerboseBlocks {
+		rates, err := c.getFeeRatesFromVerboseBlock(ctx, hash)
+		if err == nil {
+			c.recordMetrics(FetchMetrics{Height: height, Source: SourceVerboseBlock, Txs: rates.NumberOfTxs, Duration: time.Since(start)})
+			return rates, nil
+		}
+
+		c.logger.Info("falling back to looking up inputs", zap.Int32("block", height), zap.Error(err))
+	}
+
+	rates, failed, err := c.getFeeRatesFromInputs(ctx, height, hash)
 	if err != nil {
 		return nil, err
 	}
 
-	type processTxResult struct {
-		rate int
-		err  error
+	c.recordMetrics(FetchMetrics{Height: height, Source: SourceInputs, Txs: rates.NumberOfTxs, Failed: failed, Duration: time.Since(start)})
+	return rates, nil
+}
+
+// getFeeRatesFromInputs computes the fee of every tx of the block from the
+// values of its inputs, which needs a node with txindex. The txs and their
+// inputs are fetched in JSON-RPC batches of utils.BatchSize txs, which are
+// processed by a fixed number of workers so that the node is not flooded with
+// requests. It returns the number of txs whose rate could not be computed as
+// well. Once ctx is done no further batches are requested.
+func (c *RateCache) getFeeRatesFromInputs(ctx context.Context, height int32, hash *chainhash.Hash) (*FeeRates, int, error) {
+	block, err := c.rpcClient.GetBlockCtx(ctx, hash)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	feeRates := make([]int, 0)
-	ch := make(chan processTxResult, len(block.Transactions))
-	exp := 0
-	for i := 0; i < len(block.Transactions); i++ {
-		tx := block.Transactions[i]
+	c.mu.Lock()
+	workers := c.workers
+	c.mu.Unlock()
+
+	jobs := make(chan []*wire.MsgTx)
+	results := make(chan processTxResult, workers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
 		go func() {
-			rate, err := c.processTx(tx)
-			if err != nil {
-				ch <- processTxResult{0, err}
-			} else {
-				if rate > 0 {
-					ch <- processTxResult{rate, nil}
-				} else {
-					ch <- processTxResult{0, nil}
+			defer wg.Done()
+			for txs := range jobs {
+				for _, res := range c.processTxs(ctx, txs) {
+					results <- res
 				}
 			}
 		}()
-
-		exp++
 	}
 
-	for exp > 0 {
-		res := <-ch
+	go func() {
+	batches:
+		for start := 0; start < len(block.Transactions); start += utils.BatchSize {
+			end := start + utils.BatchSize
+			if end > len(block.Transactions) {
+				end = len(block.Transactions)
+			}
+
+			select {
+			case jobs <- block.Transactions[start:end]:
+			case <-ctx.Done():
+				break batches
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	fees := make([]txFee, 0, len(block.Transactions))
+	failed := 0
+	for res := range results {
 		if res.err != nil {
+			//TODO handle failed --> possibly reload or ignore as it is in gasPriceOracle
 			c.logger.Error("an error occurred", zap.Error(res.err))
-		}
-		exp--
-		if res.rate != 0 {
-			feeRates = append(feeRates, res.rate)
+			failed++
 			continue
 		}
-		//TODO handle failed --> possibly reload or ignore as it is in gasPriceOracle
+
+		if res.fee != nil {
+			fees = append(fees, *res.fee)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
 	}
 
-	return &FeeRates{Rates: feeRates, NumberOfTxs: len(block.Transactions)}, nil
+	var weight int64
+	for _, tx := range block.Transactions {
+		weight += int64(vsize(tx)) * 4
+	}
+
+	return newFeeRates(height, hash.String(), len(block.Transactions), weight, fees), failed, nil
 }
 
-func (c *RateCache) processTx(tx *wire.MsgTx) (int, error) {
-	hash := tx.TxHash()
-	rawTx, err := c.rpcClient.GetRawTransactionVerbose(&hash)
+type processTxResult struct {
+	fee *txFee // nil for the coinbase
+	err error
+}
+
+// processTxs returns the fee rates of txs. It fetches the txs and then all
+// their inputs in batches instead of a call per tx and input.
+func (c *RateCache) processTxs(ctx context.Context, txs []*wire.MsgTx) []processTxResult {
+	results := make([]processTxResult, len(txs))
+	fail := func(err error) []processTxResult {
+		for i := range results {
+			results[i].err = err
+		}
+		return results
+	}
+
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+
+	hashes := make([]*chainhash.Hash, len(txs))
+	for i, tx := range txs {
+		hash := tx.TxHash()
+		hashes[i] = &hash
+	}
+
+	rawTxs, err := c.rpcClient.GetRawTransactionsVerboseCtx(ctx, hashes)
 	if err != nil {
-		c.logger.Error("could not get tx", zap.Any("hash", hash), zap.String("error", err.Error()))
-		return 0, err
+		return fail(err)
 	}
 
-	inputSum := float64(0)
-	for _, input := range rawTx.Vin {
-		if input.IsCoinBase() {
-			return 0, nil
+	inputHashes := make([]*chainhash.Hash, 0)
+	seen := make(map[string]struct{})
+	for _, rawTx := range rawTxs {
+		if rawTx == nil {
+			continue
 		}
 
-		if input.HasWitness() {
-			//e.logger.Info("skipped segwit")
-			return 0, nil //TODO handle
+		for _, input := range rawTx.Vin {
+			if input.IsCoinBase() {
+				continue
+			}
+			if _, ok := seen[input.Txid]; ok {
+				continue
+			}
+			seen[input.Txid] = struct{}{}
+
+			inputHash := new(chainhash.Hash)
+			err = chainhash.Decode(inputHash, input.Txid)
+			if err != nil {
+				continue // reported by txFeeRate
+			}
+			inputHashes = append(inputHashes, inputHash)
 		}
+	}
 
-		inputHash := new(chainhash.Hash)
-		err = chainhash.Decode(inputHash, input.Txid)
-		if err != nil {
-			return 0, err
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+
+	inputTxs, err := c.rpcClient.GetRawTransactionsVerboseCtx(ctx, inputHashes)
+	if err != nil {
+		return fail(err)
+	}
+
+	inputs := make(map[string]*btcjson.TxRawResult, len(inputTxs))
+	for _, inputTx := range inputTxs {
+		if inputTx != nil {
+			inputs[inputTx.Txid] = inputTx
 		}
+	}
 
-		inputTx, err := c.rpcClient.GetRawTransactionVerbose(inputHash)
-		if err != nil {
-			return 0, err
+	for i, tx := range txs {
+		if rawTxs[i] == nil {
+			results[i].err = fmt.Errorf("could not get tx %v", hashes[i])
+			continue
+		}
+
+		results[i].fee, results[i].err = getTxFee(tx, rawTxs[i], inputs)
+	}
+
+	return results
+}
+
+// vsize returns the virtual size of tx, its weight divided by 4 rounded up.
+// Witness data weighs 1 WU per byte, the rest of the tx 4 WU.
+func vsize(tx *wire.MsgTx) int {
+	weight := tx.SerializeSizeStripped()*3 + tx.SerializeSize()
+	return (weight + 3) / 4
+}
+
+// getTxFee returns the fee paid by tx, or nil if tx is the coinbase. inputs
+// holds the txs its inputs spend by txid.
+func getTxFee(tx *wire.MsgTx, rawTx *btcjson.TxRawResult, inputs map[string]*btcjson.TxRawResult) (*txFee, error) {
+	inputSum := float64(0)
+	child := false
+	for _, input := range rawTx.Vin {
+		if input.IsCoinBase() {
+			return nil, nil
+		}
+
+		inputTx, ok := inputs[input.Txid]
+		if !ok {
+			return nil, fmt.Errorf("could not get input tx %v", input.Txid)
 		}
 
 		if len(inputTx.Vout) <= int(input.Vout) {
-			return 0, errors.New("too little outputs in inputTx")
+			return nil, errors.New("too little outputs in inputTx")
 		}
 
 		inputSum += inputTx.Vout[input.Vout].Value
+		if rawTx.BlockHash != "" && inputTx.BlockHash == rawTx.BlockHash {
+			child = true
+		}
 	}
 
 	outputSum := float64(0)
@@ -169,8 +487,6 @@ func (c *RateCache) processTx(tx *wire.MsgTx) (int, error) {
 	}
 
 	fee := inputSum - outputSum
-	feeInSatoshi := fee * utils.BTC //NOTE this can be really high, users constantly overpay the miners e.g. x20 compared to estimatesmartfee of BTC
-	size := tx.SerializeSize()      //TODO should this be SerializeSizeStripped in case of segwit?
-	rate := feeInSatoshi / float64(size)
-	return int(rate), nil
+	feeInSatoshi := math.Round(fee * utils.BTC) //NOTE this can be really high, users constantly overpay the miners e.g. x20 compared to estimatesmartfee of BTC
+	return &txFee{fee: int64(feeInSatoshi), vsize: int64(vsize(tx)), child: child}, nil
 }
@@ -0,0 +1,132 @@
+package feerate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRateCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// arrange
+	c := NewRateCache(nil, zap.NewNop())
+	c.SetMaxHeights(2)
+	c.add(1, &FeeRates{})
+	c.add(2, &FeeRates{})
+
+	// act: using height 1 makes height 2 the least recently used one
+	_, ok := c.get(1)
+	c.add(3, &FeeRates{})
+
+	// assert
+	assert.True(t, ok)
+	assert.Contains(t, c.cache, int32(1))
+	assert.NotContains(t, c.cache, int32(2))
+	assert.Contains(t, c.cache, int32(3))
+}
+
+func TestRateCacheKeepsPinnedHeights(t *testing.T) {
+	// arrange
+	c := NewRateCache(nil, zap.NewNop())
+	c.SetMaxHeights(1)
+	c.Pin(1)
+	c.add(1, &FeeRates{})
+
+	// act
+	c.add(2, &FeeRates{})
+	c.add(3, &FeeRates{})
+
+	// assert: pinned heights may exceed the limit
+	assert.Contains(t, c.cache, int32(1))
+	assert.NotContains(t, c.cache, int32(2))
+	assert.Contains(t, c.cache, int32(3))
+
+	// act: releasing the pin evicts the surplus height
+	c.Unpin(1)
+
+	// assert
+	assert.NotContains(t, c.cache, int32(1))
+	assert.Len(t, c.cache, 1)
+}
+
+func TestVsizeDiscountsWitness(t *testing.T) {
+	// arrange
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{Witness: wire.TxWitness{make([]byte, 100)}})
+	tx.AddTxOut(&wire.TxOut{Value: 1000, PkScript: make([]byte, 22)})
+
+	// act
+	size := vsize(tx)
+
+	// assert: marker, flag, item count, item length and 100 bytes of
+	// witness weigh 104 WU
+	assert.Equal(t, tx.SerializeSizeStripped()+26, size)
+}
+
+func TestRateCacheKeepsRecentMetrics(t *testing.T) {
+	// arrange
+	c := NewRateCache(nil, zap.NewNop())
+
+	// act
+	for h := int32(0); h < maxMetrics+5; h++ {
+		c.recordMetrics(FetchMetrics{Height: h, Source: SourceInputs})
+	}
+	metrics := c.Metrics()
+
+	// assert
+	assert.Len(t, metrics, maxMetrics)
+	assert.Equal(t, int32(5), metrics[0].Height)
+	assert.Equal(t, int32(maxMetrics+4), metrics[len(metrics)-1].Height)
+}
+
+func TestGetTxFee(t *testing.T) {
+	// arrange
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(&wire.TxIn{})
+	tx.AddTxOut(&wire.TxOut{Value: 1000, PkScript: make([]byte, 22)})
+	rawTx := &btcjson.TxRawResult{
+		BlockHash: "block",
+		Vin:       []btcjson.Vin{{Txid: "parent", Vout: 1}},
+		Vout:      []btcjson.Vout{{Value: 0.0001}},
+	}
+	inputs := map[string]*btcjson.TxRawResult{
+		"parent": {Txid: "parent", BlockHash: "block", Vout: []btcjson.Vout{{Value: 1}, {Value: 0.0002}}},
+	}
+
+	// act
+	fee, err := getTxFee(tx, rawTx, inputs)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10000), fee.fee)
+	assert.Equal(t, int64(vsize(tx)), fee.vsize)
+	assert.True(t, fee.child)
+}
+
+func TestGetTxFeeMissingInput(t *testing.T) {
+	// arrange
+	rawTx := &btcjson.TxRawResult{Vin: []btcjson.Vin{{Txid: "parent"}}}
+
+	// act
+	_, err := getTxFee(wire.NewMsgTx(2), rawTx, map[string]*btcjson.TxRawResult{})
+
+	// assert
+	assert.Error(t, err)
+}
+
+func TestGetFeeRatesForBlockContextGivesUpWhenCancelled(t *testing.T) {
+	// arrange: another call is computing the rates of the height
+	c := NewRateCache(nil, zap.NewNop())
+	c.heightMutex.TryLock(int32(5))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// act
+	_, err := c.GetFeeRatesForBlockContext(ctx, 5)
+
+	// assert
+	assert.Equal(t, context.Canceled, err)
+}
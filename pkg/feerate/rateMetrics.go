@@ -0,0 +1,70 @@
+package feerate
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// SourceVerboseBlock marks rates taken from getblock with verbosity 2
+	SourceVerboseBlock = "getblock"
+
+	// SourceInputs marks rates computed by looking up the inputs of every tx
+	SourceInputs = "inputs"
+
+	// maxMetrics is the number of most recent FetchMetrics a RateCache keeps
+	maxMetrics = 100
+)
+
+// DefaultWorkers is the number of txs a RateCache processes concurrently
+// when looking up inputs
+var DefaultWorkers = 16
+
+// FetchMetrics describes how the fee rates of a block were computed
+type FetchMetrics struct {
+	Height   int32
+	Source   string
+	Txs      int
+	Failed   int // txs whose rate could not be computed
+	Duration time.Duration
+}
+
+// SetWorkers sets the number of txs processed concurrently when looking up
+// inputs, it takes effect with the next block
+func (c *RateCache) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.workers = workers
+}
+
+// Metrics returns the metrics of the most recently computed blocks, oldest
+// first
+func (c *RateCache) Metrics() []FetchMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]FetchMetrics{}, c.metrics...)
+}
+
+func (c *RateCache) recordMetrics(metrics FetchMetrics) {
+	c.logger.Info("computed block rates",
+		zap.Int32("block", metrics.Height),
+		zap.String("source", metrics.Source),
+		zap.Int("txs", metrics.Txs),
+		zap.Int("failed", metrics.Failed),
+		zap.Duration("duration", metrics.Duration))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metrics = append(c.metrics, metrics)
+	if len(c.metrics) > maxMetrics {
+		c.metrics = c.metrics[len(c.metrics)-maxMetrics:]
+	}
+}
@@ -0,0 +1,131 @@
+package feerate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrRatesNotStored is returned by a RateStore that holds no rates for a height
+	ErrRatesNotStored = errors.New("fee rates are not stored")
+
+	// DefaultRateStorePath is where the fee rates of blocks are persisted
+	DefaultRateStorePath = "./output/rates.db"
+
+	rateBucket = []byte("rates")
+)
+
+// RateStore persists the fee rates of blocks so that they survive restarts
+type RateStore interface {
+	// Load returns the rates stored for height or ErrRatesNotStored
+	Load(height int32) (*FeeRates, error)
+
+	// Store persists the rates of the block at height
+	Store(height int32, rates *FeeRates) error
+}
+
+// BoltRateStore stores the fee rates of blocks as json in a bolt database,
+// keyed by their big endian height so that they are ordered by height. The
+// database is opened on first use, so that commands which never compute
+// rates do not open, or wait for the lock of, it.
+type BoltRateStore struct {
+	path string
+
+	mu     sync.Mutex
+	db     *bolt.DB
+	err    error
+	opened bool
+}
+
+// NewBoltRateStore returns a store keeping the rates in the bolt database at
+// path, it is created on first use if it does not exist yet
+func NewBoltRateStore(path string) *BoltRateStore {
+	return &BoltRateStore{path: path}
+}
+
+// open returns the opened database, an error opening it is returned on every
+// use rather than retried
+func (s *BoltRateStore) open() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.opened {
+		s.db, s.err = utils.OpenBolt(s.path, rateBucket)
+		s.opened = true
+	}
+
+	return s.db, s.err
+}
+
+func heightKey(height int32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return key
+}
+
+// Load returns the rates stored for height or ErrRatesNotStored
+func (s *BoltRateStore) Load(height int32) (*FeeRates, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	err = db.View(func(tx *bolt.Tx) error {
+		// the value is only valid during the transaction
+		data = append(data, tx.Bucket(rateBucket).Get(heightKey(height))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, ErrRatesNotStored
+	}
+
+	var rates FeeRates
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rates, nil
+}
+
+// Store persists the rates of the block at height
+func (s *BoltRateStore) Store(height int32, rates *FeeRates) error {
+	data, err := json.Marshal(rates)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(rateBucket).Put(heightKey(height), data)
+	})
+}
+
+// Close closes the database if it was opened, later uses fail
+func (s *BoltRateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.opened {
+		s.opened = true
+		s.err = errors.New("rate store is closed")
+		return nil
+	}
+	if s.db == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
@@ -0,0 +1,72 @@
+package feerate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltRateStoreRoundTrip(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "rates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rates.db")
+	store := NewBoltRateStore(path)
+	rates := &FeeRates{Version: ratesVersion, Rates: []float64{1, 5.5, 20}, NumberOfTxs: 4, ChildRates: []float64{20}, BlockHash: "00ab"}
+	require.NoError(t, store.Store(500000, rates))
+	require.NoError(t, store.Close())
+
+	// act
+	reopened := NewBoltRateStore(path)
+	defer reopened.Close()
+	loaded, err := reopened.Load(500000)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, rates, loaded)
+}
+
+func TestBoltRateStoreMissingHeight(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "rates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewBoltRateStore(filepath.Join(dir, "rates.db"))
+	defer store.Close()
+	require.NoError(t, store.Store(500001, &FeeRates{Version: ratesVersion}))
+
+	// act
+	_, err = store.Load(500000)
+
+	// assert
+	assert.Equal(t, ErrRatesNotStored, err)
+}
+
+func TestBoltRateStoreOpensOnFirstUse(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "rates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rates.db")
+	store := NewBoltRateStore(path)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// act
+	err = store.Close()
+	_, closed := store.Load(500000)
+
+	// assert
+	require.NoError(t, err)
+	assert.Error(t, closed)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
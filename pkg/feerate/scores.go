@@ -1,4 +1,4 @@
-package core
+package feerate
 
 import (
 	"encoding/csv"
@@ -8,13 +8,16 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/mariusgiger/bitcoin-feeestimator/pkg/feerate"
-
 	"go.uber.org/zap"
 )
 
-type score struct {
-	FeeRate         int
+// ScoredBlocks is the number of blocks following a prediction it is scored
+// against
+const ScoredBlocks = 10
+
+// Score compares the fee rates predicted at a height to the txs of a later
+// block, the scores are the percentages of its txs paying more
+type Score struct {
 	ScoreEconomical float64
 	ScoreStandard   float64
 	ScoreFast       float64
@@ -22,39 +25,50 @@ type score struct {
 }
 
 type prediction struct {
-	feeRates                *feerate.FeeRates
+	feeRates                *FeeRates
 	height                  int
 	predictedRateEconomical float64
 	predictedRateStandard   float64
 	predictedRateFast       float64
-	scores                  map[int]*score
+	scores                  map[int]*Score
 }
 
-type scores struct {
+// Scorer scores the economical, standard and fast fee rates predicted at a
+// height against the txs of the following ScoredBlocks blocks and writes the
+// scores to csv files in ./output
+type Scorer struct {
 	predictions map[int]*prediction //blockheight->predictions
+	name        string              // prefix of the score files
 
 	logger *zap.Logger
 }
 
-func newScores(logger *zap.Logger) *scores {
-	return &scores{
+// NewScorer returns a scorer writing its scores to files prefixed with name
+func NewScorer(logger *zap.Logger, name string) *Scorer {
+	return &Scorer{
 		logger:      logger,
+		name:        name,
 		predictions: make(map[int]*prediction),
 	}
 }
 
-func (s *scores) addPrediction(height int, rates *feerate.FeeRates, predictedRateEconomical float64, predictedRateStandard float64, predictedRateFast float64) {
+// AddPrediction records the fee rates predicted at height, rates are the fee
+// rates of the block at height the predictions of earlier heights are scored
+// against
+func (s *Scorer) AddPrediction(height int, rates *FeeRates, predictedRateEconomical float64, predictedRateStandard float64, predictedRateFast float64) {
 	s.predictions[height] = &prediction{
 		height:                  height,
 		feeRates:                rates,
 		predictedRateEconomical: predictedRateEconomical,
 		predictedRateStandard:   predictedRateStandard,
 		predictedRateFast:       predictedRateFast,
-		scores:                  make(map[int]*score),
+		scores:                  make(map[int]*Score),
 	}
 }
 
-func (s *scores) predictScores() error {
+// PredictScores scores the predictions against the blocks recorded since and
+// writes the scores to a new csv file
+func (s *Scorer) PredictScores() error {
 	for num, pred := range s.predictions {
 		s.comparePredictionToNext10Blocks(num, pred)
 	}
@@ -62,8 +76,8 @@ func (s *scores) predictScores() error {
 	return s.flush()
 }
 
-func (s *scores) flush() error {
-	fileName := fmt.Sprintf("corescores%v.csv", time.Now().Format(time.RFC3339))
+func (s *Scorer) flush() error {
+	fileName := fmt.Sprintf("%sscores%v.csv", s.name, time.Now().Format(time.RFC3339))
 	f, err := os.OpenFile("./output/"+fileName, os.O_CREATE|os.O_RDWR, 0660)
 	if err != nil {
 		return err
@@ -132,7 +146,7 @@ func (s *scores) flush() error {
 			strconv.FormatFloat(prediction.predictedRateFast, 'f', 3, 64),
 			strconv.Itoa(prediction.feeRates.NumberOfTxs),
 		}
-		for i := blockHeight + 1; i < blockHeight+11; i++ {
+		for i := blockHeight + 1; i <= blockHeight+ScoredBlocks; i++ {
 			score, ok := prediction.scores[i]
 			if !ok {
 				record = append(record, strconv.Itoa(-1))
@@ -148,12 +162,12 @@ func (s *scores) flush() error {
 		records = append(records, record)
 	}
 
-	s.logger.Info("prediction score", zap.Any("scores", records))
+	s.logger.Info("prediction score", zap.String("series", s.name), zap.Any("scores", records))
 	return w.WriteAll(records)
 }
 
-func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *prediction) {
-	for i := blockNumber + 1; i < blockNumber+11; i++ {
+func (s *Scorer) comparePredictionToNext10Blocks(blockNumber int, predict *prediction) {
+	for i := blockNumber + 1; i <= blockNumber+ScoredBlocks; i++ {
 		_, ok := predict.scores[i]
 		if !ok {
 			targetPrediction, targetPredictionOk := s.predictions[i]
@@ -162,10 +176,10 @@ func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *predi
 				continue
 			}
 
-			scoreEconomical := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.predictedRateEconomical)
-			scoreStandard := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.predictedRateStandard)
-			scoreFast := s.getPercentageOfTxsWithHigherFeeRate(targetPrediction.feeRates.Rates, predict.predictedRateFast)
-			predict.scores[i] = &score{
+			scoreEconomical := HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.predictedRateEconomical)
+			scoreStandard := HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.predictedRateStandard)
+			scoreFast := HigherFeeRateShare(targetPrediction.feeRates.Rates, predict.predictedRateFast)
+			predict.scores[i] = &Score{
 				ScoreEconomical: scoreEconomical,
 				ScoreStandard:   scoreStandard,
 				ScoreFast:       scoreFast,
@@ -175,10 +189,12 @@ func (s *scores) comparePredictionToNext10Blocks(blockNumber int, predict *predi
 	}
 }
 
-func (s *scores) getPercentageOfTxsWithHigherFeeRate(feeRates []int, prediction float64) float64 {
-	sort.Ints(feeRates)
+// HigherFeeRateShare returns the percentage of feeRates that are higher than
+// prediction. feeRates is sorted in place.
+func HigherFeeRateShare(feeRates []float64, prediction float64) float64 {
+	sort.Float64s(feeRates)
 	for idx, feeRate := range feeRates {
-		if float64(feeRate) > prediction {
+		if feeRate > prediction {
 			percentage := (1.0 - (float64(idx) / float64(len(feeRates)))) * 100.0 //(1-idx/txs)*100
 			return percentage
 		}
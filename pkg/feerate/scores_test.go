@@ -0,0 +1,40 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHigherFeeRateShare(t *testing.T) {
+	// arrange
+	rates := []float64{20, 1, 10, 5}
+
+	// act & assert
+	assert.Equal(t, 100.0, HigherFeeRateShare(rates, 0))
+	assert.Equal(t, 50.0, HigherFeeRateShare(rates, 6))
+	assert.Equal(t, 0.0, HigherFeeRateShare(rates, 20))
+}
+
+func TestScorerScoresFollowingBlocks(t *testing.T) {
+	// arrange
+	s := NewScorer(zap.NewNop(), "test")
+	s.AddPrediction(100, &FeeRates{Rates: []float64{1, 5, 10, 20}, NumberOfTxs: 4}, 2, 6, 12)
+	s.AddPrediction(101, &FeeRates{Rates: []float64{3, 4, 8, 30}, NumberOfTxs: 4}, 2, 6, 12)
+	s.AddPrediction(100+ScoredBlocks+1, &FeeRates{Rates: []float64{1}, NumberOfTxs: 1}, 2, 6, 12)
+
+	// act
+	for height, p := range s.predictions {
+		s.comparePredictionToNext10Blocks(height, p)
+	}
+
+	// assert
+	scores := s.predictions[100].scores
+	require.Len(t, scores, 1, "blocks after the window are not scored")
+	assert.Equal(t, 100.0, scores[101].ScoreEconomical)
+	assert.Equal(t, 50.0, scores[101].ScoreStandard)
+	assert.Equal(t, 25.0, scores[101].ScoreFast)
+	assert.Equal(t, 4, scores[101].NumberOfTxs)
+}
@@ -0,0 +1,119 @@
+package feerate
+
+// UnconfirmedRing tracks the number of unconfirmed transactions per fee
+// bucket for each of the last Len() block heights. A slot is reused once the
+// head has moved a full ring length past the height it was written for, so
+// heights outside the window (including negative ones close to genesis)
+// never alias onto a live slot.
+type UnconfirmedRing struct {
+	slots   [][]int
+	buckets int
+	head    int // highest height that has been written to or rotated in
+}
+
+// NewUnconfirmedRing creates a ring tracking size block heights of buckets fee buckets each
+func NewUnconfirmedRing(size int, buckets int) *UnconfirmedRing {
+	if size <= 0 {
+		panic("ring size must be positive")
+	}
+
+	slots := make([][]int, size)
+	for i := range slots {
+		slots[i] = make([]int, buckets)
+	}
+
+	return &UnconfirmedRing{
+		slots:   slots,
+		buckets: buckets,
+		head:    -1,
+	}
+}
+
+// Len returns the number of block heights tracked by the ring
+func (r *UnconfirmedRing) Len() int {
+	return len(r.slots)
+}
+
+// Head returns the newest height in the ring, or -1 if nothing was recorded yet
+func (r *UnconfirmedRing) Head() int {
+	return r.head
+}
+
+// slot maps a height onto its ring index, wrapping negative heights correctly
+func (r *UnconfirmedRing) slot(height int) int {
+	n := len(r.slots)
+	return ((height % n) + n) % n
+}
+
+// contains reports whether height is inside the window (head-Len(), head]
+func (r *UnconfirmedRing) contains(height int) bool {
+	return height >= 0 && height <= r.head && height > r.head-len(r.slots)
+}
+
+// Add records an unconfirmed transaction that entered the mempool at height.
+// It returns false if height has fallen out of the window, its slot belongs
+// to a newer height then.
+func (r *UnconfirmedRing) Add(height int, bucketIndex int) bool {
+	if height < 0 || height <= r.head-len(r.slots) {
+		return false
+	}
+
+	if height > r.head {
+		r.head = height
+	}
+	r.slots[r.slot(height)][bucketIndex]++
+	return true
+}
+
+// Remove drops a transaction previously added at height. It returns false if
+// there was nothing to remove, either because the slot is already empty or
+// because height has fallen out of the window.
+func (r *UnconfirmedRing) Remove(height int, bucketIndex int) bool {
+	if !r.contains(height) {
+		return false
+	}
+
+	counts := r.slots[r.slot(height)]
+	if counts[bucketIndex] <= 0 {
+		return false
+	}
+
+	counts[bucketIndex]--
+	return true
+}
+
+// At returns the number of transactions in bucketIndex that entered the
+// mempool at height. Heights outside of the window return 0.
+func (r *UnconfirmedRing) At(height int, bucketIndex int) int {
+	if !r.contains(height) {
+		return 0
+	}
+
+	return r.slots[r.slot(height)][bucketIndex]
+}
+
+// Rotate moves the head to height and empties the slot that height reuses.
+// If the head skips heights, the slots of the skipped heights are emptied as
+// well. The counts that were still in the emptied slots are added to
+// overflow, which must have one entry per bucket.
+func (r *UnconfirmedRing) Rotate(height int, overflow []int) {
+	from := height
+	if r.head >= 0 && height > r.head {
+		from = r.head + 1
+	}
+	if from <= height-len(r.slots) {
+		from = height - len(r.slots) + 1
+	}
+
+	for h := from; h <= height; h++ {
+		counts := r.slots[r.slot(h)]
+		for j := 0; j < r.buckets; j++ {
+			overflow[j] += counts[j]
+			counts[j] = 0
+		}
+	}
+
+	if height > r.head {
+		r.head = height
+	}
+}
@@ -0,0 +1,64 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnconfirmedRingIgnoresHeightsBelowGenesis(t *testing.T) {
+	// arrange
+	ring := NewUnconfirmedRing(4, 2)
+	ring.Add(1, 0)
+	ring.Add(3, 0)
+
+	// act & assert: height -3 would alias slot 1 with a plain modulo
+	assert.Equal(t, 1, ring.At(1, 0))
+	assert.Equal(t, 0, ring.At(-3, 0))
+	assert.Equal(t, 0, ring.At(-1, 0))
+}
+
+func TestUnconfirmedRingRotateMovesStaleSlotsToOverflow(t *testing.T) {
+	// arrange
+	ring := NewUnconfirmedRing(4, 2)
+	overflow := make([]int, 2)
+	ring.Add(1, 0)
+	ring.Add(2, 1)
+	ring.Add(2, 1)
+
+	// act: height 5 reuses the slot of height 1, height 6 the one of height 2
+	ring.Rotate(6, overflow)
+
+	// assert
+	assert.Equal(t, 6, ring.Head())
+	assert.Equal(t, []int{1, 2}, overflow)
+	assert.Equal(t, 0, ring.At(2, 1))
+	assert.Equal(t, 0, ring.At(6, 1))
+}
+
+func TestUnconfirmedRingRemoveOutsideWindow(t *testing.T) {
+	// arrange
+	ring := NewUnconfirmedRing(4, 1)
+	ring.Add(10, 0)
+
+	// act & assert
+	assert.False(t, ring.Remove(6, 0))
+	assert.True(t, ring.Remove(10, 0))
+	assert.False(t, ring.Remove(10, 0))
+}
+
+func TestUnconfirmedRingAddOutsideWindow(t *testing.T) {
+	// arrange
+	ring := NewUnconfirmedRing(4, 2)
+	ring.Add(10, 0)
+
+	// act: height 6 would land on the slot of height 10
+	added := ring.Add(6, 0)
+	addedNegative := ring.Add(-2, 0)
+
+	// assert
+	assert.False(t, added)
+	assert.False(t, addedNegative)
+	assert.Equal(t, 1, ring.At(10, 0))
+	assert.Equal(t, 10, ring.Head())
+}
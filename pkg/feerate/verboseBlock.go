@@ -0,0 +1,96 @@
+package feerate
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+)
+
+var (
+	// ErrNoFeeInfo is returned if a node does not report the fees of the txs
+	// in getblock
+	ErrNoFeeInfo = errors.New("node does not report fees in getblock")
+
+	// VerboseBlocks takes the fees of a block from getblock with verbosity 2
+	// before falling back to looking up the inputs of every tx
+	VerboseBlocks = true
+)
+
+// getFeeRatesFromVerboseBlock computes the fee rates of the block with the
+// given hash from the fees getblock reports, which takes a single RPC call
+func (c *RateCache) getFeeRatesFromVerboseBlock(ctx context.Context, hash *chainhash.Hash) (*FeeRates, error) {
+	block, err := c.rpcClient.GetVerboseBlockCtx(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return verboseBlockRates(block)
+}
+
+// verboseBlockRates computes the fee rates in satoshi per virtual byte of the
+// txs of block, skipping the coinbase
+func verboseBlockRates(block *utils.VerboseBlock) (*FeeRates, error) {
+	inBlock := make(map[string]struct{}, len(block.Tx))
+	for _, tx := range block.Tx {
+		inBlock[tx.Txid] = struct{}{}
+	}
+
+	fees := make([]txFee, 0, len(block.Tx))
+	var weight int64
+	for _, tx := range block.Tx {
+		vsize := verboseVsize(tx)
+		if tx.Weight > 0 {
+			weight += tx.Weight
+		} else {
+			weight += vsize * 4
+		}
+
+		if isCoinbase(tx) {
+			continue
+		}
+
+		if tx.Fee == nil {
+			return nil, ErrNoFeeInfo
+		}
+
+		fees = append(fees, txFee{
+			fee:   int64(math.Round(*tx.Fee * utils.BTC)),
+			vsize: vsize,
+			child: spendsFrom(tx, inBlock),
+		})
+	}
+
+	return newFeeRates(int32(block.Height), block.Hash, len(block.Tx), weight, fees), nil
+}
+
+// verboseVsize returns the virtual size of tx, nodes before 0.13 only report
+// its size
+func verboseVsize(tx utils.VerboseTx) int64 {
+	if tx.Vsize > 0 {
+		return tx.Vsize
+	}
+
+	if tx.Weight > 0 {
+		return (tx.Weight + 3) / 4
+	}
+
+	return tx.Size
+}
+
+func isCoinbase(tx utils.VerboseTx) bool {
+	return len(tx.Vin) > 0 && tx.Vin[0].Coinbase != ""
+}
+
+// spendsFrom reports whether tx spends an output of one of txs
+func spendsFrom(tx utils.VerboseTx, txs map[string]struct{}) bool {
+	for _, input := range tx.Vin {
+		if _, ok := txs[input.Txid]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,52 @@
+package feerate
+
+import (
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func btcFee(btc float64) *float64 {
+	return &btc
+}
+
+func TestVerboseBlockRates(t *testing.T) {
+	// arrange
+	block := &utils.VerboseBlock{
+		Hash: "00ab",
+		Tx: []utils.VerboseTx{
+			{Txid: "coinbase", Size: 100, Weight: 400, Vin: []utils.VerboseTxIn{{Coinbase: "03"}}},
+			{Txid: "parent", Size: 200, Weight: 800, Fee: btcFee(0.00001), Vin: []utils.VerboseTxIn{{Txid: "confirmed"}}},
+			{Txid: "child", Size: 100, Weight: 400, Fee: btcFee(0.00005), Vin: []utils.VerboseTxIn{{Txid: "parent"}}},
+			{Txid: "segwit", Size: 200, Vsize: 125, Weight: 500, Fee: btcFee(0.00001), Vin: []utils.VerboseTxIn{{Txid: "confirmed"}}},
+		},
+	}
+
+	// act
+	rates, err := verboseBlockRates(block)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{5, 50, 8}, rates.Rates)
+	assert.Equal(t, []float64{50}, rates.ChildRates)
+	assert.Equal(t, int64(7000), rates.TotalFees)
+	assert.Equal(t, int64(2100), rates.TotalWeight)
+	assert.Equal(t, 4, rates.NumberOfTxs)
+	assert.Equal(t, "00ab", rates.BlockHash)
+}
+
+func TestVerboseBlockRatesWithoutFees(t *testing.T) {
+	// arrange
+	block := &utils.VerboseBlock{
+		Tx: []utils.VerboseTx{
+			{Txid: "tx", Size: 200, Weight: 800, Vin: []utils.VerboseTxIn{{Txid: "confirmed"}}},
+		},
+	}
+
+	// act
+	_, err := verboseBlockRates(block)
+
+	// assert
+	assert.Equal(t, ErrNoFeeInfo, err)
+}
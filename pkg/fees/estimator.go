@@ -1,6 +1,8 @@
 package fees
 
 import (
+	"fmt"
+
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/blockchain"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/coinselection"
 	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
@@ -11,13 +13,49 @@ type Estimator struct {
 	Feerater feerate.FeeRater
 	Selector coinselection.Strategy
 	UTXOs    blockchain.UTXOManager
+
+	// Filter excludes UTXOs from the candidates for the selection
+	Filter common.UTXOFilter
+
+	// MaxFee is the fee in satoshi a selection may cost at most, 0 does not
+	// limit it
+	MaxFee int64
+
+	// MaxFeeRate is the fee rate in satoshi per kB above which no coins are
+	// selected, 0 does not limit it
+	MaxFeeRate int64
+}
+
+// FeeLimitError is returned by EstimateFees if the fee rate or the fee of the
+// selection exceeds the limits of the Estimator
+type FeeLimitError struct {
+	FeeRate    int64
+	MaxFeeRate int64
+	Fee        int64 // 0 if the fee rate exceeds the limit
+	MaxFee     int64
+}
+
+func (e *FeeLimitError) Error() string {
+	if e.MaxFeeRate > 0 && e.FeeRate > e.MaxFeeRate {
+		return fmt.Sprintf("fee rate of %d sat/kB exceeds the maximum of %d sat/kB", e.FeeRate, e.MaxFeeRate)
+	}
+
+	return fmt.Sprintf("fee of %d sat exceeds the maximum of %d sat", e.Fee, e.MaxFee)
+}
+
+// IsFeeLimit reports whether err is a FeeLimitError
+func IsFeeLimit(err error) bool {
+	_, ok := err.(*FeeLimitError)
+	return ok
 }
 
 type EstimationResult struct {
-	Set     []*common.UTXO
-	FeeRate int64
-	Fee     int64
-	Change  int64
+	Set        []*common.UTXO
+	FeeRate    int64
+	Fee        int64
+	Change     int64
+	DustChange int64 // part of Fee
+	Waste      int64
 }
 
 func (e *Estimator) EstimateFees(address string, targetValue int64) (*EstimationResult, error) {
@@ -29,17 +67,25 @@ func (e *Estimator) EstimateFees(address string, targetValue int64) (*Estimation
 	if err != nil {
 		return nil, err
 	}
+	if e.MaxFeeRate > 0 && rate > e.MaxFeeRate {
+		return nil, &FeeLimitError{FeeRate: rate, MaxFeeRate: e.MaxFeeRate, MaxFee: e.MaxFee}
+	}
 
-	// select coins
-	set, err := e.Selector.SelectCoins(utxos, targetValue, rate)
+	// select coins, e.g. immature coinbase outputs cannot be spent yet
+	set, err := e.Selector.SelectCoins(e.Filter.Filter(utxos), targetValue, rate)
 	if err != nil {
 		return nil, err
 	}
+	if e.MaxFee > 0 && set.Fee > e.MaxFee {
+		return nil, &FeeLimitError{FeeRate: rate, MaxFeeRate: e.MaxFeeRate, Fee: set.Fee, MaxFee: e.MaxFee}
+	}
 
 	return &EstimationResult{
-		Set:     set.Coins,
-		FeeRate: rate,
-		Fee:     set.Fee,
-		Change:  set.Change,
+		Set:        set.Coins,
+		FeeRate:    rate,
+		Fee:        set.Fee,
+		Change:     set.Change,
+		DustChange: set.DustChange,
+		Waste:      set.Waste,
 	}, nil
 }
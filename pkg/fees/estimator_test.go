@@ -0,0 +1,97 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/coinselection"
+	"github.com/mariusgiger/bitcoin-feeestimator/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedFeeRater int64
+
+func (r fixedFeeRater) GetFeeRate() (int64, error) {
+	return int64(r), nil
+}
+
+type fixedUTXOs []*common.UTXO
+
+func (u fixedUTXOs) GetUTXOs(address string) ([]*common.UTXO, error) {
+	return u, nil
+}
+
+func newEstimator(feeRate int64, maxFee int64, maxFeeRate int64) *Estimator {
+	return &Estimator{
+		Feerater: fixedFeeRater(feeRate),
+		Selector: coinselection.LargestFirstCoinSelector{},
+		UTXOs: fixedUTXOs{
+			{Value: 100000, Hash: "a", Confirmations: 1},
+			{Value: 50000, Hash: "b", Confirmations: 1},
+		},
+		MaxFee:     maxFee,
+		MaxFeeRate: maxFeeRate,
+	}
+}
+
+func TestEstimateFees(t *testing.T) {
+	// arrange
+	estimator := newEstimator(10000, 5000, 10000)
+
+	// act
+	result, err := estimator.EstimateFees("address", 60000)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000), result.FeeRate)
+	assert.Equal(t, int64((10+148+2*34)*10), result.Fee)
+	assert.Equal(t, 100000-60000-result.Fee, result.Change)
+}
+
+func TestEstimateFeesExceedsMaxFeeRate(t *testing.T) {
+	// arrange
+	estimator := newEstimator(10001, 0, 10000)
+
+	// act
+	_, err := estimator.EstimateFees("address", 60000)
+
+	// assert
+	require.True(t, IsFeeLimit(err))
+	assert.Equal(t, &FeeLimitError{FeeRate: 10001, MaxFeeRate: 10000}, err)
+	assert.Equal(t, "fee rate of 10001 sat/kB exceeds the maximum of 10000 sat/kB", err.Error())
+}
+
+func TestEstimateFeesExceedsMaxFee(t *testing.T) {
+	// arrange
+	estimator := newEstimator(10000, 2000, 0)
+
+	// act
+	_, err := estimator.EstimateFees("address", 60000)
+
+	// assert
+	require.True(t, IsFeeLimit(err))
+	assert.Equal(t, &FeeLimitError{FeeRate: 10000, Fee: 2260, MaxFee: 2000}, err)
+	assert.Equal(t, "fee of 2260 sat exceeds the maximum of 2000 sat", err.Error())
+}
+
+func TestIsFeeLimit(t *testing.T) {
+	assert.False(t, IsFeeLimit(errors.New("no coin selection possible")))
+	assert.False(t, IsFeeLimit(nil))
+}
+
+func TestEstimateFeesFiltersUTXOs(t *testing.T) {
+	// arrange
+	estimator := newEstimator(10000, 0, 0)
+	utxos, _ := estimator.UTXOs.GetUTXOs("address")
+	estimator.Filter.Lock(utxos[0])
+
+	// act
+	_, insufficient := estimator.EstimateFees("address", 60000)
+	result, err := estimator.EstimateFees("address", 40000)
+
+	// assert
+	assert.Equal(t, coinselection.ErrInsufficientFunds, insufficient)
+	require.NoError(t, err)
+	assert.Equal(t, []*common.UTXO{utxos[1]}, result.Set)
+}
@@ -15,8 +15,9 @@ func NewInMemoryUTXOManager() InMemoryUTXOManager {
 // AddUTXO adds a utxo to the pool, idx is used as the identifier from the input list
 func (m InMemoryUTXOManager) AddUTXO(value int64, idx int) {
 	utxo := common.UTXO{
-		Value: value,
-		ID:    idx,
+		Value:         value,
+		ID:            idx,
+		Confirmations: 1, // the simulated txs are mined
 	}
 
 	m.UTXOs[idx] = utxo
@@ -32,6 +32,12 @@ func (s *Simulation) GetFeeRate() (int64, error) {
 }
 
 func NewSimulation(logger *zap.Logger) *Simulation {
+	return NewSimulationWithStrategy(logger, coinselection.RandomCoinSelector{MaxInputs: 10, MinChangeAmount: 0})
+}
+
+// NewSimulationWithStrategy creates a simulation whose wallet selects coins
+// with selector
+func NewSimulationWithStrategy(logger *zap.Logger, selector coinselection.Strategy) *Simulation {
 	txs := readTxs("data/moneypot.csv")
 	startingSet := readTxs("data/UTXO-post-LF.csv")
 	//determine if initial utxo set is needed
@@ -44,7 +50,7 @@ func NewSimulation(logger *zap.Logger) *Simulation {
 	}
 	estimator := &fees.Estimator{
 		Feerater: sim,
-		Selector: coinselection.RandomCoinSelector{MaxInputs: 10, MinChangeAmount: 0},
+		Selector: selector,
 		UTXOs:    utxos,
 	}
 	wallet := &Wallet{
@@ -60,11 +60,21 @@ func (w *Wallet) PrintStats() {
 		return e.Change
 	}).Average()
 
+	avgDustChange := From(w.estimations).SelectT(func(e *fees.EstimationResult) int64 {
+		return e.DustChange
+	}).Average()
+
+	avgWaste := From(w.estimations).SelectT(func(e *fees.EstimationResult) int64 {
+		return e.Waste
+	}).Average()
+
 	w.logger.Info("stats",
 		zap.Any("number of tx sent", w.numberOfTxSent),
 		zap.Any("number of tx received", w.numberOfTxReceived),
 		zap.Any("avg fee", avgFee),
 		zap.Any("avg change", avgChange),
+		zap.Any("avg dust change", avgDustChange),
+		zap.Any("avg waste", avgWaste),
 		zap.Any("resulting balance", w.Balance()),
 		zap.Any("resulting utxos", w.NumberOfUTXOs()),
 	)
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"go.uber.org/zap"
+)
+
+var (
+	// MaxCachedBlocks is the number of blocks a CachedRPCClient keeps, 0
+	// disables caching blocks
+	MaxCachedBlocks = 10
+
+	// MaxCachedBlockHashes is the number of heights below the chain tip a
+	// CachedRPCClient keeps the block hash of
+	MaxCachedBlockHashes int64 = 20000
+)
+
+// newBlockCache creates the cache of blocks by hash, bounded by
+// MaxCachedBlocks. Blocks never change, a reorg only changes which hash is at
+// a height, so they do not expire.
+func newBlockCache() *Cache {
+	return NewCache(CacheOptions{MaxItems: MaxCachedBlocks})
+}
+
+// cachedBlockHash returns the cached hash of the block at height
+func (c *CachedRPCClient) cachedBlockHash(height int64) (*chainhash.Hash, bool) {
+	c.mu.RLock()
+	hash, ok := c.numberToHash[height]
+	c.mu.RUnlock()
+
+	c.metrics.lookup(CacheBlockHashes, ok)
+	if !ok {
+		return nil, false
+	}
+
+	decoded, err := chainhash.NewHashFromStr(hash)
+	if err != nil {
+		return nil, false
+	}
+
+	return decoded, true
+}
+
+func (c *CachedRPCClient) cacheBlockHash(height int64, hash *chainhash.Hash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.numberToHash[height] = hash.String()
+}
+
+// observeTip checks the cached block hashes against the chain tip hash at
+// height. Hashes above the tip and hashes below it that are no longer part of
+// the chain are dropped, walking down from the tip until a cached hash is
+// still part of the chain.
+func (c *CachedRPCClient) observeTip(height int64, hash string) {
+	c.mu.Lock()
+	if c.tipHash == hash {
+		c.mu.Unlock()
+		return
+	}
+
+	c.tipHash = hash
+	c.tipHeight = height
+	dropped := 0
+	for h := range c.numberToHash {
+		if h > height {
+			delete(c.numberToHash, h)
+			dropped++
+		}
+	}
+	if cached, ok := c.numberToHash[height]; ok && cached != hash {
+		dropped++
+	}
+	c.numberToHash[height] = hash
+	c.mu.Unlock()
+
+	for h := height - 1; h >= 0; h-- {
+		c.mu.RLock()
+		cached, ok := c.numberToHash[h]
+		c.mu.RUnlock()
+		if !ok {
+			break
+		}
+
+		var actual *chainhash.Hash
+		err := c.call("getblockhash", func(n *node) (err error) {
+			actual, err = n.rpcClient.GetBlockHash(h)
+			return err
+		})
+		if err != nil {
+			// cannot tell whether the hashes from here on down are still
+			// part of the chain
+			c.mu.Lock()
+			for below := range c.numberToHash {
+				if below <= h {
+					delete(c.numberToHash, below)
+				}
+			}
+			c.mu.Unlock()
+			break
+		}
+
+		if actual.String() == cached {
+			break
+		}
+
+		c.mu.Lock()
+		c.numberToHash[h] = actual.String()
+		c.mu.Unlock()
+		dropped++
+	}
+
+	if dropped > 0 {
+		c.logger.Info("reorg detected, replaced cached block hashes", zap.Int64("tip", height), zap.Int("replaced", dropped))
+	}
+}
+
+// pruneBlockHashes drops the hashes of blocks more than MaxCachedBlockHashes
+// below the tip and returns their number. The caller must hold mu.
+func (c *CachedRPCClient) pruneBlockHashes() int {
+	pruned := 0
+	for h := range c.numberToHash {
+		if h < c.tipHeight-MaxCachedBlockHashes {
+			delete(c.numberToHash, h)
+			pruned++
+		}
+	}
+
+	return pruned
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveTipDropsReplacedBlockHashes(t *testing.T) {
+	// arrange
+	c := newTestClient(nil)
+	c.numberToHash[98] = "a"
+	c.numberToHash[100] = "stale"
+	c.numberToHash[101] = "orphaned"
+
+	// act
+	c.observeTip(100, "tip")
+
+	// assert
+	assert.Equal(t, map[int64]string{98: "a", 100: "tip"}, c.numberToHash)
+	assert.Equal(t, int64(100), c.tipHeight)
+}
+
+func TestPruneBlockHashesKeepsRecentHeights(t *testing.T) {
+	// arrange
+	c := newTestClient(nil)
+	c.tipHeight = MaxCachedBlockHashes + 10
+	c.numberToHash[5] = "old"
+	c.numberToHash[10] = "kept"
+
+	// act
+	pruned := c.pruneBlockHashes()
+
+	// assert
+	assert.Equal(t, 1, pruned)
+	assert.Equal(t, map[int64]string{10: "kept"}, c.numberToHash)
+}
@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheOptions configures a Cache
+type CacheOptions struct {
+	// MaxItems is the number of items the cache keeps at most, 0 does not
+	// limit it
+	MaxItems int
+
+	// MaxBytes is the approximate memory in bytes the items may take as
+	// reported by Size, 0 does not limit it
+	MaxBytes int64
+
+	// TTL is for how long an item is kept after it was set, 0 keeps items
+	// until they are evicted
+	TTL time.Duration
+
+	// Size approximates the memory taken by a value, it is only needed
+	// with MaxBytes
+	Size func(value interface{}) int64
+}
+
+type cacheItem struct {
+	key        interface{}
+	value      interface{}
+	bytes      int64 // approximate memory taken by value
+	expiration time.Time
+}
+
+// expired reports whether the item expired at now
+func (item *cacheItem) expired(now time.Time) bool {
+	return !item.expiration.IsZero() && now.After(item.expiration)
+}
+
+// Cache is an LRU of values by key bounded by the number of items and their
+// approximate size. Items expire after the TTL on top of that. Expired items
+// are dropped when they are looked up or by DeleteExpired. A Cache is safe for
+// concurrent use.
+type Cache struct {
+	options CacheOptions
+	items   map[interface{}]*list.Element
+	lru     *list.List // of *cacheItem, most recently used first
+	bytes   int64
+	metrics CacheMetrics
+	mu      sync.Mutex
+}
+
+// NewCache creates an empty cache
+func NewCache(options CacheOptions) *Cache {
+	return &Cache{
+		options: options,
+		items:   make(map[interface{}]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get returns the value cached for key
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	return c.getAt(key, time.Now())
+}
+
+func (c *Cache) getAt(key interface{}, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		c.metrics.Misses++
+		return nil, false
+	}
+
+	item := element.Value.(*cacheItem)
+	if item.expired(now) {
+		c.remove(element)
+		c.metrics.Evictions++
+		c.metrics.Misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.metrics.Hits++
+	return item.value, true
+}
+
+// Set caches value for key and returns the number of least recently used
+// items evicted to make room for it
+func (c *Cache) Set(key interface{}, value interface{}) int {
+	return c.setAt(key, value, time.Now())
+}
+
+func (c *Cache) setAt(key interface{}, value interface{}, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+
+	item := &cacheItem{key: key, value: value}
+	if c.options.Size != nil {
+		item.bytes = c.options.Size(value)
+	}
+	if c.options.TTL > 0 {
+		item.expiration = now.Add(c.options.TTL)
+	}
+	c.items[key] = c.lru.PushFront(item)
+	c.bytes += item.bytes
+
+	evicted := 0
+	for c.lru.Len() > 1 && c.full() {
+		c.remove(c.lru.Back())
+		evicted++
+	}
+	c.metrics.Evictions += uint64(evicted)
+
+	return evicted
+}
+
+// Remove drops the value cached for key and reports whether there was one
+func (c *Cache) Remove(key interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if ok {
+		c.remove(element)
+	}
+
+	return ok
+}
+
+// full reports whether the cache holds more than it may
+func (c *Cache) full() bool {
+	return (c.options.MaxItems > 0 && c.lru.Len() > c.options.MaxItems) ||
+		(c.options.MaxBytes > 0 && c.bytes > c.options.MaxBytes)
+}
+
+func (c *Cache) remove(element *list.Element) {
+	item := c.lru.Remove(element).(*cacheItem)
+	delete(c.items, item.key)
+	c.bytes -= item.bytes
+}
+
+// DeleteExpired removes the expired items and returns their number
+func (c *Cache) DeleteExpired() int {
+	return c.deleteExpiredAt(time.Now())
+}
+
+func (c *Cache) deleteExpiredAt(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted := 0
+	for element := c.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if element.Value.(*cacheItem).expired(now) {
+			c.remove(element)
+			deleted++
+		}
+		element = previous
+	}
+	c.metrics.Evictions += uint64(deleted)
+
+	return deleted
+}
+
+// Len returns the number of items cached, including expired items not
+// deleted yet
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Metrics returns the lookups and evictions of the cache so far. Evictions
+// include expired items.
+func (c *Cache) Metrics() CacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := c.metrics
+	metrics.Entries = c.lru.Len()
+	return metrics
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// arrange
+	c := NewCache(CacheOptions{MaxItems: 2})
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+
+	// act
+	evicted := c.Set("c", 3)
+
+	// assert
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 2, c.Len())
+	_, found := c.Get("b")
+	assert.False(t, found)
+	value, found := c.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 1, value)
+}
+
+func TestCacheIsBoundedByBytes(t *testing.T) {
+	// arrange
+	tx := &btcjson.TxRawResult{Txid: "a", Hex: strings.Repeat("00", 250)}
+	maxTxs, maxBytes := MaxCachedTxs, MaxCachedTxBytes
+	MaxCachedTxs, MaxCachedTxBytes = 0, 2*txBytes(tx)
+	defer func() { MaxCachedTxs, MaxCachedTxBytes = maxTxs, maxBytes }()
+	c := newTxCache(0)
+
+	// act
+	c.Set("a", tx)
+	c.Set("b", &btcjson.TxRawResult{Txid: "b", Hex: tx.Hex})
+	c.Set("c", &btcjson.TxRawResult{Txid: "c", Hex: tx.Hex})
+	c.Set("c", &btcjson.TxRawResult{Txid: "c", Hex: tx.Hex})
+
+	// assert
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, 2*txBytes(tx), c.bytes)
+}
+
+func TestCacheExpiresItems(t *testing.T) {
+	// arrange
+	now := time.Now()
+	c := NewCache(CacheOptions{TTL: time.Minute})
+	c.setAt("a", 1, now)
+	c.setAt("b", 2, now.Add(time.Minute))
+	c.setAt("c", 3, now.Add(3*time.Minute))
+
+	// act
+	_, expired := c.getAt("a", now.Add(90*time.Second))
+	deleted := c.deleteExpiredAt(now.Add(150 * time.Second))
+
+	// assert
+	assert.False(t, expired)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, CacheMetrics{Misses: 1, Evictions: 2, Entries: 1}, c.Metrics())
+}
@@ -6,7 +6,6 @@ import (
 	"log"
 	"math/big"
 	"net/http"
-	"runtime"
 	"sync"
 	"time"
 
@@ -21,29 +20,52 @@ import (
 var (
 	DefaultExpiration = 5 * time.Hour
 	ErrBlockNotFound  = errors.New("block was not found")
-)
 
-type cacheItem struct {
-	tx         *btcjson.TxRawResult
-	expiration int64
-}
+	// DefaultJanitorInterval is how often expired items are deleted from the
+	// caches by default
+	DefaultJanitorInterval = 5 * time.Minute
+
+	// ErrUnexpectedResult is returned if the node answers with a result that
+	// does not match the call
+	ErrUnexpectedResult = errors.New("unexpected rpc result")
+
+	// ErrClientClosed is returned by calls made after Close
+	ErrClientClosed = errors.New("rpc client is closed")
+
+	// BatchSize is the maximum number of calls sent in one JSON-RPC batch
+	BatchSize = 500
+)
 
 type CachedRPCClient struct {
-	rpcClient  *rpcclient.Client
-	jsonClient jsonrpc.RPCClient
-	rawTxCache map[string]*cacheItem
+	nodes      []*node
+	next       int // index of the node pick tries first
+	nodesMu    sync.Mutex
+	rawTxCache *Cache  // hash->*btcjson.TxRawResult
+	store      TxStore // optional, persists confirmed txs across restarts
+	blockStats *Cache  // block hash->*BlockStats
 	janitor    *janitor
 	logger     *zap.Logger
-
+	metrics    clientMetrics
+	options    ClientOptions
+	esplora    *EsploraClient // optional, fallback for pruned blocks
+
+	// closed is set by Close, inflight counts the calls it waits for
+	closed    bool
+	closeMu   sync.RWMutex
+	inflight  sync.WaitGroup
+	done      chan struct{} // closed by Close, stops the janitor and retry backoffs
+	closeOnce sync.Once
+
+	blocks       *Cache           // hash->*wire.MsgBlock
 	numberToHash map[int64]string //used to allow both loading by number and hash to be cached
-	//TODO numberToHash should also be cleaned up
+	tipHash      string           // of the last observed chain tip, numberToHash is checked for reorgs against it
+	tipHeight    int64
 
 	mu sync.RWMutex
 }
 
 // newBitcoinClient created new Bitcoin JSON RPC client
 func newBitcoinClient(httpClient *http.Client, targetURL string, username, password string) (jsonrpc.RPCClient, error) {
-	targetURL = "http://" + targetURL //hack
 	headers := make(map[string]string)
 	// then check username and password overriddes
 	if username != "" || password != "" {
@@ -67,63 +89,204 @@ func basicAuth(username, password string) string {
 }
 
 func NewCachedRPCClient(btcRPCURL string, btcRPCUser string, btcRPCPassword string, logger *zap.Logger) *CachedRPCClient {
-	// Connect to bitcoin core RPC server using HTTP POST mode.
-	connCfg := &rpcclient.ConnConfig{
-		Host:         btcRPCURL,
-		User:         btcRPCUser,
-		Pass:         btcRPCPassword,
-		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
-		DisableTLS:   true, // Bitcoin core does not provide TLS by default
-	}
-
-	// Notice the notification parameter is nil since notifications are
-	// not supported in HTTP POST mode.
-	client, err := rpcclient.New(connCfg, nil)
+	return NewCachedRPCClientWithStore(btcRPCURL, btcRPCUser, btcRPCPassword, logger, nil)
+}
+
+// NewCachedRPCClientWithStore creates a client that looks up raw txs missing
+// in memory in store and persists the confirmed txs it fetches there
+func NewCachedRPCClientWithStore(btcRPCURL string, btcRPCUser string, btcRPCPassword string, logger *zap.Logger, store TxStore) *CachedRPCClient {
+	config := NodeConfig{URL: btcRPCURL, User: btcRPCUser, Password: btcRPCPassword}
+	client, err := NewCachedRPCClientWithConfig(config, logger, store)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{},
+	return client
+}
+
+// NewCachedRPCClientWithConfig creates a client connecting to the node as
+// described by config, store is optional
+func NewCachedRPCClientWithConfig(config NodeConfig, logger *zap.Logger, store TxStore) (*CachedRPCClient, error) {
+	return NewCachedRPCClientWithNodes([]NodeConfig{config}, logger, store)
+}
+
+// NewCachedRPCClientWithNodes creates a client routing calls across the
+// nodes described by configs, see pick. store is optional.
+func NewCachedRPCClientWithNodes(configs []NodeConfig, logger *zap.Logger, store TxStore) (*CachedRPCClient, error) {
+	return NewCachedRPCClientWithOptions(configs, logger, store, DefaultClientOptions())
+}
+
+// ClientOptions configures the caching of a CachedRPCClient
+type ClientOptions struct {
+	// Expiration is for how long raw txs and block stats are cached
+	Expiration time.Duration
+
+	// JanitorInterval is how often expired items are deleted from the caches
+	JanitorInterval time.Duration
+
+	// EsploraURL is the esplora API blocks are fetched from if the nodes
+	// pruned them, e.g. https://blockstream.info/api. Empty disables the
+	// fallback.
+	EsploraURL string
+
+	// Proxy is the SOCKS5 proxy the esplora API is connected to through, nil
+	// connects directly. The proxy of the nodes is set by NodeConfig.
+	Proxy *Proxy
+}
+
+// DefaultClientOptions returns the options used unless others are given
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Expiration:      DefaultExpiration,
+		JanitorInterval: DefaultJanitorInterval,
 	}
+}
 
-	jsonClient, err := newBitcoinClient(httpClient, btcRPCURL, btcRPCUser, btcRPCPassword)
-	if err != nil {
-		log.Fatal(err)
+// NewCachedRPCClientWithOptions creates a client like
+// NewCachedRPCClientWithNodes caching as configured by options. The client
+// must be closed to stop its janitor and connections.
+func NewCachedRPCClientWithOptions(configs []NodeConfig, logger *zap.Logger, store TxStore, options ClientOptions) (*CachedRPCClient, error) {
+	if len(configs) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	nodes := make([]*node, len(configs))
+	for i, config := range configs {
+		n, err := newNode(config)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes[i] = n
 	}
 
 	C := &CachedRPCClient{
-		rpcClient:    client,
-		jsonClient:   jsonClient,
-		rawTxCache:   make(map[string]*cacheItem),
+		nodes:        nodes,
+		rawTxCache:   newTxCache(options.Expiration),
+		store:        store,
+		blockStats:   NewCache(CacheOptions{TTL: options.Expiration}),
 		mu:           sync.RWMutex{},
 		logger:       logger,
+		blocks:       newBlockCache(),
 		numberToHash: make(map[int64]string),
+		options:      options,
+		done:         make(chan struct{}),
+	}
+	if options.EsploraURL != "" {
+		C.esplora = NewEsploraClientWithProxy(options.EsploraURL, options.Proxy)
 	}
 
-	runJanitor(C, time.Minute*5)
-	runtime.SetFinalizer(C, stopJanitor)
+	runJanitor(C, options.JanitorInterval)
 
-	return C
+	return C, nil
 }
 
 func (c *CachedRPCClient) GetRawTransactionVerbose(hash *chainhash.Hash) (*btcjson.TxRawResult, error) {
 	tx, found := c.get(hash.String())
 	if !found {
-		rawTx, err := c.rpcClient.GetRawTransactionVerbose(hash)
+		var rawTx *btcjson.TxRawResult
+		err := c.call("getrawtransaction", func(n *node) (err error) {
+			rawTx, err = n.rpcClient.GetRawTransactionVerbose(hash)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
 
 		c.set(rawTx)
+		c.persist([]*btcjson.TxRawResult{rawTx})
 		return rawTx, nil
 	}
 
 	return tx, nil
 }
 
+// GetRawTransactionsVerbose returns the txs with the given hashes in the same
+// order. Txs that are not cached are fetched in JSON-RPC batches of BatchSize
+// calls, each tx once even if its hash is given several times. Txs the node
+// could not return are nil, an error is only returned if a whole batch failed.
+func (c *CachedRPCClient) GetRawTransactionsVerbose(hashes []*chainhash.Hash) ([]*btcjson.TxRawResult, error) {
+	txs := make([]*btcjson.TxRawResult, len(hashes))
+	missing := make([]string, 0)
+	positions := make(map[string][]int)
+	for i, hash := range hashes {
+		tx, found := c.get(hash.String())
+		if found {
+			txs[i] = tx
+			continue
+		}
+
+		if _, ok := positions[hash.String()]; !ok {
+			missing = append(missing, hash.String())
+		}
+		positions[hash.String()] = append(positions[hash.String()], i)
+	}
+
+	for start := 0; start < len(missing); start += BatchSize {
+		end := start + BatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		requests := make(jsonrpc.RPCRequests, 0, end-start)
+		for _, hash := range missing[start:end] {
+			// https://bitcoincore.org/en/doc/0.17.0/rpc/rawtransactions/getrawtransaction/
+			requests = append(requests, jsonrpc.NewRequest("getrawtransaction", hash, 1))
+		}
+
+		var responses jsonrpc.RPCResponses
+		err := c.call("getrawtransaction batch", func(n *node) (err error) {
+			responses, err = n.jsonClient.CallBatch(requests)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// CallBatch numbers the requests by their index in the batch
+		fetched := make([]*btcjson.TxRawResult, 0, len(responses))
+		for _, response := range responses {
+			if response.ID < 0 || response.ID >= len(requests) {
+				continue
+			}
+
+			hash := missing[start+response.ID]
+			if response.Error != nil {
+				c.logger.Error("could not get tx", zap.String("hash", hash), zap.Error(response.Error))
+				continue
+			}
+
+			var tx btcjson.TxRawResult
+			err = response.GetObject(&tx)
+			if err != nil {
+				c.logger.Error("could not decode tx", zap.String("hash", hash), zap.Error(err))
+				continue
+			}
+
+			c.set(&tx)
+			fetched = append(fetched, &tx)
+			for _, i := range positions[hash] {
+				txs[i] = &tx
+			}
+		}
+		c.persist(fetched)
+	}
+
+	return txs, nil
+}
+
 func (c *CachedRPCClient) GetBlockChainInfo() (*btcjson.GetBlockChainInfoResult, error) {
-	return c.rpcClient.GetBlockChainInfo()
+	var info *btcjson.GetBlockChainInfoResult
+	err := c.call("getblockchaininfo", func(n *node) (err error) {
+		info, err = n.rpcClient.GetBlockChainInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.observeTip(int64(info.Blocks), info.BestBlockHash)
+	return info, nil
 }
 
 func (c *CachedRPCClient) EstimateSmartFee(numBlocks int64) (float64, error) {
@@ -134,93 +297,489 @@ func (c *CachedRPCClient) EstimateSmartFee(numBlocks int64) (float64, error) {
 
 	// https://bitcoincore.org/en/doc/0.17.0/rpc/util/estimatesmartfee/
 	var fee smartFeeResponse
-	err := c.jsonClient.CallFor(&fee, "estimatesmartfee", numBlocks)
+	err := c.call("estimatesmartfee", func(n *node) error {
+		return n.jsonClient.CallFor(&fee, "estimatesmartfee", numBlocks)
+	})
 
 	return fee.FeeRate, err
 }
 
+// MempoolInfo is the result of getmempoolinfo, fees are in BTC/kB
+type MempoolInfo struct {
+	Size          int64   `json:"size"`
+	Bytes         int64   `json:"bytes"`
+	Usage         int64   `json:"usage"`
+	MaxMempool    int64   `json:"maxmempool"`
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+}
+
+// GetMempoolInfo returns the state of the mempool of the node. It changes
+// with every tx, so it is not cached.
+func (c *CachedRPCClient) GetMempoolInfo() (*MempoolInfo, error) {
+	// https://bitcoincore.org/en/doc/0.17.0/rpc/blockchain/getmempoolinfo/
+	var info MempoolInfo
+	err := c.call("getmempoolinfo", func(n *node) error {
+		return n.jsonClient.CallFor(&info, "getmempoolinfo")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// BlockStats is the part of the result of getblockstats used for estimation
+type BlockStats struct {
+	Height      int64  `json:"height"`
+	BlockHash   string `json:"blockhash"`
+	Txs         int64  `json:"txs"`
+	TotalWeight int64  `json:"total_weight"` // excluding the coinbase
+	TotalFee    int64  `json:"totalfee"`     // satoshi
+
+	// MinFeeRate, AvgFeeRate and MaxFeeRate are in satoshi per virtual byte
+	MinFeeRate int64 `json:"minfeerate"`
+	AvgFeeRate int64 `json:"avgfeerate"`
+	MaxFeeRate int64 `json:"maxfeerate"`
+
+	// FeeRatePercentiles are the 10th, 25th, 50th, 75th and 90th percentile
+	// fee rates in satoshi per virtual byte, weighted by vsize
+	FeeRatePercentiles []float64 `json:"feerate_percentiles"`
+}
+
+// blockStatsFields are the stats requested from getblockstats. Computing all
+// of them is considerably slower.
+var blockStatsFields = []string{"height", "blockhash", "txs", "total_weight", "totalfee", "minfeerate", "avgfeerate", "maxfeerate", "feerate_percentiles"}
+
+// GetBlockStats returns the stats of the block with the given hash. Stats of
+// a block never change, so they are cached by hash.
+func (c *CachedRPCClient) GetBlockStats(hash *chainhash.Hash) (*BlockStats, error) {
+	cached, found := c.blockStats.Get(hash.String())
+	if found {
+		return cached.(*BlockStats), nil
+	}
+
+	// https://bitcoincore.org/en/doc/0.17.0/rpc/blockchain/getblockstats/
+	var stats BlockStats
+	err := c.call("getblockstats", func(n *node) error {
+		return n.jsonClient.CallFor(&stats, "getblockstats", hash.String(), blockStatsFields)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.blockStats.Set(hash.String(), &stats)
+
+	return &stats, nil
+}
+
+// VerboseBlock is the part of the result of getblock with verbosity 2 used
+// for computing fee rates
+type VerboseBlock struct {
+	Hash   string      `json:"hash"`
+	Height int64       `json:"height"`
+	Tx     []VerboseTx `json:"tx"`
+}
+
+// VerboseTx is a tx of a VerboseBlock
+type VerboseTx struct {
+	Txid   string `json:"txid"`
+	Size   int64  `json:"size"`
+	Vsize  int64  `json:"vsize"`
+	Weight int64  `json:"weight"`
+
+	// Fee is in BTC. It is nil for the coinbase and on nodes that do not
+	// report fees in getblock (before 0.22 or without undo data).
+	Fee *float64 `json:"fee"`
+
+	Vin []VerboseTxIn `json:"vin"`
+}
+
+// VerboseTxIn is an input of a VerboseTx
+type VerboseTxIn struct {
+	Txid     string `json:"txid"`
+	Vout     uint32 `json:"vout"`
+	Coinbase string `json:"coinbase"`
+}
+
+// GetVerboseBlock returns the block with the given hash including its
+// decoded txs and their fees
+func (c *CachedRPCClient) GetVerboseBlock(hash *chainhash.Hash) (*VerboseBlock, error) {
+	// https://bitcoincore.org/en/doc/22.0.0/rpc/blockchain/getblock/
+	var block VerboseBlock
+	err := c.call("getblock", func(n *node) error {
+		return n.jsonClient.CallFor(&block, "getblock", hash.String(), 2)
+	})
+	if err != nil && isPrunedError(err) && c.esplora != nil {
+		var fallback *VerboseBlock
+		err = c.fromEsplora("esplora block", func() (err error) {
+			fallback, err = c.esplora.GetVerboseBlock(hash)
+			return err
+		})
+		return fallback, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
 func (c *CachedRPCClient) EstimateFee(numBlocks int64) (float64, error) {
-	return c.rpcClient.EstimateFee(numBlocks)
+	var fee float64
+	err := c.call("estimatefee", func(n *node) (err error) {
+		fee, err = n.rpcClient.EstimateFee(numBlocks)
+		return err
+	})
+
+	return fee, err
 }
 
 func (c *CachedRPCClient) GetBestBlock() (*chainhash.Hash, int32, error) {
-	return c.rpcClient.GetBestBlock()
+	var hash *chainhash.Hash
+	var height int32
+	err := c.call("getbestblock", func(n *node) (err error) {
+		hash, height, err = n.rpcClient.GetBestBlock()
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.observeTip(int64(height), hash.String())
+	return hash, height, nil
 }
 
+// GetBlockHash returns the hash of the block at height. Hashes are cached
+// until a reorg replaces the block at height, see observeTip.
 func (c *CachedRPCClient) GetBlockHash(height int64) (*chainhash.Hash, error) {
-	return c.rpcClient.GetBlockHash(height)
+	cached, found := c.cachedBlockHash(height)
+	if found {
+		return cached, nil
+	}
+
+	var hash *chainhash.Hash
+	err := c.call("getblockhash", func(n *node) (err error) {
+		hash, err = n.rpcClient.GetBlockHash(height)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.cacheBlockHash(height, hash)
+	return hash, nil
 }
 
+// GetBlock returns the block with the given hash. The MaxCachedBlocks most
+// recently used blocks are cached.
 func (c *CachedRPCClient) GetBlock(hash *chainhash.Hash) (*wire.MsgBlock, error) {
-	return c.rpcClient.GetBlock(hash)
+	cached, found := c.blocks.Get(*hash)
+	if found {
+		return cached.(*wire.MsgBlock), nil
+	}
+
+	var block *wire.MsgBlock
+	err := c.call("getblock", func(n *node) (err error) {
+		block, err = n.rpcClient.GetBlock(hash)
+		return err
+	})
+	if err != nil && isPrunedError(err) && c.esplora != nil {
+		err = c.fromEsplora("esplora raw block", func() (err error) {
+			block, err = c.esplora.GetBlock(hash)
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if MaxCachedBlocks > 0 {
+		c.blocks.Set(*hash, block)
+	}
+	return block, nil
+}
+
+func (c *CachedRPCClient) GetBlockHeader(hash *chainhash.Hash) (*wire.BlockHeader, error) {
+	var header *wire.BlockHeader
+	err := c.call("getblockheader", func(n *node) (err error) {
+		header, err = n.rpcClient.GetBlockHeader(hash)
+		return err
+	})
+
+	return header, err
 }
 
 func (c *CachedRPCClient) GetRawMempoolVerbose() (map[string]btcjson.GetRawMempoolVerboseResult, error) {
-	return c.rpcClient.GetRawMempoolVerbose()
+	var pool map[string]btcjson.GetRawMempoolVerboseResult
+	err := c.call("getrawmempool", func(n *node) (err error) {
+		pool, err = n.rpcClient.GetRawMempoolVerbose()
+		return err
+	})
+
+	return pool, err
+}
+
+// MempoolEntry is a tx of the result of getrawmempool with verbose set. Next
+// to the fields of btcjson.GetRawMempoolVerboseResult it holds the package
+// and BIP125 fields needed for package and RBF aware estimation.
+type MempoolEntry struct {
+	Size    int32    `json:"size"`
+	Vsize   int32    `json:"vsize"`
+	Fee     float64  `json:"fee"`         // BTC
+	ModFee  float64  `json:"modifiedfee"` // BTC, fee with prioritisetransaction deltas
+	Time    int64    `json:"time"`
+	Height  int64    `json:"height"`
+	Depends []string `json:"depends"`
+
+	// StartingPriority and CurrentPriority are only reported by nodes
+	// before 0.15
+	StartingPriority float64 `json:"startingpriority"`
+	CurrentPriority  float64 `json:"currentpriority"`
+
+	// counts, sizes in virtual bytes and fees in satoshi of the tx together
+	// with its unconfirmed descendants or ancestors
+	DescendantCount int64 `json:"descendantcount"`
+	DescendantSize  int64 `json:"descendantsize"`
+	DescendantFees  int64 `json:"descendantfees"`
+	AncestorCount   int64 `json:"ancestorcount"`
+	AncestorSize    int64 `json:"ancestorsize"`
+	AncestorFees    int64 `json:"ancestorfees"`
+
+	// BIP125Replaceable is "yes" if the tx or an unconfirmed ancestor
+	// signals replaceability, "no" if not and "unknown" or empty if the node
+	// cannot tell
+	BIP125Replaceable string `json:"bip125-replaceable"`
+}
+
+// GetRawMempoolEntries returns the txs in the mempool of the node by hash
+func (c *CachedRPCClient) GetRawMempoolEntries() (map[string]MempoolEntry, error) {
+	// https://bitcoincore.org/en/doc/0.17.0/rpc/blockchain/getrawmempool/
+	var pool map[string]MempoolEntry
+	err := c.call("getrawmempool", func(n *node) error {
+		return n.jsonClient.CallFor(&pool, "getrawmempool", true)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// GetMempoolEntry returns the mempool entry of the tx with the given hash
+func (c *CachedRPCClient) GetMempoolEntry(hash string) (*MempoolEntry, error) {
+	// https://bitcoincore.org/en/doc/0.17.0/rpc/blockchain/getmempoolentry/
+	var entry *MempoolEntry
+	err := c.call("getmempoolentry", func(n *node) error {
+		return n.jsonClient.CallFor(&entry, "getmempoolentry", hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// MempoolAcceptResult is the result of testmempoolaccept for a single tx
+type MempoolAcceptResult struct {
+	Txid    string `json:"txid"`
+	Allowed bool   `json:"allowed"`
+
+	// RejectReason is why the node would not accept the tx, empty if it
+	// would
+	RejectReason string `json:"reject-reason"`
+
+	// Vsize and Fees are only reported for allowed txs by nodes from 0.21 on
+	Vsize int64              `json:"vsize"`
+	Fees  *MempoolAcceptFees `json:"fees"`
+}
+
+// MempoolAcceptFees are the fees of a tx accepted by testmempoolaccept
+type MempoolAcceptFees struct {
+	Base float64 `json:"base"` // BTC
+
+	// EffectiveFeeRate is the fee rate of the package the tx was accepted
+	// with in BTC/kvB, only reported by nodes from 25.0 on
+	EffectiveFeeRate float64 `json:"effective-feerate"`
+}
+
+// FeeRate returns the fee rate the tx would enter the mempool at in satoshi
+// per virtual byte, 0 if the node did not report it
+func (r *MempoolAcceptResult) FeeRate() float64 {
+	if r.Fees == nil {
+		return 0
+	}
+
+	if r.Fees.EffectiveFeeRate > 0 {
+		// BTC/kvB to satoshi/vB
+		return r.Fees.EffectiveFeeRate * BTC / 1000
+	}
+
+	if r.Vsize > 0 {
+		return r.Fees.Base * BTC / float64(r.Vsize)
+	}
+
+	return 0
+}
+
+// TestMempoolAccept checks whether the node would accept the raw tx, given
+// hex encoded, into its mempool without broadcasting it
+func (c *CachedRPCClient) TestMempoolAccept(rawTx string) (*MempoolAcceptResult, error) {
+	// https://bitcoincore.org/en/doc/0.17.0/rpc/rawtransactions/testmempoolaccept/
+	var results []MempoolAcceptResult
+	err := c.call("testmempoolaccept", func(n *node) error {
+		return n.jsonClient.CallFor(&results, "testmempoolaccept", []string{rawTx})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) != 1 {
+		return nil, ErrUnexpectedResult
+	}
+
+	return &results[0], nil
+}
+
+// NewWebsocketConfig returns the config to connect to the websocket endpoint
+// of a btcd node for notifications. Bitcoin core does not support websockets.
+func NewWebsocketConfig(btcRPCURL string, btcRPCUser string, btcRPCPassword string) *rpcclient.ConnConfig {
+	return &rpcclient.ConnConfig{
+		Host:       btcRPCURL,
+		Endpoint:   "ws",
+		User:       btcRPCUser,
+		Pass:       btcRPCPassword,
+		DisableTLS: true,
+	}
 }
 
 func (c *CachedRPCClient) get(hash string) (*btcjson.TxRawResult, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	item, found := c.rawTxCache[hash]
-	if found && item != nil {
-		return item.tx, found
+	cached, found := c.rawTxCache.Get(hash)
+	if found {
+		return cached.(*btcjson.TxRawResult), true
+	}
+	if c.store == nil {
+		return nil, false
+	}
+
+	tx, err := c.store.Load(hash)
+	c.metrics.lookup(CacheTxStore, err == nil)
+	if err != nil {
+		if err != ErrTxNotStored {
+			c.logger.Error("could not load stored tx", zap.String("hash", hash), zap.Error(err))
+		}
+
+		return nil, false
+	}
+
+	c.set(tx)
+	return tx, true
+}
+
+// persist stores the confirmed ones of txs if the client has a store
+func (c *CachedRPCClient) persist(txs []*btcjson.TxRawResult) {
+	if c.store == nil || len(txs) == 0 {
+		return
+	}
+
+	err := c.store.Store(txs)
+	if err != nil {
+		c.logger.Error("could not store txs", zap.Int("txs", len(txs)), zap.Error(err))
 	}
-	return nil, false
 }
 
 func (c *CachedRPCClient) set(tx *btcjson.TxRawResult) {
-	c.mu.Lock()
-	expiration := time.Now().Add(DefaultExpiration).UnixNano()
-	c.rawTxCache[tx.Hash] = &cacheItem{tx: tx, expiration: expiration}
-	c.mu.Unlock()
+	c.rawTxCache.Set(tx.Txid, tx)
 }
 
 // deleteExpired all expired items from the cache.
 func (c *CachedRPCClient) deleteExpired() {
-	c.logger.Info("deleting expired items")
-	now := time.Now().UnixNano()
+	rawTxs := c.rawTxCache.DeleteExpired()
+	blockStats := c.blockStats.DeleteExpired()
 	c.mu.Lock()
-	for k, v := range c.rawTxCache {
-		// "Inlining" of expired
-		if v.expiration > 0 && now > v.expiration {
-			c.logger.Info("deleted item", zap.String("key", k))
-			delete(c.rawTxCache, k)
-		}
-	}
+	blockHashes := c.pruneBlockHashes()
 	c.mu.Unlock()
+
+	c.logger.Info("deleted expired items", zap.Int("raw txs", rawTxs), zap.Int("block stats", blockStats))
+	c.metrics.evicted(CacheBlockHashes, blockHashes)
 }
 
+// Close stops the janitor, waits for the calls in flight to return and shuts
+// down the connections to the nodes and the store. Calls made after Close fail
+// with ErrClientClosed. Close may be called more than once.
 func (c *CachedRPCClient) Close() {
-	c.rpcClient.WaitForShutdown()
+	c.closeOnce.Do(func() {
+		c.closeMu.Lock()
+		c.closed = true
+		c.closeMu.Unlock()
+
+		close(c.done)
+		c.inflight.Wait()
+
+		for _, n := range c.nodes {
+			if rpcClient := n.clients().rpcClient; rpcClient != nil {
+				rpcClient.Shutdown()
+				rpcClient.WaitForShutdown()
+			}
+		}
+		if c.store != nil {
+			IgnoreError(c.store.Close())
+		}
+	})
+}
+
+// begin registers a call in flight, it returns ErrClientClosed once the
+// client is closed. Every successful begin must be followed by end.
+func (c *CachedRPCClient) begin() error {
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+
+	if c.closed {
+		return ErrClientClosed
+	}
+
+	c.inflight.Add(1)
+	return nil
+}
+
+// end marks a call registered by begin as returned
+func (c *CachedRPCClient) end() {
+	c.inflight.Done()
 }
 
 type janitor struct {
-	Interval time.Duration
-	stop     chan bool
+	Interval     time.Duration
+	NodeInterval time.Duration // 0 does not check the nodes
 }
 
 func (j *janitor) Run(c *CachedRPCClient) {
 	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	var nodeChecks <-chan time.Time
+	if j.NodeInterval > 0 {
+		nodeTicker := time.NewTicker(j.NodeInterval)
+		defer nodeTicker.Stop()
+		nodeChecks = nodeTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
 			c.deleteExpired()
-		case <-j.stop:
-			ticker.Stop()
+		case <-nodeChecks:
+			c.checkNodes()
+		case <-c.done:
 			return
 		}
 	}
 }
 
-func stopJanitor(c *CachedRPCClient) {
-	c.janitor.stop <- true
-}
-
 func runJanitor(c *CachedRPCClient, ci time.Duration) {
 	j := &janitor{
 		Interval: ci,
-		stop:     make(chan bool),
+	}
+	if len(c.nodes) > 1 {
+		j.NodeInterval = NodeCheckInterval
 	}
 	c.janitor = j
 	go j.Run(c)
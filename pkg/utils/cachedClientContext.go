@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	// CallTimeout bounds every call made through one of the ...Ctx methods
+	// of CachedRPCClient on top of the deadline of the context, 0 disables
+	// it
+	CallTimeout = 2 * time.Minute
+)
+
+// callContext runs call until it returns or ctx is done, whichever comes
+// first. Neither the btcd client nor the JSON-RPC client can abort a request,
+// so a call outliving ctx keeps running in the background and its result is
+// discarded.
+func callContext(ctx context.Context, call func() error) error {
+	if CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CallTimeout)
+		defer cancel()
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetBlockChainInfoCtx is GetBlockChainInfo bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetBlockChainInfoCtx(ctx context.Context) (*btcjson.GetBlockChainInfoResult, error) {
+	var info *btcjson.GetBlockChainInfoResult
+	err := callContext(ctx, func() (err error) {
+		info, err = c.GetBlockChainInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// GetBlockHashCtx is GetBlockHash bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetBlockHashCtx(ctx context.Context, height int64) (*chainhash.Hash, error) {
+	var hash *chainhash.Hash
+	err := callContext(ctx, func() (err error) {
+		hash, err = c.GetBlockHash(height)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// GetBlockCtx is GetBlock bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetBlockCtx(ctx context.Context, hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	var block *wire.MsgBlock
+	err := callContext(ctx, func() (err error) {
+		block, err = c.GetBlock(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// GetVerboseBlockCtx is GetVerboseBlock bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetVerboseBlockCtx(ctx context.Context, hash *chainhash.Hash) (*VerboseBlock, error) {
+	var block *VerboseBlock
+	err := callContext(ctx, func() (err error) {
+		block, err = c.GetVerboseBlock(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// GetRawTransactionVerboseCtx is GetRawTransactionVerbose bounded by ctx and
+// CallTimeout
+func (c *CachedRPCClient) GetRawTransactionVerboseCtx(ctx context.Context, hash *chainhash.Hash) (*btcjson.TxRawResult, error) {
+	var tx *btcjson.TxRawResult
+	err := callContext(ctx, func() (err error) {
+		tx, err = c.GetRawTransactionVerbose(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// GetRawTransactionsVerboseCtx is GetRawTransactionsVerbose bounded by ctx
+// and CallTimeout
+func (c *CachedRPCClient) GetRawTransactionsVerboseCtx(ctx context.Context, hashes []*chainhash.Hash) ([]*btcjson.TxRawResult, error) {
+	var txs []*btcjson.TxRawResult
+	err := callContext(ctx, func() (err error) {
+		txs, err = c.GetRawTransactionsVerbose(hashes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+// GetBlockStatsCtx is GetBlockStats bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetBlockStatsCtx(ctx context.Context, hash *chainhash.Hash) (*BlockStats, error) {
+	var stats *BlockStats
+	err := callContext(ctx, func() (err error) {
+		stats, err = c.GetBlockStats(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// GetMempoolInfoCtx is GetMempoolInfo bounded by ctx and CallTimeout
+func (c *CachedRPCClient) GetMempoolInfoCtx(ctx context.Context) (*MempoolInfo, error) {
+	var info *MempoolInfo
+	err := callContext(ctx, func() (err error) {
+		info, err = c.GetMempoolInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// GetRawMempoolEntriesCtx is GetRawMempoolEntries bounded by ctx and
+// CallTimeout
+func (c *CachedRPCClient) GetRawMempoolEntriesCtx(ctx context.Context) (map[string]MempoolEntry, error) {
+	var pool map[string]MempoolEntry
+	err := callContext(ctx, func() (err error) {
+		pool, err = c.GetRawMempoolEntries()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// EstimateSmartFeeCtx is EstimateSmartFee bounded by ctx and CallTimeout
+func (c *CachedRPCClient) EstimateSmartFeeCtx(ctx context.Context, numBlocks int64) (float64, error) {
+	var fee float64
+	err := callContext(ctx, func() (err error) {
+		fee, err = c.EstimateSmartFee(numBlocks)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return fee, nil
+}
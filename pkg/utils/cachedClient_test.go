@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+	"go.uber.org/zap"
+)
+
+// batchClient answers getrawtransaction batches with the txs it knows
+type batchClient struct {
+	jsonrpc.RPCClient
+	txs     map[string]bool
+	batches []jsonrpc.RPCRequests
+}
+
+func (b *batchClient) CallBatch(requests jsonrpc.RPCRequests) (jsonrpc.RPCResponses, error) {
+	b.batches = append(b.batches, requests)
+
+	responses := make(jsonrpc.RPCResponses, len(requests))
+	for i, request := range requests {
+		hash := request.Params.([]interface{})[0].(string)
+		responses[i] = &jsonrpc.RPCResponse{ID: i}
+		if b.txs[hash] {
+			responses[i].Result = btcjson.TxRawResult{Txid: hash}
+		} else {
+			responses[i].Error = &jsonrpc.RPCError{Code: -5, Message: "No such mempool or blockchain transaction"}
+		}
+	}
+
+	return responses, nil
+}
+
+func newTestClient(jsonClient jsonrpc.RPCClient) *CachedRPCClient {
+	return &CachedRPCClient{
+		nodes:        []*node{{url: "test", jsonClient: jsonClient}},
+		rawTxCache:   newTxCache(DefaultExpiration),
+		blockStats:   NewCache(CacheOptions{TTL: DefaultExpiration}),
+		blocks:       newBlockCache(),
+		numberToHash: make(map[int64]string),
+		logger:       zap.NewNop(),
+		options:      DefaultClientOptions(),
+		done:         make(chan struct{}),
+	}
+}
+
+func testHash(t *testing.T, b byte) *chainhash.Hash {
+	hash, err := chainhash.NewHash(append(make([]byte, 31), b))
+	require.NoError(t, err)
+	return hash
+}
+
+func TestGetRawTransactionsVerboseBatchesMissingTxs(t *testing.T) {
+	// arrange
+	batchSize := BatchSize
+	BatchSize = 2
+	defer func() { BatchSize = batchSize }()
+
+	known, duplicate, unknown, cached := testHash(t, 1), testHash(t, 2), testHash(t, 3), testHash(t, 4)
+	jsonClient := &batchClient{txs: map[string]bool{known.String(): true, duplicate.String(): true}}
+	c := newTestClient(jsonClient)
+	c.set(&btcjson.TxRawResult{Txid: cached.String()})
+
+	// act
+	txs, err := c.GetRawTransactionsVerbose([]*chainhash.Hash{known, duplicate, cached, unknown, duplicate})
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, txs, 5)
+	assert.Equal(t, known.String(), txs[0].Txid)
+	assert.Equal(t, duplicate.String(), txs[1].Txid)
+	assert.Equal(t, cached.String(), txs[2].Txid)
+	assert.Nil(t, txs[3])
+	assert.Equal(t, duplicate.String(), txs[4].Txid)
+	assert.Len(t, jsonClient.batches, 2)
+	_, found := c.get(known.String())
+	assert.True(t, found)
+}
+
+// failingClient fails every batch
+type failingClient struct {
+	jsonrpc.RPCClient
+}
+
+func (failingClient) CallBatch(requests jsonrpc.RPCRequests) (jsonrpc.RPCResponses, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestGetRawTransactionsVerboseFailsWithBatch(t *testing.T) {
+	// arrange
+	attempts := RPCRetryAttempts
+	RPCRetryAttempts = 0
+	defer func() { RPCRetryAttempts = attempts }()
+
+	c := newTestClient(failingClient{})
+
+	// act
+	_, err := c.GetRawTransactionsVerbose([]*chainhash.Hash{testHash(t, 1)})
+
+	// assert
+	assert.Error(t, err)
+}
+
+// statsClient answers getblockstats and counts the calls
+type statsClient struct {
+	jsonrpc.RPCClient
+	calls int
+}
+
+func (s *statsClient) CallFor(out interface{}, method string, params ...interface{}) error {
+	s.calls++
+	stats := out.(*BlockStats)
+	stats.BlockHash = params[0].(string)
+	stats.Txs = 2
+	stats.FeeRatePercentiles = []float64{1, 2, 3, 4, 5}
+	return nil
+}
+
+func TestGetBlockStatsCachesByHash(t *testing.T) {
+	// arrange
+	jsonClient := &statsClient{}
+	client := newTestClient(jsonClient)
+	hash := testHash(t, 1)
+
+	// act
+	first, err := client.GetBlockStats(hash)
+	require.NoError(t, err)
+	second, err := client.GetBlockStats(hash)
+	require.NoError(t, err)
+	_, err = client.GetBlockStats(testHash(t, 2))
+	require.NoError(t, err)
+
+	// assert
+	assert.Equal(t, 2, jsonClient.calls)
+	assert.Equal(t, hash.String(), first.BlockHash)
+	assert.Equal(t, first, second)
+}
+
+// blockingClient blocks getmempoolinfo until release is closed
+type blockingClient struct {
+	jsonrpc.RPCClient
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingClient) CallFor(out interface{}, method string, params ...interface{}) error {
+	close(b.started)
+	<-b.release
+	return nil
+}
+
+func TestCloseWaitsForCallsInFlight(t *testing.T) {
+	// arrange
+	jsonClient := &blockingClient{started: make(chan struct{}), release: make(chan struct{})}
+	client := newTestClient(jsonClient)
+	go func() {
+		_, _ = client.GetMempoolInfo()
+	}()
+	<-jsonClient.started
+
+	// act
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+
+	// assert
+	select {
+	case <-closed:
+		t.Fatal("close returned while a call was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(jsonClient.release)
+	<-closed
+	_, err := client.GetMempoolInfo()
+	assert.Equal(t, ErrClientClosed, err)
+	client.Close()
+}
+
+// acceptClient answers testmempoolaccept with result
+type acceptClient struct {
+	jsonrpc.RPCClient
+	result string
+	params []interface{}
+}
+
+func (a *acceptClient) CallFor(out interface{}, method string, params ...interface{}) error {
+	a.params = params
+	return json.Unmarshal([]byte(a.result), out)
+}
+
+func TestTestMempoolAccept(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  string
+		allowed bool
+		reason  string
+		feeRate float64
+	}{
+		{"rejected", `[{"txid":"a","allowed":false,"reject-reason":"min relay fee not met"}]`, false, "min relay fee not met", 0},
+		{"base fee", `[{"txid":"a","allowed":true,"vsize":200,"fees":{"base":0.00002}}]`, true, "", 10},
+		{"effective feerate", `[{"txid":"a","allowed":true,"vsize":200,"fees":{"base":0.00002,"effective-feerate":0.00015}}]`, true, "", 15},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// arrange
+			jsonClient := &acceptClient{result: test.result}
+			client := newTestClient(jsonClient)
+
+			// act
+			result, err := client.TestMempoolAccept("0100")
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{[]string{"0100"}}, jsonClient.params)
+			assert.Equal(t, test.allowed, result.Allowed)
+			assert.Equal(t, test.reason, result.RejectReason)
+			assert.InDelta(t, test.feeRate, result.FeeRate(), 1e-9)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// CacheRawTx names the cache of raw txs in ClientMetrics
+	CacheRawTx = "rawtx"
+
+	// CacheBlockStats names the cache of block stats in ClientMetrics
+	CacheBlockStats = "blockstats"
+
+	// CacheBlocks names the cache of blocks in ClientMetrics
+	CacheBlocks = "blocks"
+
+	// CacheBlockHashes names the cache of block hashes by height in
+	// ClientMetrics
+	CacheBlockHashes = "blockhashes"
+
+	// CacheTxStore names the lookups of raw txs missing in memory in the
+	// TxStore in ClientMetrics
+	CacheTxStore = "txstore"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of the rpc latency
+// histograms. Slower calls are counted in an additional last bucket.
+var LatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// MetricsSource is implemented by clients whose metrics can be scraped by a
+// metrics endpoint
+type MetricsSource interface {
+	Metrics() ClientMetrics
+}
+
+// ClientMetrics is a snapshot of the cache and rpc metrics of a
+// CachedRPCClient
+type ClientMetrics struct {
+	Caches map[string]CacheMetrics `json:"caches"` // by cache name
+	RPCs   map[string]RPCMetrics   `json:"rpcs"`   // by rpc
+}
+
+// CacheMetrics counts the lookups of a cache
+type CacheMetrics struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+// RPCMetrics counts the calls of an rpc. Retries are counted as calls of
+// their own.
+type RPCMetrics struct {
+	Calls   uint64    `json:"calls"`
+	Errors  uint64    `json:"errors"`
+	Latency Histogram `json:"latency"`
+}
+
+// Histogram counts durations in the buckets of LatencyBuckets
+type Histogram struct {
+	Buckets []time.Duration `json:"buckets"` // upper bounds
+	Counts  []uint64        `json:"counts"`  // one more than Buckets for slower calls
+	Sum     time.Duration   `json:"sum"`
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Buckets = LatencyBuckets
+		h.Counts = make([]uint64, len(LatencyBuckets)+1)
+	}
+
+	i := 0
+	for i < len(h.Buckets) && d > h.Buckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += d
+}
+
+func (h Histogram) copy() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// clientMetrics collects the metrics of a CachedRPCClient
+type clientMetrics struct {
+	caches map[string]*CacheMetrics
+	rpcs   map[string]*RPCMetrics
+	mu     sync.Mutex
+}
+
+func (m *clientMetrics) cache(name string) *CacheMetrics {
+	if m.caches == nil {
+		m.caches = make(map[string]*CacheMetrics)
+	}
+
+	cache, ok := m.caches[name]
+	if !ok {
+		cache = &CacheMetrics{}
+		m.caches[name] = cache
+	}
+
+	return cache
+}
+
+// lookup counts a hit or miss of the named cache
+func (m *clientMetrics) lookup(name string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if hit {
+		m.cache(name).Hits++
+	} else {
+		m.cache(name).Misses++
+	}
+}
+
+// evicted counts evictions of the named cache
+func (m *clientMetrics) evicted(name string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cache(name).Evictions += uint64(count)
+}
+
+// called counts a call of op that took d
+func (m *clientMetrics) called(op string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rpcs == nil {
+		m.rpcs = make(map[string]*RPCMetrics)
+	}
+
+	rpc, ok := m.rpcs[op]
+	if !ok {
+		rpc = &RPCMetrics{}
+		m.rpcs[op] = rpc
+	}
+
+	rpc.Calls++
+	if err != nil {
+		rpc.Errors++
+	}
+	rpc.Latency.observe(d)
+}
+
+// snapshot copies the metrics, entries holds the current number of entries
+// by cache name. caches are the metrics of the caches that count their
+// lookups themselves.
+func (m *clientMetrics) snapshot(entries map[string]int, caches map[string]*Cache) ClientMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := ClientMetrics{
+		Caches: make(map[string]CacheMetrics),
+		RPCs:   make(map[string]RPCMetrics, len(m.rpcs)),
+	}
+	for name := range entries {
+		m.cache(name)
+	}
+	for name, cache := range m.caches {
+		copied := *cache
+		copied.Entries = entries[name]
+		metrics.Caches[name] = copied
+	}
+	for name, cache := range caches {
+		metrics.Caches[name] = cache.Metrics()
+	}
+	for op, rpc := range m.rpcs {
+		copied := *rpc
+		copied.Latency = rpc.Latency.copy()
+		metrics.RPCs[op] = copied
+	}
+
+	return metrics
+}
+
+// Metrics returns the cache and rpc metrics of the client
+func (c *CachedRPCClient) Metrics() ClientMetrics {
+	c.mu.RLock()
+	entries := map[string]int{
+		CacheBlockHashes: len(c.numberToHash),
+	}
+	c.mu.RUnlock()
+
+	caches := map[string]*Cache{
+		CacheRawTx:      c.rawTxCache,
+		CacheBlockStats: c.blockStats,
+		CacheBlocks:     c.blocks,
+	}
+	return c.metrics.snapshot(entries, caches)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramCountsDurationsInBuckets(t *testing.T) {
+	// arrange
+	var h Histogram
+
+	// act
+	h.observe(5 * time.Millisecond)
+	h.observe(10 * time.Millisecond)
+	h.observe(time.Minute)
+
+	// assert
+	assert.Equal(t, uint64(2), h.Counts[0])
+	assert.Equal(t, uint64(1), h.Counts[len(LatencyBuckets)])
+	assert.Equal(t, time.Minute+15*time.Millisecond, h.Sum)
+}
+
+func TestClientMetrics(t *testing.T) {
+	// arrange
+	c := newTestClient(nil)
+	c.set(&btcjson.TxRawResult{Txid: "cached"})
+
+	// act
+	c.get("cached")
+	c.get("missing")
+	c.metrics.called("getblock", time.Millisecond, nil)
+	c.metrics.called("getblock", time.Second, errors.New("timeout"))
+	metrics := c.Metrics()
+	c.metrics.called("getblock", time.Millisecond, nil)
+
+	// assert
+	assert.Equal(t, CacheMetrics{Hits: 1, Misses: 1, Entries: 1}, metrics.Caches[CacheRawTx])
+	assert.Equal(t, CacheMetrics{}, metrics.Caches[CacheBlockStats])
+	assert.Equal(t, uint64(2), metrics.RPCs["getblock"].Calls)
+	assert.Equal(t, uint64(1), metrics.RPCs["getblock"].Errors)
+	assert.Equal(t, uint64(1), metrics.RPCs["getblock"].Latency.Counts[0])
+}
+
+var _ MetricsSource = &CachedRPCClient{}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ybbus/jsonrpc"
+	"go.uber.org/zap"
+)
+
+var (
+	// esploraPageSize is the number of txs esplora returns per page of
+	// /block/:hash/txs
+	esploraPageSize = 25
+)
+
+// EsploraClient fetches blocks from the REST API of an esplora instance,
+// e.g. https://blockstream.info/api. It is the fallback for blocks pruned
+// nodes no longer have.
+type EsploraClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewEsploraClient creates a client for the esplora API at baseURL
+func NewEsploraClient(baseURL string) *EsploraClient {
+	return NewEsploraClientWithProxy(baseURL, nil)
+}
+
+// NewEsploraClientWithProxy creates a client for the esplora API at baseURL
+// connecting through proxy
+func NewEsploraClientWithProxy(baseURL string, proxy *Proxy) *EsploraClient {
+	return &EsploraClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: NewHTTPClient(proxy, CallTimeout),
+	}
+}
+
+// esploraBlock is the part of /block/:hash used
+type esploraBlock struct {
+	ID      string `json:"id"`
+	Height  int64  `json:"height"`
+	TxCount int    `json:"tx_count"`
+}
+
+// esploraTx is the part of a tx of /block/:hash/txs used
+type esploraTx struct {
+	Txid   string `json:"txid"`
+	Size   int64  `json:"size"`
+	Weight int64  `json:"weight"`
+	Fee    int64  `json:"fee"` // satoshi
+	Vin    []struct {
+		Txid       string `json:"txid"`
+		Vout       uint32 `json:"vout"`
+		ScriptSig  string `json:"scriptsig"`
+		IsCoinbase bool   `json:"is_coinbase"`
+	} `json:"vin"`
+}
+
+// GetVerboseBlock returns the block with the given hash in the format of
+// getblock with verbosity 2. Txs are fetched page by page.
+func (e *EsploraClient) GetVerboseBlock(hash *chainhash.Hash) (*VerboseBlock, error) {
+	var info esploraBlock
+	err := e.getJSON(fmt.Sprintf("/block/%s", hash), &info)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &VerboseBlock{
+		Hash:   info.ID,
+		Height: info.Height,
+		Tx:     make([]VerboseTx, 0, info.TxCount),
+	}
+	for start := 0; start < info.TxCount; start += esploraPageSize {
+		var txs []esploraTx
+		err := e.getJSON(fmt.Sprintf("/block/%s/txs/%d", hash, start), &txs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range txs {
+			block.Tx = append(block.Tx, tx.verbose())
+		}
+	}
+
+	return block, nil
+}
+
+// verbose converts the tx to the format of getblock with verbosity 2
+func (tx esploraTx) verbose() VerboseTx {
+	verbose := VerboseTx{
+		Txid:   tx.Txid,
+		Size:   tx.Size,
+		Vsize:  (tx.Weight + 3) / 4,
+		Weight: tx.Weight,
+		Vin:    make([]VerboseTxIn, len(tx.Vin)),
+	}
+
+	coinbase := false
+	for i, in := range tx.Vin {
+		verbose.Vin[i] = VerboseTxIn{Txid: in.Txid, Vout: in.Vout}
+		if in.IsCoinbase {
+			verbose.Vin[i] = VerboseTxIn{Coinbase: in.ScriptSig}
+			coinbase = true
+		}
+	}
+
+	if !coinbase {
+		fee := float64(tx.Fee) / BTC
+		verbose.Fee = &fee
+	}
+
+	return verbose
+}
+
+// GetBlock returns the block with the given hash
+func (e *EsploraClient) GetBlock(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	raw, err := e.get(fmt.Sprintf("/block/%s/raw", hash))
+	if err != nil {
+		return nil, err
+	}
+
+	var block wire.MsgBlock
+	err = block.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+func (e *EsploraClient) getJSON(path string, out interface{}) error {
+	body, err := e.get(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func (e *EsploraClient) get(path string) ([]byte, error) {
+	resp, err := e.httpClient.Get(e.baseURL + path)
+	if err != nil {
+		return nil, err
+	}
+	defer IgnoreErrorOn(resp.Body.Close)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("esplora %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// fromEsplora calls fn, which fetches from the esplora fallback, and records
+// it like an rpc call
+func (c *CachedRPCClient) fromEsplora(op string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	c.metrics.called(op, time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("fetched pruned block from esplora", zap.String("op", op))
+	return nil
+}
+
+// isPrunedError reports whether err is the error nodes return for blocks
+// they pruned
+func isPrunedError(err error) bool {
+	switch err := err.(type) {
+	case *btcjson.RPCError:
+		return strings.Contains(err.Message, "pruned")
+	case *jsonrpc.RPCError:
+		return strings.Contains(err.Message, "pruned")
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ybbus/jsonrpc"
+)
+
+// prunedClient fails getblock like a pruned node
+type prunedClient struct {
+	jsonrpc.RPCClient
+}
+
+func (prunedClient) CallFor(out interface{}, method string, params ...interface{}) error {
+	return &jsonrpc.RPCError{Code: -1, Message: "Block not available (pruned data)"}
+}
+
+func newEsploraServer(t *testing.T, hash string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/block/%s", hash), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":"%s","height":100,"tx_count":2}`, hash)
+	})
+	mux.HandleFunc(fmt.Sprintf("/block/%s/txs/0", hash), func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"txid":"a","size":200,"weight":800,"fee":0,"vin":[{"is_coinbase":true,"scriptsig":"0164"}]},
+			{"txid":"b","size":250,"weight":561,"fee":1410,"vin":[{"txid":"c","vout":1}]}
+		]`)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestGetVerboseBlockFallsBackToEsplora(t *testing.T) {
+	// arrange
+	hash := testHash(t, 1)
+	server := newEsploraServer(t, hash.String())
+	defer server.Close()
+
+	client := newTestClient(prunedClient{})
+	client.esplora = NewEsploraClient(server.URL + "/")
+
+	// act
+	block, err := client.GetVerboseBlock(hash)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, hash.String(), block.Hash)
+	assert.Equal(t, int64(100), block.Height)
+	require.Len(t, block.Tx, 2)
+	assert.Equal(t, "0164", block.Tx[0].Vin[0].Coinbase)
+	assert.Nil(t, block.Tx[0].Fee)
+	assert.Equal(t, int64(141), block.Tx[1].Vsize)
+	assert.Equal(t, VerboseTxIn{Txid: "c", Vout: 1}, block.Tx[1].Vin[0])
+	require.NotNil(t, block.Tx[1].Fee)
+	assert.InDelta(t, 0.0000141, *block.Tx[1].Fee, 1e-12)
+}
+
+func TestGetVerboseBlockWithoutFallback(t *testing.T) {
+	// arrange
+	client := newTestClient(prunedClient{})
+
+	// act
+	_, err := client.GetVerboseBlock(testHash(t, 1))
+
+	// assert
+	assert.True(t, isPrunedError(err))
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces fileName with data. It writes to a temporary file first
+// so that a crash never leaves a truncated file behind.
+func WriteFile(fileName string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(fileName), 0770)
+	if err != nil {
+		return err
+	}
+
+	tmpFile := fileName + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0660)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, fileName)
+}
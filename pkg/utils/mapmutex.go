@@ -3,6 +3,7 @@ package utils
 //borrowed from https://github.com/EagleChen/mapmutex/blob/master/mutex.go
 
 import (
+	"context"
 	"math/rand"
 	"sync"
 	"time"
@@ -22,11 +23,22 @@ type Mutex struct {
 
 // TryLock tries to aquire the lock.
 func (m *Mutex) TryLock(key interface{}) (gotLock bool) {
+	return m.TryLockContext(context.Background(), key)
+}
+
+// TryLockContext tries to aquire the lock and gives up once ctx is done.
+func (m *Mutex) TryLockContext(ctx context.Context, key interface{}) (gotLock bool) {
 	for i := 0; i < m.maxRetry; i++ {
 		m.m.Lock()
 		if _, ok := m.locks[key]; ok { // if locked
 			m.m.Unlock()
-			time.Sleep(m.backoff(i))
+			timer := time.NewTimer(m.backoff(i))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return false
+			}
 		} else { // if unlock, lockit
 			m.locks[key] = struct{}{}
 			m.m.Unlock()
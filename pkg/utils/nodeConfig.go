@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrInvalidCookie is returned if a cookie file does not hold
+	// user:password
+	ErrInvalidCookie = errors.New("invalid rpc cookie file")
+
+	// ErrInvalidCACert is returned if a CA certificate file holds no PEM
+	// encoded certificate
+	ErrInvalidCACert = errors.New("no certificate found in CA certificate file")
+)
+
+// NodeConfig describes how to connect to the rpc interface of the node
+type NodeConfig struct {
+	// URL is host:port, which is connected to over http, or a http:// or
+	// https:// URL
+	URL string
+
+	User     string
+	Password string
+
+	// CookieFile is the .cookie file the node writes its credentials to if
+	// no rpcpassword is configured. It is used instead of User and
+	// Password if set. The node writes a new cookie when it restarts, the
+	// file is read again once the node rejects the credentials.
+	CookieFile string
+
+	// CACertFile holds the PEM encoded certificates the TLS certificate of
+	// the node is verified with, the system roots are used if it is empty
+	CACertFile string
+
+	// Proxy is the SOCKS5 proxy the node is connected to through, nil
+	// connects directly
+	Proxy *Proxy
+}
+
+// endpoint is a parsed NodeConfig
+type endpoint struct {
+	url      string // including the scheme, for the JSON-RPC client
+	host     string // host:port, for the btcd client
+	tls      bool
+	user     string
+	password string
+	caCerts  []byte // PEM
+}
+
+// parse validates the config and reads the cookie and CA certificate files
+func (config NodeConfig) parse() (*endpoint, error) {
+	rawURL := config.URL
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, errors.New("unsupported rpc url scheme " + parsed.Scheme)
+	}
+
+	e := &endpoint{
+		url:      parsed.String(),
+		host:     parsed.Host,
+		tls:      parsed.Scheme == "https",
+		user:     config.User,
+		password: config.Password,
+	}
+
+	if config.CookieFile != "" {
+		e.user, e.password, err = readCookie(config.CookieFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CACertFile != "" {
+		e.caCerts, err = ioutil.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// readCookie returns the user and password of the cookie file at path
+func readCookie(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", ErrInvalidCookie
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// tlsConfig returns the TLS config verifying the node with the configured
+// CA certificates, nil if the system roots are used
+func (e *endpoint) tlsConfig() (*tls.Config, error) {
+	if len(e.caCerts) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(e.caCerts) {
+		return nil, ErrInvalidCACert
+	}
+
+	return &tls.Config{RootCAs: pool}, nil
+}
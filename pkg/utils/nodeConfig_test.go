@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeConfigParsesURLSchemes(t *testing.T) {
+	// act
+	plain, plainErr := NodeConfig{URL: "127.0.0.1:8332"}.parse()
+	secure, secureErr := NodeConfig{URL: "https://node.example.com:8332/"}.parse()
+	_, unsupportedErr := NodeConfig{URL: "ftp://node.example.com"}.parse()
+
+	// assert
+	require.NoError(t, plainErr)
+	assert.Equal(t, "http://127.0.0.1:8332", plain.url)
+	assert.Equal(t, "127.0.0.1:8332", plain.host)
+	assert.False(t, plain.tls)
+	require.NoError(t, secureErr)
+	assert.Equal(t, "https://node.example.com:8332/", secure.url)
+	assert.Equal(t, "node.example.com:8332", secure.host)
+	assert.True(t, secure.tls)
+	assert.Error(t, unsupportedErr)
+}
+
+func TestNodeConfigReadsCookieFile(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "cookie")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cookie := filepath.Join(dir, ".cookie")
+	require.NoError(t, ioutil.WriteFile(cookie, []byte("__cookie__:secret:with:colons\n"), 0600))
+	invalid := filepath.Join(dir, "invalid")
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("secret"), 0600))
+
+	// act
+	e, err := NodeConfig{URL: "127.0.0.1:8332", User: "user", Password: "password", CookieFile: cookie}.parse()
+	_, invalidErr := NodeConfig{URL: "127.0.0.1:8332", CookieFile: invalid}.parse()
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, "__cookie__", e.user)
+	assert.Equal(t, "secret:with:colons", e.password)
+	assert.Equal(t, ErrInvalidCookie, invalidErr)
+}
+
+func TestEndpointRejectsInvalidCACert(t *testing.T) {
+	// arrange
+	e := &endpoint{caCerts: []byte("not a certificate")}
+
+	// act
+	_, err := e.tlsConfig()
+
+	// assert
+	assert.Equal(t, ErrInvalidCACert, err)
+}
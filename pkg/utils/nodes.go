@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/ybbus/jsonrpc"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNoNodes is returned if a client is created without nodes
+	ErrNoNodes = errors.New("no nodes configured")
+
+	// MaxNodeLag is the number of blocks a node may be behind the highest
+	// node before calls are no longer routed to it
+	MaxNodeLag int64 = 1
+
+	// NodeCheckInterval is how often the block heights of the nodes are
+	// compared if a client has more than one node
+	NodeCheckInterval = 30 * time.Second
+)
+
+// node is one of the nodes a CachedRPCClient routes calls to
+type node struct {
+	url        string
+	rpcClient  *rpcclient.Client
+	jsonClient jsonrpc.RPCClient
+	breaker    breaker // suspends calls while the node is unhealthy
+	height     int64   // last checked block height, 0 if unknown
+
+	config NodeConfig // to reconnect with a new cookie
+	mu     sync.Mutex // guards the clients while reconnecting
+}
+
+// newNode creates the clients connecting to the node described by config
+func newNode(config NodeConfig) (*node, error) {
+	endpoint, err := config.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := endpoint.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect to bitcoin core RPC server using HTTP POST mode.
+	connCfg := &rpcclient.ConnConfig{
+		Host:         endpoint.host,
+		User:         endpoint.user,
+		Pass:         endpoint.password,
+		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
+		DisableTLS:   !endpoint.tls,
+		Certificates: endpoint.caCerts,
+	}
+	ConfigureProxy(connCfg, config.Proxy)
+
+	// Notice the notification parameter is nil since notifications are
+	// not supported in HTTP POST mode.
+	client, err := rpcclient.New(connCfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	if config.Proxy != nil {
+		transport.DialContext = config.Proxy.DialContext
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	jsonClient, err := newBitcoinClient(httpClient, endpoint.url, endpoint.user, endpoint.password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &node{url: endpoint.url, rpcClient: client, jsonClient: jsonClient, config: config}, nil
+}
+
+// clients returns a node with the current clients of n to make a call with
+func (n *node) clients() *node {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return &node{url: n.url, rpcClient: n.rpcClient, jsonClient: n.jsonClient}
+}
+
+// reconnect replaces the clients of n with ones using the credentials read
+// from the cookie file again
+func (n *node) reconnect() error {
+	fresh, err := newNode(n.config)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	old := n.rpcClient
+	n.rpcClient, n.jsonClient = fresh.rpcClient, fresh.jsonClient
+	n.mu.Unlock()
+
+	if old != nil {
+		old.Shutdown()
+	}
+
+	return nil
+}
+
+// isUnauthorized reports whether the node rejected the credentials of a call
+func isUnauthorized(err error) bool {
+	if httpErr, ok := err.(*jsonrpc.HTTPError); ok {
+		return httpErr.Code == http.StatusUnauthorized
+	}
+
+	// the btcd client only reports the status in the message
+	return err != nil && strings.HasPrefix(err.Error(), "status code: 401,")
+}
+
+// callNode calls fn with the clients of n. The node writes a new cookie when
+// it restarts, so if it rejects the credentials of a cookie file the file is
+// read again and fn retried once.
+func (c *CachedRPCClient) callNode(n *node, fn func(n *node) error) error {
+	err := fn(n.clients())
+	if n.config.CookieFile == "" || !isUnauthorized(err) {
+		return err
+	}
+
+	reconnectErr := n.reconnect()
+	if reconnectErr != nil {
+		c.logger.Warn("could not read rpc cookie again", zap.String("node", n.url), zap.Error(reconnectErr))
+		return err
+	}
+
+	c.logger.Info("read rpc cookie again", zap.String("node", n.url))
+	return fn(n.clients())
+}
+
+// synced reports whether the node is at most MaxNodeLag blocks behind best.
+// Nodes whose height is not known yet are considered synced.
+func (n *node) synced(best int64) bool {
+	return n.height == 0 || n.height+MaxNodeLag >= best
+}
+
+// bestHeight returns the highest checked block height of the nodes. The
+// caller must hold nodesMu.
+func (c *CachedRPCClient) bestHeight() int64 {
+	var best int64
+	for _, n := range c.nodes {
+		if n.height > best {
+			best = n.height
+		}
+	}
+
+	return best
+}
+
+// pick returns the node for the next call, skipping the tried ones. Calls are
+// spread round robin across the synced nodes whose calls are not suspended.
+// Lagging nodes are only picked if no synced node is left. It returns nil if
+// every node is tried or suspended.
+func (c *CachedRPCClient) pick(tried map[*node]bool, now time.Time) *node {
+	c.nodesMu.Lock()
+	defer c.nodesMu.Unlock()
+
+	best := c.bestHeight()
+	for _, synced := range []bool{true, false} {
+		for i := range c.nodes {
+			index := (c.next + i) % len(c.nodes)
+			n := c.nodes[index]
+			if tried[n] || n.synced(best) != synced {
+				continue
+			}
+
+			if n.breaker.allow(now) {
+				c.next = (index + 1) % len(c.nodes)
+				return n
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkNodes updates the block heights of the nodes so that lagging nodes
+// are no longer picked
+func (c *CachedRPCClient) checkNodes() {
+	for _, n := range c.nodes {
+		if !n.breaker.allow(time.Now()) {
+			continue
+		}
+
+		height, err := n.clients().rpcClient.GetBlockCount()
+		n.breaker.record(err, time.Now())
+		if err != nil {
+			c.logger.Warn("could not check node", zap.String("node", n.url), zap.Error(err))
+			continue
+		}
+
+		c.nodesMu.Lock()
+		n.height = height
+		c.nodesMu.Unlock()
+	}
+
+	c.nodesMu.Lock()
+	best := c.bestHeight()
+	for _, n := range c.nodes {
+		if !n.synced(best) {
+			c.logger.Warn("node is lagging behind", zap.String("node", n.url), zap.Int64("height", n.height), zap.Int64("best", best))
+		}
+	}
+	c.nodesMu.Unlock()
+}
+
+// Health returns the health of the connection to the nodes: the health of
+// the first synced node that is healthy, or of the first node if none is
+func (c *CachedRPCClient) Health() NodeHealth {
+	healths := c.NodeHealths()
+	for _, health := range healths {
+		if health.Healthy && !health.Lagging {
+			return health
+		}
+	}
+
+	return healths[0]
+}
+
+// NodeHealths returns the health of the connection to every node
+func (c *CachedRPCClient) NodeHealths() []NodeHealth {
+	c.nodesMu.Lock()
+	defer c.nodesMu.Unlock()
+
+	now := time.Now()
+	best := c.bestHeight()
+	healths := make([]NodeHealth, len(c.nodes))
+	for i, n := range c.nodes {
+		healths[i] = n.breaker.status(now)
+		healths[i].URL = n.url
+		healths[i].Height = n.height
+		healths[i].Lagging = !n.synced(best)
+	}
+
+	return healths
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// cookieNode is a node that only accepts the password of its current cookie
+type cookieNode struct {
+	mu       sync.Mutex
+	password string
+}
+
+func (c *cookieNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	password := c.password
+	c.mu.Unlock()
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "__cookie__" || pass != password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var request struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results := map[string]interface{}{
+		"getblockhash":     "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.0001, "blocks": 2},
+	}
+	IgnoreError(json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     request.ID,
+		"result": results[request.Method],
+		"error":  nil,
+	}))
+}
+
+func TestCallFailsOverToNextNode(t *testing.T) {
+	// arrange
+	backoff := RPCRetryBackoff
+	RPCRetryBackoff = time.Hour // fails the test by timeout if it backs off
+	defer func() { RPCRetryBackoff = backoff }()
+
+	c := newTestClient(nil)
+	c.nodes = []*node{{url: "down"}, {url: "up"}}
+	var called []string
+
+	// act
+	err := c.call("getblock", func(n *node) error {
+		called = append(called, n.url)
+		if n.url == "down" {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"down", "up"}, called)
+	assert.Equal(t, 1, c.NodeHealths()[0].ConsecutiveFailures)
+}
+
+func TestPickSpreadsCallsAcrossSyncedNodes(t *testing.T) {
+	// arrange
+	c := newTestClient(nil)
+	a, b, lagging := &node{url: "a", height: 100}, &node{url: "b", height: 100}, &node{url: "lagging", height: 90}
+	c.nodes = []*node{a, lagging, b}
+	now := time.Now()
+
+	// act
+	first := c.pick(nil, now)
+	second := c.pick(nil, now)
+	third := c.pick(nil, now)
+	fallback := c.pick(map[*node]bool{a: true, b: true}, now)
+
+	// assert
+	assert.Equal(t, a, first)
+	assert.Equal(t, b, second)
+	assert.Equal(t, a, third)
+	assert.Equal(t, lagging, fallback)
+	assert.True(t, c.NodeHealths()[1].Lagging)
+	assert.Equal(t, "a", c.Health().URL)
+}
+
+func TestCallReadsRotatedCookie(t *testing.T) {
+	// arrange
+	backoff := RPCRetryBackoff
+	RPCRetryBackoff = time.Hour // fails the test by timeout if it backs off
+	defer func() { RPCRetryBackoff = backoff }()
+
+	dir, err := ioutil.TempDir("", "cookie")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cookie := filepath.Join(dir, ".cookie")
+	require.NoError(t, ioutil.WriteFile(cookie, []byte("__cookie__:before"), 0600))
+
+	server := &cookieNode{password: "before"}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	c, err := NewCachedRPCClientWithOptions([]NodeConfig{{URL: httpServer.URL, CookieFile: cookie}}, zap.NewNop(), nil, DefaultClientOptions())
+	require.NoError(t, err)
+	defer c.Close()
+
+	// the node restarts and writes a new cookie
+	require.NoError(t, ioutil.WriteFile(cookie, []byte("__cookie__:after"), 0600))
+	server.mu.Lock()
+	server.password = "after"
+	server.mu.Unlock()
+
+	// act
+	fee, feeErr := c.EstimateSmartFee(2)
+	hash, hashErr := c.GetBlockHash(0)
+
+	// assert
+	require.NoError(t, feeErr)
+	assert.Equal(t, 0.0001, fee)
+	require.NoError(t, hashErr)
+	assert.Equal(t, "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", hash.String())
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcutil"
+)
+
+var (
+	// NotificationBuffer is the number of notifications queued per channel
+	// of a Notifier. Notifications beyond it are dropped.
+	NotificationBuffer = 10000
+)
+
+// BlockNotification announces a block connected to the chain
+type BlockNotification struct {
+	Hash   *chainhash.Hash
+	Height int32
+	Time   time.Time
+}
+
+// Notifier delivers the notifications of a node. Consumers that fall behind
+// lose notifications, so they should resync from time to time.
+type Notifier struct {
+	// Blocks receives the blocks connected to the chain
+	Blocks <-chan BlockNotification
+
+	// Txs receives the hashes of the txs accepted into the mempool
+	Txs <-chan *chainhash.Hash
+
+	client *rpcclient.Client
+}
+
+// Notify subscribes to the block and tx notifications of the node config
+// connects to, see NewWebsocketConfig. Only btcd supports websocket
+// notifications.
+func (c *CachedRPCClient) Notify(config *rpcclient.ConnConfig) (*Notifier, error) {
+	blocks := make(chan BlockNotification, NotificationBuffer)
+	txs := make(chan *chainhash.Hash, NotificationBuffer)
+	handlers := &rpcclient.NotificationHandlers{
+		OnTxAccepted: func(hash *chainhash.Hash, amount btcutil.Amount) {
+			select {
+			case txs <- hash:
+			default:
+			}
+		},
+		OnBlockConnected: func(hash *chainhash.Hash, height int32, t time.Time) {
+			select {
+			case blocks <- BlockNotification{Hash: hash, Height: height, Time: t}:
+			default:
+			}
+		},
+	}
+
+	client, err := rpcclient.New(config, handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.NotifyNewTransactions(false)
+	if err != nil {
+		client.Shutdown()
+		return nil, err
+	}
+
+	err = client.NotifyBlocks()
+	if err != nil {
+		client.Shutdown()
+		return nil, err
+	}
+
+	return &Notifier{Blocks: blocks, Txs: txs, client: client}, nil
+}
+
+// Close unsubscribes from the notifications
+func (n *Notifier) Close() {
+	n.client.Shutdown()
+	n.client.WaitForShutdown()
+}
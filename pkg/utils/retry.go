@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/ybbus/jsonrpc"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrCircuitOpen is returned instead of calling the node while it is
+	// considered unhealthy
+	ErrCircuitOpen = errors.New("node is unhealthy, rpc calls are suspended")
+
+	// RPCRetryAttempts is the number of times a failed rpc call is tried
+	// again before its error is returned
+	RPCRetryAttempts = 2
+
+	// RPCRetryBackoff is the pause before the first retry of an rpc call,
+	// it doubles with every further retry
+	RPCRetryBackoff = 500 * time.Millisecond
+
+	// BreakerThreshold is the number of consecutive failed rpc calls after
+	// which the node is considered unhealthy and calls are suspended
+	BreakerThreshold = 5
+
+	// BreakerCooldown is for how long calls are suspended before a single
+	// call is let through to check whether the node recovered
+	BreakerCooldown = 30 * time.Second
+)
+
+// NodeHealth describes the health of the connection to a node
+type NodeHealth struct {
+	URL                 string    `json:"url"`
+	Height              int64     `json:"height"`  // last checked block height, 0 if unknown
+	Lagging             bool      `json:"lagging"` // more than MaxNodeLag blocks behind the highest node
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastErrorTime       time.Time `json:"lastErrorTime"`
+
+	// SuspendedUntil is when calls are let through again, zero if they are
+	// not suspended
+	SuspendedUntil time.Time `json:"suspendedUntil"`
+}
+
+// breaker is a circuit breaker suspending calls to the node after
+// BreakerThreshold consecutive failures
+type breaker struct {
+	health   NodeHealth
+	openedAt time.Time
+	mu       sync.Mutex
+}
+
+// allow reports whether a call may be made at now. Once BreakerCooldown
+// passed since the circuit opened one call is let through, its outcome
+// closes or reopens the circuit.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.health.ConsecutiveFailures < BreakerThreshold {
+		return true
+	}
+
+	if now.Sub(b.openedAt) < BreakerCooldown {
+		return false
+	}
+
+	b.openedAt = now
+	return true
+}
+
+// record updates the health with the outcome of a call at now
+func (b *breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.health.ConsecutiveFailures = 0
+		b.health.LastSuccess = now
+		return
+	}
+
+	b.health.ConsecutiveFailures++
+	b.health.LastError = err.Error()
+	b.health.LastErrorTime = now
+	if b.health.ConsecutiveFailures >= BreakerThreshold {
+		b.openedAt = now
+	}
+}
+
+// status returns the health at now
+func (b *breaker) status(now time.Time) NodeHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	health := b.health
+	health.Healthy = health.ConsecutiveFailures < BreakerThreshold
+	if !health.Healthy && now.Sub(b.openedAt) < BreakerCooldown {
+		health.SuspendedUntil = b.openedAt.Add(BreakerCooldown)
+	}
+
+	return health
+}
+
+// isNodeError reports whether err was returned by the node itself, e.g.
+// because a tx is unknown. The node is reachable then and trying again does
+// not change the answer.
+func isNodeError(err error) bool {
+	switch err.(type) {
+	case *btcjson.RPCError, *jsonrpc.RPCError:
+		return true
+	}
+
+	return false
+}
+
+// call calls fn with a node picked by pick until it succeeds or
+// RPCRetryAttempts retries failed. Retries go to the nodes not tried yet
+// first and only back off once every node was tried. Errors returned by the
+// node are not retried.
+func (c *CachedRPCClient) call(op string, fn func(n *node) error) error {
+	err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer c.end()
+
+	backoff := NewBackoff(RPCRetryBackoff)
+	tried := make(map[*node]bool)
+	for attempt := 0; ; attempt++ {
+		n := c.pick(tried, time.Now())
+		if n == nil && len(tried) > 0 {
+			tried = make(map[*node]bool)
+			n = c.pick(tried, time.Now())
+		}
+		if n == nil {
+			return ErrCircuitOpen
+		}
+		tried[n] = true
+
+		start := time.Now()
+		err := c.callNode(n, fn)
+		c.metrics.called(op, time.Since(start), err)
+		if err != nil && isNodeError(err) {
+			n.breaker.record(nil, time.Now())
+			return err
+		}
+
+		n.breaker.record(err, time.Now())
+		if err == nil || attempt >= RPCRetryAttempts {
+			return err
+		}
+
+		if len(tried) < len(c.nodes) {
+			c.logger.Warn("rpc call failed, failing over", zap.String("op", op), zap.String("node", n.url), zap.Int("attempt", attempt+1), zap.Error(err))
+			continue
+		}
+
+		c.logger.Warn("rpc call failed, retrying", zap.String("op", op), zap.Int("attempt", attempt+1), zap.Duration("backoff", backoff.Next()), zap.Error(err))
+		if !backoff.Wait(c.done) {
+			return ErrClientClosed
+		}
+		tried = make(map[*node]bool)
+	}
+}
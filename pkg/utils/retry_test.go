@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallRetriesFailedCalls(t *testing.T) {
+	// arrange
+	backoff := RPCRetryBackoff
+	RPCRetryBackoff = 0
+	defer func() { RPCRetryBackoff = backoff }()
+
+	c := newTestClient(nil)
+	calls := 0
+
+	// act
+	err := c.call("getblock", func(n *node) error {
+		calls++
+		if calls < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, c.Health().Healthy)
+}
+
+func TestCallDoesNotRetryNodeErrors(t *testing.T) {
+	// arrange
+	c := newTestClient(nil)
+	calls := 0
+	nodeErr := &btcjson.RPCError{Code: btcjson.ErrRPCNoTxInfo, Message: "No such mempool transaction"}
+
+	// act
+	err := c.call("getmempoolentry", func(n *node) error {
+		calls++
+		return nodeErr
+	})
+
+	// assert
+	assert.Equal(t, nodeErr, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 0, c.Health().ConsecutiveFailures)
+}
+
+func TestBreakerSuspendsCallsUntilCooldownPassed(t *testing.T) {
+	// arrange
+	var b breaker
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < BreakerThreshold; i++ {
+		b.record(errors.New("connection refused"), now)
+	}
+
+	// act
+	suspended := b.allow(now.Add(BreakerCooldown / 2))
+	health := b.status(now.Add(BreakerCooldown / 2))
+	trial := b.allow(now.Add(BreakerCooldown))
+	afterTrial := b.allow(now.Add(BreakerCooldown))
+	b.record(nil, now.Add(BreakerCooldown))
+
+	// assert
+	assert.False(t, suspended)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, now.Add(BreakerCooldown), health.SuspendedUntil)
+	assert.True(t, trial)
+	assert.False(t, afterTrial)
+	assert.True(t, b.status(now.Add(BreakerCooldown)).Healthy)
+	assert.True(t, b.allow(now.Add(BreakerCooldown)))
+}
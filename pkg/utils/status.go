@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TransientError is an error that is expected to go away by trying again,
+// e.g. a failed rpc call
+type TransientError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// IsTransient reports whether err is expected to go away by trying again
+func IsTransient(err error) bool {
+	_, ok := err.(*TransientError)
+	return ok
+}
+
+// Backoff is the pause between two tries of a failed call, it doubles with
+// every retry
+type Backoff struct {
+	next time.Duration
+}
+
+// NewBackoff returns a backoff pausing initial before the first retry
+func NewBackoff(initial time.Duration) *Backoff {
+	return &Backoff{next: initial}
+}
+
+// Next returns the pause before the next retry
+func (b *Backoff) Next() time.Duration {
+	return b.next
+}
+
+// Wait pauses before the next retry and doubles the pause. It returns false
+// if done is closed first.
+func (b *Backoff) Wait(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	case <-time.After(b.next):
+	}
+
+	b.next *= 2
+	return true
+}
+
+// Retry calls fn until it succeeds or attempts retries failed, pausing
+// backoff before the first retry. The last error is returned as a
+// TransientError then. Errors permanent reports true for are returned right
+// away, permanent may be nil, and so is ErrClientClosed. ErrCircuitOpen is
+// returned as a TransientError right away, the client retried the call
+// already and keeps the circuit open while backing off here. It stops
+// waiting for the next try when ctx is cancelled.
+func Retry(ctx context.Context, logger *zap.Logger, op string, attempts int, backoff time.Duration, permanent func(error) bool, fn func() error) error {
+	b := NewBackoff(backoff)
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || permanent != nil && permanent(err) || errors.Is(err, ErrClientClosed) {
+			return err
+		}
+
+		if attempt >= attempts || errors.Is(err, ErrCircuitOpen) {
+			return &TransientError{Op: op, Err: err}
+		}
+
+		logger.Warn("call failed, retrying", zap.String("op", op), zap.Int("attempt", attempt+1), zap.Duration("backoff", b.Next()), zap.Error(err))
+		if !b.Wait(ctx.Done()) {
+			return ctx.Err()
+		}
+	}
+}
+
+// RunStatus describes the outcomes of the rounds of a long running task,
+// e.g. the estimation rounds of an estimator
+type RunStatus struct {
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastErrorTime       time.Time `json:"lastErrorTime"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	TransientFailures   int       `json:"transientFailures"`
+}
+
+// Record updates the status with the outcome of a round that ended at now
+func (s *RunStatus) Record(err error, now time.Time) {
+	if err == nil {
+		s.LastSuccess = now
+		s.ConsecutiveFailures = 0
+		return
+	}
+
+	s.LastError = err.Error()
+	s.LastErrorTime = now
+	s.ConsecutiveFailures++
+	if IsTransient(err) {
+		s.TransientFailures++
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRetryReturnsPermanentErrorsRightAway(t *testing.T) {
+	// arrange
+	permanent := errors.New("not found")
+	calls := 0
+
+	// act
+	err := Retry(context.Background(), zap.NewNop(), "get tx", 3, time.Hour, func(err error) bool { return err == permanent }, func() error {
+		calls++
+		return permanent
+	})
+
+	// assert
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryGivesUpWithTransientError(t *testing.T) {
+	// arrange
+	calls := 0
+
+	// act
+	err := Retry(context.Background(), zap.NewNop(), "get tx", 2, 0, nil, func() error {
+		calls++
+		return errors.New("timeout")
+	})
+
+	// assert
+	assert.Equal(t, 3, calls)
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, "get tx: timeout", err.Error())
+}
+
+func TestRetryLeavesClientErrorsToTheClient(t *testing.T) {
+	// arrange
+	calls := 0
+	retry := func(clientErr error) error {
+		return Retry(context.Background(), zap.NewNop(), "get tx", 3, time.Hour, nil, func() error {
+			calls++
+			return clientErr
+		})
+	}
+
+	// act
+	circuitErr := retry(ErrCircuitOpen)
+	closedErr := retry(ErrClientClosed)
+
+	// assert
+	assert.Equal(t, 2, calls)
+	assert.True(t, IsTransient(circuitErr))
+	assert.Equal(t, ErrClientClosed, closedErr)
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	// arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	// act
+	err := Retry(ctx, zap.NewNop(), "get tx", 3, time.Hour, nil, func() error {
+		calls++
+		cancel()
+		return errors.New("timeout")
+	})
+
+	// assert
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRunStatusRecordsResults(t *testing.T) {
+	// arrange
+	var status RunStatus
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// act
+	status.Record(nil, now)
+	status.Record(&TransientError{Op: "get block", Err: errors.New("timeout")}, now.Add(time.Minute))
+	status.Record(errors.New("unexpected"), now.Add(2*time.Minute))
+
+	// assert
+	assert.Equal(t, now, status.LastSuccess)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Equal(t, 1, status.TransientFailures)
+	assert.Equal(t, "unexpected", status.LastError)
+	assert.Equal(t, now.Add(2*time.Minute), status.LastErrorTime)
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+var (
+	// MaxCachedTxs is the number of raw txs a CachedRPCClient keeps at most,
+	// 0 does not limit it
+	MaxCachedTxs = 200000
+
+	// MaxCachedTxBytes is the approximate memory in bytes the raw txs a
+	// CachedRPCClient keeps may take, 0 does not limit it
+	MaxCachedTxBytes int64 = 512 << 20
+)
+
+// newTxCache creates the cache of raw txs by hash, bounded by MaxCachedTxs
+// and MaxCachedTxBytes. Txs expire after expiration on top of that.
+func newTxCache(expiration time.Duration) *Cache {
+	return NewCache(CacheOptions{
+		MaxItems: MaxCachedTxs,
+		MaxBytes: MaxCachedTxBytes,
+		TTL:      expiration,
+		Size: func(value interface{}) int64 {
+			return txBytes(value.(*btcjson.TxRawResult))
+		},
+	})
+}
+
+// txBytes approximates the memory taken by tx. The decoded scripts of the
+// inputs and outputs take about as much as the hex of the whole tx again.
+func txBytes(tx *btcjson.TxRawResult) int64 {
+	return int64(len(tx.Hex))*2 + 512
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrTxNotStored is returned by a TxStore that holds no tx for a hash
+	ErrTxNotStored = errors.New("tx is not stored")
+
+	// DefaultTxStorePath is where raw txs are persisted
+	DefaultTxStorePath = "./output/txs.db"
+
+	txBucket = []byte("txs")
+)
+
+// TxStore persists raw txs so that they survive restarts. Only confirmed
+// txs are stored, they never change except for their number of
+// confirmations, which is stale once loaded.
+type TxStore interface {
+	// Load returns the tx stored for hash or ErrTxNotStored
+	Load(hash string) (*btcjson.TxRawResult, error)
+
+	// Store persists txs
+	Store(txs []*btcjson.TxRawResult) error
+
+	Close() error
+}
+
+// BoltTxStore stores raw txs as json in a bolt database
+type BoltTxStore struct {
+	db *bolt.DB
+}
+
+// NewBoltTxStore opens the bolt database at path, creating it if it does
+// not exist yet
+func NewBoltTxStore(path string) (*BoltTxStore, error) {
+	db, err := OpenBolt(path, txBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltTxStore{db: db}, nil
+}
+
+// OpenBolt opens the bolt database at path and creates bucket in it, the
+// database and its directory are created if they do not exist yet
+func OpenBolt(path string, bucket []byte) (*bolt.DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		IgnoreError(db.Close())
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Load returns the tx stored for hash or ErrTxNotStored
+func (s *BoltTxStore) Load(hash string) (*btcjson.TxRawResult, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// the value is only valid during the transaction
+		data = append(data, tx.Bucket(txBucket).Get([]byte(hash))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, ErrTxNotStored
+	}
+
+	var rawTx btcjson.TxRawResult
+	err = json.Unmarshal(data, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rawTx, nil
+}
+
+// Store persists the confirmed ones of txs in a single transaction
+func (s *BoltTxStore) Store(txs []*btcjson.TxRawResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(txBucket)
+		for _, rawTx := range txs {
+			if rawTx == nil || rawTx.BlockHash == "" {
+				continue
+			}
+
+			data, err := json.Marshal(rawTx)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(rawTx.Txid), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Close closes the database
+func (s *BoltTxStore) Close() error {
+	return s.db.Close()
+}
+
+// LazyTxStore opens a BoltTxStore on first use, so that commands which never
+// load or store a tx do not open, or wait for the lock of, the database
+type LazyTxStore struct {
+	path string
+
+	mu     sync.Mutex
+	store  *BoltTxStore
+	err    error
+	opened bool
+}
+
+// NewLazyTxStore returns a store opening the bolt database at path once it
+// is first used
+func NewLazyTxStore(path string) *LazyTxStore {
+	return &LazyTxStore{path: path}
+}
+
+// open returns the opened store, an error opening it is returned on every
+// use rather than retried
+func (s *LazyTxStore) open() (*BoltTxStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.opened {
+		s.store, s.err = NewBoltTxStore(s.path)
+		s.opened = true
+	}
+
+	return s.store, s.err
+}
+
+// Load returns the tx stored for hash or ErrTxNotStored
+func (s *LazyTxStore) Load(hash string) (*btcjson.TxRawResult, error) {
+	store, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Load(hash)
+}
+
+// Store persists the confirmed ones of txs in a single transaction
+func (s *LazyTxStore) Store(txs []*btcjson.TxRawResult) error {
+	store, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	return store.Store(txs)
+}
+
+// Close closes the database if it was opened, later uses fail
+func (s *LazyTxStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.opened {
+		s.opened = true
+		s.err = errors.New("tx store is closed")
+		return nil
+	}
+	if s.store == nil {
+		return nil
+	}
+
+	return s.store.Close()
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltTxStoreKeepsConfirmedTxs(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "txstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "txs", "txs.db")
+	store, err := NewBoltTxStore(path)
+	require.NoError(t, err)
+	confirmed := &btcjson.TxRawResult{Txid: "confirmed", BlockHash: "block", Vsize: 141}
+	unconfirmed := &btcjson.TxRawResult{Txid: "unconfirmed"}
+	require.NoError(t, store.Store([]*btcjson.TxRawResult{confirmed, unconfirmed, nil}))
+	require.NoError(t, store.Close())
+
+	// act
+	reopened, err := NewBoltTxStore(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	loaded, err := reopened.Load("confirmed")
+	_, missing := reopened.Load("unconfirmed")
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, confirmed, loaded)
+	assert.Equal(t, ErrTxNotStored, missing)
+}
+
+func TestClientLoadsTxsMissingInMemoryFromStore(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "txstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltTxStore(filepath.Join(dir, "txs.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.Store([]*btcjson.TxRawResult{{Txid: "stored", BlockHash: "block"}}))
+
+	c := newTestClient(nil)
+	c.store = store
+
+	// act
+	tx, found := c.get("stored")
+	_, missing := c.get("other")
+
+	// assert
+	assert.True(t, found)
+	assert.Equal(t, "stored", tx.Txid)
+	assert.False(t, missing)
+	assert.Equal(t, 1, c.rawTxCache.Len())
+	assert.Equal(t, uint64(1), c.Metrics().Caches[CacheTxStore].Hits)
+}
+
+func TestLazyTxStoreOpensOnFirstUse(t *testing.T) {
+	// arrange
+	dir, err := ioutil.TempDir("", "txstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "txs.db")
+	store := NewLazyTxStore(path)
+	defer store.Close()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	// act
+	_, missing := store.Load("other")
+
+	// assert
+	assert.Equal(t, ErrTxNotStored, missing)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}